@@ -0,0 +1,136 @@
+package yapay
+
+import (
+	"fmt"
+)
+
+// Cart represents the items a customer pays for. All amounts are in minor
+// units of the payment currency.
+type Cart struct {
+	Items []CartItem `json:"items" yaml:"items"`
+}
+
+// CartItem represents a single cart position
+type CartItem struct {
+	ProductID    string         `json:"product_id" yaml:"product_id"`
+	Title        string         `json:"title" yaml:"title"`
+	Quantity     int            `json:"quantity" yaml:"quantity"`
+	UnitPrice    int            `json:"unit_price" yaml:"unit_price"` // Price of one unit before discounts
+	Discounts    []ItemDiscount `json:"discounts,omitempty" yaml:"discounts,omitempty"`
+	Measurements *Measurements  `json:"measurements,omitempty" yaml:"measurements,omitempty"`
+}
+
+// ItemDiscount represents a discount applied to a whole cart position
+type ItemDiscount struct {
+	ID          string `json:"id,omitempty" yaml:"id,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Amount      int    `json:"amount" yaml:"amount"` // Discount for the position, not per unit
+}
+
+// Measurements represents the physical size of one unit, used for delivery
+type Measurements struct {
+	Weight float64 `json:"weight" yaml:"weight"` // Kilograms
+	Height float64 `json:"height" yaml:"height"` // Meters
+	Length float64 `json:"length" yaml:"length"` // Meters
+	Width  float64 `json:"width" yaml:"width"`   // Meters
+}
+
+// Subtotal returns the position price before discounts
+func (i *CartItem) Subtotal() int {
+	return i.UnitPrice * i.Quantity
+}
+
+// DiscountAmount returns the sum of the position discounts
+func (i *CartItem) DiscountAmount() int {
+	total := 0
+	for _, d := range i.Discounts {
+		total += d.Amount
+	}
+	return total
+}
+
+// Total returns the position price after discounts
+func (i *CartItem) Total() int {
+	return i.Subtotal() - i.DiscountAmount()
+}
+
+// Subtotal returns the cart price before discounts
+func (c *Cart) Subtotal() int {
+	total := 0
+	for i := range c.Items {
+		total += c.Items[i].Subtotal()
+	}
+	return total
+}
+
+// DiscountAmount returns the sum of all item discounts
+func (c *Cart) DiscountAmount() int {
+	total := 0
+	for i := range c.Items {
+		total += c.Items[i].DiscountAmount()
+	}
+	return total
+}
+
+// Total returns the amount to be paid for the cart
+func (c *Cart) Total() int {
+	return c.Subtotal() - c.DiscountAmount()
+}
+
+// Validate checks every item and reports all problems at once
+func (c *Cart) Validate() error {
+	err := NewError(ErrorCodeValidation, "invalid cart")
+	if len(c.Items) == 0 {
+		err.WithDetail("cart.items", "Cart must contain at least one item")
+	}
+
+	seen := make(map[string]bool, len(c.Items))
+	for i := range c.Items {
+		item := &c.Items[i]
+		field := fmt.Sprintf("cart.items[%d]", i)
+
+		switch {
+		case item.ProductID == "":
+			err.WithDetail(field+".product_id", "Product ID is required")
+		case seen[item.ProductID]:
+			// Receipts and the Merchant API match positions by product ID
+			err.WithDetail(field+".product_id", "Product ID must be unique")
+		}
+		seen[item.ProductID] = true
+		if item.Quantity <= 0 {
+			err.WithDetail(field+".quantity", "Quantity must be positive")
+		}
+		if item.UnitPrice < 0 {
+			err.WithDetail(field+".unit_price", "Unit price must not be negative")
+		}
+		for j, d := range item.Discounts {
+			if d.Amount < 0 {
+				err.WithDetail(fmt.Sprintf("%s.discounts[%d].amount", field, j), "Discount must not be negative")
+			}
+		}
+		if item.Total() < 0 {
+			err.WithDetail(field+".discounts", "Discounts exceed the item price")
+		}
+	}
+
+	if len(err.Details) > 0 {
+		return err
+	}
+	return nil
+}
+
+// ValidateCart checks the cart and that its total equals Amount.
+// Requests without a cart are valid.
+func (r *PaymentRequest) ValidateCart() error {
+	if r.Cart == nil {
+		return nil
+	}
+	if err := r.Cart.Validate(); err != nil {
+		return err
+	}
+	if total := r.Cart.Total(); total != r.Amount {
+		return Errorf(ErrorCodePriceMismatch, "cart total %d does not match amount %d", total, r.Amount).
+			WithDetail("amount", "Amount must equal the cart total")
+	}
+	return nil
+}
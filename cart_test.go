@@ -0,0 +1,66 @@
+package yapay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCart() *Cart {
+	return &Cart{Items: []CartItem{
+		{ProductID: "book", Title: "Book", Quantity: 2, UnitPrice: 50000},
+		{
+			ProductID: "mug",
+			Title:     "Mug",
+			Quantity:  3,
+			UnitPrice: 30000,
+			Discounts: []ItemDiscount{{ID: "promo", Amount: 15000}},
+		},
+	}}
+}
+
+func TestCart_Totals(t *testing.T) {
+	cart := newTestCart()
+
+	assert.Equal(t, 100000, cart.Items[0].Total())
+	assert.Equal(t, 90000, cart.Items[1].Subtotal())
+	assert.Equal(t, 75000, cart.Items[1].Total())
+	assert.Equal(t, 190000, cart.Subtotal())
+	assert.Equal(t, 15000, cart.DiscountAmount())
+	assert.Equal(t, 175000, cart.Total())
+}
+
+func TestCart_Validate(t *testing.T) {
+	require.NoError(t, newTestCart().Validate())
+
+	cart := &Cart{Items: []CartItem{
+		{Quantity: 0, UnitPrice: 100},
+		{ProductID: "p2", Quantity: 1, UnitPrice: 100, Discounts: []ItemDiscount{{Amount: 150}}},
+		{ProductID: "p2", Quantity: 1, UnitPrice: 100},
+	}}
+	err := cart.Validate()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	details := AsError(err).Details
+	assert.Contains(t, details, "cart.items[0].product_id")
+	assert.Contains(t, details, "cart.items[0].quantity")
+	assert.Contains(t, details, "cart.items[1].discounts")
+	assert.Contains(t, details, "cart.items[2].product_id")
+	assert.NotContains(t, details, "cart.items[1].product_id")
+
+	assert.Error(t, (&Cart{}).Validate())
+}
+
+func TestPaymentRequest_ValidateCart(t *testing.T) {
+	req := &PaymentRequest{Amount: 175000, Currency: "RUB", Cart: newTestCart()}
+	require.NoError(t, req.ValidateCart())
+
+	req.Amount = 190000
+	err := req.ValidateCart()
+	assert.True(t, errors.Is(err, ErrPriceMismatch))
+
+	assert.NoError(t, (&PaymentRequest{Amount: 100}).ValidateCart())
+}
@@ -0,0 +1,639 @@
+package yapay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Request enforcement policies
+const (
+	EnforcementStrict  = "strict"
+	EnforcementOrigin  = "origin"
+	EnforcementMonitor = "monitor"
+)
+
+// Defaults applied by LoadMerchantConfig to fields left empty
+const (
+	DefaultRequestEnforcement = EnforcementStrict
+	DefaultRateLimit          = 100
+	DefaultCurrency           = "RUB"
+	DefaultSMTPPort           = 587
+	DefaultSMTPTLS            = SMTPTLSStartTLS
+	// DefaultNotificationRatePeriod is the period of a rule rate limit
+	DefaultNotificationRatePeriod = "1h"
+	// DefaultDigestMaxPayments is the number of payments listed in a digest
+	DefaultDigestMaxPayments = 20
+)
+
+// SMTP connection security modes for EmailConfig.TLS
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+	SMTPTLSNone     = "none"
+)
+
+// Telegram parse modes for NotificationTemplate.ParseMode
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeText       = "text"
+)
+
+// Notification channels for NotificationRule.Channels
+const (
+	NotificationChannelTelegram = "telegram"
+	NotificationChannelEmail    = "email"
+)
+
+// Digest windows for NotificationRule.Digest
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+// ErrInvalidConfig is matched by errors.Is for every *ConfigError
+var ErrInvalidConfig = errors.New("invalid merchant config")
+
+// ConfigIssue is a single problem found in a merchant config
+type ConfigIssue struct {
+	Path    string // Dotted key path, e.g. "security.cors.origins[1]"
+	Line    int    // 1-based YAML line, 0 when unknown
+	Column  int
+	Message string
+}
+
+// String formats the issue as "line 7: path: message"
+func (i ConfigIssue) String() string {
+	var b strings.Builder
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", i.Line)
+	}
+	if i.Path != "" {
+		b.WriteString(i.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// ConfigError reports every problem found in a merchant config
+type ConfigError struct {
+	File   string
+	Issues []ConfigIssue
+}
+
+// Error implements the error interface
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid merchant config")
+	if e.File != "" {
+		b.WriteString(" ")
+		b.WriteString(e.File)
+	}
+	fmt.Fprintf(&b, " (%d problems):", len(e.Issues))
+	for _, issue := range e.Issues {
+		b.WriteString("\n  ")
+		b.WriteString(issue.String())
+	}
+	return b.String()
+}
+
+// Is reports whether target is ErrInvalidConfig or ErrValidation
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig || target == ErrValidation
+}
+
+// LoadOption configures LoadMerchantConfig
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	lookupEnv func(string) (string, bool)
+	providers map[string]SecretProvider
+}
+
+// WithEnvLookup replaces os.LookupEnv for ${VAR} expansion
+func WithEnvLookup(lookup func(string) (string, bool)) LoadOption {
+	return func(o *loadOptions) {
+		o.lookupEnv = lookup
+	}
+}
+
+// LoadMerchantConfig reads a merchant config.yaml. It expands ${VAR} and
+// ${VAR:-default} references in values ($${VAR} keeps the text literally),
+// resolves secret references such as file:/run/secrets/key (see
+// WithSecretProvider), rejects unknown keys, applies defaults and validates
+// the result. All problems are returned together as a *ConfigError with
+// YAML line numbers.
+func LoadMerchantConfig(path string, opts ...LoadOption) (*Merchant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	merchant, err := ParseMerchantConfig(data, opts...)
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		configErr.File = path
+	}
+	return merchant, err
+}
+
+// ParseMerchantConfig is LoadMerchantConfig for an in-memory document
+func ParseMerchantConfig(data []byte, opts ...LoadOption) (*Merchant, error) {
+	o := loadOptions{lookupEnv: os.LookupEnv, providers: make(map[string]SecretProvider)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if _, ok := o.providers[SecretSchemeFile]; !ok {
+		o.providers[SecretSchemeFile] = FileSecretProvider{}
+	}
+	if _, ok := o.providers[SecretSchemeEnv]; !ok {
+		o.providers[SecretSchemeEnv] = EnvSecretProvider{Lookup: o.lookupEnv}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &ConfigError{Issues: []ConfigIssue{yamlIssue(err.Error())}}
+	}
+	if len(doc.Content) == 0 {
+		return nil, &ConfigError{Issues: []ConfigIssue{{Message: "config is empty"}}}
+	}
+	root := doc.Content[0]
+
+	v := &configValidator{nodes: make(map[string]*yaml.Node)}
+	v.expandEnv(root, o.lookupEnv)
+	v.checkKeys(root, reflect.TypeOf(Merchant{}), "")
+
+	var merchant Merchant
+	if err := root.Decode(&merchant); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, &ConfigError{Issues: []ConfigIssue{yamlIssue(err.Error())}}
+		}
+		for _, msg := range typeErr.Errors {
+			v.issues = append(v.issues, yamlIssue(msg))
+		}
+	}
+
+	v.resolveSecrets(&merchant, o.providers)
+	applyMerchantDefaults(&merchant, v.nodes)
+	v.validate(&merchant)
+
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return &merchant, nil
+}
+
+// ValidateMerchant checks a merchant config built in code. Unlike
+// LoadMerchantConfig it applies no defaults and reports no line numbers.
+func ValidateMerchant(m *Merchant) error {
+	v := &configValidator{}
+	v.validate(m)
+	return v.err()
+}
+
+// applyMerchantDefaults fills fields that were left empty. nodes holds the
+// keys present in the document, so explicit values, including an explicit 0,
+// are never overridden; validation rejects the invalid ones.
+func applyMerchantDefaults(m *Merchant, nodes map[string]*yaml.Node) {
+	absent := func(path string) bool {
+		_, ok := nodes[path]
+		return !ok
+	}
+	if m.Security.RequestEnforcement == "" {
+		m.Security.RequestEnforcement = DefaultRequestEnforcement
+	}
+	if m.Security.RateLimit == 0 && absent("security.rate_limit") {
+		m.Security.RateLimit = DefaultRateLimit
+	}
+	if m.Yandex.Currency == "" {
+		m.Yandex.Currency = DefaultCurrency
+	}
+	m.Yandex.Currency = strings.ToUpper(m.Yandex.Currency)
+	if absent("yandex.sandbox_mode") {
+		m.Yandex.SandboxMode = m.SandboxMode
+	}
+	if m.Notifications.Email.SMTPPort == 0 && absent("notifications.email.smtp_port") {
+		m.Notifications.Email.SMTPPort = DefaultSMTPPort
+	}
+	if m.Notifications.Email.TLS == "" {
+		m.Notifications.Email.TLS = DefaultSMTPTLS
+	}
+	for i := range m.Notifications.Rules {
+		limit := &m.Notifications.Rules[i].RateLimit
+		if limit.Max > 0 && limit.Period == "" {
+			limit.Period = DefaultNotificationRatePeriod
+		}
+	}
+}
+
+// configValidator collects issues and maps key paths to their YAML key
+// nodes (or item nodes for sequences)
+type configValidator struct {
+	nodes  map[string]*yaml.Node
+	issues []ConfigIssue
+}
+
+// add records an issue at the position of path or its closest present parent
+func (v *configValidator) add(path, format string, args ...interface{}) {
+	issue := ConfigIssue{Path: path, Message: fmt.Sprintf(format, args...)}
+	for p := path; p != ""; p = parentPath(p) {
+		if node, ok := v.nodes[p]; ok {
+			issue.Line, issue.Column = node.Line, node.Column
+			break
+		}
+	}
+	v.issues = append(v.issues, issue)
+}
+
+// err returns the collected issues sorted by line, or nil
+func (v *configValidator) err() error {
+	if len(v.issues) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		return v.issues[i].Line < v.issues[j].Line
+	})
+	return &ConfigError{Issues: v.issues}
+}
+
+// parentPath returns "a.b" for "a.b.c" and "a.b" for "a.b[2]"
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+var (
+	envPattern   = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+	yamlLineExpr = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+)
+
+// expandEnv expands ${VAR} references in every scalar value
+func (v *configValidator) expandEnv(node *yaml.Node, lookup func(string) (string, bool)) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			v.expandEnv(node.Content[i], lookup)
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			v.expandEnv(child, lookup)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		node.Value = envPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			m := envPattern.FindStringSubmatch(ref)
+			hasDefault := strings.Contains(ref, ":-")
+			// As in the shell, ${VAR:-default} also replaces an empty value
+			if value, ok := lookup(m[1]); ok && (value != "" || !hasDefault) {
+				return value
+			}
+			if hasDefault {
+				return m[2]
+			}
+			v.issues = append(v.issues, ConfigIssue{
+				Line:    node.Line,
+				Column:  node.Column,
+				Message: fmt.Sprintf("environment variable %s is not set", m[1]),
+			})
+			return ""
+		})
+		// Let plain scalars such as "${PORT}" resolve to their expanded type
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkKeys records the node of every known key and reports unknown ones
+func (v *configValidator) checkKeys(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := joinPath(path, key.Value)
+			v.nodes[child] = key
+			field, ok := fields[key.Value]
+			if !ok {
+				v.add(child, "unknown key %q", key.Value)
+				continue
+			}
+			v.checkKeys(value, field.Type, child)
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			v.nodes[child] = item
+			v.checkKeys(item, t.Elem(), child)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := joinPath(path, node.Content[i].Value)
+			v.nodes[child] = node.Content[i]
+			v.checkKeys(node.Content[i+1], t.Elem(), child)
+		}
+	}
+}
+
+// yamlFields maps YAML key names to struct fields
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// yamlIssue converts a yaml.v3 message such as "line 3: cannot unmarshal ..." into an issue
+func yamlIssue(msg string) ConfigIssue {
+	if m := yamlLineExpr.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return ConfigIssue{Line: line, Message: m[2]}
+	}
+	return ConfigIssue{Message: strings.TrimPrefix(msg, "yaml: ")}
+}
+
+// validate checks the values of the merchant config
+func (v *configValidator) validate(m *Merchant) {
+	if m.ID == "" {
+		v.add("id", "is required")
+	}
+	if m.Name == "" {
+		v.add("name", "is required")
+	}
+	if m.Domain != "" && !validHostname(m.Domain) {
+		v.add("domain", "must be a host name such as example.com, got %q", m.Domain)
+	}
+
+	switch m.Security.RequestEnforcement {
+	case EnforcementStrict, EnforcementOrigin, EnforcementMonitor:
+	default:
+		v.add("security.request_enforcement", "must be one of strict, origin, monitor, got %q", m.Security.RequestEnforcement)
+	}
+	if m.Security.RateLimit < 1 {
+		v.add("security.rate_limit", "must be at least 1, got %d", m.Security.RateLimit)
+	}
+	for i, origin := range m.Security.CORS.Origins {
+		if err := validateOrigin(origin); err != nil {
+			v.add(fmt.Sprintf("security.cors.origins[%d]", i), "%v", err)
+		}
+	}
+
+	y := &m.Yandex
+	if y.MerchantID == "" {
+		v.add("yandex.merchant_id", "is required")
+	}
+	if y.SecretKey == "" && !y.SandboxMode {
+		v.add("yandex.secret_key", "is required outside sandbox mode")
+	}
+	if !IsKnownCurrency(y.Currency) {
+		v.add("yandex.currency", "unknown ISO 4217 currency %q", y.Currency)
+	}
+	if y.APIBaseURL != "" && !validHTTPURL(y.APIBaseURL) {
+		v.add("yandex.api_base_url", "must be an absolute http(s) URL, got %q", y.APIBaseURL)
+	}
+	if y.JWKSEndpoint != "" && !validHTTPURL(y.JWKSEndpoint) {
+		v.add("yandex.jwks_endpoint", "must be an absolute http(s) URL, got %q", y.JWKSEndpoint)
+	}
+	if e := y.OrdersEndpoint; e != "" && !strings.HasPrefix(e, "/") && !validHTTPURL(e) {
+		v.add("yandex.orders_endpoint", "must be a path starting with / or an absolute http(s) URL, got %q", e)
+	}
+
+	tg := &m.Notifications.Telegram
+	if tg.Enabled {
+		if tg.ChatID == "" {
+			v.add("notifications.telegram.chat_id", "is required when telegram is enabled")
+		}
+		if tg.BotToken == "" {
+			v.add("notifications.telegram.bot_token", "is required when telegram is enabled")
+		}
+	}
+	if tg.APIBaseURL != "" && !validHTTPURL(tg.APIBaseURL) {
+		v.add("notifications.telegram.api_base_url", "must be an absolute http(s) URL, got %q", tg.APIBaseURL)
+	}
+
+	email := &m.Notifications.Email
+	// 0 means unset in a config built in code; in a document it was written
+	// explicitly and is as invalid as any other port out of range
+	_, portSet := v.nodes["notifications.email.smtp_port"]
+	if (portSet || email.SMTPPort != 0) && (email.SMTPPort < 1 || email.SMTPPort > 65535) {
+		v.add("notifications.email.smtp_port", "must be between 1 and 65535, got %d", email.SMTPPort)
+	}
+	switch email.TLS {
+	case "", SMTPTLSStartTLS, SMTPTLSImplicit, SMTPTLSNone:
+	default:
+		v.add("notifications.email.tls", "must be one of %s, %s, %s, got %q", SMTPTLSStartTLS, SMTPTLSImplicit, SMTPTLSNone, email.TLS)
+	}
+	for i, to := range email.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			v.add(fmt.Sprintf("notifications.email.to[%d]", i), "must be an email address, got %q", to)
+		}
+	}
+
+	for typ, tmpl := range m.Notifications.Templates {
+		path := "notifications.templates." + string(typ)
+		if !typ.IsValid() {
+			v.add(path, "unknown notification type %q", typ)
+		}
+		switch tmpl.ParseMode {
+		case "", ParseModeHTML, ParseModeMarkdownV2, ParseModeText:
+		default:
+			v.add(path+".parse_mode", "must be one of %s, %s, %s, got %q", ParseModeHTML, ParseModeMarkdownV2, ParseModeText, tmpl.ParseMode)
+		}
+	}
+	for i := range m.Notifications.Rules {
+		v.validateRule(m, fmt.Sprintf("notifications.rules[%d]", i), &m.Notifications.Rules[i])
+	}
+	digest := &m.Notifications.Digest
+	if digest.Timezone != "" {
+		if _, err := time.LoadLocation(digest.Timezone); err != nil {
+			v.add("notifications.digest.timezone", "unknown time zone %q", digest.Timezone)
+		}
+	}
+	if digest.DailyAt != "" {
+		if _, err := time.Parse("15:04", digest.DailyAt); err != nil {
+			v.add("notifications.digest.daily_at", "must be a time such as 09:00, got %q", digest.DailyAt)
+		}
+	}
+	if digest.MaxPayments < 0 {
+		v.add("notifications.digest.max_payments", "must not be negative, got %d", digest.MaxPayments)
+	}
+	if email.Enabled {
+		if email.SMTPHost == "" {
+			v.add("notifications.email.smtp_host", "is required when email is enabled")
+		}
+		// Only reachable without defaults, e.g. from ValidateMerchant
+		if email.SMTPPort == 0 && !portSet {
+			v.add("notifications.email.smtp_port", "is required when email is enabled")
+		}
+		if _, err := mail.ParseAddress(email.From); err != nil {
+			v.add("notifications.email.from", "must be an email address, got %q", email.From)
+		}
+	}
+}
+
+// validateRule checks a notification routing rule
+func (v *configValidator) validateRule(m *Merchant, path string, rule *NotificationRule) {
+	for i, typ := range rule.Types {
+		if !typ.IsValid() {
+			v.add(fmt.Sprintf("%s.types[%d]", path, i), "unknown notification type %q", typ)
+		}
+	}
+	for i, ch := range rule.Channels {
+		p := fmt.Sprintf("%s.channels[%d]", path, i)
+		switch ch {
+		case NotificationChannelTelegram:
+			if !m.Notifications.Telegram.Enabled {
+				v.add(p, "telegram is not enabled")
+			}
+		case NotificationChannelEmail:
+			if !m.Notifications.Email.Enabled {
+				v.add(p, "email is not enabled")
+			}
+		default:
+			v.add(p, "must be one of %s, %s, got %q", NotificationChannelTelegram, NotificationChannelEmail, ch)
+		}
+	}
+	for i, chatID := range rule.Recipients.Telegram {
+		if strings.TrimSpace(chatID) == "" {
+			v.add(fmt.Sprintf("%s.recipients.telegram[%d]", path, i), "must not be empty")
+		}
+	}
+	for i, to := range rule.Recipients.Email {
+		if _, err := mail.ParseAddress(to); err != nil {
+			v.add(fmt.Sprintf("%s.recipients.email[%d]", path, i), "must be an email address, got %q", to)
+		}
+	}
+
+	var bounds [2]*Money
+	for i, amount := range []string{rule.MinAmount, rule.MaxAmount} {
+		if amount == "" {
+			continue
+		}
+		key := [2]string{"min_amount", "max_amount"}[i]
+		parsed, err := ParseMoney(amount, m.Yandex.Currency)
+		if err != nil || parsed.IsNegative() {
+			v.add(path+"."+key, "must be a non-negative decimal such as 1000.00, got %q", amount)
+			continue
+		}
+		bounds[i] = &parsed
+	}
+	if bounds[0] != nil && bounds[1] != nil && bounds[0].Minor > bounds[1].Minor {
+		v.add(path+".max_amount", "must not be less than min_amount")
+	}
+
+	if q := rule.QuietHours; !q.IsZero() {
+		start, startErr := time.Parse("15:04", q.Start)
+		if startErr != nil {
+			v.add(path+".quiet_hours.start", "must be a time such as 22:00, got %q", q.Start)
+		}
+		end, endErr := time.Parse("15:04", q.End)
+		if endErr != nil {
+			v.add(path+".quiet_hours.end", "must be a time such as 08:00, got %q", q.End)
+		}
+		if startErr == nil && endErr == nil && start.Equal(end) {
+			v.add(path+".quiet_hours.end", "must differ from start")
+		}
+	}
+	if tz := rule.QuietHours.Timezone; tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			v.add(path+".quiet_hours.timezone", "unknown time zone %q", tz)
+		}
+	}
+
+	if limit := rule.RateLimit; !limit.IsZero() {
+		if limit.Max < 1 {
+			v.add(path+".rate_limit.max", "must be at least 1, got %d", limit.Max)
+		}
+		if limit.Period != "" {
+			if d, err := time.ParseDuration(limit.Period); err != nil || d <= 0 {
+				v.add(path+".rate_limit.period", "must be a positive duration such as 10m or 1h, got %q", limit.Period)
+			}
+		}
+	}
+
+	switch rule.Digest {
+	case "", DigestHourly, DigestDaily:
+	default:
+		v.add(path+".digest", "must be one of %s, %s, got %q", DigestHourly, DigestDaily, rule.Digest)
+	}
+}
+
+var hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// validHostname reports whether s is a host name or IP address without scheme or port
+func validHostname(s string) bool {
+	return net.ParseIP(s) != nil || (len(s) <= 253 && hostnamePattern.MatchString(s))
+}
+
+// validHTTPURL reports whether s is an absolute http or https URL
+func validHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validateOrigin checks a CORS origin: "*" or scheme://host[:port] without a path
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be \"*\" or an http(s) origin such as https://example.com, got %q", origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("origin must not contain a path, query or credentials, got %q", origin)
+	}
+	if !validHostname(u.Hostname()) {
+		return fmt.Errorf("invalid origin host in %q", origin)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package yapay
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEnv(vars map[string]string) LoadOption {
+	return WithEnvLookup(func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	})
+}
+
+// issueAt returns the issue for path, failing the test if there is none
+func issueAt(t *testing.T, err error, path string) ConfigIssue {
+	t.Helper()
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr), "expected *ConfigError, got %v", err)
+	for _, issue := range configErr.Issues {
+		if issue.Path == path {
+			return issue
+		}
+	}
+	t.Fatalf("no issue for %s in:\n%v", path, err)
+	return ConfigIssue{}
+}
+
+func TestParseMerchantConfig(t *testing.T) {
+	data := []byte(`
+id: shop
+name: Shop
+domain: shop.example.com
+sandbox_mode: true
+security:
+  cors:
+    origins: ["https://shop.example.com", "http://localhost:3000"]
+yandex:
+  merchant_id: ${YANDEX_MERCHANT_ID}
+  secret_key: "${YANDEX_SECRET_KEY:-}"
+notifications:
+  email:
+    enabled: true
+    smtp_host: smtp.example.com
+    smtp_port: ${SMTP_PORT:-2525}
+    from: "Shop <noreply@example.com>"
+    password: "$${NOT_EXPANDED}"
+`)
+
+	merchant, err := ParseMerchantConfig(data, testEnv(map[string]string{"YANDEX_MERCHANT_ID": "m-1"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "m-1", merchant.Yandex.MerchantID)
+	assert.Empty(t, merchant.Yandex.SecretKey)
+	assert.Equal(t, 2525, merchant.Notifications.Email.SMTPPort)
+	assert.Equal(t, "${NOT_EXPANDED}", merchant.Notifications.Email.Password.Reveal())
+
+	// Defaults
+	assert.Equal(t, EnforcementStrict, merchant.Security.RequestEnforcement)
+	assert.Equal(t, DefaultRateLimit, merchant.Security.RateLimit)
+	assert.Equal(t, "RUB", merchant.Yandex.Currency)
+	assert.True(t, merchant.Yandex.SandboxMode, "yandex.sandbox_mode inherits the merchant setting")
+}
+
+func TestParseMerchantConfig_ReportsAllIssues(t *testing.T) {
+	data := []byte(`id: shop
+name: Shop
+cors_origins: ["https://shop.example.com"]
+security:
+  request_enforcement: stirct
+  cors:
+    origins:
+      - https://shop.example.com/
+      - shop.example.com
+yandex:
+  secret_key: ${MISSING_SECRET}
+  currency: RUR
+  jwks_endpoint: /api/jwks
+notifications:
+  telegram:
+    enabled: true
+    chat_id: "123"
+  email:
+    smtp_port: 70000
+    tls: ssl
+    to: [owner@example.com, owner]
+`)
+
+	_, err := ParseMerchantConfig(data, testEnv(nil))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"cors_origins", 3},
+		{"security.request_enforcement", 5},
+		{"security.cors.origins[0]", 8},
+		{"security.cors.origins[1]", 9},
+		{"yandex.merchant_id", 10}, // missing, reported at its parent
+		{"yandex.currency", 12},
+		{"yandex.jwks_endpoint", 13},
+		{"notifications.telegram.bot_token", 15},
+		{"notifications.email.smtp_port", 19},
+		{"notifications.email.tls", 20},
+		{"notifications.email.to[1]", 21},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.line, issueAt(t, err, tt.path).Line, tt.path)
+	}
+
+	assert.Contains(t, err.Error(), "line 11: environment variable MISSING_SECRET is not set")
+	assert.Contains(t, err.Error(), `line 5: security.request_enforcement: must be one of strict, origin, monitor, got "stirct"`)
+}
+
+func TestParseMerchantConfig_ExplicitZero(t *testing.T) {
+	data := []byte(`id: shop
+name: Shop
+security:
+  rate_limit: 0
+yandex:
+  merchant_id: m-1
+notifications:
+  email:
+    smtp_port: 0
+`)
+
+	_, err := ParseMerchantConfig(data, testEnv(nil))
+	require.Error(t, err)
+	assert.Equal(t, 4, issueAt(t, err, "security.rate_limit").Line)
+	issue := issueAt(t, err, "notifications.email.smtp_port")
+	assert.Equal(t, 9, issue.Line)
+	assert.Equal(t, "must be between 1 and 65535, got 0", issue.Message)
+}
+
+func TestParseMerchantConfig_TypeErrors(t *testing.T) {
+	data := []byte(`id: shop
+name: Shop
+security:
+  rate_limit: lots
+yandex:
+  merchant_id: m-1
+  sandbox_mode: true
+`)
+	_, err := ParseMerchantConfig(data)
+	require.Error(t, err)
+
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	require.NotEmpty(t, configErr.Issues)
+	assert.Equal(t, 4, configErr.Issues[0].Line)
+	assert.Contains(t, configErr.Issues[0].Message, "cannot unmarshal")
+
+	_, err = ParseMerchantConfig([]byte("id: [unclosed"))
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
+
+func TestParseMerchantConfig_NotificationTemplates(t *testing.T) {
+	data := []byte(`id: shop
+name: Shop
+sandbox_mode: true
+yandex:
+  merchant_id: m-1
+notifications:
+  templates:
+    payment_success:
+      subject: "Paid {{.PaymentID}}"
+      parse_mode: MarkdownV2
+    refund:
+      text: "x"
+    payment_failed:
+      parse_mode: markdown
+`)
+	_, err := ParseMerchantConfig(data)
+	require.Error(t, err)
+	assert.Equal(t, 11, issueAt(t, err, "notifications.templates.refund").Line)
+	assert.Equal(t, 14, issueAt(t, err, "notifications.templates.payment_failed.parse_mode").Line)
+
+	merchant, err := ParseMerchantConfig(data[:bytes.Index(data, []byte("    refund:"))])
+	require.NoError(t, err)
+	tmpl := merchant.Notifications.Templates[NotificationTypePaymentSuccess]
+	assert.Equal(t, "Paid {{.PaymentID}}", tmpl.Subject)
+	assert.Equal(t, ParseModeMarkdownV2, tmpl.ParseMode)
+}
+
+func TestParseMerchantConfig_NotificationRules(t *testing.T) {
+	valid := `id: shop
+name: Shop
+sandbox_mode: true
+yandex:
+  merchant_id: m-1
+notifications:
+  telegram:
+    enabled: true
+    chat_id: "1"
+    bot_token: token
+  rules:
+    - name: ops
+      types: [system_error]
+      recipients:
+        telegram: ["-100200"]
+    - types: [payment_success]
+      min_amount: 1000
+      quiet_hours: {start: "22:00", end: "08:00", timezone: Europe/Moscow}
+      rate_limit: {max: 10}
+`
+	merchant, err := ParseMerchantConfig([]byte(valid))
+	require.NoError(t, err)
+	rules := merchant.Notifications.Rules
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"-100200"}, rules[0].Recipients.Telegram)
+	assert.Equal(t, "1000", rules[1].MinAmount)
+	assert.Equal(t, DefaultNotificationRatePeriod, rules[1].RateLimit.Period)
+
+	_, err = ParseMerchantConfig([]byte(valid + `    - types: [refund]
+      channels: [email, sms]
+      recipients:
+        email: [ops]
+      min_amount: "100"
+      max_amount: "10.001"
+      quiet_hours: {start: "22:00", end: "22:00", timezone: Mars/Olympus}
+      rate_limit: {max: 0, period: soon}
+      digest: weekly
+`))
+	require.Error(t, err)
+	for path, line := range map[string]int{
+		"notifications.rules[2].types[0]":             20,
+		"notifications.rules[2].channels[0]":          21,
+		"notifications.rules[2].channels[1]":          21,
+		"notifications.rules[2].recipients.email[0]":  23,
+		"notifications.rules[2].max_amount":           25,
+		"notifications.rules[2].quiet_hours.end":      26,
+		"notifications.rules[2].quiet_hours.timezone": 26,
+		"notifications.rules[2].rate_limit.max":       27,
+		"notifications.rules[2].rate_limit.period":    27,
+		"notifications.rules[2].digest":               28,
+	} {
+		assert.Equal(t, line, issueAt(t, err, path).Line, path)
+	}
+}
+
+func TestParseMerchantConfig_NotificationDigest(t *testing.T) {
+	data := []byte(`id: shop
+name: Shop
+sandbox_mode: true
+yandex:
+  merchant_id: m-1
+notifications:
+  digest:
+    timezone: Europe/Moscow
+    daily_at: "09:00"
+`)
+	merchant, err := ParseMerchantConfig(data)
+	require.NoError(t, err)
+	assert.Equal(t, "09:00", merchant.Notifications.Digest.DailyAt)
+
+	data = bytes.Replace(data, []byte("Europe/Moscow"), []byte("Moscow"), 1)
+	data = bytes.Replace(data, []byte(`"09:00"`), []byte(`"9am"`), 1)
+	_, err = ParseMerchantConfig(append(data, "    max_payments: -1\n"...))
+	require.Error(t, err)
+	assert.Equal(t, 8, issueAt(t, err, "notifications.digest.timezone").Line)
+	assert.Equal(t, 9, issueAt(t, err, "notifications.digest.daily_at").Line)
+	assert.Equal(t, 10, issueAt(t, err, "notifications.digest.max_payments").Line)
+}
+
+func TestLoadMerchantConfig(t *testing.T) {
+	// The example plugin config must stay valid
+	merchant, err := LoadMerchantConfig(filepath.Join("examples", "simple-plugin", "config.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "simple-plugin-client", merchant.ID)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("id: shop\n"), 0o600))
+	_, err = LoadMerchantConfig(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path)
+
+	_, err = LoadMerchantConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidConfig))
+}
+
+func TestValidateMerchant(t *testing.T) {
+	merchant := &Merchant{
+		ID:   "shop",
+		Name: "Shop",
+		Security: SecurityConfig{
+			RequestEnforcement: EnforcementMonitor,
+			RateLimit:          10,
+			CORS:               CORSConfig{Origins: []string{"*"}},
+		},
+		Yandex: YandexConfig{MerchantID: "m-1", SecretKey: "key", Currency: "RUB"},
+	}
+	require.NoError(t, ValidateMerchant(merchant))
+
+	merchant.Yandex.APIBaseURL = "ftp://example.com"
+	assert.Equal(t, 0, issueAt(t, ValidateMerchant(merchant), "yandex.api_base_url").Line)
+
+	// No defaults are applied, so an enabled email channel needs a port
+	merchant.Yandex.APIBaseURL = ""
+	merchant.Notifications.Email = EmailConfig{Enabled: true, SMTPHost: "smtp.example.com", From: "shop@example.com"}
+	issue := issueAt(t, ValidateMerchant(merchant), "notifications.email.smtp_port")
+	assert.Equal(t, "is required when email is enabled", issue.Message)
+}
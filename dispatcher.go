@@ -0,0 +1,361 @@
+package yapay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Webhook event types sent by Yandex Pay
+const (
+	WebhookEventOrderStatusUpdated        = "ORDER_STATUS_UPDATED"
+	WebhookEventOperationStatusUpdated    = "OPERATION_STATUS_UPDATED"
+	WebhookEventSubscriptionStatusUpdated = "SUBSCRIPTION_STATUS_UPDATED"
+)
+
+// Operation types and statuses the dispatcher acts on
+const (
+	OperationTypeRefund    = "REFUND"
+	OperationStatusSuccess = "SUCCESS"
+)
+
+// DispatchOutcome describes what the dispatcher did with a webhook
+type DispatchOutcome string
+
+// Dispatch outcomes
+const (
+	// DispatchHandled means a handler method was called and succeeded
+	DispatchHandled DispatchOutcome = "handled"
+	// DispatchDuplicate means the payment already had the reported status or
+	// refund
+	DispatchDuplicate DispatchOutcome = "duplicate"
+	// DispatchIgnored means the event is known but requires no handler call
+	DispatchIgnored DispatchOutcome = "ignored"
+	// DispatchFallback means the event was passed to the fallback hook
+	DispatchFallback DispatchOutcome = "fallback"
+	// DispatchFailed means decoding, verification or the handler failed
+	DispatchFailed DispatchOutcome = "failed"
+)
+
+// DispatchResult reports the outcome of dispatching a webhook
+type DispatchResult struct {
+	Outcome      DispatchOutcome
+	Event        string
+	Webhook      *PaymentWebhook
+	Payment      *Payment
+	Refund       *Refund
+	Subscription *Subscription
+	// Retry reports whether Yandex Pay should deliver the webhook again
+	Retry bool
+	Err   error
+}
+
+// HTTPStatus returns the status code the host should answer Yandex Pay with.
+// Failures that cannot succeed on redelivery are acknowledged with 200 so
+// that Yandex Pay stops retrying; Err still carries the reason for logging.
+func (r *DispatchResult) HTTPStatus() int {
+	if !r.Retry {
+		return http.StatusOK
+	}
+	if status := HTTPStatusOf(r.Err); status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+		return status
+	}
+	return http.StatusServiceUnavailable
+}
+
+// PaymentLookupFunc loads the stored payment for an order. It should return
+// an error with ErrorCodeNotFound when the order is unknown, e.g.
+// fmt.Errorf("order %s: %w", orderID, ErrNotFound).
+type PaymentLookupFunc func(ctx context.Context, merchantID, orderID string) (*Payment, error)
+
+// RefundResolverFunc returns the details of the refund reported by an operation webhook
+type RefundResolverFunc func(ctx context.Context, payment *Payment, operation *OperationWebhookData) (*Refund, error)
+
+// FallbackFunc handles webhooks the dispatcher does not map to a handler method
+type FallbackFunc func(ctx context.Context, webhook *PaymentWebhook) error
+
+// Dispatcher decodes Yandex Pay webhooks and calls the matching handler methods
+type Dispatcher struct {
+	handler        ClientHandlerV2
+	verifier       *WebhookVerifier
+	lookup         PaymentLookupFunc
+	refundResolver RefundResolverFunc
+	fallback       FallbackFunc
+}
+
+// DispatcherOption configures a Dispatcher
+type DispatcherOption func(*Dispatcher)
+
+// WithVerifier makes the dispatcher treat webhook bodies as signed JWTs and
+// verify them. Without a verifier bodies are decoded as plain JSON.
+func WithVerifier(verifier *WebhookVerifier) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.verifier = verifier
+	}
+}
+
+// WithPaymentLookup hydrates payments from storage. When set, status changes
+// are checked against the payment lifecycle and repeated webhooks are reported
+// as duplicates instead of calling the handler again. The looked-up payment
+// is updated only after the handler succeeds, so the caller can persist
+// result.Payment when the outcome is DispatchHandled.
+func WithPaymentLookup(lookup PaymentLookupFunc) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.lookup = lookup
+	}
+}
+
+// WithRefundResolver enables dispatching of refund operations. Refunds also
+// require WithPaymentLookup, since the refundable amount must be known.
+func WithRefundResolver(resolver RefundResolverFunc) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.refundResolver = resolver
+	}
+}
+
+// WithFallback sets the hook for events the dispatcher cannot map
+func WithFallback(fallback FallbackFunc) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.fallback = fallback
+	}
+}
+
+// NewDispatcher creates a dispatcher for the handler. Use AdaptHandler to
+// dispatch to a v1 ClientHandler.
+func NewDispatcher(handler ClientHandlerV2, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{handler: handler}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Dispatch decodes the raw webhook body and calls the matching handler method.
+//
+// Order status updates are mapped to HandlePaymentCreated (created, pending),
+// HandlePaymentSuccess, HandlePaymentFailed and HandlePaymentCanceled.
+// Refunds are dispatched from successful REFUND operations, and subscription
+// updates through DispatchSubscription. Anything else goes to the fallback hook.
+func (d *Dispatcher) Dispatch(ctx context.Context, body []byte) *DispatchResult {
+	webhook, err := d.decode(ctx, body)
+	if err != nil {
+		return d.fail(&DispatchResult{}, err)
+	}
+	result := &DispatchResult{Event: webhook.Event, Webhook: webhook}
+
+	switch {
+	case webhook.Event == WebhookEventOrderStatusUpdated && webhook.Order != nil:
+		return d.dispatchOrder(ctx, result)
+	case webhook.Event == WebhookEventOperationStatusUpdated && webhook.Operation != nil:
+		return d.dispatchOperation(ctx, result)
+	case webhook.Event == WebhookEventSubscriptionStatusUpdated && webhook.Subscription != nil:
+		return d.dispatchSubscription(ctx, result)
+	default:
+		return d.dispatchFallback(ctx, result)
+	}
+}
+
+// decode verifies or parses the webhook body
+func (d *Dispatcher) decode(ctx context.Context, body []byte) (*PaymentWebhook, error) {
+	if d.verifier != nil {
+		return d.verifier.Verify(ctx, body)
+	}
+
+	var webhook PaymentWebhook
+	if err := json.Unmarshal(bytes.TrimSpace(body), &webhook); err != nil {
+		return nil, webhookError(ErrorCodeValidation, ErrWebhookMalformed, "invalid payload: %v", err)
+	}
+	if webhook.Event == "" {
+		return nil, webhookError(ErrorCodeValidation, ErrWebhookMalformed, "event is missing")
+	}
+	return &webhook, nil
+}
+
+// dispatchOrder handles ORDER_STATUS_UPDATED
+func (d *Dispatcher) dispatchOrder(ctx context.Context, result *DispatchResult) *DispatchResult {
+	order := result.Webhook.Order
+	status, err := ParsePaymentStatus(order.PaymentStatus)
+	if err != nil {
+		return d.fail(result, WrapError(ErrorCodeValidation, err, "invalid order payment status").
+			WithDetail("order.paymentStatus", "Unknown status"))
+	}
+
+	// Refunds are reported with amounts by REFUND operations; the order
+	// status update that accompanies them carries nothing to act on
+	if status == PaymentStatusRefunded || status == PaymentStatusPartiallyRefunded {
+		result.Outcome = DispatchIgnored
+		return result
+	}
+
+	payment, err := d.loadPayment(ctx, result.Webhook.MerchantID, order.OrderID)
+	if err != nil {
+		return d.fail(result, err)
+	}
+	result.Payment = payment
+
+	// The handler sees the new status, but a stored payment keeps the old one
+	// until the handler succeeds, so a failed webhook is not taken for a
+	// duplicate when it is redelivered
+	updated := *payment
+	if d.lookup == nil {
+		updated.Status = status
+		updated.UpdatedAt = result.Webhook.EventTime
+	} else {
+		if payment.Status == status {
+			result.Outcome = DispatchDuplicate
+			return result
+		}
+		if err := updated.TransitionTo(status); err != nil {
+			return d.fail(result, err)
+		}
+	}
+
+	switch status {
+	case PaymentStatusCreated, PaymentStatusPending:
+		err = d.handler.HandlePaymentCreated(ctx, &updated)
+	case PaymentStatusSuccess:
+		err = d.handler.HandlePaymentSuccess(ctx, &updated)
+	case PaymentStatusFailed:
+		err = d.handler.HandlePaymentFailed(ctx, &updated)
+	case PaymentStatusCanceled:
+		err = d.handler.HandlePaymentCanceled(ctx, &updated)
+	}
+	if err == nil {
+		*payment = updated
+	}
+	return d.finish(result, err)
+}
+
+// dispatchOperation handles OPERATION_STATUS_UPDATED. Only successful
+// refunds are dispatched; other operations are reflected in order updates.
+// A refund without an ID takes the operation ID, and refunds already
+// recorded in Payment.RefundIDs are reported as duplicates.
+func (d *Dispatcher) dispatchOperation(ctx context.Context, result *DispatchResult) *DispatchResult {
+	operation := result.Webhook.Operation
+	if !strings.EqualFold(operation.OperationType, OperationTypeRefund) {
+		result.Outcome = DispatchIgnored
+		return result
+	}
+	if !strings.EqualFold(operation.Status, OperationStatusSuccess) {
+		result.Outcome = DispatchIgnored
+		return result
+	}
+	if d.lookup == nil || d.refundResolver == nil {
+		return d.dispatchFallback(ctx, result)
+	}
+
+	payment, err := d.loadPayment(ctx, result.Webhook.MerchantID, operation.OrderID)
+	if err != nil {
+		return d.fail(result, err)
+	}
+	result.Payment = payment
+	if payment.HasRefund(operation.OperationID) || payment.HasRefund(operation.ExternalOperationID) {
+		result.Outcome = DispatchDuplicate
+		return result
+	}
+
+	refund, err := d.refundResolver(ctx, payment, operation)
+	if err != nil {
+		return d.fail(result, backendError(err, "failed to resolve refund"))
+	}
+	if refund.ID == "" {
+		refund.ID = operation.OperationID
+	}
+	if refund.ExternalOperationID == "" {
+		refund.ExternalOperationID = operation.ExternalOperationID
+	}
+	result.Refund = refund
+	if payment.HasRefund(refund.ID) {
+		result.Outcome = DispatchDuplicate
+		return result
+	}
+
+	return d.finish(result, DispatchRefund(ctx, d.handler, payment, refund))
+}
+
+// dispatchSubscription handles SUBSCRIPTION_STATUS_UPDATED
+func (d *Dispatcher) dispatchSubscription(ctx context.Context, result *DispatchResult) *DispatchResult {
+	sub, err := SubscriptionFromWebhook(result.Webhook)
+	if err != nil {
+		return d.fail(result, err)
+	}
+	result.Subscription = sub
+
+	handled, err := DispatchSubscription(ctx, d.handler, sub)
+	if !handled {
+		return d.dispatchFallback(ctx, result)
+	}
+	return d.finish(result, err)
+}
+
+// dispatchFallback passes the webhook to the fallback hook, if any
+func (d *Dispatcher) dispatchFallback(ctx context.Context, result *DispatchResult) *DispatchResult {
+	if d.fallback == nil {
+		result.Outcome = DispatchIgnored
+		return result
+	}
+	if err := d.fallback(ctx, result.Webhook); err != nil {
+		return d.fail(result, err)
+	}
+	result.Outcome = DispatchFallback
+	return result
+}
+
+// loadPayment returns the stored payment, or one built from the webhook without a lookup
+func (d *Dispatcher) loadPayment(ctx context.Context, merchantID, orderID string) (*Payment, error) {
+	if d.lookup == nil {
+		return &Payment{ID: orderID, OrderID: orderID, MerchantID: merchantID}, nil
+	}
+	payment, err := d.lookup(ctx, merchantID, orderID)
+	if err != nil {
+		return nil, backendError(err, "failed to load payment")
+	}
+	return payment, nil
+}
+
+// finish records the handler result
+func (d *Dispatcher) finish(result *DispatchResult, err error) *DispatchResult {
+	if err != nil {
+		return d.fail(result, err)
+	}
+	result.Outcome = DispatchHandled
+	return result
+}
+
+// fail records err and decides whether the webhook should be redelivered.
+// Typed SDK errors, sentinels included, decide for themselves by their code;
+// untyped plugin errors are retried, since redelivery is the only recovery
+// path for a lost webhook.
+func (d *Dispatcher) fail(result *DispatchResult, err error) *DispatchResult {
+	result.Outcome = DispatchFailed
+	result.Err = err
+
+	switch {
+	case hasErrorCode(err):
+		result.Retry = IsRetryable(err)
+	case errors.Is(err, ErrInvalidTransition):
+		result.Retry = false
+	default:
+		result.Retry = true
+	}
+	return result
+}
+
+// backendError classifies an untyped storage error as a retryable backend failure
+func backendError(err error, msg string) error {
+	if hasErrorCode(err) {
+		return err
+	}
+	return WrapError(ErrorCodeBackendUnavailable, err, msg)
+}
+
+// hasErrorCode reports whether err carries an SDK error code: an *Error or
+// a sentinel such as ErrNotFound, possibly wrapped
+func hasErrorCode(err error) bool {
+	var sdkErr *Error
+	var c codeError
+	return errors.As(err, &sdkErr) || errors.As(err, &c)
+}
@@ -0,0 +1,297 @@
+package yapay_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	yapaytesting "github.com/metalmon/yapay-sdk/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingHandler overrides HandlePaymentSuccess to return a fixed error
+type failingHandler struct {
+	yapay.ClientHandlerV2
+	err error
+}
+
+func (h *failingHandler) HandlePaymentSuccess(_ context.Context, _ *yapay.Payment) error {
+	return h.err
+}
+
+func orderWebhook(status string) []byte {
+	return []byte(`{
+		"event": "ORDER_STATUS_UPDATED",
+		"eventTime": "2025-09-15T10:00:00Z",
+		"merchantId": "merchant-1",
+		"order": {"orderId": "test-order-id", "paymentStatus": "` + status + `"}
+	}`)
+}
+
+func TestDispatcher_OrderStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		method string
+	}{
+		{"PENDING", "HandlePaymentCreated"},
+		{"CAPTURED", "HandlePaymentSuccess"},
+		{"FAILED", "HandlePaymentFailed"},
+		{"VOIDED", "HandlePaymentCanceled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			mock := yapaytesting.NewMockClientHandler()
+			dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(mock))
+
+			result := dispatcher.Dispatch(context.Background(), orderWebhook(tt.status))
+			require.NoError(t, result.Err)
+			assert.Equal(t, yapay.DispatchHandled, result.Outcome)
+			assert.False(t, result.Retry)
+			assert.Equal(t, http.StatusOK, result.HTTPStatus())
+			assert.Equal(t, "test-order-id", result.Payment.OrderID)
+			assert.Equal(t, "merchant-1", result.Payment.MerchantID)
+			assert.Equal(t, 1, mock.GetCallCounts()[tt.method])
+		})
+	}
+}
+
+func TestDispatcher_PaymentLookup(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	stored := testData.CreateTestPayment()
+	lookup := func(_ context.Context, _, orderID string) (*yapay.Payment, error) {
+		if orderID != stored.OrderID {
+			return nil, yapay.NewError(yapay.ErrorCodeNotFound, "order not found")
+		}
+		return stored, nil
+	}
+
+	mock := yapaytesting.NewMockClientHandler()
+	dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(mock), yapay.WithPaymentLookup(lookup))
+	ctx := context.Background()
+
+	result := dispatcher.Dispatch(ctx, orderWebhook("CAPTURED"))
+	require.NoError(t, result.Err)
+	assert.Same(t, stored, result.Payment)
+	assert.Equal(t, yapay.PaymentStatusSuccess, stored.Status)
+
+	// A redelivered webhook does not call the handler again
+	result = dispatcher.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.Equal(t, yapay.DispatchDuplicate, result.Outcome)
+	assert.Equal(t, 1, mock.GetCallCounts()["HandlePaymentSuccess"])
+
+	// Illegal transitions are reported but not retried
+	result = dispatcher.Dispatch(ctx, orderWebhook("VOIDED"))
+	assert.True(t, errors.Is(result.Err, yapay.ErrInvalidTransition))
+	assert.False(t, result.Retry)
+	assert.Equal(t, 0, mock.GetCallCounts()["HandlePaymentCanceled"])
+
+	// Storage failures are retried
+	failing := yapay.NewDispatcher(yapay.AdaptHandler(mock), yapay.WithPaymentLookup(
+		func(context.Context, string, string) (*yapay.Payment, error) {
+			return nil, errors.New("connection refused")
+		}))
+	result = failing.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.True(t, result.Retry)
+	assert.Equal(t, http.StatusServiceUnavailable, result.HTTPStatus())
+}
+
+func TestDispatcher_SentinelErrors(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		err    error
+		code   yapay.ErrorCode
+		retry  bool
+		status int
+	}{
+		{"not found", fmt.Errorf("order x: %w", yapay.ErrNotFound), yapay.ErrorCodeNotFound, false, http.StatusOK},
+		{"validation", yapay.ErrValidation, yapay.ErrorCodeValidation, false, http.StatusOK},
+		{"wrapped validation", fmt.Errorf("unknown product: %w", yapay.ErrValidation), yapay.ErrorCodeValidation, false, http.StatusOK},
+		{"rate limited", fmt.Errorf("crm: %w", yapay.ErrRateLimited), yapay.ErrorCodeRateLimited, true, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Returned by the payment lookup
+			lookup := yapay.NewDispatcher(yapay.AdaptHandler(mock), yapay.WithPaymentLookup(
+				func(context.Context, string, string) (*yapay.Payment, error) {
+					return nil, tt.err
+				}))
+			result := lookup.Dispatch(ctx, orderWebhook("CAPTURED"))
+			assert.Equal(t, yapay.DispatchFailed, result.Outcome)
+			assert.Equal(t, tt.code, yapay.ErrorCodeOf(result.Err))
+			assert.True(t, errors.Is(result.Err, tt.err))
+			assert.Equal(t, tt.retry, result.Retry)
+			assert.Equal(t, tt.status, result.HTTPStatus())
+
+			// Returned by the handler
+			handler := yapay.NewDispatcher(&failingHandler{ClientHandlerV2: yapay.AdaptHandler(mock), err: tt.err})
+			result = handler.Dispatch(ctx, orderWebhook("CAPTURED"))
+			assert.Equal(t, tt.code, yapay.ErrorCodeOf(result.Err))
+			assert.Equal(t, tt.retry, result.Retry)
+			assert.Equal(t, tt.status, result.HTTPStatus())
+		})
+	}
+}
+
+func TestDispatcher_HandlerFailureKeepsStatus(t *testing.T) {
+	stored := yapaytesting.NewTestData().CreateTestPayment()
+	lookup := func(context.Context, string, string) (*yapay.Payment, error) {
+		return stored, nil
+	}
+	mock := yapaytesting.NewMockClientHandler()
+	handler := &failingHandler{ClientHandlerV2: yapay.AdaptHandler(mock), err: errors.New("db down")}
+	dispatcher := yapay.NewDispatcher(handler, yapay.WithPaymentLookup(lookup))
+	ctx := context.Background()
+
+	result := dispatcher.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.Equal(t, yapay.DispatchFailed, result.Outcome)
+	assert.True(t, result.Retry)
+	assert.Equal(t, yapay.PaymentStatusCreated, stored.Status)
+
+	// The redelivered webhook reaches the handler again instead of being a duplicate
+	handler.err = nil
+	result = dispatcher.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.Equal(t, yapay.DispatchHandled, result.Outcome)
+	assert.Equal(t, yapay.PaymentStatusSuccess, stored.Status)
+}
+
+func TestDispatcher_HandlerErrors(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	ctx := context.Background()
+
+	untyped := yapay.NewDispatcher(&failingHandler{ClientHandlerV2: yapay.AdaptHandler(mock), err: errors.New("db down")})
+	result := untyped.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.Equal(t, yapay.DispatchFailed, result.Outcome)
+	assert.True(t, result.Retry)
+	assert.Equal(t, http.StatusInternalServerError, result.HTTPStatus())
+
+	typed := yapay.NewDispatcher(&failingHandler{
+		ClientHandlerV2: yapay.AdaptHandler(mock),
+		err:             yapay.NewError(yapay.ErrorCodeValidation, "unknown product"),
+	})
+	result = typed.Dispatch(ctx, orderWebhook("CAPTURED"))
+	assert.True(t, errors.Is(result.Err, yapay.ErrValidation))
+	assert.False(t, result.Retry)
+	assert.Equal(t, http.StatusOK, result.HTTPStatus())
+}
+
+func TestDispatcher_MalformedWebhook(t *testing.T) {
+	dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(yapaytesting.NewMockClientHandler()))
+
+	for _, body := range []string{`not json`, `{}`, `{"event":"ORDER_STATUS_UPDATED","order":{"paymentStatus":"WHATEVER"}}`} {
+		result := dispatcher.Dispatch(context.Background(), []byte(body))
+		assert.Equal(t, yapay.DispatchFailed, result.Outcome, body)
+		assert.True(t, errors.Is(result.Err, yapay.ErrValidation), body)
+		assert.False(t, result.Retry, body)
+	}
+}
+
+func TestDispatcher_Fallback(t *testing.T) {
+	var received []string
+	fallback := func(_ context.Context, webhook *yapay.PaymentWebhook) error {
+		received = append(received, webhook.Event)
+		return nil
+	}
+	dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(yapaytesting.NewMockClientHandler()), yapay.WithFallback(fallback))
+	ctx := context.Background()
+
+	result := dispatcher.Dispatch(ctx, []byte(`{"event":"SOMETHING_NEW","merchantId":"merchant-1"}`))
+	assert.Equal(t, yapay.DispatchFallback, result.Outcome)
+
+	// The mock has no subscription support, so subscription updates fall back too
+	result = dispatcher.Dispatch(ctx, []byte(`{
+		"event": "SUBSCRIPTION_STATUS_UPDATED",
+		"subscription": {"customerSubscriptionId": "sub-1", "status": "ACTIVE", "subscriptionPlanId": "plan-1"}
+	}`))
+	assert.Equal(t, yapay.DispatchFallback, result.Outcome)
+	require.NotNil(t, result.Subscription)
+	assert.Equal(t, []string{"SOMETHING_NEW", "SUBSCRIPTION_STATUS_UPDATED"}, received)
+
+	// Without a fallback unknown events are acknowledged and ignored
+	plain := yapay.NewDispatcher(yapay.AdaptHandler(yapaytesting.NewMockClientHandler()))
+	result = plain.Dispatch(ctx, []byte(`{"event":"SOMETHING_NEW"}`))
+	assert.Equal(t, yapay.DispatchIgnored, result.Outcome)
+	assert.Equal(t, http.StatusOK, result.HTTPStatus())
+}
+
+func TestDispatcher_Refund(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	payment := testData.CreateTestPayment()
+	payment.Status = yapay.PaymentStatusSuccess
+
+	mock := yapaytesting.NewMockClientHandler()
+	dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(mock),
+		yapay.WithPaymentLookup(func(context.Context, string, string) (*yapay.Payment, error) {
+			return payment, nil
+		}),
+		yapay.WithRefundResolver(func(_ context.Context, p *yapay.Payment, op *yapay.OperationWebhookData) (*yapay.Refund, error) {
+			refund := testData.CreateTestRefund(p, 400)
+			refund.ID = op.OperationID
+			return refund, nil
+		}),
+	)
+
+	body := []byte(`{
+		"event": "OPERATION_STATUS_UPDATED",
+		"merchantId": "merchant-1",
+		"operation": {"operationId": "op-1", "operationType": "REFUND", "orderId": "test-order-id", "status": "SUCCESS"}
+	}`)
+	result := dispatcher.Dispatch(context.Background(), body)
+	require.NoError(t, result.Err)
+	assert.Equal(t, yapay.DispatchHandled, result.Outcome)
+	assert.Equal(t, "op-1", result.Refund.ID)
+	assert.Equal(t, yapay.PaymentStatusPartiallyRefunded, payment.Status)
+	assert.Len(t, mock.PartialRefundCalls, 1)
+
+	// A redelivered refund operation is a duplicate
+	result = dispatcher.Dispatch(context.Background(), body)
+	assert.Equal(t, yapay.DispatchDuplicate, result.Outcome)
+	assert.Equal(t, 400, payment.RefundedAmount)
+	assert.Len(t, mock.PartialRefundCalls, 1)
+
+	// The accompanying order update needs no handler call
+	result = dispatcher.Dispatch(context.Background(), orderWebhook("PARTIALLY_REFUNDED"))
+	assert.Equal(t, yapay.DispatchIgnored, result.Outcome)
+}
+
+func TestDispatcher_RefundWithoutID(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	payment := testData.CreateTestPayment()
+	payment.Status = yapay.PaymentStatusSuccess
+	resolved := 0
+
+	mock := yapaytesting.NewMockClientHandler()
+	dispatcher := yapay.NewDispatcher(yapay.AdaptHandler(mock),
+		yapay.WithPaymentLookup(func(context.Context, string, string) (*yapay.Payment, error) {
+			return payment, nil
+		}),
+		yapay.WithRefundResolver(func(_ context.Context, p *yapay.Payment, _ *yapay.OperationWebhookData) (*yapay.Refund, error) {
+			resolved++
+			refund := testData.CreateTestRefund(p, 400)
+			refund.ID = ""
+			return refund, nil
+		}),
+	)
+
+	body := []byte(`{
+		"event": "OPERATION_STATUS_UPDATED",
+		"merchantId": "merchant-1",
+		"operation": {"operationId": "op-2", "operationType": "REFUND", "orderId": "test-order-id", "status": "SUCCESS"}
+	}`)
+	result := dispatcher.Dispatch(context.Background(), body)
+	require.NoError(t, result.Err)
+	assert.Equal(t, []string{"op-2"}, payment.RefundIDs)
+
+	// The operation ID is recognized before the refund is resolved again
+	result = dispatcher.Dispatch(context.Background(), body)
+	assert.Equal(t, yapay.DispatchDuplicate, result.Outcome)
+	assert.Equal(t, 1, resolved)
+	assert.Equal(t, 400, payment.RefundedAmount)
+}
@@ -0,0 +1,212 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is a machine-readable error category shared by the host and plugins
+type ErrorCode string
+
+// Error codes
+const (
+	ErrorCodeValidation         ErrorCode = "validation"
+	ErrorCodePriceMismatch      ErrorCode = "price_mismatch"
+	ErrorCodeBackendUnavailable ErrorCode = "backend_unavailable"
+	ErrorCodeUnauthorized       ErrorCode = "unauthorized"
+	ErrorCodeForbidden          ErrorCode = "forbidden"
+	ErrorCodeNotFound           ErrorCode = "not_found"
+	ErrorCodeConflict           ErrorCode = "conflict"
+	ErrorCodeRateLimited        ErrorCode = "rate_limited"
+	ErrorCodeTimeout            ErrorCode = "timeout"
+	ErrorCodeInternal           ErrorCode = "internal"
+)
+
+// errorCodeStatus maps error codes to HTTP status codes
+var errorCodeStatus = map[ErrorCode]int{
+	ErrorCodeValidation:         http.StatusBadRequest,
+	ErrorCodePriceMismatch:      http.StatusUnprocessableEntity,
+	ErrorCodeBackendUnavailable: http.StatusServiceUnavailable,
+	ErrorCodeUnauthorized:       http.StatusUnauthorized,
+	ErrorCodeForbidden:          http.StatusForbidden,
+	ErrorCodeNotFound:           http.StatusNotFound,
+	ErrorCodeConflict:           http.StatusConflict,
+	ErrorCodeRateLimited:        http.StatusTooManyRequests,
+	ErrorCodeTimeout:            http.StatusGatewayTimeout,
+	ErrorCodeInternal:           http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the HTTP status code for the error code
+func (c ErrorCode) HTTPStatus() int {
+	if status, ok := errorCodeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// IsRetryable reports whether errors with this code are retryable by default
+func (c ErrorCode) IsRetryable() bool {
+	switch c {
+	case ErrorCodeBackendUnavailable, ErrorCodeRateLimited, ErrorCodeTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Error is a structured SDK error. Its JSON form extends the ErrorResponse
+// schema of the payment API: {"error": ..., "code": ..., "details": {...}, "retryable": ...}.
+type Error struct {
+	Code      ErrorCode           `json:"code"`
+	Message   string              `json:"error"`
+	Details   map[string][]string `json:"details,omitempty"`
+	Retryable bool                `json:"retryable"`
+	Err       error               `json:"-"`
+}
+
+// Sentinel errors for errors.Is; an *Error matches the sentinel with the same code
+var (
+	ErrValidation         error = codeError(ErrorCodeValidation)
+	ErrPriceMismatch      error = codeError(ErrorCodePriceMismatch)
+	ErrBackendUnavailable error = codeError(ErrorCodeBackendUnavailable)
+	ErrUnauthorized       error = codeError(ErrorCodeUnauthorized)
+	ErrForbidden          error = codeError(ErrorCodeForbidden)
+	ErrNotFound           error = codeError(ErrorCodeNotFound)
+	ErrConflict           error = codeError(ErrorCodeConflict)
+	ErrRateLimited        error = codeError(ErrorCodeRateLimited)
+	ErrTimeout            error = codeError(ErrorCodeTimeout)
+	ErrInternal           error = codeError(ErrorCodeInternal)
+)
+
+// codeError is the type of the sentinel errors. It carries only a code and
+// has no setters, so the shared sentinels cannot be modified by callers.
+type codeError ErrorCode
+
+// Error implements the error interface
+func (c codeError) Error() string {
+	return string(c)
+}
+
+// NewError creates an error with the given code; retryability follows the code
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{
+		Code:      code,
+		Message:   message,
+		Retryable: code.IsRetryable(),
+	}
+}
+
+// Errorf creates an error with the given code and a formatted message
+func Errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+// WrapError creates an error with the given code that wraps err
+func WrapError(code ErrorCode, err error, message string) *Error {
+	e := NewError(code, message)
+	e.Err = err
+	return e
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = string(e.Code)
+	}
+	// A message taken from the wrapped error, as AsError does for
+	// sentinels, is not repeated
+	if e.Err != nil && e.Err.Error() != msg {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the wrapped error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a sentinel or an *Error with the same code
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case codeError:
+		return ErrorCode(t) == e.Code
+	case *Error:
+		return t.Code == e.Code
+	}
+	return false
+}
+
+// HTTPStatus returns the HTTP status code for the error
+func (e *Error) HTTPStatus() int {
+	return e.Code.HTTPStatus()
+}
+
+// WithDetail adds a field-level message and returns the error for chaining
+func (e *Error) WithDetail(field, message string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string][]string)
+	}
+	e.Details[field] = append(e.Details[field], message)
+	return e
+}
+
+// WithRetryable overrides the retryable flag and returns the error for chaining
+func (e *Error) WithRetryable(retryable bool) *Error {
+	e.Retryable = retryable
+	return e
+}
+
+// AsError converts err into an *Error. Errors that are not SDK errors are
+// classified: context deadlines become timeouts, illegal status transitions
+// become conflicts and everything else is internal.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	// A sentinel returned as is or wrapped with fmt.Errorf; the chain is
+	// kept for errors.Is and errors.As
+	var c codeError
+	if errors.As(err, &c) {
+		return WrapError(ErrorCode(c), err, err.Error())
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return WrapError(ErrorCodeTimeout, err, "deadline exceeded")
+	case errors.Is(err, ErrInvalidTransition):
+		return WrapError(ErrorCodeConflict, err, "invalid payment state")
+	default:
+		return WrapError(ErrorCodeInternal, err, "internal error")
+	}
+}
+
+// ErrorCodeOf returns the error code of err, or an empty code for nil
+func ErrorCodeOf(err error) ErrorCode {
+	if e := AsError(err); e != nil {
+		return e.Code
+	}
+	return ""
+}
+
+// IsRetryable reports whether the operation that returned err may be retried
+func IsRetryable(err error) bool {
+	e := AsError(err)
+	return e != nil && e.Retryable
+}
+
+// HTTPStatusOf returns the HTTP status code for err, or 200 for nil
+func HTTPStatusOf(err error) int {
+	if e := AsError(err); e != nil {
+		return e.HTTPStatus()
+	}
+	return http.StatusOK
+}
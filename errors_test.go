@@ -0,0 +1,105 @@
+package yapay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError_IsAndAs(t *testing.T) {
+	err := fmt.Errorf("validate: %w", NewError(ErrorCodeValidation, "amount must be positive"))
+
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.False(t, errors.Is(err, ErrPriceMismatch))
+
+	var sdkErr *Error
+	require.True(t, errors.As(err, &sdkErr))
+	assert.Equal(t, ErrorCodeValidation, sdkErr.Code)
+	assert.Equal(t, "amount must be positive", sdkErr.Error())
+}
+
+func TestError_SentinelsAreImmutable(t *testing.T) {
+	// Details can only be added to errors built with NewError, never to the
+	// shared sentinels
+	_, ok := ErrRateLimited.(*Error)
+	assert.False(t, ok)
+	assert.Equal(t, "rate_limited", ErrRateLimited.Error())
+
+	err := fmt.Errorf("fetch: %w", ErrRateLimited)
+	sdkErr := AsError(err)
+	assert.Equal(t, ErrorCodeRateLimited, sdkErr.Code)
+	assert.True(t, sdkErr.Retryable)
+	assert.Equal(t, "fetch: rate_limited", sdkErr.Error())
+	assert.True(t, errors.Is(sdkErr, ErrRateLimited))
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.False(t, errors.Is(err, ErrTimeout))
+
+	// The converted error keeps the chain of the original
+	cause := &os.PathError{Op: "open", Path: "orders.json", Err: os.ErrNotExist}
+	err = fmt.Errorf("order x: %w: %w", ErrNotFound, cause)
+	sdkErr = AsError(err)
+	assert.Equal(t, ErrorCodeNotFound, sdkErr.Code)
+	assert.Equal(t, err.Error(), sdkErr.Message)
+	assert.Equal(t, err.Error(), sdkErr.Error())
+	assert.True(t, errors.Is(sdkErr, os.ErrNotExist))
+	var pathErr *os.PathError
+	assert.True(t, errors.As(sdkErr, &pathErr))
+}
+
+func TestError_Wrap(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := WrapError(ErrorCodeBackendUnavailable, cause, "price backend unavailable")
+
+	assert.Equal(t, "price backend unavailable: connection refused", err.Error())
+	assert.True(t, errors.Is(err, cause))
+	assert.True(t, errors.Is(err, ErrBackendUnavailable))
+	assert.True(t, err.Retryable)
+	assert.Equal(t, http.StatusServiceUnavailable, err.HTTPStatus())
+}
+
+func TestError_JSONMatchesErrorResponse(t *testing.T) {
+	err := NewError(ErrorCodeValidation, "Invalid payment request").
+		WithDetail("amount", "Amount must be positive").
+		WithDetail("currency", "Currency is required")
+
+	data, marshalErr := json.Marshal(err)
+	require.NoError(t, marshalErr)
+	assert.JSONEq(t, `{
+		"code": "validation",
+		"error": "Invalid payment request",
+		"details": {
+			"amount": ["Amount must be positive"],
+			"currency": ["Currency is required"]
+		},
+		"retryable": false
+	}`, string(data))
+}
+
+func TestAsError_Classification(t *testing.T) {
+	assert.Nil(t, AsError(nil))
+	assert.Equal(t, ErrorCode(""), ErrorCodeOf(nil))
+	assert.Equal(t, http.StatusOK, HTTPStatusOf(nil))
+
+	assert.Equal(t, ErrorCodeTimeout, ErrorCodeOf(context.DeadlineExceeded))
+	assert.True(t, IsRetryable(context.DeadlineExceeded))
+
+	transitionErr := PaymentStatusCanceled.ValidateTransition(PaymentStatusSuccess)
+	assert.Equal(t, ErrorCodeConflict, ErrorCodeOf(transitionErr))
+	assert.Equal(t, http.StatusConflict, HTTPStatusOf(transitionErr))
+
+	plain := errors.New("boom")
+	assert.Equal(t, ErrorCodeInternal, ErrorCodeOf(plain))
+	assert.False(t, IsRetryable(plain))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatusOf(plain))
+
+	rateLimited := NewError(ErrorCodeRateLimited, "slow down").WithRetryable(false)
+	assert.False(t, IsRetryable(rateLimited))
+	assert.Equal(t, http.StatusTooManyRequests, HTTPStatusOf(rateLimited))
+}
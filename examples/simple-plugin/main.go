@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/metalmon/yapay-sdk"
+	"github.com/metalmon/yapay-sdk/merchantapi"
+	"github.com/metalmon/yapay-sdk/receipt"
 	"github.com/sirupsen/logrus"
 )
 
+// PluginManifest lets the host check SDK compatibility before using the plugin
+var PluginManifest = yapay.NewPluginManifest("simple-plugin", "1.0.0", yapay.CapabilityGenerator)
+
 // Handler represents a simple plugin handler
 type Handler struct {
 	merchant  *yapay.Merchant
@@ -15,9 +21,13 @@ type Handler struct {
 	generator yapay.PaymentLinkGenerator
 }
 
-// NewHandler creates a new handler (required function)
+// NewHandler creates a new handler (required function). The logger is
+// replaced by the host logger in Init.
 func NewHandler(merchant *yapay.Merchant) yapay.ClientHandler {
 	logger := logrus.New()
+	redact := yapay.NewRedactHook()
+	redact.AddMerchantSecrets(merchant)
+	logger.AddHook(redact)
 	logger.WithFields(logrus.Fields{
 		"merchant_id": merchant.Yandex.MerchantID,
 		"name":        merchant.Name,
@@ -89,15 +99,23 @@ func (h *Handler) HandlePaymentCanceled(payment *yapay.Payment) error {
 // ValidateRequest validates payment request
 func (h *Handler) ValidateRequest(req *yapay.PaymentRequest) error {
 	if req.Amount <= 0 {
-		return fmt.Errorf("amount must be positive, got: %d", req.Amount)
+		return yapay.Errorf(yapay.ErrorCodeValidation, "amount must be positive, got: %d", req.Amount).
+			WithDetail("amount", "Amount must be positive")
 	}
 
 	if req.Description == "" {
-		return fmt.Errorf("description is required")
+		return yapay.NewError(yapay.ErrorCodeValidation, "description is required").
+			WithDetail("description", "Description is required")
 	}
 
 	if req.ReturnURL == "" {
-		return fmt.Errorf("return URL is required")
+		return yapay.NewError(yapay.ErrorCodeValidation, "return URL is required").
+			WithDetail("return_url", "Return URL is required")
+	}
+
+	// The cart, if any, must add up to the requested amount
+	if err := req.ValidateCart(); err != nil {
+		return err
 	}
 
 	// Example: Validate against your business rules
@@ -139,6 +157,26 @@ func (h *Handler) SetPaymentLinkGenerator(generator interface{}) {
 	}
 }
 
+// Init adopts the host logger (optional yapay.Initializer)
+func (h *Handler) Init(ctx context.Context, env yapay.PluginEnv) error {
+	if env.Logger != nil {
+		h.logger = env.Logger
+	}
+	// Open database connections or warm caches here
+	return nil
+}
+
+// HealthCheck reports handler health (optional yapay.HealthChecker)
+func (h *Handler) HealthCheck(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close flushes state on shutdown (optional yapay.Closer)
+func (h *Handler) Close(ctx context.Context) error {
+	h.logger.WithField("merchant_id", h.merchant.Yandex.MerchantID).Info("Simple plugin handler closed")
+	return nil
+}
+
 // Example of how to implement payment link generation
 type PaymentGenerator struct {
 	merchant *yapay.Merchant
@@ -163,10 +201,16 @@ func (g *PaymentGenerator) GeneratePaymentData(req *yapay.PaymentRequest) (*yapa
 	// Generate unique order ID
 	orderID := fmt.Sprintf("order_%d_%d", time.Now().Unix(), req.Amount)
 
+	// Format the amount using the currency's minor units
+	value, err := req.Money().Decimal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to format amount: %w", err)
+	}
+
 	// Prepare payment data for Yandex Pay
 	paymentData := map[string]interface{}{
 		"amount": map[string]interface{}{
-			"value":    fmt.Sprintf("%.2f", float64(req.Amount)/100),
+			"value":    value,
 			"currency": req.Currency,
 		},
 		"confirmation": map[string]interface{}{
@@ -177,6 +221,30 @@ func (g *PaymentGenerator) GeneratePaymentData(req *yapay.PaymentRequest) (*yapa
 		"metadata":    req.Metadata,
 	}
 
+	// Pass the cart to Yandex Pay so the buyer sees every item
+	if req.Cart != nil {
+		cart, err := merchantapi.NewCart(req.Cart, req.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build cart: %w", err)
+		}
+
+		// Attach 54-FZ receipt data when the buyer left an email.
+		// Adjust VAT, payment subject and method to your tax setup.
+		if email, err := req.Metadata.GetString("user_email"); err == nil && email != "" {
+			r, err := receipt.NewBuilder(
+				receipt.WithCustomer(receipt.Customer{Email: email}),
+				receipt.WithVAT(receipt.VAT20),
+			).FromPaymentRequest(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build receipt: %w", err)
+			}
+			if err := r.ApplyTo(cart); err != nil {
+				return nil, fmt.Errorf("failed to attach receipt: %w", err)
+			}
+		}
+		paymentData["cart"] = cart
+	}
+
 	result := &yapay.PaymentGenerationResult{
 		PaymentData: paymentData,
 		OrderID:     orderID,
@@ -206,18 +274,20 @@ func (g *PaymentGenerator) ValidatePriceFromBackend(req *yapay.PaymentRequest) e
 	//
 	// Uncomment and implement if you need backend validation:
 	//
-	// productID, exists := req.Metadata["product_id"]
-	// if !exists {
+	// productID, err := req.Metadata.GetString("product_id")
+	// if errors.Is(err, yapay.ErrMetadataMissing) {
 	//     return nil // Skip validation if no product_id
+	// } else if err != nil {
+	//     return err
 	// }
 	//
-	// expectedPrice, err := g.getProductPrice(productID.(string))
+	// expectedPrice, err := g.getProductPrice(productID)
 	// if err != nil {
-	//     return fmt.Errorf("failed to get product price: %w", err)
+	//     return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, "failed to get product price")
 	// }
 	//
 	// if req.Amount != expectedPrice {
-	//     return fmt.Errorf("price mismatch: expected %d, got %d", expectedPrice, req.Amount)
+	//     return yapay.Errorf(yapay.ErrorCodePriceMismatch, "price mismatch: expected %d, got %d", expectedPrice, req.Amount)
 	// }
 
 	return nil
@@ -244,26 +314,8 @@ func (g *PaymentGenerator) CustomizeYandexPayload(payload map[string]interface{}
 	payload["merchant_name"] = g.merchant.Name
 	payload["domain"] = g.merchant.Domain
 
-	// Example: Add receipt information if needed
-	// Uncomment and customize if you need receipt:
-	//
-	// if metadata, exists := payload["metadata"].(map[string]interface{}); exists {
-	//     if userEmail, exists := metadata["user_email"]; exists {
-	//         payload["receipt"] = map[string]interface{}{
-	//             "customer": map[string]interface{}{
-	//                 "email": userEmail,
-	//             },
-	//             "items": []map[string]interface{}{
-	//                 {
-	//                     "description": payload["description"],
-	//                     "amount":       payload["amount"],
-	//                     "quantity":    "1",
-	//                     "vat_code":    "1", // НДС 20%
-	//                 },
-	//             },
-	//         }
-	//     }
-	// }
+	// Fiscal receipts are attached to the cart in GeneratePaymentData,
+	// see the receipt package.
 
 	return nil
 }
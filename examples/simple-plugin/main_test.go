@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/metalmon/yapay-sdk"
+	"github.com/metalmon/yapay-sdk/merchantapi"
 	yapaytesting "github.com/metalmon/yapay-sdk/testing"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -29,6 +31,32 @@ func TestNewHandler(t *testing.T) {
 	assert.Equal(t, merchant.Name, handler.GetMerchantName())
 }
 
+func TestPluginManifest(t *testing.T) {
+	report := yapay.CheckCompatibility(&PluginManifest)
+	assert.True(t, report.Compatible, report.String())
+
+	handler := NewHandler(yapaytesting.NewTestData().CreateTestMerchant())
+	handler.SetPaymentLinkGenerator(NewPaymentGenerator(handler.GetMerchantConfig(), logrus.New()))
+	assert.NoError(t, PluginManifest.VerifyCapabilities(yapay.AdaptHandler(handler)))
+}
+
+func TestHandler_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	merchant := yapaytesting.NewTestData().CreateTestMerchant()
+	handler := NewHandler(merchant)
+
+	lifecycle := yapay.NewLifecycle()
+	require.NoError(t, lifecycle.Start(ctx, merchant, yapay.AdaptHandler(handler)))
+
+	report := lifecycle.Readiness(ctx)
+	assert.Equal(t, yapay.HealthStatusUp, report.Status)
+	require.Len(t, report.Merchants, 1)
+	assert.NotNil(t, report.Merchants[0].Init)
+	assert.NotNil(t, report.Merchants[0].Health)
+
+	assert.NoError(t, lifecycle.Shutdown(ctx))
+}
+
 func TestHandler_HandlePaymentCreated(t *testing.T) {
 	// Create test data
 	testData := yapaytesting.NewTestData()
@@ -188,6 +216,7 @@ func TestHandler_ValidateRequest(t *testing.T) {
 			if tc.expectedError {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tc.errorMessage)
+				assert.ErrorIs(t, err, yapay.ErrValidation)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -465,6 +494,92 @@ func TestPaymentGenerator_GeneratePaymentData_DifferentCurrencies(t *testing.T)
 	}
 }
 
+func TestPaymentGenerator_GeneratePaymentData_MinorUnits(t *testing.T) {
+	// Create test data
+	testData := yapaytesting.NewTestData()
+	merchant := testData.CreateTestMerchant()
+
+	// Create payment generator with logger
+	logger := logrus.New()
+	generator := NewPaymentGenerator(merchant, logger).(*PaymentGenerator)
+
+	// Currencies with zero and three fractional digits
+	tests := []struct {
+		currency string
+		amount   int
+		expected string
+	}{
+		{"JPY", 1500, "1500"},
+		{"KWD", 1500, "1.500"},
+	}
+
+	for _, tt := range tests {
+		request := &yapay.PaymentRequest{
+			Amount:      tt.amount,
+			Currency:    tt.currency,
+			Description: "Test payment",
+			ReturnURL:   "https://example.com/return",
+		}
+
+		result, err := generator.GeneratePaymentData(request)
+		require.NoError(t, err)
+
+		amountData, exists := result.PaymentData["amount"].(map[string]interface{})
+		require.True(t, exists)
+		assert.Equal(t, tt.expected, amountData["value"])
+	}
+
+	// Unknown currencies are rejected instead of formatted incorrectly
+	_, err := generator.GeneratePaymentData(&yapay.PaymentRequest{
+		Amount:      100,
+		Currency:    "XXX",
+		Description: "Test payment",
+		ReturnURL:   "https://example.com/return",
+	})
+	assert.Error(t, err)
+}
+
+func TestPaymentGenerator_GeneratePaymentData_Cart(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	merchant := testData.CreateTestMerchant()
+	generator := NewPaymentGenerator(merchant, logrus.New()).(*PaymentGenerator)
+
+	request := &yapay.PaymentRequest{
+		Amount:      125000,
+		Currency:    "RUB",
+		Description: "Cart payment",
+		ReturnURL:   "https://example.com/return",
+		Cart: &yapay.Cart{Items: []yapay.CartItem{
+			{ProductID: "book", Title: "Book", Quantity: 2, UnitPrice: 50000},
+			{ProductID: "mug", Title: "Mug", Quantity: 1, UnitPrice: 30000, Discounts: []yapay.ItemDiscount{{Amount: 5000}}},
+		}},
+	}
+
+	result, err := generator.GeneratePaymentData(request)
+	require.NoError(t, err)
+
+	cart, ok := result.PaymentData["cart"].(*merchantapi.Cart)
+	require.True(t, ok)
+	assert.Len(t, cart.Items, 2)
+	assert.Equal(t, "1250.00", cart.Total.Amount)
+	assert.Equal(t, "250.00", cart.Items[1].DiscountedUnitPrice)
+	assert.Nil(t, cart.Items[0].Receipt)
+
+	// A buyer email adds fiscal receipt data to every item
+	request.Metadata = map[string]interface{}{"user_email": "buyer@example.com"}
+	result, err = generator.GeneratePaymentData(request)
+	require.NoError(t, err)
+	cart = result.PaymentData["cart"].(*merchantapi.Cart)
+	require.NotNil(t, cart.Items[0].Receipt)
+	assert.Equal(t, 1, cart.Items[0].Receipt.Tax, "VAT 20% in Yandex Pay tax codes")
+
+	// A cart that does not add up to the amount is rejected by ValidateRequest
+	handler := NewHandler(merchant)
+	request.Amount = 130000
+	err = handler.ValidateRequest(request)
+	assert.ErrorIs(t, err, yapay.ErrPriceMismatch)
+}
+
 // Benchmark tests
 func BenchmarkHandler_HandlePaymentCreated(b *testing.B) {
 	// Create test data
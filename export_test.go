@@ -0,0 +1,7 @@
+package yapay
+
+// Test-only access to the unexported version parsing for the external tests
+var (
+	ParseVersionRange = parseVersionRange
+	ParseSemver       = parseSemver
+)
@@ -0,0 +1,136 @@
+package yapay
+
+import (
+	"context"
+)
+
+// ClientHandlerV2 is the context-aware generation of ClientHandler.
+// Every operation receives the request context so plugins can honor
+// deadlines and cancellation and propagate trace or correlation IDs
+// to their own backends.
+type ClientHandlerV2 interface {
+	// Payment lifecycle methods
+	HandlePaymentCreated(ctx context.Context, payment *Payment) error
+	HandlePaymentSuccess(ctx context.Context, payment *Payment) error
+	HandlePaymentFailed(ctx context.Context, payment *Payment) error
+	HandlePaymentCanceled(ctx context.Context, payment *Payment) error
+
+	// Request validation
+	ValidateRequest(ctx context.Context, req *PaymentRequest) error
+
+	// Configuration and metadata
+	GetMerchantConfig() *Merchant
+	GetMerchantID() string
+	GetMerchantName() string
+
+	// Payment link generator
+	GetPaymentLinkGenerator() interface{}
+	SetPaymentLinkGenerator(generator interface{})
+}
+
+// NewHandlerV2Func is the function signature for creating a context-aware handler
+// This function must be exported from the plugin as "NewHandlerV2"
+type NewHandlerV2Func func(*Merchant) ClientHandlerV2
+
+// LegacyHandlerAdapter exposes a v1 ClientHandler as a ClientHandlerV2.
+// The context is checked before every call so that canceled or expired
+// requests never reach the legacy handler.
+type LegacyHandlerAdapter struct {
+	handler ClientHandler
+}
+
+// AdaptHandler wraps a v1 ClientHandler so it can be used wherever a
+// ClientHandlerV2 is expected. A nil handler yields nil.
+func AdaptHandler(handler ClientHandler) ClientHandlerV2 {
+	if handler == nil {
+		return nil
+	}
+	return &LegacyHandlerAdapter{handler: handler}
+}
+
+// Unwrap returns the wrapped v1 handler
+func (a *LegacyHandlerAdapter) Unwrap() ClientHandler {
+	return a.handler
+}
+
+// HandlePaymentCreated forwards to the v1 handler unless ctx is done
+func (a *LegacyHandlerAdapter) HandlePaymentCreated(ctx context.Context, payment *Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.handler.HandlePaymentCreated(payment)
+}
+
+// HandlePaymentSuccess forwards to the v1 handler unless ctx is done
+func (a *LegacyHandlerAdapter) HandlePaymentSuccess(ctx context.Context, payment *Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.handler.HandlePaymentSuccess(payment)
+}
+
+// HandlePaymentFailed forwards to the v1 handler unless ctx is done
+func (a *LegacyHandlerAdapter) HandlePaymentFailed(ctx context.Context, payment *Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.handler.HandlePaymentFailed(payment)
+}
+
+// HandlePaymentCanceled forwards to the v1 handler unless ctx is done
+func (a *LegacyHandlerAdapter) HandlePaymentCanceled(ctx context.Context, payment *Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.handler.HandlePaymentCanceled(payment)
+}
+
+// ValidateRequest forwards to the v1 handler unless ctx is done
+func (a *LegacyHandlerAdapter) ValidateRequest(ctx context.Context, req *PaymentRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.handler.ValidateRequest(req)
+}
+
+// GetMerchantConfig returns the merchant configuration of the v1 handler
+func (a *LegacyHandlerAdapter) GetMerchantConfig() *Merchant {
+	return a.handler.GetMerchantConfig()
+}
+
+// GetMerchantID returns the merchant ID of the v1 handler
+func (a *LegacyHandlerAdapter) GetMerchantID() string {
+	return a.handler.GetMerchantID()
+}
+
+// GetMerchantName returns the merchant name of the v1 handler
+func (a *LegacyHandlerAdapter) GetMerchantName() string {
+	return a.handler.GetMerchantName()
+}
+
+// GetPaymentLinkGenerator returns the payment generator of the v1 handler
+func (a *LegacyHandlerAdapter) GetPaymentLinkGenerator() interface{} {
+	return a.handler.GetPaymentLinkGenerator()
+}
+
+// SetPaymentLinkGenerator sets the payment generator on the v1 handler
+func (a *LegacyHandlerAdapter) SetPaymentLinkGenerator(generator interface{}) {
+	a.handler.SetPaymentLinkGenerator(generator)
+}
+
+// UnwrapHandler returns the innermost plugin handler behind any SDK adapters.
+// Hosts use it to discover optional interfaces implemented by the plugin itself.
+func UnwrapHandler(handler interface{}) interface{} {
+	for {
+		switch u := handler.(type) {
+		case interface{ Unwrap() ClientHandler }:
+			handler = u.Unwrap()
+		case interface{ Unwrap() ClientHandlerV2 }:
+			handler = u.Unwrap()
+		case interface{ Unwrap() ClientHandlerV3 }:
+			handler = u.Unwrap()
+		default:
+			return handler
+		}
+	}
+}
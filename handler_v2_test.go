@@ -0,0 +1,77 @@
+package yapay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	yapaytesting "github.com/metalmon/yapay-sdk/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptHandler_ForwardsCalls(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	mock := yapaytesting.NewMockClientHandler()
+	mock.SetMerchant(testData.CreateTestMerchant())
+
+	handler := yapay.AdaptHandler(mock)
+	require.NotNil(t, handler)
+
+	ctx := context.Background()
+	payment := testData.CreateTestPayment()
+
+	assert.NoError(t, handler.HandlePaymentCreated(ctx, payment))
+	assert.NoError(t, handler.HandlePaymentSuccess(ctx, payment))
+	assert.NoError(t, handler.HandlePaymentFailed(ctx, payment))
+	assert.NoError(t, handler.HandlePaymentCanceled(ctx, payment))
+	assert.NoError(t, handler.ValidateRequest(ctx, testData.CreateTestPaymentRequest()))
+
+	counts := mock.GetCallCounts()
+	assert.Equal(t, 1, counts["HandlePaymentCreated"])
+	assert.Equal(t, 1, counts["HandlePaymentSuccess"])
+	assert.Equal(t, 1, counts["HandlePaymentFailed"])
+	assert.Equal(t, 1, counts["HandlePaymentCanceled"])
+	assert.Equal(t, 1, counts["ValidateRequest"])
+
+	assert.Equal(t, mock.GetMerchantConfig(), handler.GetMerchantConfig())
+	assert.Equal(t, mock.GetMerchantID(), handler.GetMerchantID())
+	assert.Equal(t, mock.GetMerchantName(), handler.GetMerchantName())
+
+	generator := yapaytesting.NewMockPaymentGenerator()
+	handler.SetPaymentLinkGenerator(generator)
+	assert.Equal(t, generator, handler.GetPaymentLinkGenerator())
+}
+
+func TestAdaptHandler_HonorsCanceledContext(t *testing.T) {
+	testData := yapaytesting.NewTestData()
+	mock := yapaytesting.NewMockClientHandler()
+	handler := yapay.AdaptHandler(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := handler.HandlePaymentSuccess(ctx, testData.CreateTestPayment())
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	err = handler.ValidateRequest(ctx, testData.CreateTestPaymentRequest())
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// The legacy handler must not be reached after cancellation
+	counts := mock.GetCallCounts()
+	assert.Equal(t, 0, counts["HandlePaymentSuccess"])
+	assert.Equal(t, 0, counts["ValidateRequest"])
+}
+
+func TestAdaptHandler_Nil(t *testing.T) {
+	assert.Nil(t, yapay.AdaptHandler(nil))
+}
+
+func TestUnwrapHandler(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	handler := yapay.AdaptHandler(mock)
+
+	assert.Same(t, mock, yapay.UnwrapHandler(handler))
+	assert.Same(t, mock, yapay.UnwrapHandler(mock))
+}
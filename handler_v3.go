@@ -0,0 +1,168 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Payment link generator errors
+var (
+	ErrNoGenerator      = errors.New("payment link generator is not set")
+	ErrGeneratorDropped = errors.New("plugin dropped the payment link generator")
+)
+
+// ClientHandlerV3 replaces the untyped payment link generator accessors of
+// ClientHandlerV2 with a typed pair that reports errors
+type ClientHandlerV3 interface {
+	// Payment lifecycle methods
+	HandlePaymentCreated(ctx context.Context, payment *Payment) error
+	HandlePaymentSuccess(ctx context.Context, payment *Payment) error
+	HandlePaymentFailed(ctx context.Context, payment *Payment) error
+	HandlePaymentCanceled(ctx context.Context, payment *Payment) error
+
+	// Request validation
+	ValidateRequest(ctx context.Context, req *PaymentRequest) error
+
+	// Configuration and metadata
+	GetMerchantConfig() *Merchant
+	GetMerchantID() string
+	GetMerchantName() string
+
+	// PaymentGenerator returns ErrNoGenerator if no generator is set
+	PaymentGenerator() (PaymentLinkGenerator, error)
+	// SetPaymentGenerator returns an error if the handler rejects the generator
+	SetPaymentGenerator(generator PaymentLinkGenerator) error
+}
+
+// NewHandlerV3Func is the function signature for creating a v3 handler
+// This function must be exported from the plugin as "NewHandlerV3"
+type NewHandlerV3Func func(*Merchant) ClientHandlerV3
+
+// GeneratorShim gives a ClientHandlerV2 the typed generator accessors of
+// ClientHandlerV3. It implements both interfaces.
+type GeneratorShim struct {
+	ClientHandlerV2
+}
+
+// UpgradeHandler returns handler as a ClientHandlerV3, wrapping it in a
+// GeneratorShim unless it already implements the typed accessors
+func UpgradeHandler(handler ClientHandlerV2) ClientHandlerV3 {
+	if handler == nil {
+		return nil
+	}
+	if h, ok := handler.(ClientHandlerV3); ok {
+		return h
+	}
+	return &GeneratorShim{ClientHandlerV2: handler}
+}
+
+// Unwrap returns the wrapped v2 handler
+func (s *GeneratorShim) Unwrap() ClientHandlerV2 {
+	return s.ClientHandlerV2
+}
+
+// PaymentGenerator type-checks the value of GetPaymentLinkGenerator
+func (s *GeneratorShim) PaymentGenerator() (PaymentLinkGenerator, error) {
+	raw := s.GetPaymentLinkGenerator()
+	if raw == nil {
+		return nil, ErrNoGenerator
+	}
+	generator, ok := raw.(PaymentLinkGenerator)
+	if !ok {
+		return nil, fmt.Errorf("%w: plugin returned %T, which is not a PaymentLinkGenerator", ErrNoGenerator, raw)
+	}
+	return generator, nil
+}
+
+// SetPaymentGenerator sets the generator and reads it back, so a v2 handler
+// that silently ignores it yields ErrGeneratorDropped
+func (s *GeneratorShim) SetPaymentGenerator(generator PaymentLinkGenerator) error {
+	if generator == nil {
+		return ErrNoGenerator
+	}
+	s.SetPaymentLinkGenerator(generator)
+	if !sameGenerator(s.GetPaymentLinkGenerator(), generator) {
+		return fmt.Errorf("%w: %T ignored %T", ErrGeneratorDropped, UnwrapHandler(s), generator)
+	}
+	return nil
+}
+
+// sameGenerator compares generators without panicking on uncomparable types
+func sameGenerator(got interface{}, want PaymentLinkGenerator) bool {
+	if got == nil || reflect.TypeOf(got) != reflect.TypeOf(want) {
+		return false
+	}
+	if !reflect.TypeOf(got).Comparable() {
+		return true
+	}
+	return got == interface{}(want)
+}
+
+// handlerV3Adapter exposes a ClientHandlerV3 as a ClientHandlerV2
+type handlerV3Adapter struct {
+	ClientHandlerV3
+}
+
+// DowngradeHandler returns a ClientHandlerV3 as a ClientHandlerV2 for host
+// code written against v2. Values that are not a PaymentLinkGenerator are
+// ignored by SetPaymentLinkGenerator, as in v2.
+func DowngradeHandler(handler ClientHandlerV3) ClientHandlerV2 {
+	if handler == nil {
+		return nil
+	}
+	if h, ok := handler.(ClientHandlerV2); ok {
+		return h
+	}
+	return &handlerV3Adapter{ClientHandlerV3: handler}
+}
+
+// Unwrap returns the wrapped v3 handler
+func (a *handlerV3Adapter) Unwrap() ClientHandlerV3 {
+	return a.ClientHandlerV3
+}
+
+// GetPaymentLinkGenerator returns the generator or nil
+func (a *handlerV3Adapter) GetPaymentLinkGenerator() interface{} {
+	generator, err := a.PaymentGenerator()
+	if err != nil {
+		return nil
+	}
+	return generator
+}
+
+// SetPaymentLinkGenerator sets a PaymentLinkGenerator
+func (a *handlerV3Adapter) SetPaymentLinkGenerator(generator interface{}) {
+	if g, ok := generator.(PaymentLinkGenerator); ok {
+		_ = a.SetPaymentGenerator(g)
+	}
+}
+
+// InstallPaymentGenerator sets the generator on a handler of any generation
+// (ClientHandler, ClientHandlerV2 or ClientHandlerV3) and logs a warning if
+// the plugin rejects or silently drops it
+func InstallPaymentGenerator(handler interface{}, generator PaymentLinkGenerator, logger logrus.FieldLogger) error {
+	var h ClientHandlerV3
+	switch v := handler.(type) {
+	case ClientHandlerV3:
+		h = v
+	case ClientHandlerV2:
+		h = UpgradeHandler(v)
+	case ClientHandler:
+		h = UpgradeHandler(AdaptHandler(v))
+	default:
+		return fmt.Errorf("%T is not a plugin handler", handler)
+	}
+
+	err := h.SetPaymentGenerator(generator)
+	if err != nil && logger != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"merchant_id": h.GetMerchantID(),
+			"generator":   fmt.Sprintf("%T", generator),
+		}).Warn("Plugin did not accept the payment link generator")
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package yapay_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	yapaytesting "github.com/metalmon/yapay-sdk/testing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// droppingHandler is a v1 plugin that ignores every generator
+type droppingHandler struct {
+	*yapaytesting.MockClientHandler
+}
+
+func (h droppingHandler) SetPaymentLinkGenerator(interface{}) {}
+
+// typedHandler is a native v3 plugin
+type typedHandler struct {
+	yapay.ClientHandlerV2
+	generator yapay.PaymentLinkGenerator
+}
+
+func (h *typedHandler) PaymentGenerator() (yapay.PaymentLinkGenerator, error) {
+	if h.generator == nil {
+		return nil, yapay.ErrNoGenerator
+	}
+	return h.generator, nil
+}
+
+func (h *typedHandler) SetPaymentGenerator(generator yapay.PaymentLinkGenerator) error {
+	h.generator = generator
+	return nil
+}
+
+func TestUpgradeHandler(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	handler := yapay.UpgradeHandler(yapay.AdaptHandler(mock))
+
+	_, err := handler.PaymentGenerator()
+	assert.True(t, errors.Is(err, yapay.ErrNoGenerator))
+
+	generator := yapaytesting.NewMockPaymentGenerator()
+	require.NoError(t, handler.SetPaymentGenerator(generator))
+	got, err := handler.PaymentGenerator()
+	require.NoError(t, err)
+	assert.Same(t, generator, got)
+
+	// Optional interfaces are still found through the shim
+	_, ok := yapay.AsRefundHandler(handler)
+	assert.True(t, ok)
+	assert.Same(t, mock, yapay.UnwrapHandler(handler))
+
+	// A handler that already has typed accessors is returned as is
+	v3 := &typedHandler{ClientHandlerV2: yapay.AdaptHandler(mock)}
+	assert.Same(t, v3, yapay.UpgradeHandler(v3))
+}
+
+func TestGeneratorShim_Dropped(t *testing.T) {
+	handler := yapay.UpgradeHandler(yapay.AdaptHandler(droppingHandler{yapaytesting.NewMockClientHandler()}))
+	err := handler.SetPaymentGenerator(yapaytesting.NewMockPaymentGenerator())
+	assert.True(t, errors.Is(err, yapay.ErrGeneratorDropped))
+	assert.Contains(t, err.Error(), "droppingHandler")
+
+	mock := yapaytesting.NewMockClientHandler()
+	shim := &yapay.GeneratorShim{ClientHandlerV2: &untypedGenerator{ClientHandlerV2: yapay.AdaptHandler(mock)}}
+	_, err = shim.PaymentGenerator()
+	assert.True(t, errors.Is(err, yapay.ErrNoGenerator))
+	assert.Contains(t, err.Error(), "string")
+}
+
+// untypedGenerator returns a value that is not a generator
+type untypedGenerator struct {
+	yapay.ClientHandlerV2
+}
+
+func (h *untypedGenerator) GetPaymentLinkGenerator() interface{} { return "generator" }
+
+func TestDowngradeHandler(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	v3 := &typedHandler{ClientHandlerV2: yapay.AdaptHandler(mock)}
+
+	v2 := yapay.DowngradeHandler(v3)
+	assert.Same(t, v3, v2, "typedHandler also implements v2")
+
+	v2only := yapay.DowngradeHandler(struct{ yapay.ClientHandlerV3 }{v3})
+	assert.Nil(t, v2only.GetPaymentLinkGenerator())
+	generator := yapaytesting.NewMockPaymentGenerator()
+	v2only.SetPaymentLinkGenerator(generator)
+	assert.Same(t, generator, v2only.GetPaymentLinkGenerator())
+	require.NoError(t, v2only.HandlePaymentCreated(context.Background(), &yapay.Payment{ID: "p1"}))
+	assert.Len(t, mock.PaymentCreatedCalls, 1)
+}
+
+func TestInstallPaymentGenerator(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+
+	generator := yapaytesting.NewMockPaymentGenerator()
+	mock := yapaytesting.NewMockClientHandler()
+	require.NoError(t, yapay.InstallPaymentGenerator(mock, generator, logger))
+	assert.Same(t, generator, mock.PaymentGenerator)
+	assert.Empty(t, out.String())
+
+	dropping := droppingHandler{yapaytesting.NewMockClientHandler()}
+	err := yapay.InstallPaymentGenerator(dropping, generator, logger)
+	assert.True(t, errors.Is(err, yapay.ErrGeneratorDropped))
+	assert.Contains(t, out.String(), "level=warning")
+	assert.Contains(t, out.String(), "did not accept the payment link generator")
+
+	assert.Error(t, yapay.InstallPaymentGenerator(struct{}{}, generator, nil))
+}
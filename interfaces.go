@@ -6,27 +6,31 @@ import (
 
 // PaymentRequest represents a payment request
 type PaymentRequest struct {
-	Amount      int                    `json:"amount"`
-	Currency    string                 `json:"currency"`
-	Description string                 `json:"description"`
-	ReturnURL   string                 `json:"return_url"`
-	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Amount      int      `json:"amount"`
+	Currency    string   `json:"currency"`
+	Description string   `json:"description"`
+	ReturnURL   string   `json:"return_url"`
+	Metadata    Metadata `json:"metadata,omitempty"`
+	Cart        *Cart    `json:"cart,omitempty"` // Optional; its total must equal Amount
 }
 
 // Payment represents a payment
 type Payment struct {
-	ID          string                 `json:"id"`
-	OrderID     string                 `json:"order_id"`
-	MerchantID  string                 `json:"merchant_id"` // Merchant ID from Yandex Pay (also serves as client ID)
-	Amount      int                    `json:"amount"`
-	Currency    string                 `json:"currency"`
-	Description string                 `json:"description"`
-	Status      string                 `json:"status"`
-	ReturnURL   string                 `json:"return_url"`
-	PaymentURL  string                 `json:"payment_url,omitempty"`
-	Metadata    map[string]interface{} `json:"metadata,omitempty"`
-	CreatedAt   string                 `json:"created_at,omitempty"`
-	UpdatedAt   string                 `json:"updated_at,omitempty"`
+	ID             string        `json:"id"`
+	OrderID        string        `json:"order_id"`
+	MerchantID     string        `json:"merchant_id"` // Merchant ID from Yandex Pay (also serves as client ID)
+	Amount         int           `json:"amount"`
+	Currency       string        `json:"currency"`
+	Description    string        `json:"description"`
+	Status         PaymentStatus `json:"status"`
+	ReturnURL      string        `json:"return_url"`
+	PaymentURL     string        `json:"payment_url,omitempty"`
+	Metadata       Metadata      `json:"metadata,omitempty"`
+	Cart           *Cart         `json:"cart,omitempty"`
+	RefundedAmount int           `json:"refunded_amount,omitempty"` // Total refunded so far, in minor units
+	RefundIDs      []string      `json:"refund_ids,omitempty"`      // IDs of the refunds applied so far
+	CreatedAt      string        `json:"created_at,omitempty"`
+	UpdatedAt      string        `json:"updated_at,omitempty"`
 }
 
 // Merchant represents a merchant configuration
@@ -60,19 +64,116 @@ type CORSConfig struct {
 // YandexConfig represents Yandex API configuration
 type YandexConfig struct {
 	MerchantID     string `json:"merchant_id" yaml:"merchant_id"`
-	SecretKey      string `json:"secret_key" yaml:"secret_key"`
+	SecretKey      Secret `json:"secret_key" yaml:"secret_key"`
 	SandboxMode    bool   `json:"sandbox_mode" yaml:"sandbox_mode"`
 	Currency       string `json:"currency" yaml:"currency"`
 	APIBaseURL     string `json:"api_base_url,omitempty" yaml:"api_base_url,omitempty"`
 	OrdersEndpoint string `json:"orders_endpoint,omitempty" yaml:"orders_endpoint,omitempty"`
 	JWKSEndpoint   string `json:"jwks_endpoint,omitempty" yaml:"jwks_endpoint,omitempty"`
 	PrivateKeyPath string `json:"private_key_path,omitempty" yaml:"private_key_path,omitempty"`
+	// PrivateKey is resolved from a private_key_path secret reference
+	PrivateKey Secret `json:"-" yaml:"-"`
 }
 
 // NotificationConfig represents notification configuration
 type NotificationConfig struct {
 	Telegram TelegramConfig `json:"telegram" yaml:"telegram"`
 	Email    EmailConfig    `json:"email" yaml:"email"`
+	// Templates override the built-in message templates per notification type
+	Templates map[NotificationType]NotificationTemplate `json:"templates,omitempty" yaml:"templates,omitempty"`
+	// Rules route notifications to channels and recipients. Without rules
+	// every notification is sent to all enabled channels.
+	Rules []NotificationRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// Digest configures the digests that rules with a digest window collect into
+	Digest DigestConfig `json:"digest,omitempty" yaml:"digest,omitempty"`
+}
+
+// DigestConfig sets the windows and size of notification digests
+type DigestConfig struct {
+	// Timezone of the digest windows, an IANA name; UTC by default
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// DailyAt is the "HH:MM" time daily digests are sent; 00:00 by default
+	DailyAt string `json:"daily_at,omitempty" yaml:"daily_at,omitempty"`
+	// MaxPayments limits the payments listed in a digest; totals include all
+	MaxPayments int `json:"max_payments,omitempty" yaml:"max_payments,omitempty"`
+}
+
+// NotificationRule routes matching notifications to channels. A notification
+// is delivered by every rule it matches and dropped if it matches none.
+type NotificationRule struct {
+	// Name identifies the rule in logs
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Types are the notification types the rule matches; empty matches all
+	Types []NotificationType `json:"types,omitempty" yaml:"types,omitempty"`
+	// Channels are the channels to send to (telegram, email); empty means all enabled
+	Channels []string `json:"channels,omitempty" yaml:"channels,omitempty"`
+	// Recipients override the chat and addresses of the channels
+	Recipients NotificationRecipients `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+	// MinAmount and MaxAmount bound the payment amount, as decimals in the
+	// payment currency. Notifications without a payment do not match.
+	MinAmount string `json:"min_amount,omitempty" yaml:"min_amount,omitempty"`
+	MaxAmount string `json:"max_amount,omitempty" yaml:"max_amount,omitempty"`
+	// QuietHours delay delivery until the end of the interval
+	QuietHours QuietHours `json:"quiet_hours,omitempty" yaml:"quiet_hours,omitempty"`
+	// RateLimit drops notifications over the limit
+	RateLimit NotificationRateLimit `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	// Digest collects notifications into an hourly or daily digest instead
+	// of sending them one by one
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+}
+
+// NotificationRecipients override the configured recipients of a channel
+type NotificationRecipients struct {
+	// Telegram chat IDs
+	Telegram []string `json:"telegram,omitempty" yaml:"telegram,omitempty"`
+	// Email addresses
+	Email []string `json:"email,omitempty" yaml:"email,omitempty"`
+}
+
+// IsZero reports whether no recipients are set
+func (r NotificationRecipients) IsZero() bool {
+	return len(r.Telegram) == 0 && len(r.Email) == 0
+}
+
+// QuietHours is a daily interval such as 22:00-08:00 when notifications are held back
+type QuietHours struct {
+	// Start and End are "HH:MM"; an interval may cross midnight
+	Start string `json:"start,omitempty" yaml:"start,omitempty"`
+	End   string `json:"end,omitempty" yaml:"end,omitempty"`
+	// Timezone is an IANA name such as Europe/Moscow; UTC by default
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+}
+
+// IsZero reports whether quiet hours are not configured
+func (q QuietHours) IsZero() bool {
+	return q.Start == "" && q.End == ""
+}
+
+// NotificationRateLimit limits how many notifications a rule passes per period
+type NotificationRateLimit struct {
+	Max int `json:"max,omitempty" yaml:"max,omitempty"`
+	// Period is a duration such as 10m or 1h; one hour by default
+	Period string `json:"period,omitempty" yaml:"period,omitempty"`
+}
+
+// IsZero reports whether no rate limit is set
+func (l NotificationRateLimit) IsZero() bool {
+	return l.Max == 0 && l.Period == ""
+}
+
+// NotificationTemplate holds message templates for one notification type.
+// Empty fields keep the built-in template.
+type NotificationTemplate struct {
+	// Subject is the email subject (text/template)
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	// Text is the plain text email body (text/template)
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+	// HTML is the HTML email body (html/template)
+	HTML string `json:"html,omitempty" yaml:"html,omitempty"`
+	// Telegram is the Telegram message; html/template for the HTML parse mode
+	Telegram string `json:"telegram,omitempty" yaml:"telegram,omitempty"`
+	// ParseMode is the Telegram parse mode: HTML (default), MarkdownV2 or text
+	ParseMode string `json:"parse_mode,omitempty" yaml:"parse_mode,omitempty"`
 }
 
 // FieldLabels represents field labels for order metadata in notifications
@@ -82,7 +183,9 @@ type FieldLabels map[string]string
 type TelegramConfig struct {
 	Enabled  bool   `json:"enabled" yaml:"enabled"`
 	ChatID   string `json:"chat_id" yaml:"chat_id"`
-	BotToken string `json:"bot_token" yaml:"bot_token"`
+	BotToken Secret `json:"bot_token" yaml:"bot_token"`
+	// APIBaseURL overrides the Bot API URL, e.g. for a local Bot API server
+	APIBaseURL string `json:"api_base_url,omitempty" yaml:"api_base_url,omitempty"`
 }
 
 // EmailConfig represents email notification configuration
@@ -91,8 +194,14 @@ type EmailConfig struct {
 	SMTPHost string `json:"smtp_host" yaml:"smtp_host"`
 	SMTPPort int    `json:"smtp_port" yaml:"smtp_port"`
 	Username string `json:"username" yaml:"username"`
-	Password string `json:"password" yaml:"password"`
+	Password Secret `json:"password" yaml:"password"`
 	From     string `json:"from" yaml:"from"`
+	// To lists the recipient addresses
+	To []string `json:"to,omitempty" yaml:"to,omitempty"`
+	// TLS is the connection security: starttls (default), tls or none
+	TLS string `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// InsecureSkipVerify disables certificate verification for test servers
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
 }
 
 // ClientHandler defines the interface that all client handlers must implement
@@ -132,7 +241,7 @@ type PaymentGenerationResult struct {
 	Currency    string                 `json:"currency"`
 	Description string                 `json:"description"`
 	ReturnURL   string                 `json:"return_url"`
-	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Metadata    Metadata               `json:"metadata,omitempty"`
 }
 
 // PaymentSettings represents payment settings for Yandex Pay
@@ -205,4 +314,26 @@ const (
 	NotificationTypePaymentFailed  NotificationType = "payment_failed"
 	NotificationTypeSystemError    NotificationType = "system_error"
 	NotificationTypeWebhook        NotificationType = "webhook"
+	// NotificationTypeDigest is a summary of notifications collected over a window
+	NotificationTypeDigest NotificationType = "digest"
 )
+
+// NotificationTypes lists the known notification types
+var NotificationTypes = []NotificationType{
+	NotificationTypePaymentCreated,
+	NotificationTypePaymentSuccess,
+	NotificationTypePaymentFailed,
+	NotificationTypeSystemError,
+	NotificationTypeWebhook,
+	NotificationTypeDigest,
+}
+
+// IsValid reports whether t is a known notification type
+func (t NotificationType) IsValid() bool {
+	for _, known := range NotificationTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+// Command genschema writes the JSON Schema of the merchant config.yaml
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+func main() {
+	out := flag.String("o", "merchant-config.schema.json", "output file")
+	flag.Parse()
+
+	data, err := json.MarshalIndent(yapay.MerchantConfigSchema(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
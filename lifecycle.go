@@ -0,0 +1,322 @@
+package yapay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default lifecycle hook timeouts
+const (
+	DefaultInitTimeout   = 30 * time.Second
+	DefaultHealthTimeout = 5 * time.Second
+	DefaultCloseTimeout  = 15 * time.Second
+)
+
+// PluginEnv is what the host provides to Initializer.Init
+type PluginEnv struct {
+	Merchant *Merchant
+	// Logger is shared with the host and redacts merchant secrets
+	Logger *logrus.Logger
+}
+
+// Initializer is implemented by handlers that open connections or load
+// state before serving. Handlers are not used if Init fails.
+type Initializer interface {
+	Init(ctx context.Context, env PluginEnv) error
+}
+
+// HealthChecker is implemented by handlers that can report their health,
+// e.g. by pinging their database
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Closer is implemented by handlers that flush state or close connections
+// on shutdown
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// AsInitializer returns the Initializer implementation of a handler
+func AsInitializer(handler interface{}) (Initializer, bool) {
+	h, ok := UnwrapHandler(handler).(Initializer)
+	return h, ok
+}
+
+// AsHealthChecker returns the HealthChecker implementation of a handler
+func AsHealthChecker(handler interface{}) (HealthChecker, bool) {
+	h, ok := UnwrapHandler(handler).(HealthChecker)
+	return h, ok
+}
+
+// AsCloser returns the Closer implementation of a handler
+func AsCloser(handler interface{}) (Closer, bool) {
+	h, ok := UnwrapHandler(handler).(Closer)
+	return h, ok
+}
+
+// HealthStatus is the outcome of a lifecycle check
+type HealthStatus string
+
+// Health statuses
+const (
+	HealthStatusUp   HealthStatus = "up"
+	HealthStatusDown HealthStatus = "down"
+)
+
+// CheckResult is the result of a single lifecycle hook call
+type CheckResult struct {
+	Status    HealthStatus  `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// MerchantHealth is the readiness and liveness of one merchant's plugin.
+// Init and Health are nil when the plugin does not implement the hook.
+type MerchantHealth struct {
+	MerchantID string       `json:"merchant_id"`
+	Ready      bool         `json:"ready"`
+	Live       bool         `json:"live"`
+	Init       *CheckResult `json:"init,omitempty"`
+	Health     *CheckResult `json:"health,omitempty"`
+}
+
+// HealthReport aggregates MerchantHealth for all started plugins
+type HealthReport struct {
+	Status    HealthStatus     `json:"status"`
+	Merchants []MerchantHealth `json:"merchants"`
+}
+
+// Lifecycle runs plugin lifecycle hooks with timeouts and keeps their
+// results for readiness and liveness reports
+type Lifecycle struct {
+	initTimeout   time.Duration
+	healthTimeout time.Duration
+	closeTimeout  time.Duration
+	logger        *logrus.Logger
+	redact        *RedactHook
+
+	mu      sync.Mutex
+	plugins []*lifecyclePlugin
+	closed  bool
+}
+
+type lifecyclePlugin struct {
+	merchant *Merchant
+	handler  interface{}
+	init     *CheckResult // set before the plugin is registered
+}
+
+// LifecycleOption configures a Lifecycle
+type LifecycleOption func(*Lifecycle)
+
+// WithInitTimeout bounds Initializer.Init
+func WithInitTimeout(d time.Duration) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.initTimeout = d
+	}
+}
+
+// WithHealthTimeout bounds HealthChecker.HealthCheck
+func WithHealthTimeout(d time.Duration) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.healthTimeout = d
+	}
+}
+
+// WithCloseTimeout bounds Closer.Close
+func WithCloseTimeout(d time.Duration) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.closeTimeout = d
+	}
+}
+
+// WithLifecycleLogger sets the logger passed to plugins in PluginEnv. By
+// default a logger redacting the secrets of every started merchant is used.
+func WithLifecycleLogger(logger *logrus.Logger) LifecycleOption {
+	return func(l *Lifecycle) {
+		l.logger = logger
+	}
+}
+
+// NewLifecycle creates a lifecycle manager with the default timeouts
+func NewLifecycle(opts ...LifecycleOption) *Lifecycle {
+	l := &Lifecycle{
+		initTimeout:   DefaultInitTimeout,
+		healthTimeout: DefaultHealthTimeout,
+		closeTimeout:  DefaultCloseTimeout,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.logger == nil {
+		l.redact = NewRedactHook()
+		l.logger = logrus.New()
+		l.logger.AddHook(l.redact)
+	}
+	return l
+}
+
+// Start registers the merchant's handler and runs its Init hook. The handler
+// is tracked even if Init fails, so the failure shows in readiness reports.
+func (l *Lifecycle) Start(ctx context.Context, merchant *Merchant, handler interface{}) error {
+	if l.redact != nil {
+		l.redact.AddMerchantSecrets(merchant)
+	}
+
+	p := &lifecyclePlugin{merchant: merchant, handler: handler}
+	var err error
+	if init, ok := AsInitializer(handler); ok {
+		env := PluginEnv{Merchant: merchant, Logger: l.logger}
+		p.init, err = runHook(ctx, l.initTimeout, "init", func(ctx context.Context) error {
+			return init.Init(ctx, env)
+		})
+	}
+
+	l.mu.Lock()
+	l.plugins = append(l.plugins, p)
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("merchant %s: %w", merchant.ID, err)
+	}
+	return nil
+}
+
+// OnConfigChange implements Reconfigurable so the lifecycle can subscribe to
+// a ConfigWatcher: secrets of the reloaded config are redacted from then on.
+// Subscribe it before the plugin handlers so their reload logs are covered.
+func (l *Lifecycle) OnConfigChange(old, new *Merchant) error {
+	if l.redact != nil {
+		l.redact.AddMerchantSecrets(new)
+	}
+	return nil
+}
+
+// Readiness reports whether every plugin initialized and passes its health
+// check. Plugins are not ready after Shutdown.
+func (l *Lifecycle) Readiness(ctx context.Context) *HealthReport {
+	return l.report(ctx, true)
+}
+
+// Liveness reports whether every plugin passes its health check
+func (l *Lifecycle) Liveness(ctx context.Context) *HealthReport {
+	return l.report(ctx, false)
+}
+
+func (l *Lifecycle) report(ctx context.Context, readiness bool) *HealthReport {
+	l.mu.Lock()
+	plugins := append([]*lifecyclePlugin(nil), l.plugins...)
+	closed := l.closed
+	l.mu.Unlock()
+
+	report := &HealthReport{Status: HealthStatusUp, Merchants: make([]MerchantHealth, len(plugins))}
+	var wg sync.WaitGroup
+	for i := range plugins {
+		wg.Add(1)
+		go func(i int, p *lifecyclePlugin) {
+			defer wg.Done()
+			report.Merchants[i] = l.check(ctx, p, closed)
+		}(i, plugins[i])
+	}
+	wg.Wait()
+
+	for _, m := range report.Merchants {
+		if (readiness && !m.Ready) || (!readiness && !m.Live) {
+			report.Status = HealthStatusDown
+		}
+	}
+	return report
+}
+
+// check runs the health check of one plugin
+func (l *Lifecycle) check(ctx context.Context, p *lifecyclePlugin, closed bool) MerchantHealth {
+	h := MerchantHealth{MerchantID: p.merchant.ID, Init: p.init, Live: true}
+
+	if checker, ok := AsHealthChecker(p.handler); ok {
+		h.Health, _ = runHook(ctx, l.healthTimeout, "health check", checker.HealthCheck)
+		h.Live = h.Health.Status == HealthStatusUp
+	}
+	h.Ready = h.Live && !closed && (h.Init == nil || h.Init.Status == HealthStatusUp)
+	return h
+}
+
+// Shutdown runs the Close hooks in reverse start order and returns all errors
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	plugins := append([]*lifecyclePlugin(nil), l.plugins...)
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(plugins) - 1; i >= 0; i-- {
+		closer, ok := AsCloser(plugins[i].handler)
+		if !ok {
+			continue
+		}
+		if _, err := runHook(ctx, l.closeTimeout, "close", closer.Close); err != nil {
+			errs = append(errs, fmt.Errorf("merchant %s: %w", plugins[i].merchant.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReadinessHandler serves Readiness as JSON with status 200 or 503
+func (l *Lifecycle) ReadinessHandler() http.Handler {
+	return healthHandler(l.Readiness)
+}
+
+// LivenessHandler serves Liveness as JSON with status 200 or 503
+func (l *Lifecycle) LivenessHandler() http.Handler {
+	return healthHandler(l.Liveness)
+}
+
+func healthHandler(report func(context.Context) *HealthReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := report(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if result.Status != HealthStatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
+
+// runHook calls fn with a deadline. Hooks that ignore ctx are abandoned when
+// the deadline passes; a panic in fn is reported as an internal error.
+func runHook(ctx context.Context, timeout time.Duration, name string, fn func(context.Context) error) (*CheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- Errorf(ErrorCodeInternal, "%s panicked: %v", name, r)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = WrapError(ErrorCodeTimeout, ctx.Err(), fmt.Sprintf("%s did not finish within %v", name, timeout))
+	}
+
+	result := &CheckResult{Status: HealthStatusUp, Duration: time.Since(start), CheckedAt: start}
+	if err != nil {
+		result.Status = HealthStatusDown
+		result.Error = err.Error()
+	}
+	return result, err
+}
@@ -0,0 +1,149 @@
+package yapay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lifecycleHandler struct {
+	initErr   error
+	healthErr error
+	closeErr  error
+	block     bool
+
+	env    PluginEnv
+	closed bool
+}
+
+func (h *lifecycleHandler) Init(ctx context.Context, env PluginEnv) error {
+	h.env = env
+	if h.block {
+		<-make(chan struct{}) // ignores ctx
+	}
+	return h.initErr
+}
+
+func (h *lifecycleHandler) HealthCheck(ctx context.Context) error {
+	return h.healthErr
+}
+
+func (h *lifecycleHandler) Close(ctx context.Context) error {
+	h.closed = true
+	return h.closeErr
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	l := NewLifecycle(WithInitTimeout(50 * time.Millisecond))
+
+	healthy := &lifecycleHandler{}
+	require.NoError(t, l.Start(ctx, &Merchant{ID: "healthy"}, healthy))
+	assert.Equal(t, "healthy", healthy.env.Merchant.ID)
+	assert.NotNil(t, healthy.env.Logger)
+
+	require.NoError(t, l.Start(ctx, &Merchant{ID: "plain"}, struct{}{}))
+
+	report := l.Readiness(ctx)
+	assert.Equal(t, HealthStatusUp, report.Status)
+	require.Len(t, report.Merchants, 2)
+	assert.Equal(t, HealthStatusUp, report.Merchants[0].Init.Status)
+	assert.Nil(t, report.Merchants[1].Init)
+	assert.Nil(t, report.Merchants[1].Health)
+
+	// A hung Init times out and leaves the merchant not ready but live
+	err := l.Start(ctx, &Merchant{ID: "hung"}, &lifecycleHandler{block: true})
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Contains(t, err.Error(), "merchant hung")
+
+	ready := l.Readiness(ctx)
+	assert.Equal(t, HealthStatusDown, ready.Status)
+	assert.False(t, ready.Merchants[2].Ready)
+	assert.Contains(t, ready.Merchants[2].Init.Error, "init did not finish")
+	assert.Equal(t, HealthStatusUp, l.Liveness(ctx).Status)
+
+	// A failing health check affects liveness and readiness
+	healthy.healthErr = errors.New("database unreachable")
+	live := l.Liveness(ctx)
+	assert.Equal(t, HealthStatusDown, live.Status)
+	assert.False(t, live.Merchants[0].Live)
+	assert.Equal(t, "database unreachable", live.Merchants[0].Health.Error)
+}
+
+func TestLifecycle_Shutdown(t *testing.T) {
+	ctx := context.Background()
+	l := NewLifecycle()
+
+	first := &lifecycleHandler{closeErr: errors.New("flush failed")}
+	second := &lifecycleHandler{}
+	require.NoError(t, l.Start(ctx, &Merchant{ID: "first"}, first))
+	require.NoError(t, l.Start(ctx, &Merchant{ID: "second"}, second))
+
+	err := l.Shutdown(ctx)
+	assert.ErrorContains(t, err, "merchant first: flush failed")
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+
+	assert.Equal(t, HealthStatusDown, l.Readiness(ctx).Status, "not ready after shutdown")
+}
+
+func TestLifecycle_HealthHandlers(t *testing.T) {
+	ctx := context.Background()
+	l := NewLifecycle()
+	handler := &lifecycleHandler{}
+	require.NoError(t, l.Start(ctx, &Merchant{ID: "shop"}, handler))
+
+	rec := httptest.NewRecorder()
+	l.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var report HealthReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, "shop", report.Merchants[0].MerchantID)
+
+	handler.healthErr = errors.New("down")
+	rec = httptest.NewRecorder()
+	l.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestRunHook_Panic(t *testing.T) {
+	result, err := runHook(context.Background(), time.Second, "init", func(context.Context) error {
+		panic("boom")
+	})
+	assert.True(t, errors.Is(err, ErrInternal))
+	assert.Equal(t, HealthStatusDown, result.Status)
+}
+
+func TestLifecycle_RedactsReloadedSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := rateLimitConfig(10) + "  secret_key: %s\n"
+	writeWatchedConfig(t, path, fmt.Sprintf(config, "old-secret-value"))
+
+	w, err := NewConfigWatcher(path)
+	require.NoError(t, err)
+	l := NewLifecycle()
+	var out bytes.Buffer
+	l.logger.SetOutput(&out)
+	require.NoError(t, l.Start(context.Background(), w.Current(), struct{}{}))
+	w.Subscribe(l)
+
+	writeWatchedConfig(t, path, fmt.Sprintf(config, "new-secret-value"))
+	changed, err := w.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	l.logger.Infof("keys old-secret-value new-secret-value")
+	assert.NotContains(t, out.String(), "old-secret-value")
+	assert.NotContains(t, out.String(), "new-secret-value")
+}
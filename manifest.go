@@ -0,0 +1,363 @@
+package yapay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SDKVersion is the version of this SDK
+const SDKVersion = "1.1.0"
+
+// PluginManifestSymbol is the name of the exported manifest variable
+const PluginManifestSymbol = "PluginManifest"
+
+// ErrIncompatiblePlugin is returned by CompatibilityReport.Err
+var ErrIncompatiblePlugin = errors.New("incompatible plugin")
+
+// Capability is a feature a plugin declares in its manifest
+type Capability string
+
+// Plugin capabilities
+const (
+	CapabilityGenerator     Capability = "generator"
+	CapabilityRefunds       Capability = "refunds"
+	CapabilitySubscriptions Capability = "subscriptions"
+)
+
+var knownCapabilities = map[Capability]bool{
+	CapabilityGenerator:     true,
+	CapabilityRefunds:       true,
+	CapabilitySubscriptions: true,
+}
+
+// PluginManifest describes a plugin. Plugins export it as
+//
+//	var PluginManifest = yapay.NewPluginManifest("my-plugin", "1.0.0", yapay.CapabilityGenerator)
+//
+// and may ship the same data as JSON next to the .so (see ManifestPath), so
+// the host can check it before plugin.Open fails on mismatched packages.
+type PluginManifest struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// SDKVersion is the SDK version the plugin was built against
+	SDKVersion string `json:"sdk_version"`
+	// RequiresSDK is a version range such as "^1.1.0" or ">=1.1.0 <2.0.0";
+	// empty means ^SDKVersion
+	RequiresSDK  string       `json:"requires_sdk,omitempty"`
+	Capabilities []Capability `json:"capabilities,omitempty"`
+}
+
+// NewPluginManifest creates a manifest built against the current SDKVersion
+func NewPluginManifest(name, version string, capabilities ...Capability) PluginManifest {
+	return PluginManifest{
+		Name:         name,
+		Version:      version,
+		SDKVersion:   SDKVersion,
+		RequiresSDK:  "^" + SDKVersion,
+		Capabilities: capabilities,
+	}
+}
+
+// Has reports whether the manifest declares the capability
+func (m *PluginManifest) Has(c Capability) bool {
+	for _, declared := range m.Capabilities {
+		if declared == c {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyCapabilities checks that the handler implements every declared
+// capability; a declared generator must be set on the handler
+func (m *PluginManifest) VerifyCapabilities(handler ClientHandlerV2) error {
+	var missing []string
+	if _, err := UpgradeHandler(handler).PaymentGenerator(); m.Has(CapabilityGenerator) && err != nil {
+		missing = append(missing, "generator: "+err.Error())
+	}
+	if _, ok := AsRefundHandler(handler); m.Has(CapabilityRefunds) && !ok {
+		missing = append(missing, "refunds: handler does not implement RefundHandler")
+	}
+	if _, ok := AsSubscriptionHandler(handler); m.Has(CapabilitySubscriptions) && !ok {
+		missing = append(missing, "subscriptions: handler does not implement SubscriptionHandler")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("plugin %s declares missing capabilities: %s", m.Name, strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// ManifestPath returns the JSON manifest path for a plugin .so file:
+// plugins/shop/shop.so -> plugins/shop/shop.manifest.json
+func ManifestPath(pluginPath string) string {
+	return strings.TrimSuffix(pluginPath, ".so") + ".manifest.json"
+}
+
+// ReadPluginManifest reads a JSON manifest file
+func ReadPluginManifest(path string) (*PluginManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	var m PluginManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// ManifestFromSymbol converts the result of plugin.Lookup(PluginManifestSymbol)
+func ManifestFromSymbol(sym interface{}) (*PluginManifest, error) {
+	switch m := sym.(type) {
+	case *PluginManifest:
+		return m, nil
+	case func() PluginManifest:
+		manifest := m()
+		return &manifest, nil
+	default:
+		return nil, fmt.Errorf("%s has wrong type %T: expected yapay.PluginManifest", PluginManifestSymbol, sym)
+	}
+}
+
+// CompatibilityReport is the verdict of CheckCompatibility
+type CompatibilityReport struct {
+	Manifest   *PluginManifest
+	Compatible bool
+	// Problems make the plugin incompatible
+	Problems []string
+	// Warnings do not prevent loading
+	Warnings []string
+}
+
+// String returns a human-readable verdict
+func (r *CompatibilityReport) String() string {
+	var b strings.Builder
+	name := "plugin"
+	if r.Manifest != nil && r.Manifest.Name != "" {
+		name = fmt.Sprintf("plugin %s %s (built with SDK %s)", r.Manifest.Name, r.Manifest.Version, r.Manifest.SDKVersion)
+	}
+	if r.Compatible {
+		fmt.Fprintf(&b, "%s is compatible with SDK %s", name, SDKVersion)
+	} else {
+		fmt.Fprintf(&b, "%s is not compatible with SDK %s", name, SDKVersion)
+	}
+	for _, p := range r.Problems {
+		b.WriteString("\n  - " + p)
+	}
+	for _, w := range r.Warnings {
+		b.WriteString("\n  ! " + w)
+	}
+	return b.String()
+}
+
+// Err returns nil for compatible plugins and an ErrIncompatiblePlugin
+// error carrying the verdict otherwise
+func (r *CompatibilityReport) Err() error {
+	if r.Compatible {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrIncompatiblePlugin, r.String())
+}
+
+// CheckCompatibility checks a plugin manifest against this SDK version
+func CheckCompatibility(m *PluginManifest) *CompatibilityReport {
+	r := &CompatibilityReport{Manifest: m}
+	if m == nil {
+		r.Problems = append(r.Problems, "plugin has no manifest")
+		return r
+	}
+
+	if m.Name == "" {
+		r.Problems = append(r.Problems, "manifest has no name")
+	}
+	if _, err := parseSemver(m.Version); err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("plugin version: %v", err))
+	}
+
+	host, _ := parseSemver(SDKVersion)
+	built, err := parseSemver(m.SDKVersion)
+	if err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("sdk_version: %v", err))
+	}
+
+	requires := m.RequiresSDK
+	if requires == "" && err == nil {
+		requires = "^" + m.SDKVersion
+	}
+	if requires != "" {
+		constraint, err := parseVersionRange(requires)
+		switch {
+		case err != nil:
+			r.Problems = append(r.Problems, fmt.Sprintf("requires_sdk: %v", err))
+		case !constraint(host):
+			r.Problems = append(r.Problems, fmt.Sprintf("plugin requires SDK %s, host has %s", requires, SDKVersion))
+		}
+	}
+
+	if err == nil && built != host {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(
+			"plugin was built with SDK %s; Go plugins load only when built with exactly the host's package versions, rebuild against SDK %s if loading fails",
+			m.SDKVersion, SDKVersion))
+	}
+	for _, c := range m.Capabilities {
+		if !knownCapabilities[c] {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("unknown capability %q is ignored", c))
+		}
+	}
+
+	r.Compatible = len(r.Problems) == 0
+	return r
+}
+
+// semver is a parsed major.minor.patch version; pre-release versions sort
+// before the release
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+	core := strings.TrimPrefix(s, "v")
+	core, _, _ = strings.Cut(core, "+")
+	core, v.pre, _ = strings.Cut(core, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a major.minor.patch version", s)
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a major.minor.patch version", s)
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			return d
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return comparePrerelease(v.pre, o.pre)
+}
+
+// comparePrerelease orders pre-release versions by their dot-separated
+// identifiers (SemVer §11): numeric ones compare as numbers and sort before
+// alphanumeric ones, and a longer list wins when all shared ones are equal
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// parseVersionRange parses "||"-separated alternatives of space-separated
+// comparators: =, >, >=, <, <=, ^ (same major; same minor for 0.x) and ~
+// (same minor). A bare version means =.
+func parseVersionRange(s string) (func(semver) bool, error) {
+	var alternatives [][]func(semver) bool
+	for _, alt := range strings.Split(s, "||") {
+		var all []func(semver) bool
+		for _, field := range strings.Fields(strings.ReplaceAll(alt, ",", " ")) {
+			check, err := parseComparator(field)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, check...)
+		}
+		if len(all) == 0 {
+			return nil, fmt.Errorf("empty version range in %q", s)
+		}
+		alternatives = append(alternatives, all)
+	}
+
+	return func(v semver) bool {
+		for _, all := range alternatives {
+			ok := true
+			for _, check := range all {
+				ok = ok && check(v)
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseComparator(s string) ([]func(semver) bool, error) {
+	op := strings.TrimRight(s, "v0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	bound, err := parseSemver(strings.TrimPrefix(s, op))
+	if err != nil {
+		return nil, err
+	}
+	cmp := func(check func(int) bool) func(semver) bool {
+		return func(v semver) bool { return check(v.compare(bound)) }
+	}
+
+	switch op {
+	case "", "=":
+		return []func(semver) bool{cmp(func(c int) bool { return c == 0 })}, nil
+	case ">":
+		return []func(semver) bool{cmp(func(c int) bool { return c > 0 })}, nil
+	case ">=":
+		return []func(semver) bool{cmp(func(c int) bool { return c >= 0 })}, nil
+	case "<":
+		return []func(semver) bool{cmp(func(c int) bool { return c < 0 })}, nil
+	case "<=":
+		return []func(semver) bool{cmp(func(c int) bool { return c <= 0 })}, nil
+	case "^":
+		upper := semver{major: bound.major + 1}
+		if bound.major == 0 {
+			upper = semver{minor: bound.minor + 1}
+		}
+		return []func(semver) bool{
+			cmp(func(c int) bool { return c >= 0 }),
+			func(v semver) bool { return v.compare(upper) < 0 },
+		}, nil
+	case "~":
+		upper := semver{major: bound.major, minor: bound.minor + 1}
+		return []func(semver) bool{
+			cmp(func(c int) bool { return c >= 0 }),
+			func(v semver) bool { return v.compare(upper) < 0 },
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown version operator %q in %q", op, s)
+}
@@ -0,0 +1,131 @@
+package yapay_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	yapaytesting "github.com/metalmon/yapay-sdk/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	m := yapay.NewPluginManifest("shop", "1.0.0", yapay.CapabilityGenerator, yapay.CapabilityRefunds)
+	r := yapay.CheckCompatibility(&m)
+	assert.True(t, r.Compatible)
+	assert.Empty(t, r.Warnings)
+	assert.NoError(t, r.Err())
+	assert.Equal(t, "plugin shop 1.0.0 (built with SDK "+yapay.SDKVersion+") is compatible with SDK "+yapay.SDKVersion, r.String())
+
+	tests := []struct {
+		name    string
+		modify  func(m *yapay.PluginManifest)
+		problem string
+	}{
+		{"future major", func(m *yapay.PluginManifest) { m.SDKVersion, m.RequiresSDK = "2.0.0", "^2.0.0" }, "plugin requires SDK ^2.0.0"},
+		{"newer minor by default", func(m *yapay.PluginManifest) { m.SDKVersion, m.RequiresSDK = "1.99.0", "" }, "plugin requires SDK ^1.99.0"},
+		{"explicit range", func(m *yapay.PluginManifest) { m.RequiresSDK = ">=1.0.0 <1.1.0" }, "plugin requires SDK >=1.0.0 <1.1.0"},
+		{"invalid range", func(m *yapay.PluginManifest) { m.RequiresSDK = "=>1.0.0" }, "unknown version operator"},
+		{"invalid SDK version", func(m *yapay.PluginManifest) { m.SDKVersion = "latest" }, "sdk_version"},
+		{"missing name", func(m *yapay.PluginManifest) { m.Name = "" }, "manifest has no name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := yapay.NewPluginManifest("shop", "1.0.0")
+			tt.modify(&m)
+			r := yapay.CheckCompatibility(&m)
+			assert.False(t, r.Compatible)
+			assert.Contains(t, r.String(), "is not compatible")
+			assert.Contains(t, r.String(), tt.problem)
+			assert.True(t, errors.Is(r.Err(), yapay.ErrIncompatiblePlugin))
+		})
+	}
+
+	assert.False(t, yapay.CheckCompatibility(nil).Compatible)
+}
+
+func TestCheckCompatibility_Warnings(t *testing.T) {
+	m := yapay.NewPluginManifest("shop", "1.0.0", "payouts")
+	m.SDKVersion = "1.0.6"
+	m.RequiresSDK = "^1.0.0"
+
+	r := yapay.CheckCompatibility(&m)
+	assert.True(t, r.Compatible)
+	require.Len(t, r.Warnings, 2)
+	assert.Contains(t, r.Warnings[0], "rebuild against SDK "+yapay.SDKVersion)
+	assert.Contains(t, r.Warnings[1], `unknown capability "payouts"`)
+}
+
+func TestPluginManifest_VerifyCapabilities(t *testing.T) {
+	mock := yapaytesting.NewMockClientHandler()
+	handler := yapay.AdaptHandler(mock)
+
+	manifest := yapay.NewPluginManifest("mock", "1.0.0", yapay.CapabilityRefunds)
+	assert.NoError(t, manifest.VerifyCapabilities(handler))
+
+	manifest.Capabilities = append(manifest.Capabilities, yapay.CapabilityGenerator, yapay.CapabilitySubscriptions)
+	err := manifest.VerifyCapabilities(handler)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "generator")
+	assert.Contains(t, err.Error(), "subscriptions")
+
+	mock.PaymentGenerator = yapaytesting.NewMockPaymentGenerator()
+	err = manifest.VerifyCapabilities(handler)
+	assert.NotContains(t, err.Error(), "generator")
+}
+
+func TestParseVersionRange(t *testing.T) {
+	tests := []struct {
+		rng     string
+		version string
+		want    bool
+	}{
+		{"^1.2.0", "1.9.3", true},
+		{"^1.2.0", "2.0.0", false},
+		{"^1.2.0", "1.1.9", false},
+		{"^0.2.0", "0.3.0", false},
+		{"~1.2.0", "1.2.9", true},
+		{"~1.2.0", "1.3.0", false},
+		{">=1.0.0, <2.0.0", "1.5.0", true},
+		{"1.0.0 || 2.0.0", "2.0.0", true},
+		{">1.0.0", "1.0.0", false},
+		{">=1.1.0", "1.1.0-rc.1", false},
+		{">1.1.0-rc.9", "1.1.0-rc.10", true},
+		{"<1.1.0-rc.10", "1.1.0-rc.9", true},
+		{">1.1.0-rc", "1.1.0-rc.1", true},
+		{">1.1.0-rc.1", "1.1.0-rc.beta", true},
+		{"<1.1.0-alpha.2", "1.1.0-alpha.10", false},
+		{"<=1.0.0", "v1.0.0", true},
+	}
+	for _, tt := range tests {
+		check, err := yapay.ParseVersionRange(tt.rng)
+		require.NoError(t, err, tt.rng)
+		v, err := yapay.ParseSemver(tt.version)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, check(v), "%s in %s", tt.version, tt.rng)
+	}
+
+	_, err := yapay.ParseVersionRange("")
+	assert.Error(t, err)
+	_, err = yapay.ParseVersionRange("^1.2")
+	assert.Error(t, err)
+}
+
+func TestReadPluginManifest(t *testing.T) {
+	assert.Equal(t, filepath.Join("plugins", "shop", "shop.manifest.json"), yapay.ManifestPath(filepath.Join("plugins", "shop", "shop.so")))
+
+	path := filepath.Join(t.TempDir(), "shop.manifest.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"name":"shop","version":"1.0.0","sdk_version":"1.1.0","capabilities":["refunds"]}`), 0o600))
+	m, err := yapay.ReadPluginManifest(path)
+	require.NoError(t, err)
+	assert.True(t, m.Has(yapay.CapabilityRefunds))
+	assert.False(t, m.Has(yapay.CapabilityGenerator))
+
+	_, err = yapay.ManifestFromSymbol(m)
+	assert.NoError(t, err)
+	_, err = yapay.ManifestFromSymbol("shop")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package merchantapi
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// NewCart converts an SDK cart into the Yandex Pay cart payload.
+// Item discounts are listed as cart discounts and folded into item totals.
+func NewCart(cart *yapay.Cart, currency string) (*Cart, error) {
+	if err := cart.Validate(); err != nil {
+		return nil, err
+	}
+
+	format := func(minor int) (string, error) {
+		return yapay.NewMoney(int64(minor), currency).Decimal()
+	}
+
+	out := &Cart{Items: make([]CartItem, 0, len(cart.Items))}
+	for i := range cart.Items {
+		item := &cart.Items[i]
+
+		unitPrice, err := format(item.UnitPrice)
+		if err != nil {
+			return nil, err
+		}
+		subtotal, err := format(item.Subtotal())
+		if err != nil {
+			return nil, err
+		}
+		total, err := format(item.Total())
+		if err != nil {
+			return nil, err
+		}
+
+		converted := CartItem{
+			ProductID: item.ProductID,
+			Title:     item.Title,
+			Quantity:  ItemQuantity{Count: strconv.Itoa(item.Quantity)},
+			UnitPrice: unitPrice,
+			Subtotal:  subtotal,
+			Total:     total,
+		}
+		// Yandex Pay expects total = discountedUnitPrice * count, so the
+		// discounted unit price is only sent when it is exact
+		if item.DiscountAmount() > 0 && item.Total()%item.Quantity == 0 {
+			if converted.DiscountedUnitPrice, err = format(item.Total() / item.Quantity); err != nil {
+				return nil, err
+			}
+		}
+		if m := item.Measurements; m != nil {
+			converted.Measurements = &Measurements{Weight: m.Weight, Height: m.Height, Length: m.Length, Width: m.Width}
+		}
+		out.Items = append(out.Items, converted)
+
+		for j, d := range item.Discounts {
+			amount, err := format(d.Amount)
+			if err != nil {
+				return nil, err
+			}
+			id := d.ID
+			if id == "" {
+				id = fmt.Sprintf("%s-%d", item.ProductID, j+1)
+			}
+			out.Discounts = append(out.Discounts, Discount{DiscountID: id, Amount: amount, Description: d.Description})
+		}
+	}
+
+	total, err := format(cart.Total())
+	if err != nil {
+		return nil, err
+	}
+	out.Total = CartTotal{Amount: total}
+	return out, nil
+}
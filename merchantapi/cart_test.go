@@ -0,0 +1,62 @@
+package merchantapi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCart(t *testing.T) {
+	cart := &yapay.Cart{Items: []yapay.CartItem{
+		{
+			ProductID:    "mug",
+			Title:        "Mug",
+			Quantity:     3,
+			UnitPrice:    30000,
+			Discounts:    []yapay.ItemDiscount{{ID: "promo", Description: "Spring sale", Amount: 15000}},
+			Measurements: &yapay.Measurements{Weight: 0.4, Height: 0.1, Length: 0.1, Width: 0.1},
+		},
+		{
+			ProductID: "pen",
+			Title:     "Pen",
+			Quantity:  3,
+			UnitPrice: 1000,
+			Discounts: []yapay.ItemDiscount{{Amount: 100}},
+		},
+	}}
+
+	out, err := NewCart(cart, "RUB")
+	require.NoError(t, err)
+	require.Len(t, out.Items, 2)
+
+	mug := out.Items[0]
+	assert.Equal(t, "3", mug.Quantity.Count)
+	assert.Equal(t, "300.00", mug.UnitPrice)
+	assert.Equal(t, "900.00", mug.Subtotal)
+	assert.Equal(t, "250.00", mug.DiscountedUnitPrice)
+	assert.Equal(t, "750.00", mug.Total)
+	require.NotNil(t, mug.Measurements)
+	assert.Equal(t, 0.4, mug.Measurements.Weight)
+
+	// 2900 is not divisible by 3, so no discounted unit price is sent
+	pen := out.Items[1]
+	assert.Empty(t, pen.DiscountedUnitPrice)
+	assert.Equal(t, "29.00", pen.Total)
+
+	assert.Equal(t, []Discount{
+		{DiscountID: "promo", Amount: "150.00", Description: "Spring sale"},
+		{DiscountID: "pen-1", Amount: "1.00"},
+	}, out.Discounts)
+	assert.Equal(t, "779.00", out.Total.Amount)
+}
+
+func TestNewCart_Errors(t *testing.T) {
+	_, err := NewCart(&yapay.Cart{}, "RUB")
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+
+	_, err = NewCart(&yapay.Cart{Items: []yapay.CartItem{{ProductID: "p", Quantity: 1, UnitPrice: 1}}}, "XXX")
+	assert.True(t, errors.Is(err, yapay.ErrUnknownCurrency))
+}
@@ -0,0 +1,306 @@
+// Package merchantapi is a client for the Yandex Pay Merchant API orders endpoints.
+package merchantapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// Merchant API base URLs used when YandexConfig.APIBaseURL is empty
+const (
+	ProductionBaseURL = "https://pay.yandex.ru/api/merchant"
+	SandboxBaseURL    = "https://sandbox.pay.yandex.ru/api/merchant"
+)
+
+const (
+	defaultOrdersPath   = "/v1/orders"
+	defaultTimeout      = 15 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+	maxResponseSize     = 4 << 20
+)
+
+// Client calls the Yandex Pay Merchant API. It is safe for concurrent use.
+type Client struct {
+	baseURL      string
+	ordersURL    string
+	apiKey       string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for API calls
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithMaxRetries sets how many times a retryable failure is retried
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithRetryBackoff sets the initial delay between retries; it doubles on every attempt
+func WithRetryBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.retryBackoff = d
+	}
+}
+
+// New creates a client from the merchant Yandex configuration.
+// The base URL is chosen from SandboxMode unless APIBaseURL is set,
+// and OrdersEndpoint may override the orders path or URL.
+// In sandbox mode the merchant ID is used as API key when SecretKey is empty.
+func New(cfg yapay.YandexConfig, opts ...Option) (*Client, error) {
+	apiKey := cfg.SecretKey.Reveal()
+	if apiKey == "" && cfg.SandboxMode {
+		apiKey = cfg.MerchantID
+	}
+	if apiKey == "" {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "merchant API key is required").
+			WithDetail("secret_key", "Secret key is required")
+	}
+
+	baseURL := cfg.APIBaseURL
+	if baseURL == "" {
+		baseURL = ProductionBaseURL
+		if cfg.SandboxMode {
+			baseURL = SandboxBaseURL
+		}
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	ordersURL := baseURL + defaultOrdersPath
+	switch {
+	case strings.HasPrefix(cfg.OrdersEndpoint, "http://"), strings.HasPrefix(cfg.OrdersEndpoint, "https://"):
+		ordersURL = strings.TrimRight(cfg.OrdersEndpoint, "/")
+	case cfg.OrdersEndpoint != "":
+		ordersURL = baseURL + "/" + strings.Trim(cfg.OrdersEndpoint, "/")
+	}
+
+	c := &Client{
+		baseURL:      baseURL,
+		ordersURL:    ordersURL,
+		apiKey:       apiKey,
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// BaseURL returns the Merchant API base URL used by the client
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// CreateOrder creates an order and returns its payment URL
+func (c *Client) CreateOrder(ctx context.Context, req *CreateOrderRequest) (*CreateOrderResponse, error) {
+	var resp CreateOrderResponse
+	if err := c.do(ctx, http.MethodPost, c.ordersURL, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetOrder returns the order and its operations
+func (c *Client) GetOrder(ctx context.Context, orderID string) (*OrderResponse, error) {
+	var resp OrderResponse
+	if err := c.do(ctx, http.MethodGet, c.orderURL(orderID, ""), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelOrder cancels an authorized order
+func (c *Client) CancelOrder(ctx context.Context, orderID string, req *CancelRequest) (*OperationResponse, error) {
+	return c.operation(ctx, orderID, "cancel", req)
+}
+
+// CaptureOrder captures the authorized amount of an order
+func (c *Client) CaptureOrder(ctx context.Context, orderID string, req *CaptureRequest) (*OperationResponse, error) {
+	return c.operation(ctx, orderID, "capture", req)
+}
+
+// RefundOrder refunds the whole order or a part of it
+func (c *Client) RefundOrder(ctx context.Context, orderID string, req *RefundRequest) (*OperationResponse, error) {
+	return c.operation(ctx, orderID, "refund", req)
+}
+
+func (c *Client) operation(ctx context.Context, orderID, action string, body interface{}) (*OperationResponse, error) {
+	var resp OperationResponse
+	if err := c.do(ctx, http.MethodPost, c.orderURL(orderID, action), body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) orderURL(orderID, action string) string {
+	u := c.ordersURL + "/" + url.PathEscape(orderID)
+	if action != "" {
+		u += "/" + action
+	}
+	return u
+}
+
+// envelope is the common Merchant API response wrapper
+type envelope struct {
+	Status     string          `json:"status"`
+	Code       int             `json:"code"`
+	Data       json.RawMessage `json:"data"`
+	ReasonCode string          `json:"reasonCode"`
+	Reason     string          `json:"reason"`
+}
+
+// do performs the call, retrying retryable failures with the same request ID
+// so that Yandex Pay treats the attempts as one idempotent operation
+func (c *Client) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to encode merchant API request")
+		}
+	}
+
+	requestID := IdempotencyKeyFromContext(ctx)
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff(attempt, lastErr)); err != nil {
+				return yapay.AsError(err)
+			}
+		}
+
+		lastErr = c.attempt(ctx, method, endpoint, payload, requestID, attempt, out)
+		if lastErr == nil || !yapay.IsRetryable(lastErr) || ctx.Err() != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) attempt(ctx context.Context, method, endpoint string, payload []byte, requestID string, attempt int, out interface{}) error {
+	var body io.Reader = http.NoBody
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to build merchant API request")
+	}
+	req.Header.Set("Authorization", "Api-Key "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-Id", requestID)
+	req.Header.Set("X-Request-Attempt", strconv.Itoa(attempt))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("X-Request-Timeout", strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return yapay.AsError(ctxErr)
+		}
+		return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, "merchant API request failed")
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, "failed to read merchant API response")
+	}
+
+	var env envelope
+	decodeErr := json.Unmarshal(data, &env)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp, &env)
+	}
+	if decodeErr != nil {
+		return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, decodeErr, "failed to decode merchant API response")
+	}
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, "failed to decode merchant API response data")
+		}
+	}
+	return nil
+}
+
+func (c *Client) backoff(attempt int, lastErr error) time.Duration {
+	var apiErr *APIError
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	return c.retryBackoff << (attempt - 1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	// RFC 4122 version 4 UUID
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context that makes the client send key as
+// X-Request-Id. Reuse the same key when repeating an operation after a crash
+// or timeout so Yandex Pay does not execute it twice.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the key set by WithIdempotencyKey
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
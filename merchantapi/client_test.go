@@ -0,0 +1,253 @@
+package merchantapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMerchantAPI records requests and replies with queued responses
+type fakeMerchantAPI struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  []*http.Request
+	bodies    []map[string]interface{}
+	responses []fakeResponse
+}
+
+type fakeResponse struct {
+	status int
+	body   interface{}
+	header map[string]string
+}
+
+func newFakeMerchantAPI(t *testing.T) *fakeMerchantAPI {
+	f := &fakeMerchantAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeMerchantAPI) enqueue(status int, body interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, fakeResponse{status: status, body: body})
+}
+
+func (f *fakeMerchantAPI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, body)
+
+	resp := fakeResponse{status: http.StatusInternalServerError, body: map[string]string{"status": "fail"}}
+	if len(f.responses) > 0 {
+		resp = f.responses[0]
+		f.responses = f.responses[1:]
+	}
+	for k, v := range resp.header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	_ = json.NewEncoder(w).Encode(resp.body)
+}
+
+func success(data interface{}) map[string]interface{} {
+	return map[string]interface{}{"status": "success", "code": 200, "data": data}
+}
+
+func newTestClient(t *testing.T, f *fakeMerchantAPI) *Client {
+	client, err := New(yapay.YandexConfig{
+		MerchantID: "merchant-1",
+		SecretKey:  "api-key",
+		APIBaseURL: f.URL,
+	}, WithHTTPClient(f.Client()), WithRetryBackoff(time.Millisecond))
+	require.NoError(t, err)
+	return client
+}
+
+func TestNew_BaseURLSelection(t *testing.T) {
+	sandbox, err := New(yapay.YandexConfig{MerchantID: "m", SandboxMode: true})
+	require.NoError(t, err)
+	assert.Equal(t, SandboxBaseURL, sandbox.BaseURL())
+	assert.Equal(t, "m", sandbox.apiKey)
+
+	production, err := New(yapay.YandexConfig{MerchantID: "m", SecretKey: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, ProductionBaseURL, production.BaseURL())
+	assert.Equal(t, ProductionBaseURL+"/v1/orders", production.ordersURL)
+
+	custom, err := New(yapay.YandexConfig{SecretKey: "k", APIBaseURL: "https://api.test/", OrdersEndpoint: "/v2/orders/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.test/v2/orders", custom.ordersURL)
+
+	_, err = New(yapay.YandexConfig{MerchantID: "m"})
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+}
+
+func TestClient_CreateOrder(t *testing.T) {
+	f := newFakeMerchantAPI(t)
+	f.enqueue(http.StatusOK, success(map[string]string{"paymentUrl": "https://pay.test/l/abc"}))
+	client := newTestClient(t, f)
+
+	resp, err := client.CreateOrder(context.Background(), &CreateOrderRequest{
+		OrderID:      "order-1",
+		CurrencyCode: "RUB",
+		Cart: Cart{
+			Items: []CartItem{{ProductID: "p1", Title: "Course", Quantity: ItemQuantity{Count: "1"}, Total: "10.00"}},
+			Total: CartTotal{Amount: "10.00"},
+		},
+		RedirectURLs: &RedirectURLs{OnSuccess: "https://shop.test/ok"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://pay.test/l/abc", resp.PaymentURL)
+
+	require.Len(t, f.requests, 1)
+	req := f.requests[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/v1/orders", req.URL.Path)
+	assert.Equal(t, "Api-Key api-key", req.Header.Get("Authorization"))
+	assert.NotEmpty(t, req.Header.Get("X-Request-Id"))
+	assert.Equal(t, "order-1", f.bodies[0]["orderId"])
+	assert.Equal(t, "RUB", f.bodies[0]["currencyCode"])
+}
+
+func TestClient_OrderOperations(t *testing.T) {
+	f := newFakeMerchantAPI(t)
+	client := newTestClient(t, f)
+	ctx := context.Background()
+
+	f.enqueue(http.StatusOK, success(map[string]interface{}{
+		"order": map[string]interface{}{
+			"orderId":       "order/1",
+			"paymentStatus": "CAPTURED",
+			"currencyCode":  "RUB",
+			"created":       "2025-09-15T10:00:00Z",
+		},
+	}))
+	order, err := client.GetOrder(ctx, "order/1")
+	require.NoError(t, err)
+	assert.Equal(t, "CAPTURED", order.Order.PaymentStatus)
+	assert.Equal(t, 2025, order.Order.Created.Year())
+	assert.Equal(t, "/v1/orders/order%2F1", f.requests[0].URL.EscapedPath())
+
+	operation := func(opType string) map[string]interface{} {
+		return success(map[string]interface{}{
+			"operation": map[string]string{"operationId": "op-" + opType, "operationType": opType, "status": "PENDING"},
+		})
+	}
+
+	f.enqueue(http.StatusOK, operation("REFUND"))
+	refund, err := client.RefundOrder(ctx, "order-1", &RefundRequest{RefundAmount: "5.00", ExternalOperationID: "r1"})
+	require.NoError(t, err)
+	assert.Equal(t, "REFUND", refund.Operation.OperationType)
+	assert.Equal(t, "/v1/orders/order-1/refund", f.requests[1].URL.Path)
+	assert.Equal(t, "5.00", f.bodies[1]["refundAmount"])
+
+	f.enqueue(http.StatusOK, operation("CAPTURE"))
+	_, err = client.CaptureOrder(ctx, "order-1", &CaptureRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/orders/order-1/capture", f.requests[2].URL.Path)
+
+	f.enqueue(http.StatusOK, operation("CANCEL"))
+	_, err = client.CancelOrder(ctx, "order-1", &CancelRequest{Reason: "out of stock"})
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/orders/order-1/cancel", f.requests[3].URL.Path)
+	assert.Equal(t, "out of stock", f.bodies[3]["reason"])
+}
+
+func TestClient_RetriesWithSameIdempotencyKey(t *testing.T) {
+	f := newFakeMerchantAPI(t)
+	f.enqueue(http.StatusServiceUnavailable, map[string]string{"status": "fail", "reasonCode": "UNAVAILABLE"})
+	f.enqueue(http.StatusTooManyRequests, map[string]string{"status": "fail", "reasonCode": "RATE_LIMITED"})
+	f.enqueue(http.StatusOK, success(map[string]string{"paymentUrl": "https://pay.test/l/abc"}))
+	client := newTestClient(t, f)
+
+	ctx := WithIdempotencyKey(context.Background(), "create-order-1")
+	_, err := client.CreateOrder(ctx, &CreateOrderRequest{OrderID: "order-1"})
+	require.NoError(t, err)
+
+	require.Len(t, f.requests, 3)
+	for i, req := range f.requests {
+		assert.Equal(t, "create-order-1", req.Header.Get("X-Request-Id"))
+		assert.Equal(t, strconv.Itoa(i), req.Header.Get("X-Request-Attempt"))
+		assert.Equal(t, "order-1", f.bodies[i]["orderId"])
+	}
+}
+
+func TestClient_ErrorDecoding(t *testing.T) {
+	tests := []struct {
+		status    int
+		sentinel  error
+		retryable bool
+	}{
+		{http.StatusBadRequest, yapay.ErrValidation, false},
+		{http.StatusUnauthorized, yapay.ErrUnauthorized, false},
+		{http.StatusForbidden, yapay.ErrForbidden, false},
+		{http.StatusNotFound, yapay.ErrNotFound, false},
+		{http.StatusConflict, yapay.ErrConflict, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			f := newFakeMerchantAPI(t)
+			f.enqueue(tt.status, map[string]interface{}{
+				"status":     "fail",
+				"code":       tt.status,
+				"reasonCode": "ORDER_NOT_FOUND",
+				"reason":     "order not found",
+			})
+			client := newTestClient(t, f)
+
+			_, err := client.GetOrder(context.Background(), "missing")
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.sentinel))
+			assert.Equal(t, tt.retryable, yapay.IsRetryable(err))
+			assert.Len(t, f.requests, 1, "non-retryable errors must not be retried")
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, "ORDER_NOT_FOUND", apiErr.ReasonCode)
+			assert.Equal(t, "order not found", apiErr.Reason)
+		})
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	f := newFakeMerchantAPI(t)
+	client := newTestClient(t, f)
+	client.maxRetries = 2
+
+	_, err := client.GetOrder(context.Background(), "order-1")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, yapay.ErrBackendUnavailable))
+	assert.True(t, yapay.IsRetryable(err))
+	assert.Len(t, f.requests, 3)
+}
+
+func TestClient_ContextCanceled(t *testing.T) {
+	f := newFakeMerchantAPI(t)
+	client := newTestClient(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.GetOrder(ctx, "order-1")
+	require.Error(t, err)
+	assert.Empty(t, f.requests)
+}
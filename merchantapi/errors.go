@@ -0,0 +1,68 @@
+package merchantapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// APIError is the failure reported by the Merchant API. It is wrapped in a
+// *yapay.Error, so callers can use both errors.As(err, &apiErr) and
+// errors.Is(err, yapay.ErrNotFound).
+type APIError struct {
+	StatusCode int
+	ReasonCode string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("merchant API %d %s: %s", e.StatusCode, e.ReasonCode, e.Reason)
+	}
+	return fmt.Sprintf("merchant API %d %s", e.StatusCode, e.ReasonCode)
+}
+
+// decodeError converts a non-2xx response into a structured SDK error
+func decodeError(resp *http.Response, env *envelope) *yapay.Error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		ReasonCode: env.ReasonCode,
+		Reason:     env.Reason,
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if apiErr.ReasonCode == "" {
+		apiErr.ReasonCode = http.StatusText(resp.StatusCode)
+	}
+
+	return yapay.WrapError(errorCodeForStatus(resp.StatusCode), apiErr, "merchant API request rejected")
+}
+
+func errorCodeForStatus(status int) yapay.ErrorCode {
+	switch {
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return yapay.ErrorCodeValidation
+	case status == http.StatusUnauthorized:
+		return yapay.ErrorCodeUnauthorized
+	case status == http.StatusForbidden:
+		return yapay.ErrorCodeForbidden
+	case status == http.StatusNotFound:
+		return yapay.ErrorCodeNotFound
+	case status == http.StatusConflict:
+		return yapay.ErrorCodeConflict
+	case status == http.StatusTooManyRequests:
+		return yapay.ErrorCodeRateLimited
+	case status == http.StatusGatewayTimeout, status == http.StatusRequestTimeout:
+		return yapay.ErrorCodeTimeout
+	case status >= http.StatusInternalServerError:
+		return yapay.ErrorCodeBackendUnavailable
+	default:
+		return yapay.ErrorCodeInternal
+	}
+}
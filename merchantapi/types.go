@@ -0,0 +1,175 @@
+package merchantapi
+
+import (
+	"time"
+)
+
+// Amounts are decimal strings in major units, e.g. "1234.50".
+// Use yapay.Money.Decimal to produce them from minor units.
+
+// CreateOrderRequest is the body of the create order call
+type CreateOrderRequest struct {
+	OrderID                 string        `json:"orderId"`
+	CurrencyCode            string        `json:"currencyCode"`
+	Cart                    Cart          `json:"cart"`
+	RedirectURLs            *RedirectURLs `json:"redirectUrls,omitempty"`
+	AvailablePaymentMethods []string      `json:"availablePaymentMethods,omitempty"`
+	TTL                     int           `json:"ttl,omitempty"`
+	Metadata                string        `json:"metadata,omitempty"`
+}
+
+// RedirectURLs are the pages the buyer returns to after payment
+type RedirectURLs struct {
+	OnSuccess string `json:"onSuccess"`
+	OnError   string `json:"onError,omitempty"`
+	OnAbort   string `json:"onAbort,omitempty"`
+}
+
+// Cart is the order cart
+type Cart struct {
+	Items     []CartItem `json:"items"`
+	Discounts []Discount `json:"discounts,omitempty"`
+	Total     CartTotal  `json:"total"`
+}
+
+// CartItem is a single cart position
+type CartItem struct {
+	ProductID           string        `json:"productId"`
+	Title               string        `json:"title,omitempty"`
+	Quantity            ItemQuantity  `json:"quantity"`
+	UnitPrice           string        `json:"unitPrice,omitempty"`
+	DiscountedUnitPrice string        `json:"discountedUnitPrice,omitempty"`
+	Subtotal            string        `json:"subtotal,omitempty"`
+	Total               string        `json:"total,omitempty"`
+	Measurements        *Measurements `json:"measurements,omitempty"`
+	Receipt             *ItemReceipt  `json:"receipt,omitempty"`
+}
+
+// ItemReceipt is the fiscal (54-FZ) data of a cart item
+type ItemReceipt struct {
+	Tax                int              `json:"tax"`
+	Title              string           `json:"title,omitempty"`
+	Measure            int              `json:"measure,omitempty"`
+	PaymentMethodType  string           `json:"paymentMethodType,omitempty"`
+	PaymentSubjectType string           `json:"paymentSubjectType,omitempty"`
+	Agent              *ReceiptAgent    `json:"agent,omitempty"`
+	Supplier           *ReceiptSupplier `json:"supplier,omitempty"`
+}
+
+// ReceiptAgent describes the agent selling on behalf of the supplier
+type ReceiptAgent struct {
+	AgentType        int               `json:"agentType"`
+	Operation        string            `json:"operation,omitempty"`
+	Phones           []string          `json:"phones,omitempty"`
+	PaymentsOperator *PaymentsOperator `json:"paymentsOperator,omitempty"`
+	TransferOperator *TransferOperator `json:"transferOperator,omitempty"`
+}
+
+// PaymentsOperator is the payment acceptance operator of a payment agent
+type PaymentsOperator struct {
+	Phones []string `json:"phones,omitempty"`
+}
+
+// TransferOperator is the money transfer operator of a bank payment agent
+type TransferOperator struct {
+	INN     string   `json:"inn,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Phones  []string `json:"phones,omitempty"`
+}
+
+// ReceiptSupplier is the supplier of an item sold by an agent
+type ReceiptSupplier struct {
+	INN    string   `json:"inn,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Phones []string `json:"phones,omitempty"`
+}
+
+// ItemQuantity is the quantity of a cart item
+type ItemQuantity struct {
+	Count string `json:"count"`
+}
+
+// Discount is a discount applied to the cart
+type Discount struct {
+	DiscountID  string `json:"discountId"`
+	Amount      string `json:"amount"`
+	Description string `json:"description,omitempty"`
+}
+
+// Measurements are the dimensions of one unit: weight in kilograms, sizes in meters
+type Measurements struct {
+	Weight float64 `json:"weight"`
+	Height float64 `json:"height"`
+	Length float64 `json:"length"`
+	Width  float64 `json:"width"`
+}
+
+// CartTotal is the total amount of a cart
+type CartTotal struct {
+	Amount string `json:"amount"`
+}
+
+// CreateOrderResponse is returned by the create order call
+type CreateOrderResponse struct {
+	PaymentURL string `json:"paymentUrl"`
+}
+
+// Order is an order as stored by Yandex Pay
+type Order struct {
+	OrderID       string    `json:"orderId"`
+	MerchantID    string    `json:"merchantId,omitempty"`
+	PaymentStatus string    `json:"paymentStatus"`
+	CurrencyCode  string    `json:"currencyCode"`
+	Cart          *Cart     `json:"cart,omitempty"`
+	PaymentURL    string    `json:"paymentUrl,omitempty"`
+	Metadata      string    `json:"metadata,omitempty"`
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+}
+
+// Operation is an asynchronous order operation (capture, cancel, refund)
+type Operation struct {
+	OperationID         string    `json:"operationId"`
+	OperationType       string    `json:"operationType"`
+	OrderID             string    `json:"orderId"`
+	Status              string    `json:"status"`
+	Amount              string    `json:"amount,omitempty"`
+	ExternalOperationID string    `json:"externalOperationId,omitempty"`
+	Reason              string    `json:"reason,omitempty"`
+	Created             time.Time `json:"created"`
+	Updated             time.Time `json:"updated"`
+}
+
+// OrderResponse is returned by the get order call
+type OrderResponse struct {
+	Order      Order       `json:"order"`
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+// CancelRequest is the body of the cancel call
+type CancelRequest struct {
+	Reason              string `json:"reason"`
+	ExternalOperationID string `json:"externalOperationId,omitempty"`
+}
+
+// CaptureRequest is the body of the capture call. OrderAmount and Cart are
+// only needed when capturing less than the authorized amount.
+type CaptureRequest struct {
+	ExternalOperationID string `json:"externalOperationId,omitempty"`
+	OrderAmount         string `json:"orderAmount,omitempty"`
+	Cart                *Cart  `json:"cart,omitempty"`
+}
+
+// RefundRequest is the body of the refund call
+type RefundRequest struct {
+	RefundAmount        string `json:"refundAmount"`
+	OrderAmount         string `json:"orderAmount,omitempty"`
+	ExternalOperationID string `json:"externalOperationId,omitempty"`
+	Cart                *Cart  `json:"cart,omitempty"`
+}
+
+// OperationResponse is returned by cancel, capture and refund calls
+type OperationResponse struct {
+	Operation Operation `json:"operation"`
+}
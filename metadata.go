@@ -0,0 +1,204 @@
+package yapay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ErrMetadataMissing is wrapped by Metadata getters for absent keys
+var ErrMetadataMissing = errors.New("metadata key is missing")
+
+// Metadata holds free-form payment data. Its JSON form is a plain object;
+// numbers decoded from JSON are float64, which the typed getters handle.
+type Metadata map[string]interface{}
+
+// Get returns the raw value of key
+func (m Metadata) Get(key string) (interface{}, bool) {
+	value, ok := m[key]
+	return value, ok && value != nil
+}
+
+// GetString returns key as a string. Numbers are formatted without
+// exponent, so numeric IDs such as 12345 read back as "12345".
+func (m Metadata) GetString(key string) (string, error) {
+	value, err := m.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	}
+	if n, ok := toInt64(value); ok {
+		return strconv.FormatInt(n, 10), nil
+	}
+	return "", metadataTypeError(key, value, "a string")
+}
+
+// GetInt64 returns key as an integer. Whole float64 values and numeric
+// strings are accepted; fractions are an error.
+func (m Metadata) GetInt64(key string) (int64, error) {
+	value, err := m.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+	default:
+		if n, ok := toInt64(value); ok {
+			return n, nil
+		}
+	}
+	return 0, metadataTypeError(key, value, "an integer")
+}
+
+// GetBool returns key as a boolean; strings such as "true" or "1" are accepted
+func (m Metadata) GetBool(key string) (bool, error) {
+	value, err := m.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, metadataTypeError(key, value, "a boolean")
+}
+
+// GetTime returns key as a time. RFC 3339 strings and Unix timestamps in
+// seconds are accepted.
+func (m Metadata) GetTime(key string) (time.Time, error) {
+	value, err := m.lookup(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, nil
+		}
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	default:
+		if n, ok := toInt64(value); ok {
+			return time.Unix(n, 0).UTC(), nil
+		}
+	}
+	return time.Time{}, metadataTypeError(key, value, "an RFC 3339 time")
+}
+
+// Decode fills the struct pointed to by v from the metadata using its json tags
+func (m Metadata) Decode(v interface{}) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return WrapError(ErrorCodeValidation, err, "metadata cannot be encoded")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		e := WrapError(ErrorCodeValidation, err, "metadata does not match the expected structure")
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			e.WithDetail("metadata."+typeErr.Field, fmt.Sprintf("must be %s", typeErr.Type))
+		}
+		return e
+	}
+	return nil
+}
+
+// Encode stores the fields of struct v in the metadata using its json tags,
+// overwriting existing keys
+func (m Metadata) Encode(v interface{}) error {
+	encoded, err := EncodeMetadata(v)
+	if err != nil {
+		return err
+	}
+	if m == nil && len(encoded) > 0 {
+		return NewError(ErrorCodeInternal, "cannot encode into nil metadata")
+	}
+	for key, value := range encoded {
+		m[key] = value
+	}
+	return nil
+}
+
+// EncodeMetadata converts struct v into Metadata using its json tags. Values
+// take the form they would have after a JSON round trip.
+func EncodeMetadata(v interface{}) (Metadata, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, WrapError(ErrorCodeValidation, err, "metadata cannot be encoded")
+	}
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, WrapError(ErrorCodeValidation, err, "metadata must encode to a JSON object")
+	}
+	return m, nil
+}
+
+func (m Metadata) lookup(key string) (interface{}, error) {
+	value, ok := m.Get(key)
+	if !ok {
+		return nil, WrapError(ErrorCodeValidation, ErrMetadataMissing, fmt.Sprintf("metadata key %q", key)).
+			WithDetail("metadata."+key, "is required")
+	}
+	return value, nil
+}
+
+func metadataTypeError(key string, value interface{}, want string) *Error {
+	return Errorf(ErrorCodeValidation, "metadata key %q is %T, not %s", key, value, want).
+		WithDetail("metadata."+key, "must be "+want)
+}
+
+// toInt64 converts Go integer types
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
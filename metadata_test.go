@@ -0,0 +1,150 @@
+package yapay
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeMetadata(t *testing.T, data string) Metadata {
+	t.Helper()
+	var req PaymentRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"metadata":`+data+`}`), &req))
+	return req.Metadata
+}
+
+func TestMetadata_Getters(t *testing.T) {
+	m := decodeMetadata(t, `{
+		"email": "buyer@example.com",
+		"product_id": 12345,
+		"quantity": "3",
+		"discount": 2.5,
+		"gift": true,
+		"subscribe": "false",
+		"delivery_at": "2024-05-01T12:30:00+03:00",
+		"created_unix": 1714555800,
+		"empty": null
+	}`)
+
+	s, err := m.GetString("email")
+	require.NoError(t, err)
+	assert.Equal(t, "buyer@example.com", s)
+
+	s, err = m.GetString("product_id")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", s)
+
+	n, err := m.GetInt64("product_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345), n)
+
+	n, err = m.GetInt64("quantity")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	b, err := m.GetBool("gift")
+	require.NoError(t, err)
+	assert.True(t, b)
+
+	b, err = m.GetBool("subscribe")
+	require.NoError(t, err)
+	assert.False(t, b)
+
+	tm, err := m.GetTime("delivery_at")
+	require.NoError(t, err)
+	assert.True(t, tm.Equal(time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)))
+
+	tm, err = m.GetTime("created_unix")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1714555800), tm.Unix())
+}
+
+func TestMetadata_GetterErrors(t *testing.T) {
+	m := Metadata{"discount": 2.5, "email": "buyer@example.com", "tags": []interface{}{"a"}, "empty": nil}
+
+	_, err := m.GetInt64("discount")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrValidation))
+	var yerr *Error
+	require.True(t, errors.As(err, &yerr))
+	assert.Equal(t, []string{"must be an integer"}, yerr.Details["metadata.discount"])
+
+	_, err = m.GetBool("email")
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	_, err = m.GetTime("email")
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	_, err = m.GetString("tags")
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	for _, key := range []string{"missing", "empty"} {
+		_, err = m.GetString(key)
+		assert.True(t, errors.Is(err, ErrMetadataMissing), key)
+		assert.True(t, errors.Is(err, ErrValidation), key)
+	}
+
+	var nilMeta Metadata
+	_, err = nilMeta.GetInt64("anything")
+	assert.True(t, errors.Is(err, ErrMetadataMissing))
+}
+
+type orderMetadata struct {
+	ProductID  string    `json:"product_id"`
+	Quantity   int       `json:"quantity"`
+	Gift       bool      `json:"gift,omitempty"`
+	DeliveryAt time.Time `json:"delivery_at"`
+}
+
+func TestMetadata_DecodeEncode(t *testing.T) {
+	m := decodeMetadata(t, `{"product_id":"sku-1","quantity":2,"delivery_at":"2024-05-01T12:30:00Z","extra":"kept"}`)
+
+	var order orderMetadata
+	require.NoError(t, m.Decode(&order))
+	assert.Equal(t, "sku-1", order.ProductID)
+	assert.Equal(t, 2, order.Quantity)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), order.DeliveryAt)
+
+	order.Quantity = 5
+	order.Gift = true
+	require.NoError(t, m.Encode(order))
+	assert.Equal(t, float64(5), m["quantity"])
+	assert.Equal(t, true, m["gift"])
+	assert.Equal(t, "kept", m["extra"])
+
+	// Encoded metadata has the same JSON form as before
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"product_id":"sku-1","quantity":5,"gift":true,"delivery_at":"2024-05-01T12:30:00Z","extra":"kept"}`, string(data))
+}
+
+func TestMetadata_DecodeTypeMismatch(t *testing.T) {
+	m := Metadata{"quantity": "many"}
+	var order orderMetadata
+	err := m.Decode(&order)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrValidation))
+	var yerr *Error
+	require.True(t, errors.As(err, &yerr))
+	assert.Contains(t, yerr.Details, "metadata.quantity")
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	m, err := EncodeMetadata(orderMetadata{ProductID: "sku-2", Quantity: 1})
+	require.NoError(t, err)
+	id, err := m.GetString("product_id")
+	require.NoError(t, err)
+	assert.Equal(t, "sku-2", id)
+	_, ok := m.Get("gift")
+	assert.False(t, ok)
+
+	_, err = EncodeMetadata([]string{"not", "an", "object"})
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	var nilMeta Metadata
+	assert.Error(t, nilMeta.Encode(orderMetadata{}))
+}
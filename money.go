@@ -0,0 +1,313 @@
+package yapay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// currencyExponents maps the active ISO 4217 currency codes to the number of
+// minor-unit digits, as published in ISO 4217 list one
+var currencyExponents = map[string]int{
+	// Zero-decimal currencies
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0,
+	"KMF": 0, "KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0,
+	"VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+
+	// Two-decimal currencies
+	"AED": 2, "AFN": 2, "ALL": 2, "AMD": 2, "ANG": 2, "AOA": 2,
+	"ARS": 2, "AUD": 2, "AWG": 2, "AZN": 2, "BAM": 2, "BBD": 2,
+	"BDT": 2, "BGN": 2, "BMD": 2, "BND": 2, "BOB": 2, "BOV": 2,
+	"BRL": 2, "BSD": 2, "BTN": 2, "BWP": 2, "BYN": 2, "BZD": 2,
+	"CAD": 2, "CDF": 2, "CHE": 2, "CHF": 2, "CHW": 2, "CNY": 2,
+	"COP": 2, "COU": 2, "CRC": 2, "CUP": 2, "CVE": 2, "CZK": 2,
+	"DKK": 2, "DOP": 2, "DZD": 2, "EGP": 2, "ERN": 2, "ETB": 2,
+	"EUR": 2, "FJD": 2, "FKP": 2, "GBP": 2, "GEL": 2, "GHS": 2,
+	"GIP": 2, "GMD": 2, "GTQ": 2, "GYD": 2, "HKD": 2, "HNL": 2,
+	"HTG": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "IRR": 2,
+	"JMD": 2, "KES": 2, "KGS": 2, "KHR": 2, "KPW": 2, "KYD": 2,
+	"KZT": 2, "LAK": 2, "LBP": 2, "LKR": 2, "LRD": 2, "LSL": 2,
+	"MAD": 2, "MDL": 2, "MGA": 2, "MKD": 2, "MMK": 2, "MNT": 2,
+	"MOP": 2, "MRU": 2, "MUR": 2, "MVR": 2, "MWK": 2, "MXN": 2,
+	"MXV": 2, "MYR": 2, "MZN": 2, "NAD": 2, "NGN": 2, "NIO": 2,
+	"NOK": 2, "NPR": 2, "NZD": 2, "PAB": 2, "PEN": 2, "PGK": 2,
+	"PHP": 2, "PKR": 2, "PLN": 2, "QAR": 2, "RON": 2, "RSD": 2,
+	"RUB": 2, "SAR": 2, "SBD": 2, "SCR": 2, "SDG": 2, "SEK": 2,
+	"SGD": 2, "SHP": 2, "SLE": 2, "SLL": 2, "SOS": 2, "SRD": 2,
+	"SSP": 2, "STN": 2, "SVC": 2, "SYP": 2, "SZL": 2, "THB": 2,
+	"TJS": 2, "TMT": 2, "TOP": 2, "TRY": 2, "TTD": 2, "TWD": 2,
+	"TZS": 2, "UAH": 2, "USD": 2, "USN": 2, "UYU": 2, "UZS": 2,
+	"VED": 2, "VES": 2, "WST": 2, "XCD": 2, "XCG": 2, "YER": 2,
+	"ZAR": 2, "ZMW": 2, "ZWG": 2,
+
+	// Three-decimal currencies
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+
+	// Four-decimal units of account
+	"CLF": 4, "UYW": 4,
+}
+
+// ErrUnknownCurrency is returned when a currency is missing from the exponent table
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrCurrencyMismatch is returned when arithmetic mixes different currencies
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrInvalidAmount is returned when a decimal amount cannot be parsed
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// CurrencyExponent returns the number of minor-unit digits for an ISO 4217 code
+func CurrencyExponent(currency string) (int, error) {
+	exp, ok := currencyExponents[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return exp, nil
+}
+
+// IsKnownCurrency reports whether the currency is present in the exponent table
+func IsKnownCurrency(currency string) bool {
+	_, err := CurrencyExponent(currency)
+	return err == nil
+}
+
+// Money is an amount in minor units (kopecks, cents, ...) of a currency.
+// It is encoded as {"amount": <minor units>, "currency": "..."}, matching the
+// integer amount fields used elsewhere in the SDK.
+type Money struct {
+	Minor    int64  `json:"amount" yaml:"amount"`
+	Currency string `json:"currency" yaml:"currency"`
+}
+
+// NewMoney creates a Money value from minor units
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal string such as "1234.50" in the given currency.
+// More fractional digits than the currency allows are rejected instead of rounded.
+func ParseMoney(value, currency string) (Money, error) {
+	exp, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	s := strings.TrimSpace(value)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, hasDot := strings.Cut(s, ".")
+	if whole == "" || (hasDot && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %q has more than %d fractional digits for %s", ErrInvalidAmount, value, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q: %v", ErrInvalidAmount, value, err)
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return NewMoney(minor, currency), nil
+}
+
+// Decimal formats the amount as a decimal string with exactly as many
+// fractional digits as the currency defines, e.g. "1234.50" for RUB.
+// This is the amount format used by the Yandex Pay API.
+func (m Money) Decimal() (string, error) {
+	exp, err := CurrencyExponent(m.Currency)
+	if err != nil {
+		return "", err
+	}
+
+	abs := m.Minor
+	sign := ""
+	if abs < 0 {
+		sign = "-"
+		// math.MinInt64 has no positive counterpart, format it via uint64
+		if abs == math.MinInt64 {
+			return sign + formatMinor(uint64(math.MaxInt64)+1, exp), nil
+		}
+		abs = -abs
+	}
+	return sign + formatMinor(uint64(abs), exp), nil
+}
+
+// String returns a human-readable representation such as "1234.50 RUB"
+func (m Money) String() string {
+	value, err := m.Decimal()
+	if err != nil {
+		return fmt.Sprintf("%d %s", m.Minor, m.Currency)
+	}
+	return value + " " + m.Currency
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+// Add returns m + other, refusing to mix currencies or overflow
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	sum := m.Minor + other.Minor
+	if (other.Minor > 0 && sum < m.Minor) || (other.Minor < 0 && sum > m.Minor) {
+		return Money{}, fmt.Errorf("%w: overflow adding %s and %s", ErrInvalidAmount, m, other)
+	}
+	return Money{Minor: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other, refusing to mix currencies or overflow
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Minor == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: overflow subtracting %s", ErrInvalidAmount, other)
+	}
+	return m.Add(Money{Minor: -other.Minor, Currency: other.Currency})
+}
+
+// Mul returns m multiplied by n, refusing to overflow
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Minor == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Minor * n
+	if product/n != m.Minor || (n == -1 && m.Minor == math.MinInt64) {
+		return Money{}, fmt.Errorf("%w: overflow multiplying %s by %d", ErrInvalidAmount, m, n)
+	}
+	return Money{Minor: product, Currency: m.Currency}, nil
+}
+
+// Cmp compares m with other and returns -1, 0 or +1
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Minor < other.Minor:
+		return -1, nil
+	case m.Minor > other.Minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// UnmarshalJSON accepts a bare integer in minor units (the legacy amount
+// format), {"amount": <minor units>, "currency": "..."} or the Yandex Pay
+// form {"value": "12.34", "currency": "..."}.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		var minor int64
+		if err := json.Unmarshal(data, &minor); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+		}
+		m.Minor = minor
+		return nil
+	}
+
+	var raw struct {
+		Amount   *int64 `json:"amount"`
+		Value    string `json:"value"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return m.fromParts(raw.Amount, raw.Value, raw.Currency)
+}
+
+// UnmarshalYAML accepts the same forms as UnmarshalJSON
+func (m *Money) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var minor int64
+		if err := node.Decode(&minor); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, node.Value)
+		}
+		m.Minor = minor
+		return nil
+	}
+
+	var raw struct {
+		Amount   *int64 `yaml:"amount"`
+		Value    string `yaml:"value"`
+		Currency string `yaml:"currency"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	return m.fromParts(raw.Amount, raw.Value, raw.Currency)
+}
+
+func (m *Money) fromParts(amount *int64, value, currency string) error {
+	if amount != nil {
+		*m = NewMoney(*amount, currency)
+		return nil
+	}
+	parsed, err := ParseMoney(value, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if !strings.EqualFold(m.Currency, other.Currency) {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
+
+// Money returns the request amount as Money
+func (r *PaymentRequest) Money() Money {
+	return NewMoney(int64(r.Amount), r.Currency)
+}
+
+// Money returns the payment amount as Money
+func (p *Payment) Money() Money {
+	return NewMoney(int64(p.Amount), p.Currency)
+}
+
+// Money returns the generated amount as Money
+func (r *PaymentGenerationResult) Money() Money {
+	return NewMoney(int64(r.Amount), r.Currency)
+}
+
+func formatMinor(abs uint64, exp int) string {
+	digits := strconv.FormatUint(abs, 10)
+	if exp == 0 {
+		return digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
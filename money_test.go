@@ -0,0 +1,160 @@
+package yapay
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMoney_Decimal(t *testing.T) {
+	tests := []struct {
+		money    Money
+		expected string
+	}{
+		{NewMoney(1000, "RUB"), "10.00"},
+		{NewMoney(5, "RUB"), "0.05"},
+		{NewMoney(-150, "usd"), "-1.50"},
+		{NewMoney(1500, "JPY"), "1500"},
+		{NewMoney(1500, "KWD"), "1.500"},
+		{NewMoney(math.MinInt64, "JPY"), "-9223372036854775808"},
+	}
+
+	for _, tt := range tests {
+		value, err := tt.money.Decimal()
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, value)
+	}
+
+	_, err := NewMoney(100, "XXX").Decimal()
+	assert.True(t, errors.Is(err, ErrUnknownCurrency))
+}
+
+func TestCurrencyExponent_ISO4217(t *testing.T) {
+	for currency, expected := range map[string]int{
+		"CAD": 2, "AUD": 2, "INR": 2, "PLN": 2, "SEK": 2, "HKD": 2, "HUF": 2,
+		"XAF": 0, "BIF": 0, "TND": 3, "CLF": 4,
+	} {
+		exp, err := CurrencyExponent(currency)
+		require.NoError(t, err, currency)
+		assert.Equal(t, expected, exp, currency)
+	}
+
+	value, err := NewMoney(123456, "CAD").Decimal()
+	require.NoError(t, err)
+	assert.Equal(t, "1234.56", value)
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		expected int64
+	}{
+		{"10.00", "RUB", 1000},
+		{"10.5", "RUB", 1050},
+		{"10", "RUB", 1000},
+		{"-0.01", "RUB", -1},
+		{"1500", "JPY", 1500},
+		{"1.5", "KWD", 1500},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseMoney(tt.value, tt.currency)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.expected, m.Minor)
+
+		// Formatting and parsing must round-trip
+		value, err := m.Decimal()
+		require.NoError(t, err)
+		back, err := ParseMoney(value, tt.currency)
+		require.NoError(t, err)
+		assert.Equal(t, m, back)
+	}
+
+	for _, invalid := range []string{"", "abc", "1.", ".5", "1.005", "1e3", "1,50", "--1"} {
+		_, err := ParseMoney(invalid, "RUB")
+		assert.True(t, errors.Is(err, ErrInvalidAmount), invalid)
+	}
+
+	_, err := ParseMoney("10.5", "JPY")
+	assert.True(t, errors.Is(err, ErrInvalidAmount))
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a := NewMoney(1000, "RUB")
+	b := NewMoney(250, "RUB")
+
+	sum, err := a.Add(b)
+	require.NoError(t, err)
+	assert.Equal(t, NewMoney(1250, "RUB"), sum)
+
+	diff, err := a.Sub(b)
+	require.NoError(t, err)
+	assert.Equal(t, NewMoney(750, "RUB"), diff)
+
+	product, err := b.Mul(3)
+	require.NoError(t, err)
+	assert.Equal(t, NewMoney(750, "RUB"), product)
+
+	cmp, err := a.Cmp(b)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cmp)
+
+	_, err = a.Add(NewMoney(100, "USD"))
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+
+	_, err = a.Cmp(NewMoney(100, "USD"))
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+
+	_, err = NewMoney(math.MaxInt64, "RUB").Add(NewMoney(1, "RUB"))
+	assert.True(t, errors.Is(err, ErrInvalidAmount))
+
+	_, err = NewMoney(math.MaxInt64/2+1, "RUB").Mul(2)
+	assert.True(t, errors.Is(err, ErrInvalidAmount))
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(NewMoney(1050, "RUB"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount":1050,"currency":"RUB"}`, string(data))
+
+	var m Money
+	require.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, NewMoney(1050, "RUB"), m)
+
+	// Legacy integer amounts keep working
+	require.NoError(t, json.Unmarshal([]byte(`1000`), &m))
+	assert.Equal(t, int64(1000), m.Minor)
+
+	// Yandex Pay decimal form
+	require.NoError(t, json.Unmarshal([]byte(`{"value":"12.34","currency":"RUB"}`), &m))
+	assert.Equal(t, NewMoney(1234, "RUB"), m)
+
+	assert.Error(t, json.Unmarshal([]byte(`"12.34"`), &m))
+}
+
+func TestMoney_YAML(t *testing.T) {
+	var holder struct {
+		Price Money `yaml:"price"`
+		Fee   Money `yaml:"fee"`
+	}
+
+	doc := "price:\n  value: \"99.90\"\n  currency: RUB\nfee: 150\n"
+	require.NoError(t, yaml.Unmarshal([]byte(doc), &holder))
+	assert.Equal(t, NewMoney(9990, "RUB"), holder.Price)
+	assert.Equal(t, int64(150), holder.Fee.Minor)
+
+	out, err := yaml.Marshal(NewMoney(9990, "RUB"))
+	require.NoError(t, err)
+	assert.Equal(t, "amount: 9990\ncurrency: RUB\n", string(out))
+}
+
+func TestPaymentRequest_Money(t *testing.T) {
+	req := &PaymentRequest{Amount: 1000, Currency: "rub"}
+	assert.Equal(t, NewMoney(1000, "RUB"), req.Money())
+}
@@ -0,0 +1,460 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// Digest defaults
+const (
+	DefaultDigestPollInterval = time.Minute
+	// DigestTopReasons is how many failure reasons a digest lists
+	DigestTopReasons = 5
+)
+
+// DigestPayment is the latest known state of a payment in a digest
+type DigestPayment struct {
+	ID          string              `json:"id"`
+	OrderID     string              `json:"order_id,omitempty"`
+	Amount      int                 `json:"amount"`
+	Currency    string              `json:"currency,omitempty"`
+	Status      yapay.PaymentStatus `json:"status,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Reason      string              `json:"reason,omitempty"`
+	Metadata    yapay.Metadata      `json:"metadata,omitempty"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// DigestTotal is the number and sum of the payments with one status and currency
+type DigestTotal struct {
+	Status   yapay.PaymentStatus `json:"status"`
+	Currency string              `json:"currency"`
+	Count    int                 `json:"count"`
+	Amount   int64               `json:"amount"`
+}
+
+// DigestReason is a failure reason and how many payments failed with it
+type DigestReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// DigestSummary is the content of a digest notification, available to
+// templates as .Digest
+type DigestSummary struct {
+	Window string    `json:"window"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Totals are ordered by payment status and currency
+	Totals []DigestTotal `json:"totals,omitempty"`
+	// FailureReasons are the most frequent reasons of failed payments
+	FailureReasons []DigestReason `json:"failure_reasons,omitempty"`
+	// Payments are ordered by their last update, up to the configured limit
+	Payments []DigestPayment `json:"payments,omitempty"`
+	// Omitted is the number of payments left out of Payments
+	Omitted int `json:"omitted,omitempty"`
+	// Events counts the collected notifications by type
+	Events map[yapay.NotificationType]int `json:"events,omitempty"`
+}
+
+// DigestBucket is the aggregation state of one digest window for one route
+type DigestBucket struct {
+	Key        string                         `json:"key"`
+	Rule       string                         `json:"rule,omitempty"`
+	Window     string                         `json:"window"`
+	Channel    Channel                        `json:"channel"`
+	Recipients []string                       `json:"recipients,omitempty"`
+	Start      time.Time                      `json:"start"`
+	End        time.Time                      `json:"end"`
+	Payments   map[string]*DigestPayment      `json:"payments,omitempty"`
+	Events     map[yapay.NotificationType]int `json:"events"`
+	// Seen holds the outbox deduplication keys of the collected requests
+	Seen map[string]bool `json:"seen,omitempty"`
+}
+
+// Digest collects the notifications that routing rules send to an hourly or
+// daily digest and, when a window ends, queues one digest notification per
+// channel and recipients in the outbox. Windows follow the time zone and
+// daily_at time of notifications.digest. The state is kept in a DigestStore,
+// so collected notifications survive a restart. A Digest is safe for
+// concurrent use.
+type Digest struct {
+	store        DigestStore
+	clientID     string
+	location     *time.Location
+	dailyAt      time.Duration
+	maxPayments  int
+	pollInterval time.Duration
+	logger       logrus.FieldLogger
+	now          func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*DigestBucket // nil until loaded from the store
+}
+
+// DigestOption configures a Digest
+type DigestOption func(*Digest)
+
+// WithDigestPollInterval sets how often Run looks for ended windows
+func WithDigestPollInterval(d time.Duration) DigestOption {
+	return func(dg *Digest) {
+		dg.pollInterval = d
+	}
+}
+
+// WithDigestLogger sets the logger for flush failures
+func WithDigestLogger(logger logrus.FieldLogger) DigestOption {
+	return func(dg *Digest) {
+		dg.logger = logger
+	}
+}
+
+// NewDigest creates a digest for the merchant. Pass it to the outbox with
+// WithDigestSink and start it with Run.
+func NewDigest(m *yapay.Merchant, store DigestStore, opts ...DigestOption) (*Digest, error) {
+	if m == nil {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "merchant is required")
+	}
+	cfg := m.Notifications.Digest
+	d := &Digest{
+		store:        store,
+		clientID:     m.ID,
+		maxPayments:  cfg.MaxPayments,
+		pollInterval: DefaultDigestPollInterval,
+		logger:       logrus.StandardLogger(),
+		now:          time.Now,
+	}
+	if d.maxPayments == 0 {
+		d.maxPayments = yapay.DefaultDigestMaxPayments
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	var err error
+	if d.location, err = time.LoadLocation(cfg.Timezone); err != nil {
+		return nil, yapay.Errorf(yapay.ErrorCodeValidation, "invalid digest time zone %q", cfg.Timezone).
+			WithDetail("notifications.digest.timezone", err.Error())
+	}
+	if cfg.DailyAt != "" {
+		if d.dailyAt, err = parseClock(cfg.DailyAt); err != nil {
+			return nil, yapay.Errorf(yapay.ErrorCodeValidation, "invalid digest time %q", cfg.DailyAt).
+				WithDetail("notifications.digest.daily_at", "must be a time such as 09:00")
+		}
+	}
+	return d, nil
+}
+
+// Collect adds req to the current window of the route's digest. A request
+// with the same client, payment ID and type as one already collected in the
+// window is a redelivery: it is not counted again and ErrDuplicate is
+// returned. Collect implements DigestSink.
+func (d *Digest) Collect(ctx context.Context, route Route, req *yapay.NotificationRequest) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(ctx); err != nil {
+		return err
+	}
+
+	now := d.now()
+	start, end := d.window(route.Digest, now)
+	key := digestKey(d.clientID, route.Digest, start) + "/" + string(route.Channel)
+	if len(route.Recipients) > 0 {
+		key += "/" + strings.Join(route.Recipients, ",")
+	}
+	b, ok := d.buckets[key]
+	if !ok {
+		b = &DigestBucket{
+			Key:        key,
+			Rule:       route.Rule,
+			Window:     route.Digest,
+			Channel:    route.Channel,
+			Recipients: route.Recipients,
+			Start:      start,
+			End:        end,
+			Events:     make(map[yapay.NotificationType]int),
+		}
+		d.buckets[key] = b
+	}
+	if !b.add(req, now) {
+		return ErrDuplicate
+	}
+	return d.save(ctx)
+}
+
+// add records a request, or reports false for a request already recorded;
+// payments keep their latest state
+func (b *DigestBucket) add(req *yapay.NotificationRequest, now time.Time) bool {
+	if req.PaymentID != "" {
+		key := dedupKey(req)
+		if b.Seen[key] {
+			return false
+		}
+		if b.Seen == nil {
+			b.Seen = make(map[string]bool)
+		}
+		b.Seen[key] = true
+	}
+	b.Events[req.Type]++
+
+	payment := paymentFromData(req.Data[DataKeyPayment])
+	id := req.PaymentID
+	if id == "" && payment != nil {
+		id = payment.ID
+	}
+	if id == "" {
+		return true
+	}
+	if b.Payments == nil {
+		b.Payments = make(map[string]*DigestPayment)
+	}
+	p, ok := b.Payments[id]
+	if !ok {
+		p = &DigestPayment{ID: id}
+		b.Payments[id] = p
+	}
+	if payment != nil {
+		p.OrderID = payment.OrderID
+		p.Amount = payment.Amount
+		p.Currency = payment.Currency
+		p.Status = payment.Status
+		p.Description = payment.Description
+		p.Metadata = payment.Metadata
+	}
+	if status, ok := typeStatuses[req.Type]; ok && (payment == nil || payment.Status == "") {
+		p.Status = status
+	}
+	if reason, ok := req.Data[DataKeyReason]; ok && reason != nil {
+		p.Reason = formatValue(reason)
+	}
+	p.UpdatedAt = now
+	return true
+}
+
+// typeStatuses are the payment statuses implied by notification types
+var typeStatuses = map[yapay.NotificationType]yapay.PaymentStatus{
+	yapay.NotificationTypePaymentCreated: yapay.PaymentStatusCreated,
+	yapay.NotificationTypePaymentSuccess: yapay.PaymentStatusSuccess,
+	yapay.NotificationTypePaymentFailed:  yapay.PaymentStatusFailed,
+}
+
+// Run flushes ended windows into the outbox until ctx is done
+func (d *Digest) Run(ctx context.Context, outbox *Outbox) error {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Flush(ctx, outbox); err != nil && ctx.Err() == nil {
+			d.logger.WithError(err).Error("Notification digest failed")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush queues a digest notification for every window that has ended and
+// removes its state. It returns the number of digests queued. A digest
+// queued again after a failure is deduplicated by the outbox.
+func (d *Digest) Flush(ctx context.Context, outbox *Outbox) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(ctx); err != nil {
+		return 0, err
+	}
+
+	now := d.now()
+	var due []*DigestBucket
+	for _, b := range d.buckets {
+		if !b.End.After(now) {
+			due = append(due, b)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Key < due[j].Key })
+
+	flushed := 0
+	for _, b := range due {
+		req := &yapay.NotificationRequest{
+			Type:     yapay.NotificationTypeDigest,
+			ClientID: d.clientID,
+			Data:     map[string]interface{}{DataKeyDigest: d.summary(b)},
+		}
+		route := Route{Rule: b.Rule, Channel: b.Channel, Recipients: b.Recipients}
+		// Buckets of the same window may share a recipient, so the key names
+		// the recipient set to keep their outbox items apart
+		key := digestKey(d.clientID, b.Window, b.Start)
+		if len(b.Recipients) > 0 {
+			key += "/" + strings.Join(b.Recipients, ",")
+		}
+		if _, err := outbox.enqueue(ctx, key, req, []Route{route}); err != nil {
+			if flushed > 0 {
+				err = errors.Join(err, d.save(ctx))
+			}
+			return flushed, err
+		}
+		delete(d.buckets, b.Key)
+		flushed++
+	}
+	if flushed == 0 {
+		return 0, nil
+	}
+	return flushed, d.save(ctx)
+}
+
+// digestKey identifies a digest window of a client; Flush appends the
+// recipient set and the outbox the channel and recipient
+func digestKey(clientID, window string, start time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/%s", clientID, yapay.NotificationTypeDigest, window, start.UTC().Format(time.RFC3339))
+}
+
+// window returns the hourly or daily window containing now
+func (d *Digest) window(kind string, now time.Time) (time.Time, time.Time) {
+	local := now.In(d.location)
+	if kind == yapay.DigestHourly {
+		start := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, d.location)
+		return start, start.Add(time.Hour)
+	}
+	start := time.Date(local.Year(), local.Month(), local.Day(),
+		int(d.dailyAt/time.Hour), int(d.dailyAt%time.Hour/time.Minute), 0, 0, d.location)
+	if local.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// Buckets returns the windows being collected, ordered by key
+func (d *Digest) Buckets(ctx context.Context) ([]*DigestBucket, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(ctx); err != nil {
+		return nil, err
+	}
+	return d.sorted(), nil
+}
+
+func (d *Digest) sorted() []*DigestBucket {
+	buckets := make([]*DigestBucket, 0, len(d.buckets))
+	for _, b := range d.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
+func (d *Digest) load(ctx context.Context) error {
+	if d.buckets != nil {
+		return nil
+	}
+	buckets, err := d.store.Load(ctx)
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to load digest state")
+	}
+	d.buckets = make(map[string]*DigestBucket, len(buckets))
+	for _, b := range buckets {
+		d.buckets[b.Key] = b
+	}
+	return nil
+}
+
+// save persists the buckets. On failure the in-memory state is dropped, so
+// the next call reloads the last saved state.
+func (d *Digest) save(ctx context.Context) error {
+	if err := d.store.Save(ctx, d.sorted()); err != nil {
+		d.buckets = nil
+		return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to save digest state")
+	}
+	return nil
+}
+
+// summary aggregates a bucket for rendering
+func (d *Digest) summary(b *DigestBucket) *DigestSummary {
+	s := &DigestSummary{Window: b.Window, Start: b.Start, End: b.End, Events: b.Events}
+
+	payments := make([]DigestPayment, 0, len(b.Payments))
+	for _, p := range b.Payments {
+		payments = append(payments, *p)
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		if !payments[i].UpdatedAt.Equal(payments[j].UpdatedAt) {
+			return payments[i].UpdatedAt.Before(payments[j].UpdatedAt)
+		}
+		return payments[i].ID < payments[j].ID
+	})
+
+	totals := make(map[[2]string]*DigestTotal)
+	reasons := make(map[string]int)
+	for _, p := range payments {
+		key := [2]string{string(p.Status), p.Currency}
+		t, ok := totals[key]
+		if !ok {
+			t = &DigestTotal{Status: p.Status, Currency: p.Currency}
+			totals[key] = t
+		}
+		t.Count++
+		t.Amount += int64(p.Amount)
+		if p.Status == yapay.PaymentStatusFailed {
+			reasons[p.Reason]++
+		}
+	}
+	for _, t := range totals {
+		s.Totals = append(s.Totals, *t)
+	}
+	sort.Slice(s.Totals, func(i, j int) bool {
+		a, b := s.Totals[i], s.Totals[j]
+		if oa, ob := statusOrder(a.Status), statusOrder(b.Status); oa != ob {
+			return oa < ob
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Currency < b.Currency
+	})
+
+	for reason, count := range reasons {
+		s.FailureReasons = append(s.FailureReasons, DigestReason{Reason: reason, Count: count})
+	}
+	sort.Slice(s.FailureReasons, func(i, j int) bool {
+		a, b := s.FailureReasons[i], s.FailureReasons[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Reason < b.Reason
+	})
+	if len(s.FailureReasons) > DigestTopReasons {
+		s.FailureReasons = s.FailureReasons[:DigestTopReasons]
+	}
+
+	if len(payments) > d.maxPayments {
+		s.Omitted = len(payments) - d.maxPayments
+		payments = payments[:d.maxPayments]
+	}
+	s.Payments = payments
+	return s
+}
+
+// statusOrder sorts payment statuses along their lifecycle
+func statusOrder(s yapay.PaymentStatus) int {
+	for i, status := range []yapay.PaymentStatus{
+		yapay.PaymentStatusCreated,
+		yapay.PaymentStatusPending,
+		yapay.PaymentStatusSuccess,
+		yapay.PaymentStatusFailed,
+		yapay.PaymentStatusCanceled,
+		yapay.PaymentStatusPartiallyRefunded,
+		yapay.PaymentStatusRefunded,
+	} {
+		if s == status {
+			return i
+		}
+	}
+	return 100
+}
@@ -0,0 +1,341 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func digestMerchant() *yapay.Merchant {
+	merchant := testMerchant()
+	merchant.Notifications.Digest = yapay.DigestConfig{Timezone: "Europe/Moscow", DailyAt: "09:00", MaxPayments: 2}
+	merchant.Notifications.Rules = []yapay.NotificationRule{{
+		Types:  []yapay.NotificationType{yapay.NotificationTypePaymentSuccess, yapay.NotificationTypePaymentFailed},
+		Digest: yapay.DigestDaily,
+	}}
+	return merchant
+}
+
+type digestEnv struct {
+	outbox *Outbox
+	digest *Digest
+	clock  *testClock
+	tg     *fakeNotifier
+	email  *fakeNotifier
+}
+
+func newDigestEnv(t *testing.T, merchant *yapay.Merchant, store DigestStore, outboxStore Store) *digestEnv {
+	r, err := NewRenderer(merchant, WithLocation(time.FixedZone("MSK", 3*60*60)))
+	require.NoError(t, err)
+	router, err := NewRouter(merchant)
+	require.NoError(t, err)
+	digest, err := NewDigest(merchant, store)
+	require.NoError(t, err)
+
+	env := &digestEnv{
+		digest: digest,
+		// 2024-05-01 12:00 in Moscow
+		clock: &testClock{t: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+		tg:    &fakeNotifier{},
+		email: &fakeNotifier{},
+	}
+	env.outbox = NewOutbox(outboxStore, Notifiers{ChannelTelegram: env.tg, ChannelEmail: env.email}, r,
+		WithRouter(router), WithDigestSink(digest))
+	env.outbox.now = env.clock.now
+	env.outbox.jitter = func() float64 { return 1 }
+	digest.now = env.clock.now
+	return env
+}
+
+func (e *digestEnv) enqueue(t *testing.T, typ yapay.NotificationType, id string, amount int, currency, reason string) {
+	payment := testPayment()
+	payment.ID, payment.OrderID = id, "order-"+id
+	payment.Amount, payment.Currency = amount, currency
+	payment.Status = ""
+	req := NewPaymentNotification(typ, payment, "")
+	if reason != "" {
+		req.Data[DataKeyReason] = reason
+	}
+	queued, err := e.outbox.Enqueue(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, queued)
+	e.clock.advance(time.Minute)
+}
+
+func TestDigest_Summary(t *testing.T) {
+	env := newDigestEnv(t, digestMerchant(), NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 100000, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p2", 50000, "RUB", "card declined")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p3", 2000, "USD", "card declined")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p4", 3000, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p4", 3000, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p5", 1000, "RUB", "")
+
+	buckets, err := env.digest.Buckets(ctx)
+	require.NoError(t, err)
+	require.Len(t, buckets, 2, "one bucket per channel")
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 5, 1, 9, 0, 0, 0, moscow).Equal(buckets[0].Start))
+	assert.True(t, time.Date(2024, 5, 2, 9, 0, 0, 0, moscow).Equal(buckets[0].End))
+
+	s := env.digest.summary(buckets[0])
+	assert.Equal(t, []DigestTotal{
+		{Status: yapay.PaymentStatusSuccess, Currency: "RUB", Count: 3, Amount: 104000},
+		{Status: yapay.PaymentStatusFailed, Currency: "RUB", Count: 1, Amount: 50000},
+		{Status: yapay.PaymentStatusFailed, Currency: "USD", Count: 1, Amount: 2000},
+	}, s.Totals)
+	assert.Equal(t, []DigestReason{{Reason: "card declined", Count: 2}}, s.FailureReasons)
+	require.Len(t, s.Payments, 2)
+	assert.Equal(t, "p1", s.Payments[0].ID)
+	assert.Equal(t, 3, s.Omitted)
+	assert.Equal(t, map[yapay.NotificationType]int{
+		yapay.NotificationTypePaymentSuccess: 3,
+		yapay.NotificationTypePaymentFailed:  3,
+	}, s.Events)
+
+	// Nothing goes out before the window ends
+	flushed, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	assert.Zero(t, flushed)
+	_, err = env.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, env.tg.count())
+}
+
+func TestDigest_FlushRendersThroughChannels(t *testing.T) {
+	env := newDigestEnv(t, digestMerchant(), NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 123456, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p2", 50000, "RUB", "card declined")
+
+	env.clock.advance(24 * time.Hour)
+	flushed, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+	buckets, err := env.digest.Buckets(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, buckets)
+
+	_, err = env.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, env.tg.count())
+	require.Equal(t, 1, env.email.count())
+
+	tg := env.tg.sent[0]
+	assert.Equal(t, yapay.NotificationTypeDigest, tg.Type)
+	assert.Contains(t, tg.Text, "<b>Сводка</b> 01.05.2024 09:00 - 02.05.2024 09:00")
+	assert.Contains(t, tg.Text, "оплачен: 1 на 1 234.56 RUB")
+	assert.Contains(t, tg.Text, "card declined: 1")
+	assert.Contains(t, tg.Text, "order-p1: 1 234.56 RUB, оплачен, Gift: <code>да</code>, ID товара: <code>sku-1</code>")
+	assert.LessOrEqual(t, len([]rune(tg.Text)), TelegramMaxMessageLength)
+
+	email := env.email.sent[0]
+	assert.Equal(t, "Сводка 01.05.2024 09:00 - 02.05.2024 09:00 - Shop & Co", email.Subject)
+	assert.Contains(t, email.Text, "ошибка: 1 на 500.00 RUB")
+	assert.Contains(t, email.Text, "Причина: card declined")
+	assert.Contains(t, email.Text, "ID товара: sku-1")
+	assert.Contains(t, email.Text, "Платеж не прошел: 1")
+	assert.Contains(t, email.HTML, "<h3>Причины ошибок</h3>")
+
+	// The window is gone, so a second flush finds nothing
+	flushed, err = env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	assert.Zero(t, flushed)
+}
+
+func TestDigest_TelegramFitsMessageLimit(t *testing.T) {
+	merchant := digestMerchant()
+	merchant.Notifications.Digest.MaxPayments = 20
+	env := newDigestEnv(t, merchant, NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	for i := 1; i <= 20; i++ {
+		payment := testPayment()
+		payment.ID, payment.OrderID = fmt.Sprintf("p%d", i), fmt.Sprintf("order-p%d", i)
+		payment.Status = ""
+		payment.Metadata["comment"] = strings.Repeat("Доставка курьером до двери, ", 6)
+		queued, err := env.outbox.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, payment, ""))
+		require.NoError(t, err)
+		require.True(t, queued)
+		env.clock.advance(time.Minute)
+	}
+
+	env.clock.advance(24 * time.Hour)
+	_, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	_, err = env.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, env.tg.count())
+	require.Equal(t, 1, env.email.count())
+
+	text := env.tg.sent[0].Text
+	assert.LessOrEqual(t, utf8.RuneCountInString(text), TelegramMaxMessageLength)
+	listed := strings.Count(text, "order-p")
+	assert.Greater(t, listed, 0)
+	assert.Less(t, listed, 20)
+	assert.Contains(t, text, fmt.Sprintf("И еще платежей: %d", 20-listed))
+	assert.Contains(t, text, "оплачен: 20 на")
+
+	// Email has no such limit and lists every payment
+	assert.Contains(t, env.email.sent[0].Text, "order-p20")
+}
+
+func TestDigest_TelegramDropsLongFailureReasons(t *testing.T) {
+	env := newDigestEnv(t, digestMerchant(), NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	for i := 1; i <= DigestTopReasons; i++ {
+		reason := fmt.Sprintf("%d: %s", i, strings.Repeat("Банк-эмитент отклонил операцию. ", 30))
+		env.enqueue(t, yapay.NotificationTypePaymentFailed, fmt.Sprintf("p%d", i), 1000, "RUB", reason)
+	}
+
+	env.clock.advance(24 * time.Hour)
+	_, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	_, err = env.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, env.tg.count())
+
+	text := env.tg.sent[0].Text
+	assert.LessOrEqual(t, utf8.RuneCountInString(text), TelegramMaxMessageLength)
+	assert.NotContains(t, text, "Банк-эмитент")
+	assert.Contains(t, text, fmt.Sprintf("ошибка: %d на", DigestTopReasons))
+	assert.Contains(t, text, fmt.Sprintf("И еще платежей: %d", DigestTopReasons))
+}
+
+func TestDigest_RedeliveryCountedOnce(t *testing.T) {
+	env := newDigestEnv(t, digestMerchant(), NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 100000, "RUB", "")
+	payment := testPayment()
+	payment.ID, payment.OrderID = "p1", "order-p1"
+	queued, err := env.outbox.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, payment, ""))
+	require.NoError(t, err)
+	assert.False(t, queued, "a redelivered request is a duplicate")
+
+	buckets, err := env.digest.Buckets(ctx)
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+	s := env.digest.summary(buckets[0])
+	assert.Equal(t, map[yapay.NotificationType]int{yapay.NotificationTypePaymentSuccess: 1}, s.Events)
+	assert.Equal(t, 1, s.Totals[0].Count)
+}
+
+func TestDigest_OverlappingRecipients(t *testing.T) {
+	merchant := digestMerchant()
+	merchant.Notifications.Rules = []yapay.NotificationRule{
+		{
+			Name:       "sales",
+			Types:      []yapay.NotificationType{yapay.NotificationTypePaymentSuccess},
+			Channels:   []string{yapay.NotificationChannelTelegram},
+			Recipients: yapay.NotificationRecipients{Telegram: []string{"1"}},
+			Digest:     yapay.DigestDaily,
+		},
+		{
+			Name:       "ops",
+			Types:      []yapay.NotificationType{yapay.NotificationTypePaymentFailed},
+			Channels:   []string{yapay.NotificationChannelTelegram},
+			Recipients: yapay.NotificationRecipients{Telegram: []string{"1", "2"}},
+			Digest:     yapay.DigestDaily,
+		},
+	}
+	env := newDigestEnv(t, merchant, NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 100000, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p2", 50000, "RUB", "card declined")
+
+	env.clock.advance(24 * time.Hour)
+	flushed, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+	_, err = env.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	// Chat 1 gets both digests, chat 2 only the ops one
+	require.Equal(t, 3, env.tg.count())
+	byChat := make(map[string][]string)
+	for _, msg := range env.tg.sent {
+		require.Len(t, msg.Recipients, 1)
+		byChat[msg.Recipients[0]] = append(byChat[msg.Recipients[0]], msg.Text)
+	}
+	require.Len(t, byChat["1"], 2)
+	require.Len(t, byChat["2"], 1)
+	assert.Contains(t, byChat["2"][0], "card declined")
+}
+
+func TestDigest_HourlyWindow(t *testing.T) {
+	merchant := digestMerchant()
+	merchant.Notifications.Rules[0].Digest = yapay.DigestHourly
+	merchant.Notifications.Rules[0].Channels = []string{yapay.NotificationChannelTelegram}
+	env := newDigestEnv(t, merchant, NewMemoryDigestStore(), NewMemoryStore())
+	ctx := context.Background()
+
+	env.clock.t = time.Date(2024, 5, 1, 9, 40, 0, 0, time.UTC)
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 100, "RUB", "")
+	env.clock.advance(20 * time.Minute) // 10:01 UTC, the next hour
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p2", 100, "RUB", "")
+
+	flushed, err := env.digest.Flush(ctx, env.outbox)
+	require.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	buckets, err := env.digest.Buckets(ctx)
+	require.NoError(t, err)
+	require.Len(t, buckets, 1)
+	assert.Equal(t, "shop/digest/hourly/2024-05-01T10:00:00Z/telegram", buckets[0].Key)
+}
+
+func TestDigest_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	newStores := func() (DigestStore, Store) {
+		digestStore, err := NewFileDigestStore(filepath.Join(dir, "digest.json"))
+		require.NoError(t, err)
+		outboxStore, err := NewFileStore(filepath.Join(dir, "outbox.json"))
+		require.NoError(t, err)
+		return digestStore, outboxStore
+	}
+	ctx := context.Background()
+
+	digestStore, outboxStore := newStores()
+	env := newDigestEnv(t, digestMerchant(), digestStore, outboxStore)
+	env.enqueue(t, yapay.NotificationTypePaymentSuccess, "p1", 100000, "RUB", "")
+	env.enqueue(t, yapay.NotificationTypePaymentFailed, "p2", 50000, "RUB", "expired card")
+
+	// Restart after the window ended
+	digestStore, outboxStore = newStores()
+	restarted := newDigestEnv(t, digestMerchant(), digestStore, outboxStore)
+	restarted.clock.t = env.clock.t.Add(24 * time.Hour)
+
+	flushed, err := restarted.digest.Flush(ctx, restarted.outbox)
+	require.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+	_, err = restarted.outbox.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, restarted.email.count())
+	assert.Contains(t, restarted.email.sent[0].Text, "expired card: 1")
+	assert.Contains(t, restarted.email.sent[0].Text, "оплачен: 1 на 1 000.00 RUB")
+}
+
+func TestNewDigest_InvalidConfig(t *testing.T) {
+	merchant := testMerchant()
+	merchant.Notifications.Digest.Timezone = "Mars/Olympus"
+	_, err := NewDigest(merchant, NewMemoryDigestStore())
+	assert.ErrorIs(t, err, yapay.ErrValidation)
+
+	merchant.Notifications.Digest = yapay.DigestConfig{DailyAt: "9am"}
+	_, err = NewDigest(merchant, NewMemoryDigestStore())
+	assert.ErrorIs(t, err, yapay.ErrValidation)
+}
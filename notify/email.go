@@ -0,0 +1,266 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// Email sends messages over SMTP. Every Send opens a new session.
+type Email struct {
+	host     string
+	port     int
+	username string
+	password yapay.Secret
+	from     *mail.Address
+	to       []*mail.Address
+	tlsMode  string
+	tls      *tls.Config
+	opts     *options
+}
+
+// NewEmail creates an email notifier from the merchant config. Recipients
+// come from cfg.To unless a message sets its own.
+func NewEmail(cfg yapay.EmailConfig, opts ...Option) (*Email, error) {
+	if cfg.SMTPHost == "" {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "smtp host is required").
+			WithDetail("notifications.email.smtp_host", "is required")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, yapay.WrapError(yapay.ErrorCodeValidation, err, "invalid sender address").
+			WithDetail("notifications.email.from", "must be an email address")
+	}
+	to, addrErr := parseAddresses(cfg.To)
+	if addrErr != nil {
+		return nil, addrErr.WithDetail("notifications.email.to", "must be email addresses")
+	}
+
+	e := &Email{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     from,
+		to:       to,
+		tlsMode:  cfg.TLS,
+		opts:     newOptions(opts),
+	}
+	if e.port == 0 {
+		e.port = yapay.DefaultSMTPPort
+	}
+	switch e.tlsMode {
+	case "":
+		e.tlsMode = yapay.DefaultSMTPTLS
+	case yapay.SMTPTLSStartTLS, yapay.SMTPTLSImplicit, yapay.SMTPTLSNone:
+	default:
+		return nil, yapay.Errorf(yapay.ErrorCodeValidation, "unknown smtp tls mode %q", cfg.TLS).
+			WithDetail("notifications.email.tls", "must be starttls, tls or none")
+	}
+
+	if e.opts.tlsConfig != nil {
+		e.tls = e.opts.tlsConfig.Clone()
+	} else {
+		e.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if e.tls.ServerName == "" {
+		e.tls.ServerName = e.host
+	}
+	if cfg.InsecureSkipVerify {
+		e.tls.InsecureSkipVerify = true
+	}
+	return e, nil
+}
+
+func parseAddresses(list []string) ([]*mail.Address, *yapay.Error) {
+	addrs := make([]*mail.Address, 0, len(list))
+	for _, s := range list {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, yapay.WrapError(yapay.ErrorCodeValidation, err, fmt.Sprintf("invalid recipient address %q", s))
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// Send delivers the message to msg.Recipients or the configured addresses
+func (e *Email) Send(ctx context.Context, msg *Message) error {
+	to := e.to
+	if len(msg.Recipients) > 0 {
+		var err *yapay.Error
+		if to, err = parseAddresses(msg.Recipients); err != nil {
+			return err
+		}
+	}
+	if len(to) == 0 {
+		return yapay.NewError(yapay.ErrorCodeValidation, "email recipients are required").
+			WithDetail("notifications.email.to", "is required")
+	}
+
+	body, err := e.compose(msg, to)
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to compose email")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.opts.timeout)
+	defer cancel()
+	return e.deliver(ctx, to, body)
+}
+
+// deliver runs one SMTP session; the connection is closed when ctx is done
+func (e *Email) deliver(ctx context.Context, to []*mail.Address, body []byte) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return smtpError(ctx, "dial", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if e.tlsMode == yapay.SMTPTLSImplicit {
+		tlsConn := tls.Client(conn, e.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return smtpError(ctx, "TLS", err)
+		}
+		conn = tlsConn
+	}
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		return smtpError(ctx, "greeting", err)
+	}
+	defer c.Close()
+
+	if e.tlsMode == yapay.SMTPTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return yapay.Errorf(yapay.ErrorCodeValidation, "smtp server %s does not support STARTTLS", addr).
+				WithDetail("notifications.email.tls", "set to tls or none for this server")
+		}
+		if err := c.StartTLS(e.tls); err != nil {
+			return smtpError(ctx, "STARTTLS", err)
+		}
+	}
+
+	if e.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return yapay.Errorf(yapay.ErrorCodeValidation, "smtp server %s does not support AUTH", addr)
+		}
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password.Reveal(), e.host)); err != nil {
+			return smtpError(ctx, "AUTH", err)
+		}
+	}
+
+	if err := c.Mail(e.from.Address); err != nil {
+		return smtpError(ctx, "MAIL", err)
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return smtpError(ctx, "RCPT", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(ctx, "DATA", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return smtpError(ctx, "DATA", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(ctx, "DATA", err)
+	}
+	_ = c.Quit()
+	return nil
+}
+
+// compose builds a MIME message: text/plain, or multipart/alternative when
+// the message has an HTML body
+func (e *Email) compose(msg *Message, to []*mail.Address) ([]byte, error) {
+	var buf bytes.Buffer
+	recipients := make([]string, len(to))
+	for i, addr := range to {
+		recipients[i] = addr.String()
+	}
+
+	header := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", e.from.String())
+	header("To", strings.Join(recipients, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", e.messageID())
+	header("MIME-Version", "1.0")
+	if msg.Type != "" {
+		header("X-Yapay-Notification", string(msg.Type))
+	}
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func (e *Email) messageID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	domain := e.from.Address[strings.LastIndex(e.from.Address, "@")+1:]
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
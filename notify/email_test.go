@@ -0,0 +1,321 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a minimal in-process SMTP server recording delivered messages
+type fakeSMTP struct {
+	ln        net.Listener
+	tls       *tls.Config
+	startTLS  bool
+	password  string
+	rejectTo  string
+	greetWait time.Duration
+
+	mu       sync.Mutex
+	auth     []string
+	from     []string
+	rcpts    [][]string
+	messages [][]byte
+	usedTLS  []bool
+}
+
+// testCertificate returns a certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return srv.TLS.Certificates[0], pool
+}
+
+func newFakeSMTP(t *testing.T, implicitTLS bool, setup func(*fakeSMTP)) *fakeSMTP {
+	f := &fakeSMTP{password: "secret"}
+	if setup != nil {
+		setup(f)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if implicitTLS {
+		ln = tls.NewListener(ln, f.tls)
+	}
+	f.ln = ln
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, implicitTLS)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) port() int {
+	return f.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (f *fakeSMTP) serve(conn net.Conn, secure bool) {
+	defer conn.Close()
+	time.Sleep(f.greetWait)
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) { _ = tp.PrintfLine(format, args...) }
+
+	reply("220 fake ESMTP")
+	var from string
+	var rcpts []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-fake")
+			if f.startTLS && !secure {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, f.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			creds, _ := base64.StdEncoding.DecodeString(encoded)
+			parts := strings.Split(string(creds), "\x00")
+			f.mu.Lock()
+			f.auth = append(f.auth, parts[1])
+			f.mu.Unlock()
+			if len(parts) != 3 || parts[2] != f.password {
+				reply("535 5.7.8 authentication failed")
+				continue
+			}
+			reply("235 2.7.0 ok")
+		case "MAIL":
+			from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if to == f.rejectTo {
+				reply("550 5.1.1 no such user")
+				continue
+			}
+			rcpts = append(rcpts, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.from = append(f.from, from)
+			f.rcpts = append(f.rcpts, rcpts)
+			f.messages = append(f.messages, data)
+			f.usedTLS = append(f.usedTLS, secure)
+			f.mu.Unlock()
+			reply("250 ok queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (f *fakeSMTP) config(mode string) yapay.EmailConfig {
+	return yapay.EmailConfig{
+		Enabled:  true,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: f.port(),
+		Username: "shop",
+		Password: yapay.Secret(f.password),
+		From:     "Shop <shop@example.com>",
+		To:       []string{"owner@example.com", "Бухгалтерия <books@example.com>"},
+		TLS:      mode,
+	}
+}
+
+func TestEmail_SendMultipart(t *testing.T) {
+	cert, pool := testCertificate(t)
+	fake := newFakeSMTP(t, false, func(f *fakeSMTP) {
+		f.startTLS = true
+		f.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	})
+
+	email, err := NewEmail(fake.config(yapay.SMTPTLSStartTLS), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	require.NoError(t, err)
+	err = email.Send(context.Background(), &Message{
+		Type:    yapay.NotificationTypePaymentSuccess,
+		Subject: "Оплата получена",
+		Text:    "Заказ 42 оплачен",
+		HTML:    "<p>Заказ <b>42</b> оплачен</p>",
+	})
+	require.NoError(t, err)
+
+	require.Len(t, fake.messages, 1)
+	assert.True(t, fake.usedTLS[0])
+	assert.Equal(t, []string{"shop"}, fake.auth)
+	assert.Equal(t, "shop@example.com", fake.from[0])
+	assert.Equal(t, []string{"owner@example.com", "books@example.com"}, fake.rcpts[0])
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(fake.messages[0])))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Оплата получена", subject)
+	assert.Equal(t, "payment_success", msg.Header.Get("X-Yapay-Notification"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part) // quoted-printable is decoded by NextPart
+		require.NoError(t, err)
+		bodies = append(bodies, string(data))
+	}
+	assert.Equal(t, []string{"Заказ 42 оплачен", "<p>Заказ <b>42</b> оплачен</p>"}, bodies)
+}
+
+func TestEmail_ImplicitTLSAndRecipientsOverride(t *testing.T) {
+	cert, pool := testCertificate(t)
+	fake := newFakeSMTP(t, true, func(f *fakeSMTP) {
+		f.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	})
+
+	email, err := NewEmail(fake.config(yapay.SMTPTLSImplicit), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	require.NoError(t, err)
+	err = email.Send(context.Background(), &Message{Subject: "ops", Text: "disk full", Recipients: []string{"ops@example.com"}})
+	require.NoError(t, err)
+	require.Len(t, fake.messages, 1)
+	assert.True(t, fake.usedTLS[0])
+	assert.Equal(t, []string{"ops@example.com"}, fake.rcpts[0])
+
+	// An untrusted certificate fails the handshake
+	email, err = NewEmail(fake.config(yapay.SMTPTLSImplicit))
+	require.NoError(t, err)
+	err = email.Send(context.Background(), &Message{Text: "x"})
+	require.Error(t, err)
+	assert.Len(t, fake.messages, 1)
+}
+
+func TestEmail_Errors(t *testing.T) {
+	t.Run("starttls not offered", func(t *testing.T) {
+		fake := newFakeSMTP(t, false, nil)
+		email, err := NewEmail(fake.config(yapay.SMTPTLSStartTLS))
+		require.NoError(t, err)
+		err = email.Send(context.Background(), &Message{Text: "x"})
+		assert.True(t, errors.Is(err, yapay.ErrValidation))
+		assert.Empty(t, fake.messages)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		fake := newFakeSMTP(t, false, nil)
+		cfg := fake.config(yapay.SMTPTLSNone)
+		cfg.Password = "wrong"
+		email, err := NewEmail(cfg)
+		require.NoError(t, err)
+		err = email.Send(context.Background(), &Message{Text: "x"})
+		assert.True(t, errors.Is(err, yapay.ErrUnauthorized))
+		var smtpErr *SMTPError
+		require.True(t, errors.As(err, &smtpErr))
+		assert.Equal(t, "AUTH", smtpErr.Stage)
+		assert.Equal(t, 535, smtpErr.Code)
+		assert.NotContains(t, err.Error(), "wrong")
+	})
+
+	t.Run("rejected recipient", func(t *testing.T) {
+		fake := newFakeSMTP(t, false, func(f *fakeSMTP) { f.rejectTo = "books@example.com" })
+		email, err := NewEmail(fake.config(yapay.SMTPTLSNone))
+		require.NoError(t, err)
+		err = email.Send(context.Background(), &Message{Text: "x"})
+		assert.True(t, errors.Is(err, yapay.ErrValidation))
+		assert.False(t, yapay.IsRetryable(err))
+		var smtpErr *SMTPError
+		require.True(t, errors.As(err, &smtpErr))
+		assert.Equal(t, "RCPT", smtpErr.Stage)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		fake := newFakeSMTP(t, false, func(f *fakeSMTP) { f.greetWait = time.Second })
+		email, err := NewEmail(fake.config(yapay.SMTPTLSNone), WithTimeout(50*time.Millisecond))
+		require.NoError(t, err)
+		err = email.Send(context.Background(), &Message{Text: "x"})
+		assert.True(t, errors.Is(err, yapay.ErrTimeout))
+		assert.True(t, yapay.IsRetryable(err))
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		port := ln.Addr().(*net.TCPAddr).Port
+		ln.Close()
+
+		email, err := NewEmail(yapay.EmailConfig{SMTPHost: "127.0.0.1", SMTPPort: port, From: "shop@example.com", To: []string{"a@example.com"}, TLS: yapay.SMTPTLSNone})
+		require.NoError(t, err)
+		err = email.Send(context.Background(), &Message{Text: "x"})
+		assert.True(t, errors.Is(err, yapay.ErrBackendUnavailable), strconv.Quote(err.Error()))
+	})
+}
+
+func TestNewEmail_Validation(t *testing.T) {
+	base := yapay.EmailConfig{SMTPHost: "smtp.example.com", From: "shop@example.com"}
+
+	_, err := NewEmail(yapay.EmailConfig{From: "shop@example.com"})
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+
+	cfg := base
+	cfg.TLS = "ssl"
+	_, err = NewEmail(cfg)
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+
+	cfg = base
+	cfg.To = []string{"nope"}
+	_, err = NewEmail(cfg)
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+
+	email, err := NewEmail(base)
+	require.NoError(t, err)
+	err = email.Send(context.Background(), &Message{Text: "no recipients"})
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+}
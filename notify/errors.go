@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// TelegramError is a failure reported by the Bot API. It is wrapped in a
+// *yapay.Error, so callers can use both errors.As(err, &tgErr) and
+// errors.Is(err, yapay.ErrRateLimited).
+type TelegramError struct {
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	RetryAfter  time.Duration
+}
+
+// Error implements the error interface
+func (e *TelegramError) Error() string {
+	return fmt.Sprintf("telegram API %d: %s", e.ErrorCode, e.Description)
+}
+
+// SMTPError is a failure reply of an SMTP server
+type SMTPError struct {
+	// Stage is the SMTP command that failed, e.g. "RCPT"
+	Stage   string
+	Code    int
+	Message string
+}
+
+// Error implements the error interface
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("smtp %s: %d %s", e.Stage, e.Code, e.Message)
+}
+
+// RecipientError is the failure to deliver a message to one recipient
+type RecipientError struct {
+	Recipient string
+	Err       error
+}
+
+// Error implements the error interface
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Recipient, e.Err)
+}
+
+// Unwrap returns the recipient's delivery error
+func (e *RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// DeliveryError reports a message that did not reach all of its recipients.
+// It keeps the error of every failed recipient, so callers can retry exactly
+// the recipients that failed and leave out the ones that received it.
+type DeliveryError struct {
+	// Delivered are the recipients that received the message
+	Delivered []string
+	// Failed holds one error per recipient that did not receive it
+	Failed []*RecipientError
+}
+
+// Error implements the error interface
+func (e *DeliveryError) Error() string {
+	failures := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		failures[i] = f.Error()
+	}
+	return fmt.Sprintf("%d of %d recipients failed: %s",
+		len(e.Failed), len(e.Failed)+len(e.Delivered), strings.Join(failures, "; "))
+}
+
+// Unwrap returns the recipient errors for errors.Is and errors.As
+func (e *DeliveryError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+// FailedRecipients returns the recipients that did not receive the message
+func (e *DeliveryError) FailedRecipients() []string {
+	recipients := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		recipients[i] = f.Recipient
+	}
+	return recipients
+}
+
+// code returns the code of the first permanent failure, or of the first
+// failure if every recipient failed transiently, so the error is retryable
+// only when a retry can reach all the failed recipients
+func (e *DeliveryError) code() yapay.ErrorCode {
+	code := yapay.ErrorCodeInternal
+	for i, f := range e.Failed {
+		c := yapay.ErrorCodeOf(f.Err)
+		if c == "" {
+			c = yapay.ErrorCodeInternal
+		}
+		if !yapay.IsRetryable(f.Err) {
+			return c
+		}
+		if i == 0 {
+			code = c
+		}
+	}
+	return code
+}
+
+func telegramErrorCode(status int) yapay.ErrorCode {
+	switch {
+	case status == http.StatusBadRequest:
+		return yapay.ErrorCodeValidation
+	case status == http.StatusUnauthorized, status == http.StatusNotFound:
+		// The Bot API answers 404 for an unknown token
+		return yapay.ErrorCodeUnauthorized
+	case status == http.StatusForbidden:
+		return yapay.ErrorCodeForbidden
+	case status == http.StatusTooManyRequests:
+		return yapay.ErrorCodeRateLimited
+	case status >= http.StatusInternalServerError:
+		return yapay.ErrorCodeBackendUnavailable
+	default:
+		return yapay.ErrorCodeInternal
+	}
+}
+
+// smtpError converts an error of an SMTP session stage into a structured SDK error
+func smtpError(ctx context.Context, stage string, err error) *yapay.Error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return yapay.AsError(ctxErr)
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		smtpErr := &SMTPError{Stage: stage, Code: protoErr.Code, Message: protoErr.Msg}
+		return yapay.WrapError(smtpErrorCode(stage, protoErr.Code), smtpErr, "smtp server rejected the message")
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return yapay.WrapError(yapay.ErrorCodeTimeout, err, fmt.Sprintf("smtp %s timed out", stage))
+	}
+	return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, fmt.Sprintf("smtp %s failed", stage))
+}
+
+func smtpErrorCode(stage string, code int) yapay.ErrorCode {
+	switch {
+	case code >= 400 && code < 500:
+		// Transient negative completion: greylisting, full mailbox, rate limits
+		return yapay.ErrorCodeBackendUnavailable
+	case stage == "AUTH" || code == 530 || code == 535:
+		return yapay.ErrorCodeUnauthorized
+	case stage == "MAIL" || stage == "RCPT":
+		return yapay.ErrorCodeValidation
+	default:
+		return yapay.ErrorCodeInternal
+	}
+}
@@ -0,0 +1,162 @@
+// Package notify sends merchant notifications through the channels
+// configured in yapay.NotificationConfig: Telegram and email.
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// DefaultTimeout bounds a single delivery: one Bot API call or one SMTP session
+const DefaultTimeout = 10 * time.Second
+
+// Channel is a notification delivery channel
+type Channel string
+
+// Notification channels
+const (
+	ChannelTelegram Channel = "telegram"
+	ChannelEmail    Channel = "email"
+)
+
+// Message is a rendered notification
+type Message struct {
+	Type yapay.NotificationType
+	// Subject is the email subject; Telegram does not use it
+	Subject string
+	// Text is the plain text body
+	Text string
+	// ParseMode is the Telegram parse mode of Text: "", "HTML" or "MarkdownV2"
+	ParseMode string
+	// HTML is an optional HTML body sent by email as an alternative to Text
+	HTML string
+	// Recipients overrides the configured Telegram chat IDs or email addresses
+	Recipients []string
+}
+
+var defaultSubjects = map[yapay.NotificationType]string{
+	yapay.NotificationTypePaymentCreated: "Payment created",
+	yapay.NotificationTypePaymentSuccess: "Payment succeeded",
+	yapay.NotificationTypePaymentFailed:  "Payment failed",
+	yapay.NotificationTypeSystemError:    "System error",
+	yapay.NotificationTypeWebhook:        "Webhook received",
+	yapay.NotificationTypeDigest:         "Notification digest",
+}
+
+// MessageFromRequest creates a plain text message from a notification request
+func MessageFromRequest(req *yapay.NotificationRequest) *Message {
+	subject, ok := defaultSubjects[req.Type]
+	if !ok {
+		subject = string(req.Type)
+	}
+	if req.PaymentID != "" {
+		subject += " " + req.PaymentID
+	}
+	return &Message{Type: req.Type, Subject: subject, Text: req.Message}
+}
+
+// Notifier delivers messages through one channel. Errors are *yapay.Error
+// values, so yapay.IsRetryable tells transient failures from permanent ones.
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Notifiers are the notifiers of a merchant by channel. Send delivers to all
+// of them.
+type Notifiers map[Channel]Notifier
+
+// FromMerchant creates notifiers for the enabled channels of the merchant
+func FromMerchant(m *yapay.Merchant, opts ...Option) (Notifiers, error) {
+	return New(m.Notifications, opts...)
+}
+
+// New creates notifiers for the enabled channels of cfg
+func New(cfg yapay.NotificationConfig, opts ...Option) (Notifiers, error) {
+	notifiers := make(Notifiers)
+	if cfg.Telegram.Enabled {
+		tg, err := NewTelegram(cfg.Telegram, opts...)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[ChannelTelegram] = tg
+	}
+	if cfg.Email.Enabled {
+		email, err := NewEmail(cfg.Email, opts...)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[ChannelEmail] = email
+	}
+	return notifiers, nil
+}
+
+// Channels returns the configured channels in a stable order
+func (n Notifiers) Channels() []Channel {
+	channels := make([]Channel, 0, len(n))
+	for ch := range n {
+		channels = append(channels, ch)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	return channels
+}
+
+// Send delivers msg through every channel and returns the joined errors of
+// the channels that failed; the message is not resent to channels that succeeded
+func (n Notifiers) Send(ctx context.Context, msg *Message) error {
+	var errs []error
+	for _, ch := range n.Channels() {
+		if err := n[ch].Send(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ch, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Option configures Telegram and Email notifiers
+type Option func(*options)
+
+type options struct {
+	timeout    time.Duration
+	httpClient *http.Client
+	tlsConfig  *tls.Config
+}
+
+// WithTimeout bounds a single delivery; DefaultTimeout is used otherwise
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for Bot API calls
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithTLSConfig sets the TLS config for SMTP connections, e.g. to trust a
+// private CA. ServerName defaults to the SMTP host.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.httpClient == nil {
+		o.httpClient = &http.Client{}
+	}
+	return o
+}
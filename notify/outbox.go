@@ -0,0 +1,429 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// Outbox defaults
+const (
+	DefaultMaxAttempts  = 8
+	DefaultRetryBackoff = 5 * time.Second
+	DefaultMaxBackoff   = time.Hour
+	DefaultPollInterval = 5 * time.Second
+	DefaultRetention    = 7 * 24 * time.Hour
+)
+
+// Outbox persists notification requests and delivers them in the background,
+// so a notification survives a host restart or a channel outage. Each request
+// is queued as one item per channel and, when a rule names recipients, per
+// recipient; a failed item is retried with exponential backoff and jitter
+// without resending the items that were delivered. Items are marked dead
+// after MaxAttempts, or at once on errors that are not retryable such as a
+// rejected bot token. Delivered and dead items are purged after the
+// retention period.
+type Outbox struct {
+	store     Store
+	notifiers Notifiers
+	renderer  *Renderer
+	router    *Router
+	digests   DigestSink
+
+	maxAttempts  int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+	retention    time.Duration
+	logger       logrus.FieldLogger
+	now          func() time.Time
+	jitter       func() float64
+
+	wake chan struct{}
+}
+
+// OutboxOption configures an Outbox
+type OutboxOption func(*Outbox)
+
+// WithMaxAttempts sets how many delivery attempts are made before an item is dead
+func WithMaxAttempts(n int) OutboxOption {
+	return func(o *Outbox) {
+		o.maxAttempts = n
+	}
+}
+
+// WithRetryBackoff sets the delay after the first failure and its upper
+// bound; the delay doubles on every attempt
+func WithRetryBackoff(initial, max time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.retryBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// WithPollInterval sets how often Run looks for due items
+func WithPollInterval(d time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.pollInterval = d
+	}
+}
+
+// WithRetention sets how long delivered and dead items are kept; while kept,
+// they deduplicate new requests and dead items can be retried
+func WithRetention(d time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.retention = d
+	}
+}
+
+// WithOutboxLogger sets the logger for delivery failures
+func WithOutboxLogger(logger logrus.FieldLogger) OutboxOption {
+	return func(o *Outbox) {
+		o.logger = logger
+	}
+}
+
+// WithRouter applies routing rules to enqueued requests. Without a router
+// every request is queued for all channels.
+func WithRouter(r *Router) OutboxOption {
+	return func(o *Outbox) {
+		o.router = r
+	}
+}
+
+// WithDigestSink sets where requests routed to a digest are collected.
+// Without a sink such requests are dropped with a warning.
+func WithDigestSink(s DigestSink) OutboxOption {
+	return func(o *Outbox) {
+		o.digests = s
+	}
+}
+
+// DigestSink collects requests that routing rules send to a digest. Collect
+// returns ErrDuplicate for a request it has already collected.
+type DigestSink interface {
+	Collect(ctx context.Context, route Route, req *yapay.NotificationRequest) error
+}
+
+// NewOutbox creates an outbox delivering through the notifiers. Start the
+// worker with Run.
+func NewOutbox(store Store, notifiers Notifiers, renderer *Renderer, opts ...OutboxOption) *Outbox {
+	o := &Outbox{
+		store:        store,
+		notifiers:    notifiers,
+		renderer:     renderer,
+		maxAttempts:  DefaultMaxAttempts,
+		retryBackoff: DefaultRetryBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+		pollInterval: DefaultPollInterval,
+		retention:    DefaultRetention,
+		logger:       logrus.StandardLogger(),
+		now:          time.Now,
+		jitter:       mathrand.Float64,
+		wake:         make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Enqueue routes req and queues it for every route; routes in quiet hours
+// are delivered when they end. It reports false if nothing was queued: the
+// request matched no rule, or it is a duplicate of one with the same client,
+// payment ID and type that was queued before and is still retained.
+// Requests without a payment ID are never deduplicated. Only requests that
+// queue something count against the rate limits of the routing rules.
+func (o *Outbox) Enqueue(ctx context.Context, req *yapay.NotificationRequest) (bool, error) {
+	key := dedupKey(req)
+	now := o.now()
+	queued := false
+	for _, g := range o.router.route(req, o.notifiers.Channels(), now) {
+		ok, err := o.enqueue(ctx, key, req, g.routes)
+		if !ok {
+			g.rule.release(now)
+		}
+		if err != nil {
+			return queued, err
+		}
+		queued = queued || ok
+	}
+	return queued, nil
+}
+
+// enqueue queues req for the routes under IDs derived from key
+func (o *Outbox) enqueue(ctx context.Context, key string, req *yapay.NotificationRequest, routes []Route) (bool, error) {
+	now := o.now()
+	queued := false
+	for _, route := range routes {
+		if route.Digest != "" {
+			if o.digests == nil {
+				o.logger.WithFields(logrus.Fields{"rule": route.Rule, "type": req.Type}).
+					Warn("Notification routed to a digest, but no digest is configured")
+				continue
+			}
+			err := o.digests.Collect(ctx, route, req)
+			if errors.Is(err, ErrDuplicate) {
+				continue
+			}
+			if err != nil {
+				return queued, err
+			}
+			queued = true
+			continue
+		}
+
+		// Each recipient is a separate item, so a recipient that fails is
+		// retried and reported on its own
+		recipients := [][]string{nil}
+		if len(route.Recipients) > 0 {
+			recipients = recipients[:0]
+			for _, r := range route.Recipients {
+				recipients = append(recipients, []string{r})
+			}
+		}
+		for _, to := range recipients {
+			item := &OutboxItem{
+				ID:            key + "/" + string(route.Channel),
+				Channel:       route.Channel,
+				Request:       *req,
+				Recipients:    to,
+				Status:        OutboxPending,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				NextAttemptAt: now,
+			}
+			if len(to) > 0 {
+				item.ID += "/" + to[0]
+			}
+			if route.NotBefore.After(now) {
+				item.NextAttemptAt = route.NotBefore
+			}
+			err := o.store.Add(ctx, item)
+			if errors.Is(err, ErrDuplicate) {
+				continue
+			}
+			if err != nil {
+				return queued, yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to queue notification")
+			}
+			queued = true
+		}
+	}
+	if queued {
+		o.notify()
+	}
+	return queued, nil
+}
+
+// dedupKey identifies a request by client, payment and type
+func dedupKey(req *yapay.NotificationRequest) string {
+	if req.PaymentID == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		return fmt.Sprintf("%s/%s/%s", req.ClientID, req.Type, hex.EncodeToString(b))
+	}
+	return fmt.Sprintf("%s/%s/%s", req.ClientID, req.Type, req.PaymentID)
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due items until ctx is done. New items are delivered at once;
+// retries wait for their backoff.
+func (o *Outbox) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := o.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			o.logger.WithError(err).Error("Notification outbox failed")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// ProcessDue makes one delivery attempt for every pending item that is due
+// and purges delivered and dead items past the retention period. It returns
+// the number of items delivered.
+func (o *Outbox) ProcessDue(ctx context.Context) (int, error) {
+	items, err := o.store.List(ctx, OutboxPending, OutboxDelivered, OutboxDead)
+	if err != nil {
+		return 0, err
+	}
+
+	now := o.now()
+	delivered := 0
+	var expired []string
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		switch {
+		case item.Status == OutboxDelivered, item.Status == OutboxDead:
+			if now.Sub(item.UpdatedAt) > o.retention {
+				expired = append(expired, item.ID)
+			}
+		case !item.NextAttemptAt.After(now):
+			ok, err := o.attempt(ctx, item)
+			if err != nil {
+				return delivered, err
+			}
+			if ok {
+				delivered++
+			}
+		}
+	}
+	if len(expired) > 0 {
+		if err := o.store.Delete(ctx, expired...); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// attempt delivers one item and records the outcome
+func (o *Outbox) attempt(ctx context.Context, item *OutboxItem) (bool, error) {
+	err := o.deliver(ctx, item)
+	if ctx.Err() != nil {
+		// Shutting down: the attempt does not count
+		return false, nil
+	}
+
+	item.Attempts++
+	item.UpdatedAt = o.now()
+	if err == nil {
+		item.Status = OutboxDelivered
+		item.LastError = ""
+	} else {
+		item.LastError = err.Error()
+		var delivery *DeliveryError
+		if errors.As(err, &delivery) && len(delivery.Delivered) > 0 {
+			// Retry only the recipients that failed
+			item.Delivered = append(item.Delivered, delivery.Delivered...)
+			item.Recipients = delivery.FailedRecipients()
+		}
+		log := o.logger.WithError(err).WithFields(logrus.Fields{
+			"outbox_id": item.ID,
+			"channel":   item.Channel,
+			"attempts":  item.Attempts,
+		})
+		if !yapay.IsRetryable(err) || item.Attempts >= o.maxAttempts {
+			item.Status = OutboxDead
+			log.Error("Notification is dead")
+		} else {
+			item.NextAttemptAt = item.UpdatedAt.Add(o.backoff(item.Attempts, err))
+			log.WithField("next_attempt_at", item.NextAttemptAt).Warn("Notification delivery failed, will retry")
+		}
+	}
+	if updateErr := o.store.Update(ctx, item); updateErr != nil {
+		return false, updateErr
+	}
+	return err == nil, nil
+}
+
+func (o *Outbox) deliver(ctx context.Context, item *OutboxItem) error {
+	notifier, ok := o.notifiers[item.Channel]
+	if !ok {
+		return yapay.Errorf(yapay.ErrorCodeValidation, "channel %s is not configured", item.Channel)
+	}
+	msg, err := o.renderer.Render(item.Channel, &item.Request)
+	if err != nil {
+		return err
+	}
+	if len(item.Recipients) > 0 {
+		msg.Recipients = item.Recipients
+	}
+	return notifier.Send(ctx, msg)
+}
+
+// backoff returns the delay before the next attempt: retryBackoff doubled
+// per attempt up to maxBackoff, with equal jitter, and no shorter than a
+// Retry-After reported by the channel
+func (o *Outbox) backoff(attempts int, err error) time.Duration {
+	d := o.retryBackoff
+	for i := 1; i < attempts && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+	d = d/2 + time.Duration(o.jitter()*float64(d/2))
+
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > d {
+		d = tgErr.RetryAfter
+	}
+	return d
+}
+
+// Items returns the items with the given statuses, or all items
+func (o *Outbox) Items(ctx context.Context, statuses ...OutboxStatus) ([]*OutboxItem, error) {
+	return o.store.List(ctx, statuses...)
+}
+
+// Pending returns the items waiting for delivery or a retry
+func (o *Outbox) Pending(ctx context.Context) ([]*OutboxItem, error) {
+	return o.store.List(ctx, OutboxPending)
+}
+
+// Dead returns the items that will not be retried automatically
+func (o *Outbox) Dead(ctx context.Context) ([]*OutboxItem, error) {
+	return o.store.List(ctx, OutboxDead)
+}
+
+// Retry requeues a dead item with a fresh attempt budget
+func (o *Outbox) Retry(ctx context.Context, id string) error {
+	item, err := o.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if item.Status != OutboxDead {
+		return fmt.Errorf("outbox item %s is %s, not dead", id, item.Status)
+	}
+	item.Status = OutboxPending
+	item.Attempts = 0
+	item.UpdatedAt = o.now()
+	item.NextAttemptAt = item.UpdatedAt
+	if err := o.store.Update(ctx, item); err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// Handler serves the outbox items as JSON; ?status=pending,dead filters them
+func (o *Outbox) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var statuses []OutboxStatus
+		if s := r.URL.Query().Get("status"); s != "" {
+			for _, status := range strings.Split(s, ",") {
+				statuses = append(statuses, OutboxStatus(strings.TrimSpace(status)))
+			}
+		}
+		items, err := o.store.List(r.Context(), statuses...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	})
+}
@@ -0,0 +1,410 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNotifier records messages and fails with queued errors
+type fakeNotifier struct {
+	mu   sync.Mutex
+	sent []*Message
+	errs []error
+}
+
+func (f *fakeNotifier) Send(_ context.Context, msg *Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeNotifier) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent)
+}
+
+// testClock is a controllable time source
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestOutbox(t *testing.T, store Store, notifiers Notifiers, opts ...OutboxOption) (*Outbox, *testClock) {
+	r, err := NewRenderer(testMerchant())
+	require.NoError(t, err)
+	o := NewOutbox(store, notifiers, r, opts...)
+	clock := &testClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	o.now = clock.now
+	o.jitter = func() float64 { return 1 }
+	return o, clock
+}
+
+func retryableErr() error {
+	return yapay.NewError(yapay.ErrorCodeBackendUnavailable, "telegram is down")
+}
+
+func TestOutbox_DeliverAndDeduplicate(t *testing.T) {
+	tg := &fakeNotifier{}
+	o, _ := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg})
+	ctx := context.Background()
+	req := NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "")
+
+	queued, err := o.Enqueue(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, queued)
+	queued, err = o.Enqueue(ctx, req)
+	require.NoError(t, err)
+	assert.False(t, queued, "same payment and type is a duplicate")
+
+	delivered, err := o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	require.Equal(t, 1, tg.count())
+	assert.Contains(t, tg.sent[0].Text, "Платеж получен")
+
+	// Still a duplicate after delivery, while the item is retained
+	queued, err = o.Enqueue(ctx, req)
+	require.NoError(t, err)
+	assert.False(t, queued)
+
+	// Requests without a payment ID are not deduplicated
+	sysErr := &yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError, Message: "boom"}
+	for i := 0; i < 2; i++ {
+		queued, err = o.Enqueue(ctx, sysErr)
+		require.NoError(t, err)
+		assert.True(t, queued)
+	}
+	pending, err := o.Pending(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+}
+
+func TestOutbox_RetryWithBackoff(t *testing.T) {
+	tg := &fakeNotifier{errs: []error{retryableErr(), retryableErr()}}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg}, WithRetryBackoff(time.Second, 10*time.Second))
+	ctx := context.Background()
+
+	_, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentFailed, testPayment(), ""))
+	require.NoError(t, err)
+
+	delivered, err := o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, delivered)
+	pending, err := o.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Equal(t, clock.t.Add(time.Second), pending[0].NextAttemptAt)
+	assert.Contains(t, pending[0].LastError, "telegram is down")
+
+	// Not due yet
+	clock.advance(500 * time.Millisecond)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	pending, _ = o.Pending(ctx)
+	assert.Equal(t, 1, pending[0].Attempts)
+
+	clock.advance(500 * time.Millisecond)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	pending, _ = o.Pending(ctx)
+	assert.Equal(t, 2, pending[0].Attempts)
+	assert.Equal(t, clock.t.Add(2*time.Second), pending[0].NextAttemptAt)
+
+	clock.advance(2 * time.Second)
+	delivered, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 1, tg.count())
+}
+
+func TestOutbox_Backoff(t *testing.T) {
+	o, _ := newTestOutbox(t, NewMemoryStore(), nil, WithRetryBackoff(time.Second, 5*time.Second))
+	assert.Equal(t, time.Second, o.backoff(1, nil))
+	assert.Equal(t, 4*time.Second, o.backoff(3, nil))
+	assert.Equal(t, 5*time.Second, o.backoff(10, nil))
+
+	o.jitter = func() float64 { return 0 }
+	assert.Equal(t, 2*time.Second, o.backoff(3, nil))
+
+	flood := yapay.WrapError(yapay.ErrorCodeRateLimited, &TelegramError{RetryAfter: 30 * time.Second}, "flood")
+	assert.Equal(t, 30*time.Second, o.backoff(1, flood))
+}
+
+func TestOutbox_DeadAndRetry(t *testing.T) {
+	tg := &fakeNotifier{errs: []error{retryableErr(), retryableErr()}}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg}, WithMaxAttempts(2), WithRetryBackoff(time.Second, time.Second))
+	ctx := context.Background()
+
+	_, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentFailed, testPayment(), ""))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = o.ProcessDue(ctx)
+		require.NoError(t, err)
+		clock.advance(time.Minute)
+	}
+
+	dead, err := o.Dead(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Zero(t, tg.count())
+
+	require.NoError(t, o.Retry(ctx, dead[0].ID))
+	assert.Error(t, o.Retry(ctx, dead[0].ID), "only dead items can be retried")
+	assert.True(t, errors.Is(o.Retry(ctx, "missing"), ErrItemNotFound))
+
+	delivered, err := o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+}
+
+func TestOutbox_PermanentErrorIsDeadAtOnce(t *testing.T) {
+	tg := &fakeNotifier{errs: []error{yapay.NewError(yapay.ErrorCodeUnauthorized, "bad token")}}
+	o, _ := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg})
+	ctx := context.Background()
+
+	_, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentFailed, testPayment(), ""))
+	require.NoError(t, err)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	dead, err := o.Dead(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 1, dead[0].Attempts)
+	assert.Equal(t, "bad token", dead[0].LastError)
+}
+
+func TestOutbox_PartialFailureDoesNotResend(t *testing.T) {
+	tg := &fakeNotifier{errs: []error{retryableErr()}}
+	email := &fakeNotifier{}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg, ChannelEmail: email})
+	ctx := context.Background()
+
+	_, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), ""))
+	require.NoError(t, err)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	clock.advance(time.Hour)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, tg.count())
+	assert.Equal(t, 1, email.count())
+	assert.NotEmpty(t, email.sent[0].HTML)
+}
+
+func TestOutbox_RetriesOnlyFailedChats(t *testing.T) {
+	fake := newFakeTelegram(t)
+	fail := true
+	fake.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["chat_id"] == "2" && fail {
+			fail = false
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: fake.notifier(t)})
+	ctx := context.Background()
+
+	req := NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "")
+	_, err := o.enqueue(ctx, dedupKey(req), req, []Route{{Channel: ChannelTelegram, Recipients: []string{"1", "2"}}})
+	require.NoError(t, err)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	clock.advance(time.Hour)
+	delivered, err := o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	var chats []interface{}
+	for _, m := range fake.messages {
+		chats = append(chats, m["chat_id"])
+	}
+	assert.Equal(t, []interface{}{"1", "2", "2"}, chats)
+}
+
+func TestOutbox_ItemPerRecipient(t *testing.T) {
+	tg := &fakeNotifier{}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg})
+	ctx := context.Background()
+
+	req := NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "")
+	routes := []Route{{Channel: ChannelTelegram, Recipients: []string{"1", "2"}}}
+	queued, err := o.enqueue(ctx, dedupKey(req), req, routes)
+	require.NoError(t, err)
+	assert.True(t, queued)
+
+	items, err := o.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	ids := []string{items[0].ID, items[1].ID}
+	assert.ElementsMatch(t, []string{dedupKey(req) + "/telegram/1", dedupKey(req) + "/telegram/2"}, ids)
+
+	// The first chat fails for good; the second is delivered on its own
+	tg.errs = []error{yapay.NewError(yapay.ErrorCodeForbidden, "bot was blocked")}
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	dead, err := o.Dead(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Len(t, dead[0].Recipients, 1)
+	require.Equal(t, 1, tg.count())
+	assert.NotEqual(t, dead[0].Recipients, tg.sent[0].Recipients)
+
+	// A redelivered request queues nothing for either chat
+	clock.advance(time.Minute)
+	queued, err = o.enqueue(ctx, dedupKey(req), req, routes)
+	require.NoError(t, err)
+	assert.False(t, queued)
+}
+
+func TestOutbox_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox", "shop.json")
+	ctx := context.Background()
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	down := &fakeNotifier{errs: []error{retryableErr()}}
+	o, clock := newTestOutbox(t, store, Notifiers{ChannelTelegram: down})
+	_, err = o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "paid"))
+	require.NoError(t, err)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	// Restart: a new store and outbox read the same file
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	tg := &fakeNotifier{}
+	o, _ = newTestOutbox(t, store, Notifiers{ChannelTelegram: tg})
+	o.now = func() time.Time { return clock.t.Add(time.Hour) }
+
+	queued, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "paid"))
+	require.NoError(t, err)
+	assert.False(t, queued, "deduplication survives the restart")
+
+	delivered, err := o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	require.Equal(t, 1, tg.count())
+	assert.Contains(t, tg.sent[0].Text, "1 234.56 RUB", "payment restored from the file")
+
+	items, err := o.Items(ctx)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, OutboxDelivered, items[0].Status)
+	assert.Equal(t, 2, items[0].Attempts)
+}
+
+func TestOutbox_RetentionPurgesDeliveredAndDead(t *testing.T) {
+	tg := &fakeNotifier{}
+	email := &fakeNotifier{errs: []error{yapay.NewError(yapay.ErrorCodeUnauthorized, "bad password")}}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg, ChannelEmail: email}, WithRetention(time.Hour))
+	ctx := context.Background()
+	req := NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "")
+
+	_, err := o.Enqueue(ctx, req)
+	require.NoError(t, err)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	dead, err := o.Dead(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+
+	clock.advance(2 * time.Hour)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	items, err := o.Items(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	queued, err := o.Enqueue(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, queued)
+}
+
+func TestOutbox_RunAndHandler(t *testing.T) {
+	tg := &fakeNotifier{}
+	r, err := NewRenderer(testMerchant())
+	require.NoError(t, err)
+	o := NewOutbox(NewMemoryStore(), Notifiers{ChannelTelegram: tg}, r, WithPollInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- o.Run(ctx) }()
+
+	_, err = o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentCreated, testPayment(), ""))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return tg.count() == 1 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	rec := httptest.NewRecorder()
+	o.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/outbox?status=delivered", nil))
+	var body struct {
+		Items []OutboxItem `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Items, 1)
+	assert.Equal(t, "shop/payment_created/pay-1/telegram", body.Items[0].ID)
+
+	rec = httptest.NewRecorder()
+	o.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/outbox?status=pending,dead", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Empty(t, body.Items)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	item := &OutboxItem{ID: "a", Channel: ChannelEmail, Status: OutboxPending, CreatedAt: time.Unix(1, 0)}
+	require.NoError(t, store.Add(ctx, item))
+	assert.True(t, errors.Is(store.Add(ctx, item), ErrDuplicate))
+	require.NoError(t, store.Add(ctx, &OutboxItem{ID: "b", Status: OutboxDead, CreatedAt: time.Unix(2, 0)}))
+
+	item.Status = OutboxDelivered
+	require.NoError(t, store.Update(ctx, item))
+	assert.True(t, errors.Is(store.Update(ctx, &OutboxItem{ID: "c"}), ErrItemNotFound))
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	items, err := reopened.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "a", items[0].ID)
+	assert.Equal(t, OutboxDelivered, items[0].Status)
+
+	dead, err := reopened.List(ctx, OutboxDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+
+	require.NoError(t, reopened.Delete(ctx, "a", "missing"))
+	_, err = reopened.Get(ctx, "a")
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+}
@@ -0,0 +1,704 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// Keys of NotificationRequest.Data read by the Renderer
+const (
+	DataKeyPayment = "payment"
+	DataKeyReason  = "reason"
+	DataKeyDigest  = "digest"
+)
+
+// DefaultDateLayout is the layout of the date template helper
+const DefaultDateLayout = "02.01.2006 15:04"
+
+// NewPaymentNotification creates a notification request carrying the payment,
+// so templates can show its amount, status and metadata
+func NewPaymentNotification(typ yapay.NotificationType, payment *yapay.Payment, message string) *yapay.NotificationRequest {
+	return &yapay.NotificationRequest{
+		Type:      typ,
+		ClientID:  payment.MerchantID,
+		PaymentID: payment.ID,
+		Message:   message,
+		Data:      map[string]interface{}{DataKeyPayment: payment},
+	}
+}
+
+// TemplateData is the data notification templates are executed with
+type TemplateData struct {
+	Type      yapay.NotificationType
+	Merchant  *yapay.Merchant
+	ClientID  string
+	PaymentID string
+	Message   string
+	// Payment is decoded from Data["payment"]; nil if the request has none
+	Payment *yapay.Payment
+	// Reason is Data["reason"], e.g. why a payment failed
+	Reason string
+	// Metadata is the payment metadata
+	Metadata yapay.Metadata
+	// Digest is decoded from Data["digest"] for digest notifications
+	Digest *DigestSummary
+	Data   map[string]interface{}
+	Now    time.Time
+}
+
+// Field is a labeled value listed by the fields and details helpers
+type Field struct {
+	Key   string
+	Label string
+	Value string
+}
+
+// Renderer turns notification requests into messages using per-type
+// templates: the built-in ones, those passed with WithTemplates and those
+// in the merchant config, in increasing precedence. It is safe for
+// concurrent use.
+//
+// Templates can use these helpers besides the standard ones:
+//
+//	title .Type           built-in title of the notification type
+//	label "key"           FieldLabels entry, or the key made readable
+//	fields .Metadata      labeled metadata entries sorted by key
+//	details .             labeled payment fields: order, status, reason...
+//	money .Amount "RUB"   amount in minor units as "1 234.50 RUB"
+//	date .CreatedAt       RFC 3339 string or time.Time in the renderer location
+//	status .Status        human-readable payment status
+//	get .Data "key"       map value, or "" when missing
+//	md "text"             text escaped for Telegram MarkdownV2
+type Renderer struct {
+	merchant  *yapay.Merchant
+	location  *time.Location
+	layout    string
+	now       func() time.Time
+	templates map[yapay.NotificationType]yapay.NotificationTemplate
+
+	sets     map[yapay.NotificationType]*templateSet
+	fallback *templateSet
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// templateSet holds the parsed templates of one notification type
+type templateSet struct {
+	subject   *texttemplate.Template
+	text      *texttemplate.Template
+	html      *htmltemplate.Template
+	telegram  executor
+	parseMode string
+}
+
+// RenderOption configures a Renderer
+type RenderOption func(*Renderer)
+
+// WithLocation sets the time zone of the date helper; UTC by default
+func WithLocation(loc *time.Location) RenderOption {
+	return func(r *Renderer) {
+		r.location = loc
+	}
+}
+
+// WithDateLayout sets the layout of the date helper
+func WithDateLayout(layout string) RenderOption {
+	return func(r *Renderer) {
+		r.layout = layout
+	}
+}
+
+// WithTemplates sets plugin templates; the merchant config overrides them
+func WithTemplates(templates map[yapay.NotificationType]yapay.NotificationTemplate) RenderOption {
+	return func(r *Renderer) {
+		r.templates = templates
+	}
+}
+
+// NewRenderer parses the merchant's templates and checks them against a
+// sample payment, so template errors surface at startup
+func NewRenderer(m *yapay.Merchant, opts ...RenderOption) (*Renderer, error) {
+	if m == nil {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "merchant is required")
+	}
+	r := &Renderer{
+		merchant: m,
+		location: time.UTC,
+		layout:   DefaultDateLayout,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	builtin := yapay.NotificationTemplate{
+		Subject:   defaultSubjectTemplate,
+		Text:      defaultTextTemplate,
+		HTML:      defaultHTMLTemplate,
+		Telegram:  defaultTelegramTemplate,
+		ParseMode: yapay.ParseModeHTML,
+	}
+	var err error
+	if r.fallback, err = r.parse("default", builtin); err != nil {
+		return nil, yapay.WrapError(yapay.ErrorCodeInternal, err, "invalid built-in notification template")
+	}
+
+	digest := yapay.NotificationTemplate{
+		Subject:   defaultDigestSubjectTemplate,
+		Text:      defaultDigestTextTemplate,
+		HTML:      defaultDigestHTMLTemplate,
+		Telegram:  defaultDigestTelegramTemplate,
+		ParseMode: yapay.ParseModeHTML,
+	}
+
+	r.sets = make(map[yapay.NotificationType]*templateSet)
+	for _, typ := range yapay.NotificationTypes {
+		base := builtin
+		if typ == yapay.NotificationTypeDigest {
+			base = digest
+		}
+		tmpl := mergeTemplate(mergeTemplate(base, r.templates[typ]), m.Notifications.Templates[typ])
+		set, err := r.parse(string(typ), tmpl)
+		if err == nil {
+			err = r.check(set, typ)
+		}
+		if err != nil {
+			return nil, yapay.WrapError(yapay.ErrorCodeValidation, err, "invalid notification template").
+				WithDetail("notifications.templates."+string(typ), err.Error())
+		}
+		r.sets[typ] = set
+	}
+	return r, nil
+}
+
+// mergeTemplate overrides the non-empty fields of base. The parse mode goes
+// with the Telegram template it belongs to.
+func mergeTemplate(base, override yapay.NotificationTemplate) yapay.NotificationTemplate {
+	if override.Subject != "" {
+		base.Subject = override.Subject
+	}
+	if override.Text != "" {
+		base.Text = override.Text
+	}
+	if override.HTML != "" {
+		base.HTML = override.HTML
+	}
+	if override.Telegram != "" {
+		base.Telegram = override.Telegram
+		base.ParseMode = override.ParseMode
+		if base.ParseMode == "" {
+			base.ParseMode = yapay.ParseModeHTML
+		}
+	}
+	return base
+}
+
+func (r *Renderer) parse(name string, tmpl yapay.NotificationTemplate) (*templateSet, error) {
+	funcs := r.funcs()
+	text := func(field, src string) (*texttemplate.Template, error) {
+		t, err := texttemplate.New(name + "." + field).Funcs(funcs).Option("missingkey=zero").Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		return t, nil
+	}
+	html := func(field, src string) (*htmltemplate.Template, error) {
+		t, err := htmltemplate.New(name + "." + field).Funcs(htmltemplate.FuncMap(funcs)).Option("missingkey=zero").Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		return t, nil
+	}
+
+	set := &templateSet{parseMode: tmpl.ParseMode}
+	var err error
+	if set.subject, err = text("subject", tmpl.Subject); err != nil {
+		return nil, err
+	}
+	if set.text, err = text("text", tmpl.Text); err != nil {
+		return nil, err
+	}
+	if set.html, err = html("html", tmpl.HTML); err != nil {
+		return nil, err
+	}
+	if tmpl.ParseMode == yapay.ParseModeHTML {
+		set.telegram, err = html("telegram", tmpl.Telegram)
+	} else {
+		set.telegram, err = text("telegram", tmpl.Telegram)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// check executes every template of the set with a sample payment
+func (r *Renderer) check(set *templateSet, typ yapay.NotificationType) error {
+	metadata := yapay.Metadata{}
+	for key := range r.merchant.FieldLabels {
+		metadata[key] = "sample"
+	}
+	currency := r.merchant.Yandex.Currency
+	if currency == "" {
+		currency = yapay.DefaultCurrency
+	}
+	payment := &yapay.Payment{
+		ID:          "sample-payment",
+		OrderID:     "sample-order",
+		MerchantID:  r.merchant.ID,
+		Amount:      150000,
+		Currency:    currency,
+		Description: "Sample",
+		Status:      yapay.PaymentStatusSuccess,
+		Metadata:    metadata,
+		CreatedAt:   r.now().Format(time.RFC3339),
+	}
+	req := NewPaymentNotification(typ, payment, "Sample message")
+	req.Data[DataKeyReason] = "sample reason"
+	req.Data[DataKeyDigest] = &DigestSummary{
+		Window:         yapay.DigestDaily,
+		Start:          r.now().Add(-24 * time.Hour),
+		End:            r.now(),
+		Totals:         []DigestTotal{{Status: payment.Status, Currency: currency, Count: 1, Amount: int64(payment.Amount)}},
+		FailureReasons: []DigestReason{{Reason: "sample reason", Count: 1}},
+		Payments: []DigestPayment{{
+			ID: payment.ID, OrderID: payment.OrderID, Amount: payment.Amount, Currency: currency,
+			Status: payment.Status, Description: payment.Description, Metadata: metadata, UpdatedAt: r.now(),
+		}},
+		Omitted: 1,
+		Events:  map[yapay.NotificationType]int{typ: 1},
+	}
+
+	data := r.data(req)
+	for _, t := range []struct {
+		field string
+		tmpl  executor
+	}{{"subject", set.subject}, {"text", set.text}, {"html", set.html}, {"telegram", set.telegram}} {
+		if _, err := execute(t.tmpl, data); err != nil {
+			return fmt.Errorf("%s: %w", t.field, err)
+		}
+	}
+	return nil
+}
+
+// Render builds the message for a channel: the Telegram template for
+// ChannelTelegram, and the subject, text and HTML templates otherwise
+func (r *Renderer) Render(ch Channel, req *yapay.NotificationRequest) (*Message, error) {
+	set, ok := r.sets[req.Type]
+	if !ok {
+		set = r.fallback
+	}
+	data := r.data(req)
+	msg := &Message{Type: req.Type}
+
+	var err error
+	if ch == ChannelTelegram {
+		if msg.Text, err = execute(set.telegram, data); err != nil {
+			return nil, renderError(req, "telegram", err)
+		}
+		if data.Digest != nil && utf8.RuneCountInString(msg.Text) > TelegramMaxMessageLength {
+			if msg.Text, err = fitDigest(set.telegram, data, msg.Text); err != nil {
+				return nil, renderError(req, "telegram", err)
+			}
+		}
+		if set.parseMode != yapay.ParseModeText {
+			msg.ParseMode = set.parseMode
+		}
+		return msg, nil
+	}
+
+	if msg.Subject, err = execute(set.subject, data); err != nil {
+		return nil, renderError(req, "subject", err)
+	}
+	msg.Subject = strings.Join(strings.Fields(msg.Subject), " ")
+	if msg.Text, err = execute(set.text, data); err != nil {
+		return nil, renderError(req, "text", err)
+	}
+	if msg.HTML, err = execute(set.html, data); err != nil {
+		return nil, renderError(req, "html", err)
+	}
+	return msg, nil
+}
+
+// fitDigest renders a digest that exceeds the Telegram message limit with
+// fewer payments, counting the ones left out in Omitted, until it fits. If
+// it is still too long without payments, the failure reasons are dropped and,
+// as a last resort, the text is cut at a line boundary.
+func fitDigest(t executor, data *TemplateData, text string) (string, error) {
+	summary := *data.Digest
+	trimmed := *data
+	trimmed.Digest = &summary
+	var err error
+	for n := len(summary.Payments) - 1; n >= 0; n-- {
+		summary.Omitted = data.Digest.Omitted + len(data.Digest.Payments) - n
+		summary.Payments = data.Digest.Payments[:n]
+		if text, err = execute(t, &trimmed); err != nil {
+			return "", err
+		}
+		if utf8.RuneCountInString(text) <= TelegramMaxMessageLength {
+			return text, nil
+		}
+	}
+
+	if len(summary.FailureReasons) > 0 {
+		summary.FailureReasons = nil
+		if text, err = execute(t, &trimmed); err != nil {
+			return "", err
+		}
+		if utf8.RuneCountInString(text) <= TelegramMaxMessageLength {
+			return text, nil
+		}
+	}
+	return truncateLines(text, TelegramMaxMessageLength), nil
+}
+
+// truncateLines cuts text to at most limit runes, ending with an ellipsis.
+// It cuts after a whole line when there is one, so HTML tags opened on a
+// line stay closed.
+func truncateLines(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	cut := runes[:limit-1]
+	for i := len(cut) - 1; i > 0; i-- {
+		if cut[i] == '\n' {
+			cut = cut[:i+1]
+			break
+		}
+	}
+	return string(cut) + "…"
+}
+
+func renderError(req *yapay.NotificationRequest, field string, err error) *yapay.Error {
+	return yapay.WrapError(yapay.ErrorCodeInternal, err, fmt.Sprintf("failed to render %s notification %s", req.Type, field))
+}
+
+func execute(t executor, data *TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(buf.String(), "\n\n")), nil
+}
+
+// blankLines matches runs of empty lines left by optional template sections
+var blankLines = regexp.MustCompile(`\n([ \t]*\n){2,}`)
+
+// Notify renders req for every channel and sends it. A channel whose message
+// fails to render is reported like a delivery failure.
+func (n Notifiers) Notify(ctx context.Context, r *Renderer, req *yapay.NotificationRequest) error {
+	var errs []error
+	for _, ch := range n.Channels() {
+		msg, err := r.Render(ch, req)
+		if err == nil {
+			err = n[ch].Send(ctx, msg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ch, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Renderer) data(req *yapay.NotificationRequest) *TemplateData {
+	d := &TemplateData{
+		Type:      req.Type,
+		Merchant:  r.merchant,
+		ClientID:  req.ClientID,
+		PaymentID: req.PaymentID,
+		Message:   req.Message,
+		Data:      req.Data,
+		Now:       r.now().In(r.location),
+	}
+	if d.Data == nil {
+		d.Data = map[string]interface{}{}
+	}
+	d.Payment = paymentFromData(req.Data[DataKeyPayment])
+	if d.Payment != nil {
+		d.Metadata = d.Payment.Metadata
+		if d.PaymentID == "" {
+			d.PaymentID = d.Payment.ID
+		}
+	}
+	if reason, ok := req.Data[DataKeyReason]; ok && reason != nil {
+		d.Reason = formatValue(reason)
+	}
+	d.Digest = digestFromData(req.Data[DataKeyDigest])
+	return d
+}
+
+// digestFromData accepts a *DigestSummary or its JSON object form
+func digestFromData(v interface{}) *DigestSummary {
+	switch s := v.(type) {
+	case nil:
+		return nil
+	case *DigestSummary:
+		return s
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var s DigestSummary
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil
+	}
+	return &s
+}
+
+// paymentFromData accepts a *yapay.Payment or its JSON object form, as
+// found in requests restored from storage
+func paymentFromData(v interface{}) *yapay.Payment {
+	switch p := v.(type) {
+	case nil:
+		return nil
+	case *yapay.Payment:
+		return p
+	case yapay.Payment:
+		return &p
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var p yapay.Payment
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil
+	}
+	return &p
+}
+
+func (r *Renderer) funcs() texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		"title":   title,
+		"label":   r.label,
+		"fields":  r.fields,
+		"details": r.details,
+		"money":   formatMoney,
+		"date":    r.date,
+		"status":  statusTitle,
+		"get":     get,
+		"md":      escapeMarkdownV2,
+	}
+}
+
+func title(t yapay.NotificationType) string {
+	if s, ok := defaultTitles[t]; ok {
+		return s
+	}
+	return humanize(string(t))
+}
+
+// label returns the FieldLabels entry for key. Missing labels fall back to
+// the built-in label and then to the key itself, made readable.
+func (r *Renderer) label(key string) string {
+	if s := r.merchant.FieldLabels[key]; s != "" {
+		return s
+	}
+	if s, ok := defaultLabels[key]; ok {
+		return s
+	}
+	return humanize(key)
+}
+
+func (r *Renderer) fields(m interface{}) []Field {
+	var values map[string]interface{}
+	switch v := m.(type) {
+	case yapay.Metadata:
+		values = v
+	case map[string]interface{}:
+		values = v
+	default:
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fields := make([]Field, len(keys))
+	for i, key := range keys {
+		fields[i] = Field{Key: key, Label: r.label(key), Value: formatValue(values[key])}
+	}
+	return fields
+}
+
+// details lists the payment fields that are set, followed by the reason
+func (r *Renderer) details(d *TemplateData) []Field {
+	var fields []Field
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, Field{Key: key, Label: r.label(key), Value: value})
+		}
+	}
+	if p := d.Payment; p != nil {
+		add("order_id", p.OrderID)
+		add("payment_id", p.ID)
+		add("status", statusTitle(p.Status))
+		add("description", p.Description)
+		add("created_at", r.date(p.CreatedAt))
+		if p.RefundedAmount > 0 {
+			add("refunded", formatMoney(p.RefundedAmount, p.Currency))
+		}
+	} else {
+		add("payment_id", d.PaymentID)
+	}
+	add("reason", d.Reason)
+	return fields
+}
+
+// date formats a time.Time or RFC 3339 string; other strings are returned as is
+func (r *Renderer) date(v interface{}) string {
+	var t time.Time
+	switch value := v.(type) {
+	case time.Time:
+		t = value
+	case *time.Time:
+		if value == nil {
+			return ""
+		}
+		t = *value
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return value
+		}
+		t = parsed
+	default:
+		return formatValue(v)
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(r.location).Format(r.layout)
+}
+
+func statusTitle(s yapay.PaymentStatus) string {
+	if title, ok := statusTitles[s]; ok {
+		return title
+	}
+	return string(s)
+}
+
+// formatMoney formats minor units with grouped thousands: "1 234.50 RUB"
+func formatMoney(amount interface{}, currency string) string {
+	var minor int64
+	switch v := amount.(type) {
+	case int:
+		minor = int64(v)
+	case int64:
+		minor = v
+	case float64:
+		minor = int64(v)
+	case json.Number:
+		minor, _ = v.Int64()
+	case yapay.Money:
+		minor, currency = v.Minor, v.Currency
+	default:
+		return formatValue(amount)
+	}
+
+	m := yapay.NewMoney(minor, currency)
+	value, err := m.Decimal()
+	if err != nil {
+		return m.String()
+	}
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign, value = "-", value[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(value, ".")
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + " " + whole[i:]
+	}
+	if hasFrac {
+		whole += "." + frac
+	}
+	return sign + whole + " " + m.Currency
+}
+
+func get(m interface{}, key string) interface{} {
+	var value interface{}
+	switch v := m.(type) {
+	case yapay.Metadata:
+		value = v[key]
+	case map[string]interface{}:
+		value = v[key]
+	case yapay.FieldLabels:
+		value = v[key]
+	}
+	if value == nil {
+		return ""
+	}
+	return value
+}
+
+// formatValue renders metadata values: whole numbers without exponent,
+// booleans as да/нет and nested values as JSON
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		if value {
+			return "да"
+		}
+		return "нет"
+	case json.Number:
+		return value.String()
+	case fmt.Stringer:
+		return value.String()
+	case map[string]interface{}, []interface{}:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// humanize turns "product_id" into "Product id"
+func humanize(key string) string {
+	s := strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ", ".", " ").Replace(key))
+	if s == "" {
+		return key
+	}
+	first, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(first)) + s[size:]
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escapeMarkdownV2 escapes the characters reserved by Telegram MarkdownV2
+func escapeMarkdownV2(s interface{}) string {
+	return markdownV2Escaper.Replace(formatValue(s))
+}
@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMerchant() *yapay.Merchant {
+	return &yapay.Merchant{
+		ID:          "shop",
+		Name:        "Shop & Co",
+		FieldLabels: yapay.FieldLabels{"product_id": "ID товара"},
+	}
+}
+
+func testPayment() *yapay.Payment {
+	return &yapay.Payment{
+		ID:          "pay-1",
+		OrderID:     "order-1",
+		MerchantID:  "shop",
+		Amount:      123456,
+		Currency:    "RUB",
+		Description: "Курс <Go>",
+		Status:      yapay.PaymentStatusFailed,
+		CreatedAt:   "2024-05-01T10:00:00Z",
+		Metadata:    yapay.Metadata{"product_id": "sku-1", "user_email": "buyer@example.com", "gift": true},
+	}
+}
+
+func TestRenderer_BuiltinTemplates(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	r, err := NewRenderer(testMerchant(), WithLocation(moscow))
+	require.NoError(t, err)
+
+	req := NewPaymentNotification(yapay.NotificationTypePaymentFailed, testPayment(), "")
+	req.Data[DataKeyReason] = "card declined"
+
+	msg, err := r.Render(ChannelEmail, req)
+	require.NoError(t, err)
+	assert.Equal(t, yapay.NotificationTypePaymentFailed, msg.Type)
+	assert.Equal(t, "Платеж не прошел 1 234.56 RUB - Shop & Co", msg.Subject)
+	assert.Contains(t, msg.Text, "Заказ: order-1")
+	assert.Contains(t, msg.Text, "Статус: ошибка")
+	assert.Contains(t, msg.Text, "Создан: 01.05.2024 13:00")
+	assert.Contains(t, msg.Text, "Причина: card declined")
+	assert.Contains(t, msg.Text, "ID товара: sku-1")
+	// Missing labels fall back to the readable key
+	assert.Contains(t, msg.Text, "User email: buyer@example.com")
+	assert.Contains(t, msg.Text, "Gift: да")
+	assert.Contains(t, msg.HTML, "<td>Курс &lt;Go&gt;</td>")
+	assert.Contains(t, msg.HTML, "Shop &amp; Co")
+
+	tg, err := r.Render(ChannelTelegram, req)
+	require.NoError(t, err)
+	assert.Equal(t, yapay.ParseModeHTML, tg.ParseMode)
+	assert.Contains(t, tg.Text, "<b>Платеж не прошел</b> 1 234.56 RUB")
+	assert.Contains(t, tg.Text, "Описание: Курс &lt;Go&gt;")
+	assert.Contains(t, tg.Text, "ID товара: <code>sku-1</code>")
+	assert.Empty(t, tg.Subject)
+}
+
+func TestRenderer_RequestWithoutPayment(t *testing.T) {
+	r, err := NewRenderer(testMerchant())
+	require.NoError(t, err)
+
+	msg, err := r.Render(ChannelEmail, &yapay.NotificationRequest{
+		Type:    yapay.NotificationTypeSystemError,
+		Message: "database is unreachable",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Системная ошибка - Shop & Co", msg.Subject)
+	assert.Equal(t, "Системная ошибка\n\ndatabase is unreachable\n\nShop & Co", msg.Text)
+
+	// Unknown types use the built-in templates
+	msg, err = r.Render(ChannelEmail, &yapay.NotificationRequest{Type: "refund_issued", PaymentID: "pay-9"})
+	require.NoError(t, err)
+	assert.Contains(t, msg.Subject, "Refund issued")
+	assert.Contains(t, msg.Text, "Платеж: pay-9")
+}
+
+func TestRenderer_PaymentRestoredFromJSON(t *testing.T) {
+	r, err := NewRenderer(testMerchant())
+	require.NoError(t, err)
+
+	data, err := json.Marshal(NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), "ok"))
+	require.NoError(t, err)
+	var req yapay.NotificationRequest
+	require.NoError(t, json.Unmarshal(data, &req))
+
+	msg, err := r.Render(ChannelEmail, &req)
+	require.NoError(t, err)
+	assert.Contains(t, msg.Subject, "1 234.56 RUB")
+	assert.Contains(t, msg.Text, "ID товара: sku-1")
+}
+
+func TestRenderer_Overrides(t *testing.T) {
+	merchant := testMerchant()
+	merchant.Notifications.Templates = map[yapay.NotificationType]yapay.NotificationTemplate{
+		yapay.NotificationTypePaymentSuccess: {
+			Telegram:  `*Оплата* {{md (money .Payment.Amount .Payment.Currency)}} от {{md (get .Metadata "user_email")}}{{md (get .Metadata "missing")}}`,
+			ParseMode: yapay.ParseModeMarkdownV2,
+		},
+		yapay.NotificationTypePaymentCreated: {
+			Subject: "Config subject {{.PaymentID}}",
+		},
+	}
+	plugin := map[yapay.NotificationType]yapay.NotificationTemplate{
+		yapay.NotificationTypePaymentCreated: {Subject: "Plugin subject", Text: "Plugin text {{label \"product_id\"}}"},
+	}
+
+	r, err := NewRenderer(merchant, WithTemplates(plugin))
+	require.NoError(t, err)
+
+	tg, err := r.Render(ChannelTelegram, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), ""))
+	require.NoError(t, err)
+	assert.Equal(t, yapay.ParseModeMarkdownV2, tg.ParseMode)
+	assert.Equal(t, `*Оплата* 1 234\.56 RUB от buyer@example\.com`, tg.Text)
+
+	msg, err := r.Render(ChannelEmail, NewPaymentNotification(yapay.NotificationTypePaymentCreated, testPayment(), ""))
+	require.NoError(t, err)
+	assert.Equal(t, "Config subject pay-1", msg.Subject)
+	assert.Equal(t, "Plugin text ID товара", msg.Text)
+	assert.Contains(t, msg.HTML, "<!DOCTYPE html>")
+
+	// Types without overrides keep the built-in HTML Telegram template
+	tg, err = r.Render(ChannelTelegram, NewPaymentNotification(yapay.NotificationTypePaymentFailed, testPayment(), ""))
+	require.NoError(t, err)
+	assert.Equal(t, yapay.ParseModeHTML, tg.ParseMode)
+}
+
+func TestNewRenderer_InvalidTemplates(t *testing.T) {
+	for name, tmpl := range map[string]yapay.NotificationTemplate{
+		"syntax":        {Text: "{{.Payment.Amount"},
+		"unknown field": {Subject: "{{.Payment.Price}}"},
+		"unknown func":  {HTML: "<p>{{rub .Payment.Amount}}</p>"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			merchant := testMerchant()
+			merchant.Notifications.Templates = map[yapay.NotificationType]yapay.NotificationTemplate{
+				yapay.NotificationTypeWebhook: tmpl,
+			}
+			_, err := NewRenderer(merchant)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, yapay.ErrValidation))
+			var yerr *yapay.Error
+			require.True(t, errors.As(err, &yerr))
+			assert.Contains(t, yerr.Details, "notifications.templates.webhook")
+		})
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	assert.Equal(t, "0.05 RUB", formatMoney(5, "RUB"))
+	assert.Equal(t, "-1 000 000.00 RUB", formatMoney(int64(-100000000), "rub"))
+	assert.Equal(t, "1 500 JPY", formatMoney(float64(1500), "JPY"))
+	assert.Equal(t, "100 XXX", formatMoney(100, "XXX"))
+
+	assert.Equal(t, "Product id", humanize("product_id"))
+	assert.Equal(t, "Город", humanize("город"))
+	assert.Equal(t, `a\_b\.c\!`, escapeMarkdownV2("a_b.c!"))
+	assert.Equal(t, "12345", formatValue(float64(12345)))
+	assert.Equal(t, `{"a":1}`, formatValue(map[string]interface{}{"a": 1}))
+}
+
+func TestNotifiers_Notify(t *testing.T) {
+	fake := newFakeTelegram(t)
+	merchant := testMerchant()
+	merchant.Notifications.Telegram = yapay.TelegramConfig{Enabled: true, ChatID: "1", BotToken: testBotToken, APIBaseURL: fake.URL}
+
+	notifiers, err := FromMerchant(merchant)
+	require.NoError(t, err)
+	r, err := NewRenderer(merchant)
+	require.NoError(t, err)
+
+	err = notifiers.Notify(context.Background(), r, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), ""))
+	require.NoError(t, err)
+	require.Len(t, fake.messages, 1)
+	assert.Equal(t, "HTML", fake.messages[0]["parse_mode"])
+	assert.Contains(t, fake.messages[0]["text"], "<b>Платеж получен</b>")
+}
+
+func TestRenderer_DigestTruncatedAsLastResort(t *testing.T) {
+	merchant := testMerchant()
+	merchant.Notifications.Templates = map[yapay.NotificationType]yapay.NotificationTemplate{
+		yapay.NotificationTypeDigest: {Telegram: strings.Repeat("<b>Строка</b> сводки\n", 300)},
+	}
+	r, err := NewRenderer(merchant)
+	require.NoError(t, err)
+
+	req := &yapay.NotificationRequest{
+		Type:     yapay.NotificationTypeDigest,
+		ClientID: merchant.ID,
+		Data:     map[string]interface{}{DataKeyDigest: &DigestSummary{Window: yapay.DigestDaily}},
+	}
+	msg, err := r.Render(ChannelTelegram, req)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, utf8.RuneCountInString(msg.Text), TelegramMaxMessageLength)
+	assert.True(t, strings.HasSuffix(msg.Text, "</b> сводки\n…"), "cut after a whole line")
+}
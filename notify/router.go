@@ -0,0 +1,312 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// Route is one delivery of a notification decided by a routing rule
+type Route struct {
+	// Rule is the rule name, or its position such as "rules[0]"
+	Rule    string
+	Channel Channel
+	// Recipients override the channel's configured recipients
+	Recipients []string
+	// NotBefore is the end of the rule's quiet hours; zero sends at once
+	NotBefore time.Time
+	// Digest is yapay.DigestHourly or yapay.DigestDaily when the
+	// notification goes into a digest instead of being sent by itself
+	Digest string
+}
+
+// Router applies the routing rules of a merchant config
+// (notifications.rules). Without rules every notification is routed to all
+// channels. A Router is safe for concurrent use.
+type Router struct {
+	rules  []*rule
+	logger logrus.FieldLogger
+}
+
+// rule is a parsed yapay.NotificationRule with its rate limit state
+type rule struct {
+	config yapay.NotificationRule
+	name   string
+	types  map[yapay.NotificationType]bool
+
+	quiet      bool
+	quietStart time.Duration
+	quietEnd   time.Duration
+	location   *time.Location
+
+	period time.Duration
+	mu     sync.Mutex
+	passed []time.Time
+}
+
+// RouterOption configures a Router
+type RouterOption func(*Router)
+
+// WithRouterLogger sets the logger for dropped notifications
+func WithRouterLogger(logger logrus.FieldLogger) RouterOption {
+	return func(r *Router) {
+		r.logger = logger
+	}
+}
+
+// NewRouter parses the merchant's routing rules
+func NewRouter(m *yapay.Merchant, opts ...RouterOption) (*Router, error) {
+	if m == nil {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "merchant is required")
+	}
+	r := &Router{logger: logrus.StandardLogger()}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	var invalid *yapay.Error
+	fail := func(path, format string, args ...interface{}) {
+		if invalid == nil {
+			invalid = yapay.NewError(yapay.ErrorCodeValidation, "invalid notification rule")
+		}
+		invalid.WithDetail(path, fmt.Sprintf(format, args...))
+	}
+	for i, cfg := range m.Notifications.Rules {
+		path := fmt.Sprintf("notifications.rules[%d]", i)
+		rl := &rule{config: cfg, name: cfg.Name, types: make(map[yapay.NotificationType]bool)}
+		if rl.name == "" {
+			rl.name = fmt.Sprintf("rules[%d]", i)
+		}
+		for _, typ := range cfg.Types {
+			rl.types[typ] = true
+		}
+
+		if q := cfg.QuietHours; !q.IsZero() {
+			start, err := parseClock(q.Start)
+			if err != nil {
+				fail(path+".quiet_hours.start", "must be a time such as 22:00, got %q", q.Start)
+			}
+			end, err := parseClock(q.End)
+			if err != nil {
+				fail(path+".quiet_hours.end", "must be a time such as 08:00, got %q", q.End)
+			}
+			loc, err := time.LoadLocation(q.Timezone)
+			if err != nil {
+				fail(path+".quiet_hours.timezone", "unknown time zone %q", q.Timezone)
+			}
+			rl.quiet = start != end
+			rl.quietStart, rl.quietEnd, rl.location = start, end, loc
+		}
+
+		if cfg.RateLimit.Max > 0 {
+			period := cfg.RateLimit.Period
+			if period == "" {
+				period = yapay.DefaultNotificationRatePeriod
+			}
+			d, err := time.ParseDuration(period)
+			if err != nil || d <= 0 {
+				fail(path+".rate_limit.period", "must be a positive duration such as 10m or 1h, got %q", period)
+			}
+			rl.period = d
+		}
+		r.rules = append(r.rules, rl)
+	}
+	if invalid != nil {
+		return nil, invalid
+	}
+	return r, nil
+}
+
+// parseClock parses "HH:MM" as the time since midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Route returns the deliveries of req over the available channels at now.
+// Every matching rule contributes its routes; an empty result means the
+// notification is dropped. Matching a rule counts against its rate limit.
+func (r *Router) Route(req *yapay.NotificationRequest, channels []Channel, now time.Time) []Route {
+	var routes []Route
+	for _, g := range r.route(req, channels, now) {
+		routes = append(routes, g.routes...)
+	}
+	return routes
+}
+
+// ruleRoutes are the routes of one rule; rule is nil without rules
+type ruleRoutes struct {
+	rule   *rule
+	routes []Route
+}
+
+// route returns the routes of req grouped by rule, so the outbox can return
+// the rate limit budget of a rule whose routes queued nothing
+func (r *Router) route(req *yapay.NotificationRequest, channels []Channel, now time.Time) []ruleRoutes {
+	if r == nil || len(r.rules) == 0 {
+		routes := make([]Route, len(channels))
+		for i, ch := range channels {
+			routes[i] = Route{Channel: ch}
+		}
+		return []ruleRoutes{{routes: routes}}
+	}
+
+	var groups []ruleRoutes
+	for _, rl := range r.rules {
+		if !rl.matches(req) {
+			continue
+		}
+		if !rl.allow(now) {
+			r.logger.WithFields(logrus.Fields{
+				"rule":       rl.name,
+				"type":       req.Type,
+				"payment_id": req.PaymentID,
+			}).Warn("Notification dropped by rule rate limit")
+			continue
+		}
+		notBefore := rl.quietUntil(now)
+		g := ruleRoutes{rule: rl}
+		for _, ch := range channels {
+			if !rl.sendsTo(ch) {
+				continue
+			}
+			route := Route{
+				Rule:       rl.name,
+				Channel:    ch,
+				Recipients: rl.recipients(ch),
+				Digest:     rl.config.Digest,
+			}
+			if route.Digest == "" {
+				route.NotBefore = notBefore
+			}
+			g.routes = append(g.routes, route)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// matches checks the type and amount conditions of the rule
+func (rl *rule) matches(req *yapay.NotificationRequest) bool {
+	if len(rl.types) > 0 && !rl.types[req.Type] {
+		return false
+	}
+	if rl.config.MinAmount == "" && rl.config.MaxAmount == "" {
+		return true
+	}
+
+	payment := paymentFromData(req.Data[DataKeyPayment])
+	if payment == nil {
+		return false
+	}
+	amount := int64(payment.Amount)
+	for i, value := range []string{rl.config.MinAmount, rl.config.MaxAmount} {
+		if value == "" {
+			continue
+		}
+		// A threshold that does not fit the payment currency, such as
+		// 10.50 for JPY, does not match
+		bound, err := yapay.ParseMoney(value, payment.Currency)
+		if err != nil || (i == 0 && amount < bound.Minor) || (i == 1 && amount > bound.Minor) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rl *rule) sendsTo(ch Channel) bool {
+	if len(rl.config.Channels) == 0 {
+		return true
+	}
+	for _, c := range rl.config.Channels {
+		if Channel(c) == ch {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *rule) recipients(ch Channel) []string {
+	switch ch {
+	case ChannelTelegram:
+		return rl.config.Recipients.Telegram
+	case ChannelEmail:
+		return rl.config.Recipients.Email
+	}
+	return nil
+}
+
+// allow records a notification against the rate limit, or reports false if
+// the limit is reached
+func (rl *rule) allow(now time.Time) bool {
+	if rl.config.RateLimit.Max <= 0 {
+		return true
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := now.Add(-rl.period)
+	kept := rl.passed[:0]
+	for _, t := range rl.passed {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	rl.passed = kept
+	if len(rl.passed) >= rl.config.RateLimit.Max {
+		return false
+	}
+	rl.passed = append(rl.passed, now)
+	return true
+}
+
+// release takes back a notification recorded by allow at now, e.g. a
+// duplicate that was not queued
+func (rl *rule) release(now time.Time) {
+	if rl == nil || rl.config.RateLimit.Max <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for i := len(rl.passed) - 1; i >= 0; i-- {
+		if rl.passed[i].Equal(now) {
+			rl.passed = append(rl.passed[:i], rl.passed[i+1:]...)
+			return
+		}
+	}
+}
+
+// quietUntil returns the end of the quiet hours containing now, or zero
+func (rl *rule) quietUntil(now time.Time) time.Time {
+	if !rl.quiet {
+		return time.Time{}
+	}
+	local := now.In(rl.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, rl.location)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second
+	at := func(day time.Time, d time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, rl.location)
+	}
+
+	if rl.quietStart < rl.quietEnd {
+		if sinceMidnight >= rl.quietStart && sinceMidnight < rl.quietEnd {
+			return at(midnight, rl.quietEnd)
+		}
+		return time.Time{}
+	}
+	// The interval crosses midnight, e.g. 22:00-08:00
+	switch {
+	case sinceMidnight < rl.quietEnd:
+		return at(midnight, rl.quietEnd)
+	case sinceMidnight >= rl.quietStart:
+		return at(midnight.AddDate(0, 0, 1), rl.quietEnd)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var bothChannels = []Channel{ChannelEmail, ChannelTelegram}
+
+func newTestRouter(t *testing.T, rules ...yapay.NotificationRule) *Router {
+	merchant := testMerchant()
+	merchant.Notifications.Rules = rules
+	r, err := NewRouter(merchant)
+	require.NoError(t, err)
+	return r
+}
+
+func paymentNotification(typ yapay.NotificationType, amount int, currency string) *yapay.NotificationRequest {
+	payment := testPayment()
+	payment.Amount = amount
+	payment.Currency = currency
+	return NewPaymentNotification(typ, payment, "")
+}
+
+func TestRouter_WithoutRules(t *testing.T) {
+	routes := newTestRouter(t).Route(&yapay.NotificationRequest{Type: yapay.NotificationTypeWebhook}, bothChannels, time.Now())
+	assert.Equal(t, []Route{{Channel: ChannelEmail}, {Channel: ChannelTelegram}}, routes)
+
+	var nilRouter *Router
+	assert.Len(t, nilRouter.Route(&yapay.NotificationRequest{}, bothChannels, time.Now()), 2)
+}
+
+func TestRouter_TypesChannelsAndRecipients(t *testing.T) {
+	r := newTestRouter(t,
+		yapay.NotificationRule{
+			Name:       "ops",
+			Types:      []yapay.NotificationType{yapay.NotificationTypeSystemError},
+			Channels:   []string{yapay.NotificationChannelTelegram},
+			Recipients: yapay.NotificationRecipients{Telegram: []string{"-100200"}},
+		},
+		yapay.NotificationRule{
+			Types:  []yapay.NotificationType{yapay.NotificationTypePaymentCreated},
+			Digest: yapay.DigestDaily,
+		},
+	)
+	now := time.Now()
+
+	routes := r.Route(&yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError}, bothChannels, now)
+	assert.Equal(t, []Route{{Rule: "ops", Channel: ChannelTelegram, Recipients: []string{"-100200"}}}, routes)
+
+	routes = r.Route(&yapay.NotificationRequest{Type: yapay.NotificationTypePaymentCreated}, bothChannels, now)
+	require.Len(t, routes, 2)
+	assert.Equal(t, "rules[1]", routes[0].Rule)
+	assert.Equal(t, yapay.DigestDaily, routes[0].Digest)
+
+	// Matches no rule
+	assert.Empty(t, r.Route(&yapay.NotificationRequest{Type: yapay.NotificationTypeWebhook}, bothChannels, now))
+	// A rule channel that is not available is skipped
+	assert.Empty(t, r.Route(&yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError}, []Channel{ChannelEmail}, now))
+}
+
+func TestRouter_AmountThresholds(t *testing.T) {
+	r := newTestRouter(t, yapay.NotificationRule{MinAmount: "1000", MaxAmount: "5000.50"})
+	now := time.Now()
+
+	for _, tc := range []struct {
+		amount   int
+		currency string
+		matches  bool
+	}{
+		{99999, "RUB", false},
+		{100000, "RUB", true},
+		{500050, "RUB", true},
+		{500051, "RUB", false},
+		{1000, "JPY", false}, // 5000.50 does not fit JPY
+	} {
+		routes := r.Route(paymentNotification(yapay.NotificationTypePaymentSuccess, tc.amount, tc.currency), bothChannels, now)
+		assert.Equal(t, tc.matches, len(routes) > 0, "%d %s", tc.amount, tc.currency)
+	}
+
+	// Requests without a payment do not match amount rules
+	assert.Empty(t, r.Route(&yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError}, bothChannels, now))
+}
+
+func TestRouter_QuietHours(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	night := newTestRouter(t, yapay.NotificationRule{
+		QuietHours: yapay.QuietHours{Start: "22:00", End: "08:00", Timezone: "Europe/Moscow"},
+	})
+	lunch := newTestRouter(t, yapay.NotificationRule{
+		QuietHours: yapay.QuietHours{Start: "13:00", End: "14:30"},
+	})
+	req := &yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError}
+
+	for _, tc := range []struct {
+		router *Router
+		now    time.Time
+		want   time.Time
+	}{
+		{night, time.Date(2024, 5, 1, 23, 15, 0, 0, moscow), time.Date(2024, 5, 2, 8, 0, 0, 0, moscow)},
+		{night, time.Date(2024, 5, 2, 7, 59, 0, 0, moscow), time.Date(2024, 5, 2, 8, 0, 0, 0, moscow)},
+		{night, time.Date(2024, 5, 2, 8, 0, 0, 0, moscow), time.Time{}},
+		// 19:30 UTC is 22:30 in Moscow
+		{night, time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC), time.Date(2024, 5, 2, 8, 0, 0, 0, moscow)},
+		{lunch, time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC)},
+		{lunch, time.Date(2024, 5, 1, 12, 59, 0, 0, time.UTC), time.Time{}},
+		{lunch, time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC), time.Time{}},
+	} {
+		routes := tc.router.Route(req, []Channel{ChannelTelegram}, tc.now)
+		require.Len(t, routes, 1)
+		assert.True(t, tc.want.Equal(routes[0].NotBefore), "at %s got %s", tc.now, routes[0].NotBefore)
+	}
+}
+
+func TestRouter_RateLimit(t *testing.T) {
+	r := newTestRouter(t, yapay.NotificationRule{RateLimit: yapay.NotificationRateLimit{Max: 2, Period: "10m"}})
+	req := &yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Len(t, r.Route(req, bothChannels, start), 2)
+	assert.Len(t, r.Route(req, bothChannels, start.Add(time.Minute)), 2)
+	assert.Empty(t, r.Route(req, bothChannels, start.Add(2*time.Minute)))
+	assert.Len(t, r.Route(req, bothChannels, start.Add(10*time.Minute+time.Second)), 2)
+}
+
+func TestNewRouter_InvalidRules(t *testing.T) {
+	merchant := testMerchant()
+	merchant.Notifications.Rules = []yapay.NotificationRule{
+		{QuietHours: yapay.QuietHours{Start: "22", End: "08:00", Timezone: "Mars/Olympus"}},
+		{RateLimit: yapay.NotificationRateLimit{Max: 1, Period: "-1h"}},
+	}
+	_, err := NewRouter(merchant)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+	var yerr *yapay.Error
+	require.True(t, errors.As(err, &yerr))
+	assert.Contains(t, yerr.Details, "notifications.rules[0].quiet_hours.start")
+	assert.Contains(t, yerr.Details, "notifications.rules[0].quiet_hours.timezone")
+	assert.Contains(t, yerr.Details, "notifications.rules[1].rate_limit.period")
+}
+
+// fakeDigest records the requests routed to a digest
+type fakeDigest struct {
+	routes []Route
+}
+
+func (d *fakeDigest) Collect(_ context.Context, route Route, _ *yapay.NotificationRequest) error {
+	d.routes = append(d.routes, route)
+	return nil
+}
+
+func TestOutbox_Routing(t *testing.T) {
+	merchant := testMerchant()
+	merchant.Notifications.Rules = []yapay.NotificationRule{
+		{
+			Name:       "ops",
+			Types:      []yapay.NotificationType{yapay.NotificationTypeSystemError},
+			Channels:   []string{yapay.NotificationChannelTelegram},
+			Recipients: yapay.NotificationRecipients{Telegram: []string{"-100200"}},
+		},
+		{
+			Types:  []yapay.NotificationType{yapay.NotificationTypePaymentCreated},
+			Digest: yapay.DigestDaily,
+		},
+		{
+			Types:      []yapay.NotificationType{yapay.NotificationTypePaymentSuccess},
+			Channels:   []string{yapay.NotificationChannelEmail},
+			QuietHours: yapay.QuietHours{Start: "10:00", End: "13:00"},
+		},
+	}
+	router, err := NewRouter(merchant)
+	require.NoError(t, err)
+
+	tg, email, digest := &fakeNotifier{}, &fakeNotifier{}, &fakeDigest{}
+	o, clock := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: tg, ChannelEmail: email},
+		WithRouter(router), WithDigestSink(digest))
+	ctx := context.Background()
+
+	queued, err := o.Enqueue(ctx, &yapay.NotificationRequest{Type: yapay.NotificationTypeSystemError, Message: "boom"})
+	require.NoError(t, err)
+	assert.True(t, queued)
+	queued, err = o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentCreated, testPayment(), ""))
+	require.NoError(t, err)
+	assert.True(t, queued)
+	assert.Len(t, digest.routes, 2)
+	queued, err = o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, testPayment(), ""))
+	require.NoError(t, err)
+	assert.True(t, queued)
+	queued, err = o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypeWebhook, testPayment(), ""))
+	require.NoError(t, err)
+	assert.False(t, queued, "no rule matches webhooks")
+
+	// 12:00 is in quiet hours: only the ops message goes out
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, tg.count())
+	assert.Equal(t, []string{"-100200"}, tg.sent[0].Recipients)
+	assert.Zero(t, email.count())
+
+	clock.advance(time.Hour)
+	_, err = o.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, email.count())
+}
+
+func TestOutbox_RateLimitCountsQueuedOnly(t *testing.T) {
+	router := newTestRouter(t, yapay.NotificationRule{RateLimit: yapay.NotificationRateLimit{Max: 2, Period: "1h"}})
+	o, _ := newTestOutbox(t, NewMemoryStore(), Notifiers{ChannelTelegram: &fakeNotifier{}}, WithRouter(router))
+	ctx := context.Background()
+
+	enqueue := func(paymentID string) bool {
+		payment := testPayment()
+		payment.ID = paymentID
+		queued, err := o.Enqueue(ctx, NewPaymentNotification(yapay.NotificationTypePaymentSuccess, payment, ""))
+		require.NoError(t, err)
+		return queued
+	}
+
+	assert.True(t, enqueue("p1"))
+	// Redeliveries of p1 are duplicates and leave the budget alone
+	assert.False(t, enqueue("p1"))
+	assert.False(t, enqueue("p1"))
+	assert.True(t, enqueue("p2"))
+	assert.False(t, enqueue("p3"), "the limit of 2 is reached")
+}
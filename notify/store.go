@@ -0,0 +1,410 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// ErrDuplicate is returned by Store.Add for an item ID that is already
+// stored, and by DigestSink.Collect for a request already collected
+var ErrDuplicate = errors.New("notification already queued")
+
+// ErrItemNotFound is returned for unknown outbox item IDs
+var ErrItemNotFound = errors.New("outbox item not found")
+
+// OutboxStatus is the delivery state of an outbox item
+type OutboxStatus string
+
+// Outbox item statuses
+const (
+	OutboxPending   OutboxStatus = "pending"
+	OutboxDelivered OutboxStatus = "delivered"
+	OutboxDead      OutboxStatus = "dead"
+)
+
+// OutboxItem is a notification request queued for one channel
+type OutboxItem struct {
+	ID      string                    `json:"id"`
+	Channel Channel                   `json:"channel"`
+	Request yapay.NotificationRequest `json:"request"`
+	// Recipients override the channel's configured recipients
+	Recipients []string `json:"recipients,omitempty"`
+	// Delivered are the recipients that received the message on an attempt
+	// that failed for others; retries are sent only to Recipients
+	Delivered     []string     `json:"delivered,omitempty"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"last_error,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+}
+
+// Store persists outbox items. Implementations must be safe for concurrent
+// use and must not retain the items passed to them.
+type Store interface {
+	// Add stores a new item, or returns ErrDuplicate if its ID exists
+	Add(ctx context.Context, item *OutboxItem) error
+	// Update replaces a stored item, or returns ErrItemNotFound
+	Update(ctx context.Context, item *OutboxItem) error
+	// Get returns a stored item, or ErrItemNotFound
+	Get(ctx context.Context, id string) (*OutboxItem, error)
+	// List returns the items with the given statuses (all items if none are
+	// given) ordered by creation time
+	List(ctx context.Context, statuses ...OutboxStatus) ([]*OutboxItem, error)
+	// Delete removes items; unknown IDs are ignored
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// MemoryStore keeps outbox items in memory. Items do not survive a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string][]byte)}
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(_ context.Context, item *OutboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return addItem(s.items, item)
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(_ context.Context, item *OutboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateItem(s.items, item)
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id string) (*OutboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return getItem(s.items, id)
+}
+
+// List implements Store
+func (s *MemoryStore) List(_ context.Context, statuses ...OutboxStatus) ([]*OutboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listItems(s.items, statuses)
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.items, id)
+	}
+	return nil
+}
+
+// FileStore keeps outbox items in a JSON file that is rewritten atomically
+// on every change. It suits the low volume of merchant notifications; use
+// one file per merchant.
+type FileStore struct {
+	path  string
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+// NewFileStore opens the store at path, creating its directory if needed
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, items: make(map[string][]byte)}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid outbox file %s: %w", path, err)
+	}
+	for _, raw := range items {
+		var item OutboxItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("invalid outbox file %s: %w", path, err)
+		}
+		s.items[item.ID] = raw
+	}
+	return s, nil
+}
+
+// Add implements Store
+func (s *FileStore) Add(_ context.Context, item *OutboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := addItem(s.items, item); err != nil {
+		return err
+	}
+	return s.save(func() { delete(s.items, item.ID) })
+}
+
+// Update implements Store
+func (s *FileStore) Update(_ context.Context, item *OutboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.items[item.ID]
+	if err := updateItem(s.items, item); err != nil {
+		return err
+	}
+	return s.save(func() {
+		if ok {
+			s.items[item.ID] = old
+		}
+	})
+}
+
+// Get implements Store
+func (s *FileStore) Get(_ context.Context, id string) (*OutboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return getItem(s.items, id)
+}
+
+// List implements Store
+func (s *FileStore) List(_ context.Context, statuses ...OutboxStatus) ([]*OutboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listItems(s.items, statuses)
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := make(map[string][]byte)
+	for _, id := range ids {
+		if raw, ok := s.items[id]; ok {
+			removed[id] = raw
+			delete(s.items, id)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return s.save(func() {
+		for id, raw := range removed {
+			s.items[id] = raw
+		}
+	})
+}
+
+// save writes all items to a temporary file and renames it over the store
+// file. On failure rollback restores the in-memory state.
+func (s *FileStore) save(rollback func()) error {
+	items, _ := listItems(s.items, nil)
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		rollback()
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Items are stored as JSON, so callers never share state with the store and
+// both stores return requests in the form they have after a restart.
+
+func addItem(items map[string][]byte, item *OutboxItem) error {
+	if _, ok := items[item.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicate, item.ID)
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox item: %w", err)
+	}
+	items[item.ID] = data
+	return nil
+}
+
+func updateItem(items map[string][]byte, item *OutboxItem) error {
+	if _, ok := items[item.ID]; !ok {
+		return fmt.Errorf("%w: %s", ErrItemNotFound, item.ID)
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox item: %w", err)
+	}
+	items[item.ID] = data
+	return nil
+}
+
+func getItem(items map[string][]byte, id string) (*OutboxItem, error) {
+	raw, ok := items[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+	var item OutboxItem
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox item: %w", err)
+	}
+	return &item, nil
+}
+
+func listItems(items map[string][]byte, statuses []OutboxStatus) ([]*OutboxItem, error) {
+	list := make([]*OutboxItem, 0, len(items))
+	for id := range items {
+		item, err := getItem(items, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(statuses) == 0 || containsStatus(statuses, item.Status) {
+			list = append(list, item)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func containsStatus(statuses []OutboxStatus, s OutboxStatus) bool {
+	for _, status := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DigestStore persists the aggregation state of digests. Digest saves all
+// buckets after every change, which suits the few open windows a merchant
+// has at a time.
+type DigestStore interface {
+	// Load returns the saved buckets, or none if nothing was saved yet
+	Load(ctx context.Context) ([]*DigestBucket, error)
+	// Save replaces the saved buckets
+	Save(ctx context.Context, buckets []*DigestBucket) error
+}
+
+// MemoryDigestStore keeps digest state in memory. It does not survive a restart.
+type MemoryDigestStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewMemoryDigestStore creates an empty in-memory digest store
+func NewMemoryDigestStore() *MemoryDigestStore {
+	return &MemoryDigestStore{}
+}
+
+// Load implements DigestStore
+func (s *MemoryDigestStore) Load(_ context.Context) ([]*DigestBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeBuckets(s.data, "memory")
+}
+
+// Save implements DigestStore
+func (s *MemoryDigestStore) Save(_ context.Context, buckets []*DigestBucket) error {
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return fmt.Errorf("failed to encode digest state: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	return nil
+}
+
+// FileDigestStore keeps digest state in a JSON file that is rewritten
+// atomically on every change; use one file per merchant
+type FileDigestStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileDigestStore opens the store at path, creating its directory if needed
+func NewFileDigestStore(path string) (*FileDigestStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create digest directory: %w", err)
+	}
+	return &FileDigestStore{path: path}, nil
+}
+
+// Load implements DigestStore
+func (s *FileDigestStore) Load(_ context.Context) ([]*DigestBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read digest state: %w", err)
+	}
+	return decodeBuckets(data, s.path)
+}
+
+// Save implements DigestStore
+func (s *FileDigestStore) Save(_ context.Context, buckets []*DigestBucket) error {
+	data, err := json.MarshalIndent(buckets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode digest state: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write digest state: %w", err)
+	}
+	return nil
+}
+
+func decodeBuckets(data []byte, source string) ([]*DigestBucket, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var buckets []*DigestBucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, fmt.Errorf("invalid digest state %s: %w", source, err)
+	}
+	return buckets, nil
+}
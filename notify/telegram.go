@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// TelegramAPIBaseURL is the Bot API URL used when TelegramConfig.APIBaseURL is empty
+const TelegramAPIBaseURL = "https://api.telegram.org"
+
+// TelegramMaxMessageLength is the Bot API limit for message text in characters
+const TelegramMaxMessageLength = 4096
+
+const maxTelegramResponseSize = 1 << 20
+
+// Telegram sends messages through the Telegram Bot API. It is safe for
+// concurrent use.
+type Telegram struct {
+	baseURL string
+	token   yapay.Secret
+	chatIDs []string
+	opts    *options
+}
+
+// NewTelegram creates a Telegram notifier from the merchant config
+func NewTelegram(cfg yapay.TelegramConfig, opts ...Option) (*Telegram, error) {
+	if cfg.BotToken == "" {
+		return nil, yapay.NewError(yapay.ErrorCodeValidation, "telegram bot token is required").
+			WithDetail("notifications.telegram.bot_token", "is required")
+	}
+	baseURL := cfg.APIBaseURL
+	if baseURL == "" {
+		baseURL = TelegramAPIBaseURL
+	}
+
+	t := &Telegram{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   cfg.BotToken,
+		opts:    newOptions(opts),
+	}
+	if cfg.ChatID != "" {
+		t.chatIDs = []string{cfg.ChatID}
+	}
+	return t, nil
+}
+
+// telegramResponse is the Bot API response envelope
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Send posts the message to every chat: msg.Recipients or the configured chat
+// ID. If a chat fails, the error wraps a *DeliveryError with the outcome of
+// every chat; it is retryable only if every failed chat failed transiently.
+func (t *Telegram) Send(ctx context.Context, msg *Message) error {
+	chatIDs := t.chatIDs
+	if len(msg.Recipients) > 0 {
+		chatIDs = msg.Recipients
+	}
+	if len(chatIDs) == 0 {
+		return yapay.NewError(yapay.ErrorCodeValidation, "telegram chat ID is required").
+			WithDetail("notifications.telegram.chat_id", "is required")
+	}
+	if n := utf8.RuneCountInString(msg.Text); n > TelegramMaxMessageLength {
+		return yapay.Errorf(yapay.ErrorCodeValidation, "telegram message has %d characters, the limit is %d", n, TelegramMaxMessageLength)
+	}
+
+	// Every chat is delivered separately, so one failing chat does not hide
+	// the outcome of the others
+	delivery := &DeliveryError{}
+	for _, chatID := range chatIDs {
+		if err := t.send(ctx, chatID, msg); err != nil {
+			delivery.Failed = append(delivery.Failed, &RecipientError{Recipient: chatID, Err: err})
+			continue
+		}
+		delivery.Delivered = append(delivery.Delivered, chatID)
+	}
+	if len(delivery.Failed) == 0 {
+		return nil
+	}
+	return yapay.WrapError(delivery.code(), delivery, "telegram delivery failed")
+}
+
+func (t *Telegram) send(ctx context.Context, chatID string, msg *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, t.opts.timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     msg.Text,
+		"parse_mode":               msg.ParseMode,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeInternal, err, "failed to encode telegram request")
+	}
+
+	endpoint := t.baseURL + "/bot" + t.token.Reveal() + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeInternal, t.redact(err), "failed to build telegram request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.opts.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return yapay.AsError(ctxErr)
+		}
+		return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, t.redact(err), "telegram request failed")
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTelegramResponseSize))
+	if err != nil {
+		return yapay.WrapError(yapay.ErrorCodeBackendUnavailable, err, "failed to read telegram response")
+	}
+	var body telegramResponse
+	decodeErr := json.Unmarshal(data, &body)
+	if resp.StatusCode == http.StatusOK && decodeErr == nil && body.OK {
+		return nil
+	}
+
+	tgErr := &TelegramError{
+		StatusCode:  resp.StatusCode,
+		ErrorCode:   body.ErrorCode,
+		Description: body.Description,
+		RetryAfter:  time.Duration(body.Parameters.RetryAfter) * time.Second,
+	}
+	if tgErr.ErrorCode == 0 {
+		tgErr.ErrorCode = resp.StatusCode
+	}
+	if tgErr.Description == "" {
+		tgErr.Description = http.StatusText(resp.StatusCode)
+	}
+	return yapay.WrapError(telegramErrorCode(resp.StatusCode), tgErr, "telegram rejected the message")
+}
+
+// redact removes the bot token from the URL in transport errors
+func (t *Telegram) redact(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, t.token.Reveal(), yapay.Redacted)
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBotToken = "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11"
+
+// fakeTelegram is a Bot API stub that records sendMessage calls
+type fakeTelegram struct {
+	*httptest.Server
+	mu       sync.Mutex
+	paths    []string
+	messages []map[string]interface{}
+	reply    func(w http.ResponseWriter, body map[string]interface{})
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	f := &fakeTelegram{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		f.mu.Lock()
+		f.paths = append(f.paths, r.URL.Path)
+		f.messages = append(f.messages, body)
+		reply := f.reply
+		f.mu.Unlock()
+
+		if reply != nil {
+			reply(w, body)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTelegram) notifier(t *testing.T, opts ...Option) *Telegram {
+	tg, err := NewTelegram(yapay.TelegramConfig{
+		Enabled:    true,
+		ChatID:     "-100500",
+		BotToken:   testBotToken,
+		APIBaseURL: f.URL,
+	}, opts...)
+	require.NoError(t, err)
+	return tg
+}
+
+func TestTelegram_Send(t *testing.T) {
+	fake := newFakeTelegram(t)
+	tg := fake.notifier(t)
+
+	err := tg.Send(context.Background(), &Message{Text: "<b>Paid</b>", ParseMode: "HTML"})
+	require.NoError(t, err)
+
+	require.Len(t, fake.messages, 1)
+	assert.Equal(t, "/bot"+testBotToken+"/sendMessage", fake.paths[0])
+	assert.Equal(t, "-100500", fake.messages[0]["chat_id"])
+	assert.Equal(t, "<b>Paid</b>", fake.messages[0]["text"])
+	assert.Equal(t, "HTML", fake.messages[0]["parse_mode"])
+
+	// Recipients override the configured chat
+	err = tg.Send(context.Background(), &Message{Text: "ops", Recipients: []string{"1", "2"}})
+	require.NoError(t, err)
+	require.Len(t, fake.messages, 3)
+	assert.Equal(t, "1", fake.messages[1]["chat_id"])
+	assert.Equal(t, "2", fake.messages[2]["chat_id"])
+}
+
+func TestTelegram_APIErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		code      yapay.ErrorCode
+		retryable bool
+	}{
+		{"bad request", 400, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`, yapay.ErrorCodeValidation, false},
+		{"blocked", 403, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`, yapay.ErrorCodeForbidden, false},
+		{"bad token", 404, `{"ok":false,"error_code":404,"description":"Not Found"}`, yapay.ErrorCodeUnauthorized, false},
+		{"flood", 429, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`, yapay.ErrorCodeRateLimited, true},
+		{"outage", 502, `<html>Bad Gateway</html>`, yapay.ErrorCodeBackendUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeTelegram(t)
+			fake.reply = func(w http.ResponseWriter, _ map[string]interface{}) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}
+
+			err := fake.notifier(t).Send(context.Background(), &Message{Text: "hi"})
+			require.Error(t, err)
+			assert.Equal(t, tt.code, yapay.ErrorCodeOf(err))
+			assert.Equal(t, tt.retryable, yapay.IsRetryable(err))
+
+			var tgErr *TelegramError
+			require.True(t, errors.As(err, &tgErr))
+			assert.Equal(t, tt.status, tgErr.StatusCode)
+			if tt.status == 429 {
+				assert.Equal(t, 7*time.Second, tgErr.RetryAfter)
+			}
+			assert.NotContains(t, err.Error(), testBotToken)
+		})
+	}
+}
+
+func TestTelegram_PerChatErrors(t *testing.T) {
+	fake := newFakeTelegram(t)
+	failures := map[string]int{}
+	fake.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		status, ok := failures[body["chat_id"].(string)]
+		if !ok {
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"ok":false}`))
+	}
+	tg := fake.notifier(t)
+	msg := &Message{Text: "ops", Recipients: []string{"1", "2", "3"}}
+
+	// A transient failure of the first chat must not hide a permanent one
+	failures = map[string]int{"1": http.StatusBadGateway, "3": http.StatusForbidden}
+	err := tg.Send(context.Background(), msg)
+	require.Error(t, err)
+	assert.False(t, yapay.IsRetryable(err))
+	assert.Equal(t, yapay.ErrorCodeForbidden, yapay.ErrorCodeOf(err))
+
+	var delivery *DeliveryError
+	require.True(t, errors.As(err, &delivery))
+	assert.Equal(t, []string{"2"}, delivery.Delivered)
+	assert.Equal(t, []string{"1", "3"}, delivery.FailedRecipients())
+	assert.Equal(t, yapay.ErrorCodeBackendUnavailable, yapay.ErrorCodeOf(delivery.Failed[0].Err))
+	assert.Contains(t, err.Error(), "2 of 3 recipients failed")
+
+	// Only transient failures: retrying the failed chats may succeed
+	failures = map[string]int{"1": http.StatusBadGateway, "3": http.StatusTooManyRequests}
+	err = tg.Send(context.Background(), msg)
+	assert.True(t, yapay.IsRetryable(err))
+	assert.Equal(t, yapay.ErrorCodeBackendUnavailable, yapay.ErrorCodeOf(err))
+}
+
+func TestTelegram_TransportErrorRedactsToken(t *testing.T) {
+	fake := newFakeTelegram(t)
+	tg := fake.notifier(t)
+	fake.Close()
+
+	err := tg.Send(context.Background(), &Message{Text: "hi"})
+	require.Error(t, err)
+	assert.True(t, yapay.IsRetryable(err))
+	assert.NotContains(t, err.Error(), testBotToken)
+	assert.Contains(t, err.Error(), yapay.Redacted)
+}
+
+func TestTelegram_Timeout(t *testing.T) {
+	fake := newFakeTelegram(t)
+	release := make(chan struct{})
+	defer close(release)
+	fake.reply = func(http.ResponseWriter, map[string]interface{}) { <-release }
+
+	err := fake.notifier(t, WithTimeout(50*time.Millisecond)).Send(context.Background(), &Message{Text: "hi"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, yapay.ErrTimeout))
+}
+
+func TestTelegram_Validation(t *testing.T) {
+	_, err := NewTelegram(yapay.TelegramConfig{Enabled: true, ChatID: "1"})
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+
+	fake := newFakeTelegram(t)
+	tg, err := NewTelegram(yapay.TelegramConfig{BotToken: testBotToken, APIBaseURL: fake.URL})
+	require.NoError(t, err)
+	assert.True(t, errors.Is(tg.Send(context.Background(), &Message{Text: "no chat"}), yapay.ErrValidation))
+
+	long := strings.Repeat("я", TelegramMaxMessageLength+1)
+	assert.True(t, errors.Is(fake.notifier(t).Send(context.Background(), &Message{Text: long}), yapay.ErrValidation))
+	assert.Empty(t, fake.messages)
+}
+
+func TestFromMerchant(t *testing.T) {
+	fake := newFakeTelegram(t)
+	merchant := &yapay.Merchant{ID: "m-1"}
+	notifiers, err := FromMerchant(merchant)
+	require.NoError(t, err)
+	assert.Empty(t, notifiers)
+	assert.NoError(t, notifiers.Send(context.Background(), &Message{Text: "nobody"}))
+
+	merchant.Notifications.Telegram = yapay.TelegramConfig{Enabled: true, ChatID: "1", BotToken: testBotToken, APIBaseURL: fake.URL}
+	merchant.Notifications.Email = yapay.EmailConfig{Enabled: true, SMTPHost: "127.0.0.1", SMTPPort: 1, From: "shop@example.com", To: []string{"owner@example.com"}, TLS: yapay.SMTPTLSNone}
+	notifiers, err = FromMerchant(merchant, WithTimeout(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []Channel{ChannelEmail, ChannelTelegram}, notifiers.Channels())
+
+	// Telegram delivers even though email fails; the error names the channel
+	err = notifiers.Send(context.Background(), MessageFromRequest(&yapay.NotificationRequest{
+		Type:      yapay.NotificationTypePaymentSuccess,
+		PaymentID: "pay-1",
+		Message:   "paid",
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "email: ")
+	assert.Len(t, fake.messages, 1)
+	assert.Equal(t, "paid", fake.messages[0]["text"])
+
+	merchant.Notifications.Email.From = "not an address"
+	_, err = FromMerchant(merchant)
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+}
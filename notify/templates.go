@@ -0,0 +1,202 @@
+package notify
+
+import "github.com/metalmon/yapay-sdk"
+
+// Built-in templates shared by all notification types. The title of each
+// type comes from the title helper.
+const (
+	defaultSubjectTemplate = `{{title .Type}}{{with .Payment}} {{money .Amount .Currency}}{{end}} - {{.Merchant.Name}}`
+
+	defaultTextTemplate = `{{title .Type}}
+{{- with .Payment}} {{money .Amount .Currency}}{{end}}
+{{with .Message}}
+{{.}}
+{{end}}
+{{- range details .}}
+{{.Label}}: {{.Value}}
+{{- end}}
+{{- with fields .Metadata}}
+{{range .}}
+{{.Label}}: {{.Value}}
+{{- end}}
+{{- end}}
+
+{{.Merchant.Name}}`
+
+	defaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif; color: #222;">
+<h2>{{title .Type}}{{with .Payment}} {{money .Amount .Currency}}{{end}}</h2>
+{{with .Message}}<p>{{.}}</p>{{end}}
+<table cellpadding="4" style="border-collapse: collapse;">
+{{- range details .}}
+<tr><td style="color: #666;">{{.Label}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+{{- range fields .Metadata}}
+<tr><td style="color: #666;">{{.Label}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+<p style="color: #999;">{{.Merchant.Name}}</p>
+</body>
+</html>`
+
+	defaultTelegramTemplate = `<b>{{title .Type}}</b>{{with .Payment}} {{money .Amount .Currency}}{{end}}
+{{with .Message}}
+{{.}}
+{{end}}
+{{- range details .}}
+{{.Label}}: {{.Value}}
+{{- end}}
+{{- with fields .Metadata}}
+{{range .}}
+{{.Label}}: <code>{{.Value}}</code>
+{{- end}}
+{{- end}}
+
+<i>{{.Merchant.Name}}</i>`
+)
+
+// Built-in digest templates. Payments are listed with their metadata
+// labeled by FieldLabels.
+const (
+	defaultDigestSubjectTemplate = `{{title .Type}}{{with .Digest}} {{date .Start}} - {{date .End}}{{end}} - {{.Merchant.Name}}`
+
+	defaultDigestTextTemplate = `{{title .Type}}
+{{- with .Digest}} {{date .Start}} - {{date .End}}
+{{range .Totals}}
+{{status .Status}}: {{.Count}} на {{money .Amount .Currency}}
+{{- end}}
+{{- with .FailureReasons}}
+
+Причины ошибок:
+{{- range .}}
+{{or .Reason "не указана"}}: {{.Count}}
+{{- end}}
+{{- end}}
+{{- with .Payments}}
+
+Платежи:
+{{- range .}}
+
+{{with .OrderID}}{{.}}{{else}}{{.ID}}{{end}}: {{money .Amount .Currency}}, {{status .Status}}
+{{- with .Description}}
+{{.}}
+{{- end}}
+{{- with .Reason}}
+{{label "reason"}}: {{.}}
+{{- end}}
+{{- range fields .Metadata}}
+{{.Label}}: {{.Value}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- with .Omitted}}
+
+И еще платежей: {{.}}
+{{- end}}
+
+{{range $type, $count := .Events}}
+{{title $type}}: {{$count}}
+{{- end}}
+{{- end}}
+
+{{.Merchant.Name}}`
+
+	defaultDigestHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif; color: #222;">
+{{- with .Digest}}
+<h2>{{title $.Type}} {{date .Start}} - {{date .End}}</h2>
+<table cellpadding="4" style="border-collapse: collapse;">
+{{- range .Totals}}
+<tr><td style="color: #666;">{{status .Status}}</td><td>{{.Count}}</td><td>{{money .Amount .Currency}}</td></tr>
+{{- end}}
+</table>
+{{- with .FailureReasons}}
+<h3>Причины ошибок</h3>
+<table cellpadding="4" style="border-collapse: collapse;">
+{{- range .}}
+<tr><td>{{or .Reason "не указана"}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Payments}}
+<h3>Платежи</h3>
+{{- range .}}
+<p><b>{{with .OrderID}}{{.}}{{else}}{{.ID}}{{end}}</b>: {{money .Amount .Currency}}, {{status .Status}}
+{{- with .Description}}<br>{{.}}{{end}}
+{{- with .Reason}}<br>{{label "reason"}}: {{.}}{{end}}
+{{- range fields .Metadata}}<br><span style="color: #666;">{{.Label}}:</span> {{.Value}}{{end}}</p>
+{{- end}}
+{{- end}}
+{{- with .Omitted}}
+<p>И еще платежей: {{.}}</p>
+{{- end}}
+<p style="color: #666;">
+{{- range $type, $count := .Events}}{{title $type}}: {{$count}}<br>{{end -}}
+</p>
+{{- end}}
+<p style="color: #999;">{{.Merchant.Name}}</p>
+</body>
+</html>`
+
+	defaultDigestTelegramTemplate = `<b>{{title .Type}}</b>
+{{- with .Digest}} {{date .Start}} - {{date .End}}
+{{range .Totals}}
+{{status .Status}}: {{.Count}} на {{money .Amount .Currency}}
+{{- end}}
+{{- with .FailureReasons}}
+
+<b>Причины ошибок</b>
+{{- range .}}
+{{or .Reason "не указана"}}: {{.Count}}
+{{- end}}
+{{- end}}
+{{- with .Payments}}
+
+<b>Платежи</b>
+{{- range .}}
+{{with .OrderID}}{{.}}{{else}}{{.ID}}{{end}}: {{money .Amount .Currency}}, {{status .Status}}
+{{- range fields .Metadata}}, {{.Label}}: <code>{{.Value}}</code>{{end}}
+{{- end}}
+{{- end}}
+{{- with .Omitted}}
+И еще платежей: {{.}}
+{{- end}}
+{{- end}}
+
+<i>{{.Merchant.Name}}</i>`
+)
+
+// defaultTitles are the built-in titles of notification types
+var defaultTitles = map[yapay.NotificationType]string{
+	yapay.NotificationTypePaymentCreated: "Новый платеж",
+	yapay.NotificationTypePaymentSuccess: "Платеж получен",
+	yapay.NotificationTypePaymentFailed:  "Платеж не прошел",
+	yapay.NotificationTypeSystemError:    "Системная ошибка",
+	yapay.NotificationTypeWebhook:        "Webhook",
+	yapay.NotificationTypeDigest:         "Сводка",
+}
+
+// defaultLabels label the payment fields listed by the details helper;
+// FieldLabels entries with the same keys take precedence
+var defaultLabels = map[string]string{
+	"order_id":    "Заказ",
+	"payment_id":  "Платеж",
+	"status":      "Статус",
+	"description": "Описание",
+	"created_at":  "Создан",
+	"reason":      "Причина",
+	"refunded":    "Возвращено",
+}
+
+// statusTitles are human-readable payment statuses
+var statusTitles = map[yapay.PaymentStatus]string{
+	yapay.PaymentStatusCreated:           "создан",
+	yapay.PaymentStatusPending:           "ожидает оплаты",
+	yapay.PaymentStatusSuccess:           "оплачен",
+	yapay.PaymentStatusFailed:            "ошибка",
+	yapay.PaymentStatusCanceled:          "отменен",
+	yapay.PaymentStatusPartiallyRefunded: "частично возвращен",
+	yapay.PaymentStatusRefunded:          "возвращен",
+}
@@ -0,0 +1,167 @@
+package receipt
+
+import (
+	"strings"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// DefaultProductID is the product ID of the single item built for requests without a cart
+const DefaultProductID = "payment"
+
+// Builder creates receipts from payment requests and carts
+type Builder struct {
+	customer       Customer
+	vat            VATRate
+	paymentSubject PaymentSubject
+	paymentMethod  PaymentMethod
+	measure        MeasureUnit
+	agent          *Agent
+	itemHook       func(src *yapay.CartItem, item *Item)
+}
+
+// Option configures a Builder
+type Option func(*Builder)
+
+// WithCustomer sets the buyer contact
+func WithCustomer(customer Customer) Option {
+	return func(b *Builder) {
+		b.customer = customer
+	}
+}
+
+// WithVAT sets the VAT rate of every item
+func WithVAT(vat VATRate) Option {
+	return func(b *Builder) {
+		b.vat = vat
+	}
+}
+
+// WithPaymentSubject sets the payment subject of every item
+func WithPaymentSubject(subject PaymentSubject) Option {
+	return func(b *Builder) {
+		b.paymentSubject = subject
+	}
+}
+
+// WithPaymentMethod sets the payment method of every item
+func WithPaymentMethod(method PaymentMethod) Option {
+	return func(b *Builder) {
+		b.paymentMethod = method
+	}
+}
+
+// WithMeasure sets the measure unit of every item
+func WithMeasure(measure MeasureUnit) Option {
+	return func(b *Builder) {
+		b.measure = measure
+	}
+}
+
+// WithAgent marks every item as sold by an agent
+func WithAgent(agent Agent) Option {
+	return func(b *Builder) {
+		b.agent = &agent
+	}
+}
+
+// WithItemHook lets the caller adjust each item, e.g. to set a per-product VAT
+// rate. The source cart item is nil for requests without a cart.
+func WithItemHook(hook func(src *yapay.CartItem, item *Item)) Option {
+	return func(b *Builder) {
+		b.itemHook = hook
+	}
+}
+
+// NewBuilder creates a builder. Items default to VAT20, COMMODITY and FULL_PAYMENT.
+func NewBuilder(opts ...Option) *Builder {
+	b := &Builder{
+		vat:            VAT20,
+		paymentSubject: PaymentSubjectCommodity,
+		paymentMethod:  PaymentMethodFullPayment,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// FromPaymentRequest builds and validates a receipt for the request.
+// Requests without a cart get a single item titled with the description.
+// The receipt total must equal the request amount.
+func (b *Builder) FromPaymentRequest(req *yapay.PaymentRequest) (*Receipt, error) {
+	if req.Cart != nil {
+		if err := req.ValidateCart(); err != nil {
+			return nil, err
+		}
+		return b.FromCart(req.Cart, req.Currency)
+	}
+
+	item := b.newItem(DefaultProductID, req.Description, 1, req.Amount, req.Amount)
+	if b.itemHook != nil {
+		b.itemHook(nil, &item)
+	}
+	return b.build(req.Currency, []Item{item}, req.Amount)
+}
+
+// FromCart builds and validates a receipt for the cart.
+// A position whose total is not divisible by its quantity becomes two items
+// with the same product ID.
+func (b *Builder) FromCart(cart *yapay.Cart, currency string) (*Receipt, error) {
+	if err := cart.Validate(); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(cart.Items))
+	for i := range cart.Items {
+		src := &cart.Items[i]
+		// 54-FZ receipts show prices after discounts, and every item must
+		// have price * quantity == amount. A total that cannot be spread
+		// evenly is split into an item at the floor price and one for the
+		// remaining units at one minor unit more.
+		price, rest := src.Total()/src.Quantity, src.Total()%src.Quantity
+		split := []Item{b.newItem(src.ProductID, src.Title, src.Quantity-rest, price, price*(src.Quantity-rest))}
+		if rest > 0 {
+			split = append(split, b.newItem(src.ProductID, src.Title, rest, price+1, (price+1)*rest))
+		}
+		for j := range split {
+			if b.itemHook != nil {
+				b.itemHook(src, &split[j])
+			}
+			items = append(items, split[j])
+		}
+	}
+	return b.build(currency, items, cart.Total())
+}
+
+// newItem creates an item with the builder defaults
+func (b *Builder) newItem(productID, title string, quantity, price, amount int) Item {
+	return Item{
+		ProductID:      productID,
+		Title:          title,
+		Quantity:       quantity,
+		Price:          price,
+		Amount:         amount,
+		VAT:            b.vat,
+		PaymentSubject: b.paymentSubject,
+		PaymentMethod:  b.paymentMethod,
+		Measure:        b.measure,
+		Agent:          b.agent,
+	}
+}
+
+// build assembles the receipt and checks it against the expected total
+func (b *Builder) build(currency string, items []Item, total int) (*Receipt, error) {
+	r := &Receipt{
+		Customer: b.customer,
+		Currency: strings.ToUpper(currency),
+		Items:    items,
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if r.Total() != total {
+		return nil, yapay.Errorf(yapay.ErrorCodePriceMismatch, "receipt total %d does not match payment amount %d", r.Total(), total)
+	}
+	return r, nil
+}
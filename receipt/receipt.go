@@ -0,0 +1,356 @@
+// Package receipt builds and validates 54-FZ fiscal receipts for Yandex Pay orders.
+package receipt
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/metalmon/yapay-sdk"
+)
+
+// VATRate is a VAT rate as encoded by Yandex Pay in the tax field of a cart
+// item receipt (CartItem.receipt.tax in the Merchant API reference for
+// POST /v1/orders)
+type VATRate int
+
+// VAT rates. The values are the Yandex Pay tax codes, which differ from the
+// vat_code numbering of other acquirers: 1 is 20%, not "without VAT".
+const (
+	VAT20     VATRate = 1  // НДС 20%
+	VAT10     VATRate = 2  // НДС 10%
+	VAT20_120 VATRate = 3  // НДС 20/120
+	VAT10_110 VATRate = 4  // НДС 10/110
+	VAT0      VATRate = 5  // НДС 0%
+	VATNone   VATRate = 6  // Без НДС
+	VAT5      VATRate = 7  // НДС 5%
+	VAT7      VATRate = 8  // НДС 7%
+	VAT5_105  VATRate = 9  // НДС 5/105
+	VAT7_107  VATRate = 10 // НДС 7/107
+)
+
+// IsValid reports whether the rate is known
+func (v VATRate) IsValid() bool {
+	return v >= VAT20 && v <= VAT7_107
+}
+
+// PaymentMethod is the payment method attribute (tag 1214)
+type PaymentMethod string
+
+// Payment methods
+const (
+	PaymentMethodFullPrepayment    PaymentMethod = "FULL_PREPAYMENT"
+	PaymentMethodPartialPrepayment PaymentMethod = "PARTIAL_PREPAYMENT"
+	PaymentMethodAdvance           PaymentMethod = "ADVANCE"
+	PaymentMethodFullPayment       PaymentMethod = "FULL_PAYMENT"
+	PaymentMethodPartialPayment    PaymentMethod = "PARTIAL_PAYMENT"
+	PaymentMethodCredit            PaymentMethod = "CREDIT"
+	PaymentMethodCreditPayment     PaymentMethod = "CREDIT_PAYMENT"
+)
+
+var paymentMethods = map[PaymentMethod]bool{
+	PaymentMethodFullPrepayment:    true,
+	PaymentMethodPartialPrepayment: true,
+	PaymentMethodAdvance:           true,
+	PaymentMethodFullPayment:       true,
+	PaymentMethodPartialPayment:    true,
+	PaymentMethodCredit:            true,
+	PaymentMethodCreditPayment:     true,
+}
+
+// IsValid reports whether the payment method is known
+func (m PaymentMethod) IsValid() bool {
+	return paymentMethods[m]
+}
+
+// PaymentSubject is the payment subject attribute (tag 1212)
+type PaymentSubject string
+
+// Payment subjects
+const (
+	PaymentSubjectCommodity            PaymentSubject = "COMMODITY"
+	PaymentSubjectExcise               PaymentSubject = "EXCISE"
+	PaymentSubjectJob                  PaymentSubject = "JOB"
+	PaymentSubjectService              PaymentSubject = "SERVICE"
+	PaymentSubjectGamblingBet          PaymentSubject = "GAMBLING_BET"
+	PaymentSubjectGamblingPrize        PaymentSubject = "GAMBLING_PRIZE"
+	PaymentSubjectLottery              PaymentSubject = "LOTTERY"
+	PaymentSubjectLotteryPrize         PaymentSubject = "LOTTERY_PRIZE"
+	PaymentSubjectIntellectualActivity PaymentSubject = "INTELLECTUAL_ACTIVITY"
+	PaymentSubjectPayment              PaymentSubject = "PAYMENT"
+	PaymentSubjectAgentCommission      PaymentSubject = "AGENT_COMMISSION"
+	PaymentSubjectComposite            PaymentSubject = "COMPOSITE"
+	PaymentSubjectAnother              PaymentSubject = "ANOTHER"
+)
+
+var paymentSubjects = map[PaymentSubject]bool{
+	PaymentSubjectCommodity:            true,
+	PaymentSubjectExcise:               true,
+	PaymentSubjectJob:                  true,
+	PaymentSubjectService:              true,
+	PaymentSubjectGamblingBet:          true,
+	PaymentSubjectGamblingPrize:        true,
+	PaymentSubjectLottery:              true,
+	PaymentSubjectLotteryPrize:         true,
+	PaymentSubjectIntellectualActivity: true,
+	PaymentSubjectPayment:              true,
+	PaymentSubjectAgentCommission:      true,
+	PaymentSubjectComposite:            true,
+	PaymentSubjectAnother:              true,
+}
+
+// IsValid reports whether the payment subject is known
+func (s PaymentSubject) IsValid() bool {
+	return paymentSubjects[s]
+}
+
+// MeasureUnit is the unit of quantity (tag 2108). The zero value means pieces.
+type MeasureUnit int
+
+// Common measure units
+const (
+	MeasurePiece      MeasureUnit = 0
+	MeasureGram       MeasureUnit = 10
+	MeasureKilogram   MeasureUnit = 11
+	MeasureMeter      MeasureUnit = 22
+	MeasureLiter      MeasureUnit = 41
+	MeasureKilowattHr MeasureUnit = 50
+	MeasureDay        MeasureUnit = 70
+	MeasureHour       MeasureUnit = 71
+	MeasureMegabyte   MeasureUnit = 81
+	MeasureGigabyte   MeasureUnit = 82
+	MeasureOther      MeasureUnit = 255
+)
+
+// AgentType is the agent attribute (tag 1222)
+type AgentType int
+
+// Agent types
+const (
+	AgentBankPaymentAgent    AgentType = 1
+	AgentBankPaymentSubagent AgentType = 2
+	AgentPaymentAgent        AgentType = 3
+	AgentPaymentSubagent     AgentType = 4
+	AgentAttorney            AgentType = 5
+	AgentCommissioner        AgentType = 6
+	AgentOther               AgentType = 7
+)
+
+// IsValid reports whether the agent type is known
+func (t AgentType) IsValid() bool {
+	return t >= AgentBankPaymentAgent && t <= AgentOther
+}
+
+// Customer is the buyer contact the receipt is sent to
+type Customer struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"` // International format, e.g. +79001234567
+	Name  string `json:"name,omitempty"`
+	INN   string `json:"inn,omitempty"`
+}
+
+// Supplier is the supplier of an item sold by an agent
+type Supplier struct {
+	INN    string   `json:"inn"`
+	Name   string   `json:"name"`
+	Phones []string `json:"phones,omitempty"`
+}
+
+// TransferOperator is the money transfer operator of a bank payment agent
+type TransferOperator struct {
+	INN     string   `json:"inn"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Phones  []string `json:"phones,omitempty"`
+}
+
+// Agent describes the agent selling an item on behalf of a supplier
+type Agent struct {
+	Type                   AgentType         `json:"type"`
+	Operation              string            `json:"operation,omitempty"`
+	Phones                 []string          `json:"phones,omitempty"`
+	PaymentsOperatorPhones []string          `json:"payments_operator_phones,omitempty"`
+	TransferOperator       *TransferOperator `json:"transfer_operator,omitempty"`
+	Supplier               Supplier          `json:"supplier"`
+}
+
+// Item is a receipt position. Price and Amount are in minor units, after discounts.
+type Item struct {
+	ProductID      string         `json:"product_id"`
+	Title          string         `json:"title"`
+	Quantity       int            `json:"quantity"`
+	Price          int            `json:"price"`  // Price of one unit
+	Amount         int            `json:"amount"` // Price of the whole position
+	VAT            VATRate        `json:"vat"`
+	PaymentSubject PaymentSubject `json:"payment_subject"`
+	PaymentMethod  PaymentMethod  `json:"payment_method"`
+	Measure        MeasureUnit    `json:"measure,omitempty"`
+	Agent          *Agent         `json:"agent,omitempty"`
+}
+
+// Receipt is a fiscal receipt for one payment
+type Receipt struct {
+	Customer Customer `json:"customer"`
+	Currency string   `json:"currency"`
+	Items    []Item   `json:"items"`
+}
+
+// MaxTitleLength is the maximum length of an item title (tag 1030)
+const MaxTitleLength = 128
+
+var (
+	phonePattern = regexp.MustCompile(`^\+\d{10,15}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// Total returns the sum of the item amounts
+func (r *Receipt) Total() int {
+	total := 0
+	for _, item := range r.Items {
+		total += item.Amount
+	}
+	return total
+}
+
+// Validate checks the receipt against the 54-FZ requirements and reports all problems at once
+func (r *Receipt) Validate() error {
+	err := yapay.NewError(yapay.ErrorCodeValidation, "invalid receipt")
+
+	if r.Customer.Email == "" && r.Customer.Phone == "" {
+		err.WithDetail("customer", "Email or phone is required")
+	}
+	if r.Customer.Email != "" && !emailPattern.MatchString(r.Customer.Email) {
+		err.WithDetail("customer.email", "Invalid email")
+	}
+	if r.Customer.Phone != "" && !phonePattern.MatchString(r.Customer.Phone) {
+		err.WithDetail("customer.phone", "Phone must be in international format, e.g. +79001234567")
+	}
+	if r.Customer.INN != "" && !ValidINN(r.Customer.INN) {
+		err.WithDetail("customer.inn", "Invalid INN")
+	}
+	if !yapay.IsKnownCurrency(r.Currency) {
+		err.WithDetail("currency", "Unknown currency")
+	}
+	if len(r.Items) == 0 {
+		err.WithDetail("items", "Receipt must contain at least one item")
+	}
+
+	for i := range r.Items {
+		r.Items[i].validate(fmt.Sprintf("items[%d]", i), err)
+	}
+
+	if len(err.Details) > 0 {
+		return err
+	}
+	return nil
+}
+
+// validate adds the item problems to err
+func (item *Item) validate(field string, err *yapay.Error) {
+	if item.Title == "" {
+		err.WithDetail(field+".title", "Title is required")
+	} else if utf8.RuneCountInString(item.Title) > MaxTitleLength {
+		err.WithDetail(field+".title", fmt.Sprintf("Title must be at most %d characters", MaxTitleLength))
+	}
+	if item.Quantity <= 0 {
+		err.WithDetail(field+".quantity", "Quantity must be positive")
+	}
+	if item.Price < 0 || item.Amount < 0 {
+		err.WithDetail(field+".amount", "Amounts must not be negative")
+	}
+	// Discounts may leave a remainder, but the amount can never exceed the full price
+	if item.Amount > item.Price*item.Quantity {
+		err.WithDetail(field+".amount", "Amount must not exceed price times quantity")
+	}
+	if !item.VAT.IsValid() {
+		err.WithDetail(field+".vat", "Unknown VAT rate")
+	}
+	if !item.PaymentSubject.IsValid() {
+		err.WithDetail(field+".payment_subject", "Unknown payment subject")
+	}
+	if !item.PaymentMethod.IsValid() {
+		err.WithDetail(field+".payment_method", "Unknown payment method")
+	}
+	// An advance is paid before the subject is known, so it is always a payment
+	if item.PaymentMethod == PaymentMethodAdvance && item.PaymentSubject != PaymentSubjectPayment {
+		err.WithDetail(field+".payment_subject", "Advance payments must use the PAYMENT subject")
+	}
+	if item.Agent != nil {
+		item.Agent.validate(field+".agent", err)
+	}
+}
+
+// validate adds the agent problems to err
+func (a *Agent) validate(field string, err *yapay.Error) {
+	if !a.Type.IsValid() {
+		err.WithDetail(field+".type", "Unknown agent type")
+	}
+	if !ValidINN(a.Supplier.INN) {
+		err.WithDetail(field+".supplier.inn", "Supplier INN is required and must be valid")
+	}
+	if a.Supplier.Name == "" {
+		err.WithDetail(field+".supplier.name", "Supplier name is required")
+	}
+
+	switch a.Type {
+	case AgentBankPaymentAgent, AgentBankPaymentSubagent:
+		if a.Operation == "" {
+			err.WithDetail(field+".operation", "Operation is required for bank payment agents")
+		}
+		if len(a.Phones) == 0 {
+			err.WithDetail(field+".phones", "Agent phone is required for bank payment agents")
+		}
+		if op := a.TransferOperator; op == nil || op.Name == "" || op.Address == "" || !ValidINN(op.INN) {
+			err.WithDetail(field+".transfer_operator", "Transfer operator name, address and INN are required")
+		}
+	case AgentPaymentAgent, AgentPaymentSubagent:
+		if len(a.Phones) == 0 {
+			err.WithDetail(field+".phones", "Agent phone is required for payment agents")
+		}
+		if len(a.PaymentsOperatorPhones) == 0 {
+			err.WithDetail(field+".payments_operator_phones", "Payments operator phone is required for payment agents")
+		}
+	}
+
+	for _, phone := range append(append([]string{}, a.Phones...), a.PaymentsOperatorPhones...) {
+		if !phonePattern.MatchString(phone) {
+			err.WithDetail(field+".phones", fmt.Sprintf("Invalid phone %q", phone))
+		}
+	}
+}
+
+// INN check digit coefficients for 10- and 12-digit numbers
+var (
+	innWeights10  = []int{2, 4, 10, 3, 5, 9, 4, 6, 8}
+	innWeights12a = []int{7, 2, 4, 10, 3, 5, 9, 4, 6, 8}
+	innWeights12b = []int{3, 7, 2, 4, 10, 3, 5, 9, 4, 6, 8}
+)
+
+// ValidINN reports whether s is a 10-digit (organization) or 12-digit
+// (individual) INN with correct check digits
+func ValidINN(s string) bool {
+	digits := make([]int, len(s))
+	for i, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+		digits[i] = int(r - '0')
+	}
+
+	check := func(weights []int) int {
+		sum := 0
+		for i, w := range weights {
+			sum += w * digits[i]
+		}
+		return sum % 11 % 10
+	}
+
+	switch len(digits) {
+	case 10:
+		return check(innWeights10) == digits[9]
+	case 12:
+		return check(innWeights12a) == digits[10] && check(innWeights12b) == digits[11]
+	default:
+		return false
+	}
+}
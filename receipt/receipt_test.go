@@ -0,0 +1,249 @@
+package receipt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/metalmon/yapay-sdk/merchantapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest() *yapay.PaymentRequest {
+	return &yapay.PaymentRequest{
+		Amount:      125000,
+		Currency:    "rub",
+		Description: "Order #42",
+		Cart: &yapay.Cart{Items: []yapay.CartItem{
+			{ProductID: "book", Title: "Book", Quantity: 2, UnitPrice: 50000},
+			{ProductID: "course", Title: "Online course", Quantity: 1, UnitPrice: 30000, Discounts: []yapay.ItemDiscount{{Amount: 5000}}},
+		}},
+	}
+}
+
+func TestBuilder_FromPaymentRequest(t *testing.T) {
+	builder := NewBuilder(
+		WithCustomer(Customer{Email: "buyer@example.com"}),
+		WithItemHook(func(src *yapay.CartItem, item *Item) {
+			if src.ProductID == "course" {
+				item.VAT = VATNone
+				item.PaymentSubject = PaymentSubjectService
+			}
+		}),
+	)
+
+	r, err := builder.FromPaymentRequest(newTestRequest())
+	require.NoError(t, err)
+	assert.Equal(t, "RUB", r.Currency)
+	require.Len(t, r.Items, 2)
+	assert.Equal(t, 125000, r.Total())
+
+	assert.Equal(t, VAT20, r.Items[0].VAT)
+	assert.Equal(t, PaymentSubjectCommodity, r.Items[0].PaymentSubject)
+	assert.Equal(t, PaymentMethodFullPayment, r.Items[0].PaymentMethod)
+
+	// Receipts show prices after discounts
+	assert.Equal(t, 25000, r.Items[1].Price)
+	assert.Equal(t, VATNone, r.Items[1].VAT)
+	assert.Equal(t, PaymentSubjectService, r.Items[1].PaymentSubject)
+}
+
+func TestBuilder_FromPaymentRequest_WithoutCart(t *testing.T) {
+	builder := NewBuilder(WithCustomer(Customer{Phone: "+79001234567"}), WithPaymentSubject(PaymentSubjectService))
+
+	r, err := builder.FromPaymentRequest(&yapay.PaymentRequest{Amount: 9900, Currency: "RUB", Description: "Subscription"})
+	require.NoError(t, err)
+	require.Len(t, r.Items, 1)
+	assert.Equal(t, DefaultProductID, r.Items[0].ProductID)
+	assert.Equal(t, "Subscription", r.Items[0].Title)
+	assert.Equal(t, 9900, r.Items[0].Amount)
+}
+
+func TestBuilder_Errors(t *testing.T) {
+	// No customer contact
+	_, err := NewBuilder().FromPaymentRequest(newTestRequest())
+	assert.True(t, errors.Is(err, yapay.ErrValidation))
+	assert.Contains(t, yapay.AsError(err).Details, "customer")
+
+	// Cart does not add up to the amount
+	req := newTestRequest()
+	req.Amount = 1
+	_, err = NewBuilder(WithCustomer(Customer{Email: "buyer@example.com"})).FromPaymentRequest(req)
+	assert.True(t, errors.Is(err, yapay.ErrPriceMismatch))
+}
+
+func TestReceipt_Validate(t *testing.T) {
+	valid := Item{
+		ProductID:      "p1",
+		Title:          "Item",
+		Quantity:       1,
+		Price:          100,
+		Amount:         100,
+		VAT:            VAT20,
+		PaymentSubject: PaymentSubjectCommodity,
+		PaymentMethod:  PaymentMethodFullPayment,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *Receipt)
+		field  string
+	}{
+		{"invalid email", func(r *Receipt) { r.Customer.Email = "not-an-email" }, "customer.email"},
+		{"invalid phone", func(r *Receipt) { r.Customer = Customer{Phone: "8 900 123"} }, "customer.phone"},
+		{"unknown currency", func(r *Receipt) { r.Currency = "XXX" }, "currency"},
+		{"no items", func(r *Receipt) { r.Items = nil }, "items"},
+		{"long title", func(r *Receipt) { r.Items[0].Title = string(make([]rune, MaxTitleLength+1)) }, "items[0].title"},
+		{"unknown VAT", func(r *Receipt) { r.Items[0].VAT = 11 }, "items[0].vat"},
+		{"amount above price", func(r *Receipt) { r.Items[0].Amount = 101 }, "items[0].amount"},
+		{"advance of a commodity", func(r *Receipt) { r.Items[0].PaymentMethod = PaymentMethodAdvance }, "items[0].payment_subject"},
+		{"agent without supplier", func(r *Receipt) { r.Items[0].Agent = &Agent{Type: AgentCommissioner} }, "items[0].agent.supplier.inn"},
+		{"payment agent without operator phone", func(r *Receipt) {
+			r.Items[0].Agent = &Agent{
+				Type:     AgentPaymentAgent,
+				Phones:   []string{"+79001234567"},
+				Supplier: Supplier{INN: "7707083893", Name: "Supplier"},
+			}
+		}, "items[0].agent.payments_operator_phones"},
+		{"bank agent without transfer operator", func(r *Receipt) {
+			r.Items[0].Agent = &Agent{
+				Type:      AgentBankPaymentAgent,
+				Operation: "Transfer",
+				Phones:    []string{"+79001234567"},
+				Supplier:  Supplier{INN: "7707083893", Name: "Supplier"},
+			}
+		}, "items[0].agent.transfer_operator"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Receipt{Customer: Customer{Email: "buyer@example.com"}, Currency: "RUB", Items: []Item{valid}}
+			require.NoError(t, r.Validate())
+
+			tt.modify(r)
+			err := r.Validate()
+			require.Error(t, err)
+			assert.Contains(t, yapay.AsError(err).Details, tt.field)
+		})
+	}
+}
+
+func TestValidINN(t *testing.T) {
+	assert.True(t, ValidINN("7707083893"))
+	assert.True(t, ValidINN("500100732259"))
+	assert.False(t, ValidINN("7707083894"))
+	assert.False(t, ValidINN("500100732250"))
+	assert.False(t, ValidINN("12345"))
+	assert.False(t, ValidINN("77070838a3"))
+}
+
+func TestReceipt_ValidateReportsAllCustomerProblems(t *testing.T) {
+	r := &Receipt{
+		Customer: Customer{Email: "not-an-email", Phone: "8 900 123", INN: "123"},
+		Currency: "RUB",
+		Items: []Item{{
+			ProductID: "p1", Title: "Item", Quantity: 1, Price: 100, Amount: 100, VAT: VAT20,
+			PaymentSubject: PaymentSubjectCommodity, PaymentMethod: PaymentMethodFullPayment,
+		}},
+	}
+	err := r.Validate()
+	require.Error(t, err)
+	details := yapay.AsError(err).Details
+	assert.Contains(t, details, "customer.email")
+	assert.Contains(t, details, "customer.phone")
+	assert.Contains(t, details, "customer.inn")
+}
+
+func TestVATRate_YandexTaxCodes(t *testing.T) {
+	// The tax codes of the Yandex Pay Merchant API (CartItem.receipt.tax)
+	documented := map[int]VATRate{
+		1:  VAT20,
+		2:  VAT10,
+		3:  VAT20_120,
+		4:  VAT10_110,
+		5:  VAT0,
+		6:  VATNone,
+		7:  VAT5,
+		8:  VAT7,
+		9:  VAT5_105,
+		10: VAT7_107,
+	}
+	for code, vat := range documented {
+		assert.True(t, vat.IsValid(), code)
+		item := Item{VAT: vat}
+		assert.Equal(t, code, item.YandexReceipt().Tax)
+	}
+	assert.False(t, VATRate(0).IsValid())
+	assert.False(t, VATRate(11).IsValid())
+}
+
+func TestReceipt_ApplyTo(t *testing.T) {
+	req := newTestRequest()
+	agent := Agent{
+		Type:                   AgentPaymentAgent,
+		Phones:                 []string{"+79001234567"},
+		PaymentsOperatorPhones: []string{"+79007654321"},
+		Supplier:               Supplier{INN: "7707083893", Name: "Supplier LLC"},
+	}
+	r, err := NewBuilder(WithCustomer(Customer{Email: "buyer@example.com"}), WithAgent(agent)).FromPaymentRequest(req)
+	require.NoError(t, err)
+
+	cart, err := merchantapi.NewCart(req.Cart, req.Currency)
+	require.NoError(t, err)
+	require.NoError(t, r.ApplyTo(cart))
+
+	receipt := cart.Items[0].Receipt
+	require.NotNil(t, receipt)
+	assert.Equal(t, 1, receipt.Tax)
+	assert.Equal(t, "COMMODITY", receipt.PaymentSubjectType)
+	assert.Equal(t, "FULL_PAYMENT", receipt.PaymentMethodType)
+	assert.Equal(t, 3, receipt.Agent.AgentType)
+	assert.Equal(t, []string{"+79007654321"}, receipt.Agent.PaymentsOperator.Phones)
+	assert.Equal(t, "7707083893", receipt.Supplier.INN)
+
+	cart.Items = append(cart.Items, merchantapi.CartItem{ProductID: "unknown"})
+	assert.True(t, errors.Is(r.ApplyTo(cart), yapay.ErrValidation))
+}
+
+func TestReceipt_SplitsUnevenPosition(t *testing.T) {
+	req := &yapay.PaymentRequest{
+		Amount:   10000,
+		Currency: "RUB",
+		Cart: &yapay.Cart{Items: []yapay.CartItem{
+			{ProductID: "pen", Title: "Pen", Quantity: 3, UnitPrice: 4000, Discounts: []yapay.ItemDiscount{{Amount: 2000}}},
+		}},
+	}
+	r, err := NewBuilder(WithCustomer(Customer{Email: "buyer@example.com"})).FromPaymentRequest(req)
+	require.NoError(t, err)
+	require.Len(t, r.Items, 2)
+	for _, item := range r.Items {
+		assert.Equal(t, item.Price*item.Quantity, item.Amount)
+	}
+	assert.Equal(t, 2, r.Items[0].Quantity)
+	assert.Equal(t, 3333, r.Items[0].Price)
+	assert.Equal(t, 1, r.Items[1].Quantity)
+	assert.Equal(t, 3334, r.Items[1].Price)
+	assert.Equal(t, 10000, r.Total())
+
+	cart, err := merchantapi.NewCart(req.Cart, req.Currency)
+	require.NoError(t, err)
+	require.NoError(t, r.ApplyTo(cart))
+	require.Len(t, cart.Items, 2)
+
+	assert.Equal(t, "pen", cart.Items[0].ProductID)
+	assert.Equal(t, "2", cart.Items[0].Quantity.Count)
+	assert.Equal(t, "40.00", cart.Items[0].UnitPrice)
+	assert.Equal(t, "33.33", cart.Items[0].DiscountedUnitPrice)
+	assert.Equal(t, "80.00", cart.Items[0].Subtotal)
+	assert.Equal(t, "66.66", cart.Items[0].Total)
+	assert.NotNil(t, cart.Items[0].Receipt)
+
+	assert.Equal(t, "pen-2", cart.Items[1].ProductID)
+	assert.Equal(t, "1", cart.Items[1].Quantity.Count)
+	assert.Equal(t, "33.34", cart.Items[1].DiscountedUnitPrice)
+	assert.Equal(t, "40.00", cart.Items[1].Subtotal)
+	assert.Equal(t, "33.34", cart.Items[1].Total)
+	assert.NotNil(t, cart.Items[1].Receipt)
+	assert.Equal(t, "100.00", cart.Total.Amount)
+}
@@ -0,0 +1,115 @@
+package receipt
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/metalmon/yapay-sdk"
+	"github.com/metalmon/yapay-sdk/merchantapi"
+)
+
+// ApplyTo attaches the fiscal data of every receipt item to the cart item
+// with the same product ID. Every cart item must have a receipt item.
+// A cart item whose receipt was split by FromCart is split the same way;
+// the extra lines get the product ID suffixed with their number.
+func (r *Receipt) ApplyTo(cart *merchantapi.Cart) error {
+	items := make(map[string][]*Item, len(r.Items))
+	for i := range r.Items {
+		items[r.Items[i].ProductID] = append(items[r.Items[i].ProductID], &r.Items[i])
+	}
+
+	out := make([]merchantapi.CartItem, 0, len(r.Items))
+	for i := range cart.Items {
+		matched := items[cart.Items[i].ProductID]
+		switch len(matched) {
+		case 0:
+			return yapay.Errorf(yapay.ErrorCodeValidation, "no receipt item for product %s", cart.Items[i].ProductID).
+				WithDetail("receipt.items", "Every cart item needs a receipt item")
+		case 1:
+			cart.Items[i].Receipt = matched[0].YandexReceipt()
+			out = append(out, cart.Items[i])
+		default:
+			lines, err := splitCartItem(cart.Items[i], matched, r.Currency)
+			if err != nil {
+				return err
+			}
+			out = append(out, lines...)
+		}
+	}
+	cart.Items = out
+	return nil
+}
+
+// splitCartItem turns a cart item into one line per receipt item, keeping
+// the item totals in line with the receipt prices
+func splitCartItem(line merchantapi.CartItem, items []*Item, currency string) ([]merchantapi.CartItem, error) {
+	unitPrice, err := yapay.ParseMoney(line.UnitPrice, currency)
+	if err != nil {
+		return nil, err
+	}
+	format := func(minor int) (string, error) {
+		return yapay.NewMoney(int64(minor), currency).Decimal()
+	}
+
+	out := make([]merchantapi.CartItem, 0, len(items))
+	for j, item := range items {
+		split := line
+		if j > 0 {
+			split.ProductID = fmt.Sprintf("%s-%d", line.ProductID, j+1)
+		}
+		split.Quantity = merchantapi.ItemQuantity{Count: strconv.Itoa(item.Quantity)}
+
+		subtotal, err := unitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return nil, err
+		}
+		if split.Subtotal, err = subtotal.Decimal(); err != nil {
+			return nil, err
+		}
+		if split.DiscountedUnitPrice, err = format(item.Price); err != nil {
+			return nil, err
+		}
+		if split.Total, err = format(item.Amount); err != nil {
+			return nil, err
+		}
+		split.Receipt = item.YandexReceipt()
+		out = append(out, split)
+	}
+	return out, nil
+}
+
+// YandexReceipt converts the item into the Yandex Pay cart item receipt
+func (item *Item) YandexReceipt() *merchantapi.ItemReceipt {
+	out := &merchantapi.ItemReceipt{
+		Tax:                int(item.VAT),
+		Title:              item.Title,
+		Measure:            int(item.Measure),
+		PaymentMethodType:  string(item.PaymentMethod),
+		PaymentSubjectType: string(item.PaymentSubject),
+	}
+
+	if a := item.Agent; a != nil {
+		out.Agent = &merchantapi.ReceiptAgent{
+			AgentType: int(a.Type),
+			Operation: a.Operation,
+			Phones:    a.Phones,
+		}
+		if len(a.PaymentsOperatorPhones) > 0 {
+			out.Agent.PaymentsOperator = &merchantapi.PaymentsOperator{Phones: a.PaymentsOperatorPhones}
+		}
+		if op := a.TransferOperator; op != nil {
+			out.Agent.TransferOperator = &merchantapi.TransferOperator{
+				INN:     op.INN,
+				Name:    op.Name,
+				Address: op.Address,
+				Phones:  op.Phones,
+			}
+		}
+		out.Supplier = &merchantapi.ReceiptSupplier{
+			INN:    a.Supplier.INN,
+			Name:   a.Supplier.Name,
+			Phones: a.Supplier.Phones,
+		}
+	}
+	return out
+}
@@ -0,0 +1,180 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RefundStatus represents the status of a refund operation
+type RefundStatus string
+
+// Refund statuses
+const (
+	RefundStatusPending RefundStatus = "pending"
+	RefundStatusSuccess RefundStatus = "success"
+	RefundStatusFailed  RefundStatus = "failed"
+)
+
+// ErrRefundApplied is returned by Payment.ApplyRefund for a refund ID that has
+// already been applied to the payment
+var ErrRefundApplied = errors.New("refund already applied")
+
+// RefundRequest represents a request to refund a payment fully or partially
+type RefundRequest struct {
+	PaymentID           string `json:"payment_id"`
+	Amount              int    `json:"amount"` // Amount to refund, in minor units
+	Currency            string `json:"currency"`
+	Reason              string `json:"reason,omitempty"`
+	ExternalOperationID string `json:"external_operation_id,omitempty"`
+}
+
+// Refund represents a refund of a payment
+type Refund struct {
+	ID                  string       `json:"id"`
+	PaymentID           string       `json:"payment_id"`
+	OrderID             string       `json:"order_id"`
+	Amount              int          `json:"amount"` // Refunded amount, in minor units
+	Currency            string       `json:"currency"`
+	Reason              string       `json:"reason,omitempty"`
+	Status              RefundStatus `json:"status"`
+	ExternalOperationID string       `json:"external_operation_id,omitempty"`
+	CreatedAt           string       `json:"created_at,omitempty"`
+}
+
+// RefundHandler is an optional interface for plugins that react to refunds.
+// The host discovers it with AsRefundHandler.
+type RefundHandler interface {
+	// HandlePaymentRefunded is called when the payment has been refunded in full
+	HandlePaymentRefunded(ctx context.Context, payment *Payment, refund *Refund) error
+	// HandlePartialRefund is called when a part of the payment has been refunded
+	HandlePartialRefund(ctx context.Context, payment *Payment, refund *Refund) error
+}
+
+// AsRefundHandler returns the plugin's RefundHandler, looking through SDK adapters
+func AsRefundHandler(handler interface{}) (RefundHandler, bool) {
+	h, ok := UnwrapHandler(handler).(RefundHandler)
+	return h, ok
+}
+
+// RefundableAmount returns the amount that can still be refunded, in minor units
+func (p *Payment) RefundableAmount() int {
+	if p.Status != PaymentStatusSuccess && p.Status != PaymentStatusPartiallyRefunded {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
+
+// Validate checks the request against the payment it refunds
+func (r *RefundRequest) Validate(payment *Payment) error {
+	if r.PaymentID != "" && r.PaymentID != payment.ID {
+		return Errorf(ErrorCodeValidation, "refund is for payment %s, not %s", r.PaymentID, payment.ID).
+			WithDetail("payment_id", "Payment ID does not match")
+	}
+	if r.Amount <= 0 {
+		return Errorf(ErrorCodeValidation, "refund amount must be positive, got: %d", r.Amount).
+			WithDetail("amount", "Amount must be positive")
+	}
+	if r.Currency != "" && !strings.EqualFold(r.Currency, payment.Currency) {
+		return Errorf(ErrorCodeValidation, "refund currency %s does not match payment currency %s", r.Currency, payment.Currency).
+			WithDetail("currency", "Currency does not match the payment")
+	}
+	if err := payment.Status.ValidateTransition(PaymentStatusRefunded); err != nil {
+		return err
+	}
+	if remaining := payment.RefundableAmount(); r.Amount > remaining {
+		return Errorf(ErrorCodeValidation, "refund amount %d exceeds refundable amount %d", r.Amount, remaining).
+			WithDetail("amount", "Amount exceeds the refundable amount")
+	}
+	return nil
+}
+
+// NewRefund validates the request and creates a pending refund for the payment
+func (r *RefundRequest) NewRefund(payment *Payment, id string) (*Refund, error) {
+	if err := r.Validate(payment); err != nil {
+		return nil, err
+	}
+	return &Refund{
+		ID:                  id,
+		PaymentID:           payment.ID,
+		OrderID:             payment.OrderID,
+		Amount:              r.Amount,
+		Currency:            payment.Currency,
+		Reason:              r.Reason,
+		Status:              RefundStatusPending,
+		ExternalOperationID: r.ExternalOperationID,
+		CreatedAt:           time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// HasRefund reports whether the refund with the given ID has been applied
+func (p *Payment) HasRefund(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, applied := range p.RefundIDs {
+		if applied == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyRefund records a completed refund against the payment. It moves the
+// payment to refunded when nothing is left to refund and to partially_refunded
+// otherwise, and reports whether the refund was partial. A refund ID that has
+// already been applied returns ErrRefundApplied and leaves the payment as is.
+func (p *Payment) ApplyRefund(refund *Refund) (partial bool, err error) {
+	if p.HasRefund(refund.ID) {
+		return false, ErrRefundApplied
+	}
+	req := RefundRequest{PaymentID: refund.PaymentID, Amount: refund.Amount, Currency: refund.Currency}
+	if err := req.Validate(p); err != nil {
+		return false, err
+	}
+
+	next := PaymentStatusRefunded
+	if p.RefundedAmount+refund.Amount < p.Amount {
+		next = PaymentStatusPartiallyRefunded
+	}
+	if err := p.TransitionTo(next); err != nil {
+		return false, err
+	}
+	p.RefundedAmount += refund.Amount
+	if refund.ID != "" {
+		p.RefundIDs = append(p.RefundIDs, refund.ID)
+	}
+	return next == PaymentStatusPartiallyRefunded, nil
+}
+
+// DispatchRefund calls the matching RefundHandler method with the payment as
+// it will be after the refund, and applies the refund to payment only when
+// the handler succeeds, so a failed handler can be retried on redelivery.
+// A refund that has already been applied is skipped, as are handlers
+// without refund support (the refund is still applied).
+func DispatchRefund(ctx context.Context, handler interface{}, payment *Payment, refund *Refund) error {
+	if payment.HasRefund(refund.ID) {
+		return nil
+	}
+
+	updated := *payment
+	updated.RefundIDs = append([]string(nil), payment.RefundIDs...)
+	partial, err := updated.ApplyRefund(refund)
+	if err != nil {
+		return err
+	}
+
+	if refundHandler, ok := AsRefundHandler(handler); ok {
+		if partial {
+			err = refundHandler.HandlePartialRefund(ctx, &updated, refund)
+		} else {
+			err = refundHandler.HandlePaymentRefunded(ctx, &updated, refund)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	*payment = updated
+	return nil
+}
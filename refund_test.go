@@ -0,0 +1,150 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refundRecorder implements only RefundHandler
+type refundRecorder struct {
+	full    []*Refund
+	partial []*Refund
+	err     error
+}
+
+func (r *refundRecorder) HandlePaymentRefunded(_ context.Context, _ *Payment, refund *Refund) error {
+	r.full = append(r.full, refund)
+	return r.err
+}
+
+func (r *refundRecorder) HandlePartialRefund(_ context.Context, _ *Payment, refund *Refund) error {
+	r.partial = append(r.partial, refund)
+	return r.err
+}
+
+func newSucceededPayment() *Payment {
+	return &Payment{ID: "p1", OrderID: "o1", Amount: 1000, Currency: "RUB", Status: PaymentStatusSuccess}
+}
+
+func TestPayment_ApplyRefund(t *testing.T) {
+	payment := newSucceededPayment()
+
+	partial, err := payment.ApplyRefund(&Refund{PaymentID: "p1", Amount: 300, Currency: "RUB"})
+	require.NoError(t, err)
+	assert.True(t, partial)
+	assert.Equal(t, PaymentStatusPartiallyRefunded, payment.Status)
+	assert.Equal(t, 300, payment.RefundedAmount)
+	assert.Equal(t, 700, payment.RefundableAmount())
+
+	partial, err = payment.ApplyRefund(&Refund{PaymentID: "p1", Amount: 200, Currency: "RUB"})
+	require.NoError(t, err)
+	assert.True(t, partial)
+
+	partial, err = payment.ApplyRefund(&Refund{PaymentID: "p1", Amount: 500, Currency: "RUB"})
+	require.NoError(t, err)
+	assert.False(t, partial)
+	assert.Equal(t, PaymentStatusRefunded, payment.Status)
+	assert.Equal(t, 0, payment.RefundableAmount())
+
+	_, err = payment.ApplyRefund(&Refund{PaymentID: "p1", Amount: 1, Currency: "RUB"})
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+}
+
+func TestPayment_ApplyRefundOnce(t *testing.T) {
+	payment := newSucceededPayment()
+	refund := &Refund{ID: "r1", PaymentID: "p1", Amount: 300, Currency: "RUB"}
+
+	_, err := payment.ApplyRefund(refund)
+	require.NoError(t, err)
+	assert.True(t, payment.HasRefund("r1"))
+
+	_, err = payment.ApplyRefund(refund)
+	assert.True(t, errors.Is(err, ErrRefundApplied))
+	assert.Equal(t, 300, payment.RefundedAmount)
+	assert.Equal(t, []string{"r1"}, payment.RefundIDs)
+}
+
+func TestRefundRequest_Validate(t *testing.T) {
+	payment := newSucceededPayment()
+	payment.RefundedAmount = 900
+	payment.Status = PaymentStatusPartiallyRefunded
+
+	tests := []struct {
+		name string
+		req  RefundRequest
+	}{
+		{"exceeds refundable amount", RefundRequest{Amount: 101}},
+		{"zero amount", RefundRequest{Amount: 0}},
+		{"other currency", RefundRequest{Amount: 50, Currency: "USD"}},
+		{"other payment", RefundRequest{PaymentID: "p2", Amount: 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, errors.Is(tt.req.Validate(payment), ErrValidation))
+		})
+	}
+
+	require.NoError(t, (&RefundRequest{Amount: 100, Currency: "rub"}).Validate(payment))
+
+	canceled := newSucceededPayment()
+	canceled.Status = PaymentStatusCanceled
+	err := (&RefundRequest{Amount: 100}).Validate(canceled)
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+	assert.Equal(t, ErrorCodeConflict, ErrorCodeOf(err))
+}
+
+func TestRefundRequest_NewRefund(t *testing.T) {
+	payment := newSucceededPayment()
+	refund, err := (&RefundRequest{Amount: 400, Reason: "damaged"}).NewRefund(payment, "r1")
+	require.NoError(t, err)
+	assert.Equal(t, "r1", refund.ID)
+	assert.Equal(t, "o1", refund.OrderID)
+	assert.Equal(t, "RUB", refund.Currency)
+	assert.Equal(t, RefundStatusPending, refund.Status)
+
+	// Creating a refund does not change the payment until it is applied
+	assert.Equal(t, 0, payment.RefundedAmount)
+}
+
+func TestDispatchRefund(t *testing.T) {
+	recorder := &refundRecorder{}
+	payment := newSucceededPayment()
+	ctx := context.Background()
+
+	require.NoError(t, DispatchRefund(ctx, recorder, payment, &Refund{Amount: 250, Currency: "RUB"}))
+	require.NoError(t, DispatchRefund(ctx, recorder, payment, &Refund{Amount: 750, Currency: "RUB"}))
+	assert.Len(t, recorder.partial, 1)
+	assert.Len(t, recorder.full, 1)
+
+	// Handlers without refund support still get the payment updated
+	other := newSucceededPayment()
+	require.NoError(t, DispatchRefund(ctx, struct{}{}, other, &Refund{Amount: 1000, Currency: "RUB"}))
+	assert.Equal(t, PaymentStatusRefunded, other.Status)
+}
+
+func TestDispatchRefund_HandlerFailure(t *testing.T) {
+	recorder := &refundRecorder{err: errors.New("backend down")}
+	payment := newSucceededPayment()
+	refund := &Refund{ID: "r1", Amount: 400, Currency: "RUB"}
+	ctx := context.Background()
+
+	// The payment is untouched, so the redelivered refund is handled again
+	require.Error(t, DispatchRefund(ctx, recorder, payment, refund))
+	assert.Equal(t, PaymentStatusSuccess, payment.Status)
+	assert.Zero(t, payment.RefundedAmount)
+	assert.Empty(t, payment.RefundIDs)
+
+	recorder.err = nil
+	require.NoError(t, DispatchRefund(ctx, recorder, payment, refund))
+	assert.Len(t, recorder.partial, 2)
+	assert.Equal(t, 400, payment.RefundedAmount)
+
+	// A replay of an applied refund does nothing
+	require.NoError(t, DispatchRefund(ctx, recorder, payment, refund))
+	assert.Len(t, recorder.partial, 2)
+	assert.Equal(t, 400, payment.RefundedAmount)
+}
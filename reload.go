@@ -0,0 +1,193 @@
+package yapay
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchInterval is how often ConfigWatcher checks config.yaml
+const DefaultWatchInterval = 5 * time.Second
+
+// ErrConfigVetoed is returned when a Reconfigurable plugin rejects a new config
+var ErrConfigVetoed = errors.New("config change vetoed")
+
+// Reconfigurable is implemented by handlers that can apply a new merchant
+// config without a restart. Returning an error vetoes the change: the host
+// keeps the old config and calls OnConfigChange(new, old) on the handlers
+// that already accepted it.
+type Reconfigurable interface {
+	OnConfigChange(old, new *Merchant) error
+}
+
+// AsReconfigurable returns the Reconfigurable implementation of a handler,
+// looking through adapters such as AdaptHandler
+func AsReconfigurable(handler interface{}) (Reconfigurable, bool) {
+	r, ok := UnwrapHandler(handler).(Reconfigurable)
+	return r, ok
+}
+
+// ConfigWatcher reloads a merchant config.yaml when its contents change.
+// New versions are validated by LoadMerchantConfig rules, offered to
+// Reconfigurable handlers and then swapped in atomically; Current always
+// returns a complete, valid config.
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	loadOpts []LoadOption
+	onReload func(old, new *Merchant, err error)
+
+	current atomic.Pointer[Merchant]
+
+	mu       sync.Mutex
+	sum      [sha256.Size]byte
+	handlers []interface{}
+}
+
+// WatchOption configures a ConfigWatcher
+type WatchOption func(*ConfigWatcher)
+
+// WithWatchInterval sets the polling interval
+func WithWatchInterval(d time.Duration) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.interval = d
+	}
+}
+
+// WithWatchLoadOptions passes options such as WithSecretProvider to every load
+func WithWatchLoadOptions(opts ...LoadOption) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.loadOpts = append(w.loadOpts, opts...)
+	}
+}
+
+// WithReloadCallback is called after every reload attempt triggered by a
+// change: with the swapped configs on success, or with the error when the
+// new version was invalid or vetoed
+func WithReloadCallback(fn func(old, new *Merchant, err error)) WatchOption {
+	return func(w *ConfigWatcher) {
+		w.onReload = fn
+	}
+}
+
+// NewConfigWatcher loads the config at path; it fails if the initial
+// version is invalid
+func NewConfigWatcher(path string, opts ...WatchOption) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{path: path, interval: DefaultWatchInterval}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	merchant, err := w.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	w.sum = sha256.Sum256(data)
+	w.current.Store(merchant)
+	return w, nil
+}
+
+// Current returns the active config. Callers must not modify it.
+func (w *ConfigWatcher) Current() *Merchant {
+	return w.current.Load()
+}
+
+// Subscribe registers a handler to be asked about config changes. Handlers
+// that do not implement Reconfigurable are ignored.
+func (w *ConfigWatcher) Subscribe(handler interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
+// Run checks the file every interval until ctx is done. Reload errors are
+// reported through WithReloadCallback and do not stop the watcher.
+func (w *ConfigWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_, _ = w.Reload()
+		}
+	}
+}
+
+// Reload applies the file if its contents changed since the last attempt.
+// It reports whether a new config was swapped in. A version that failed
+// validation or was vetoed is not retried until the file changes again.
+func (w *ConfigWatcher) Reload() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config file: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if sum == w.sum {
+		return false, nil
+	}
+	w.sum = sum
+
+	old := w.current.Load()
+	merchant, err := w.parse(data)
+	if err == nil && merchant.ID != old.ID {
+		err = fmt.Errorf("%w: merchant id cannot change from %q to %q", ErrInvalidConfig, old.ID, merchant.ID)
+	}
+	if err == nil {
+		err = w.offer(old, merchant)
+	}
+	if err == nil {
+		w.current.Store(merchant)
+	}
+
+	if w.onReload != nil {
+		w.onReload(old, merchant, err)
+	}
+	return err == nil, err
+}
+
+// parse loads data with the watcher's options, attributing issues to the file
+func (w *ConfigWatcher) parse(data []byte) (*Merchant, error) {
+	merchant, err := ParseMerchantConfig(data, w.loadOpts...)
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		configErr.File = w.path
+	}
+	return merchant, err
+}
+
+// offer asks every Reconfigurable handler to accept the change and rolls
+// back the ones that accepted if a later one vetoes
+func (w *ConfigWatcher) offer(old, new *Merchant) error {
+	var accepted []Reconfigurable
+	for _, handler := range w.handlers {
+		r, ok := AsReconfigurable(handler)
+		if !ok {
+			continue
+		}
+		if err := r.OnConfigChange(old, new); err != nil {
+			errs := []error{fmt.Errorf("%w by %T: %w", ErrConfigVetoed, UnwrapHandler(handler), err)}
+			for i := len(accepted) - 1; i >= 0; i-- {
+				if rbErr := accepted[i].OnConfigChange(new, old); rbErr != nil {
+					errs = append(errs, fmt.Errorf("rollback of %T failed: %w", accepted[i], rbErr))
+				}
+			}
+			return errors.Join(errs...)
+		}
+		accepted = append(accepted, r)
+	}
+	return nil
+}
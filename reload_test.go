@@ -0,0 +1,150 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchedConfig = `id: shop
+name: Shop
+security:
+  rate_limit: %d
+yandex:
+  merchant_id: m-1
+  sandbox_mode: true
+`
+
+type reconfigurableHandler struct {
+	veto    error
+	changes [][2]int
+}
+
+func (h *reconfigurableHandler) OnConfigChange(old, new *Merchant) error {
+	if h.veto != nil {
+		return h.veto
+	}
+	h.changes = append(h.changes, [2]int{old.Security.RateLimit, new.Security.RateLimit})
+	return nil
+}
+
+func writeWatchedConfig(t *testing.T, path, config string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+}
+
+func rateLimitConfig(limit int) string {
+	return fmt.Sprintf(watchedConfig, limit)
+}
+
+func TestConfigWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeWatchedConfig(t, path, rateLimitConfig(10))
+
+	var reloads []error
+	w, err := NewConfigWatcher(path, WithReloadCallback(func(old, new *Merchant, err error) {
+		reloads = append(reloads, err)
+	}))
+	require.NoError(t, err)
+	first := &reconfigurableHandler{}
+	w.Subscribe(first)
+	w.Subscribe(struct{}{}) // not Reconfigurable, ignored
+
+	changed, err := w.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed, "unchanged file")
+
+	old := w.Current()
+	writeWatchedConfig(t, path, rateLimitConfig(20))
+	changed, err = w.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 20, w.Current().Security.RateLimit)
+	assert.Equal(t, 10, old.Security.RateLimit, "the old config is not modified")
+	assert.Equal(t, [][2]int{{10, 20}}, first.changes)
+
+	// Invalid versions keep the current config and are not retried
+	writeWatchedConfig(t, path, "id: shop\nname: Shop\n")
+	_, err = w.Reload()
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	assert.Contains(t, err.Error(), path)
+	assert.Equal(t, 20, w.Current().Security.RateLimit)
+	changed, err = w.Reload()
+	assert.False(t, changed)
+	assert.NoError(t, err)
+
+	// The merchant ID cannot change
+	writeWatchedConfig(t, path, strings.Replace(rateLimitConfig(30), "id: shop", "id: other", 1))
+	_, err = w.Reload()
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	assert.Len(t, reloads, 3)
+}
+
+func TestConfigWatcher_VetoRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeWatchedConfig(t, path, rateLimitConfig(10))
+	w, err := NewConfigWatcher(path)
+	require.NoError(t, err)
+
+	accepting := &reconfigurableHandler{}
+	vetoing := &reconfigurableHandler{veto: errors.New("rate limit too high")}
+	w.Subscribe(accepting)
+	w.Subscribe(vetoing)
+
+	writeWatchedConfig(t, path, rateLimitConfig(1000))
+	changed, err := w.Reload()
+	assert.False(t, changed)
+	assert.True(t, errors.Is(err, ErrConfigVetoed))
+	assert.Contains(t, err.Error(), "rate limit too high")
+
+	assert.Equal(t, 10, w.Current().Security.RateLimit)
+	assert.Equal(t, [][2]int{{10, 1000}, {1000, 10}}, accepting.changes, "accepted change is rolled back")
+}
+
+func TestConfigWatcher_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeWatchedConfig(t, path, rateLimitConfig(10))
+
+	reloaded := make(chan *Merchant, 1)
+	w, err := NewConfigWatcher(path,
+		WithWatchInterval(10*time.Millisecond),
+		WithReloadCallback(func(_, new *Merchant, err error) {
+			if err == nil {
+				reloaded <- new
+			}
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	writeWatchedConfig(t, path, rateLimitConfig(50))
+	select {
+	case m := <-reloaded:
+		assert.Equal(t, 50, m.Security.RateLimit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+	assert.Equal(t, 50, w.Current().Security.RateLimit)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestNewConfigWatcher_InvalidInitialConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeWatchedConfig(t, path, "id: shop\n")
+	_, err := NewConfigWatcher(path)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
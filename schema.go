@@ -0,0 +1,459 @@
+package yapay
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate go run ./internal/genschema -o docs/api-reference/merchant-config.schema.json
+
+// MerchantConfigSchemaID is the $id of the merchant config schema
+const MerchantConfigSchemaID = "https://github.com/metalmon/yapay-sdk/docs/api-reference/merchant-config.schema.json"
+
+// Schema is the subset of JSON Schema (draft 2020-12) used for merchant configs
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	ID          string `json:"$id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is nil (allowed), false or a *Schema for the values
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	Items                *Schema     `json:"items,omitempty"`
+
+	Enum      []string    `json:"enum,omitempty"`
+	Const     interface{} `json:"const,omitempty"`
+	Format    string      `json:"format,omitempty"`
+	Pattern   string      `json:"pattern,omitempty"`
+	MinLength *int        `json:"minLength,omitempty"`
+	Minimum   *float64    `json:"minimum,omitempty"`
+	Maximum   *float64    `json:"maximum,omitempty"`
+	Default   interface{} `json:"default,omitempty"`
+
+	If   *Schema `json:"if,omitempty"`
+	Then *Schema `json:"then,omitempty"`
+
+	// pattern is Pattern compiled when the schema is built or registered
+	pattern *regexp.Regexp
+	// schemaIssues are problems of the schema itself, such as invalid patterns
+	schemaIssues []ConfigIssue
+}
+
+// SchemaOption customizes the generated merchant config schema
+type SchemaOption func(*Schema)
+
+// WithMetadataSchema describes the plugin-specific "metadata" section.
+// The SDK description is kept when the plugin schema has none. Patterns are
+// compiled here; an invalid one makes Validate fail for every document.
+func WithMetadataSchema(metadata *Schema) SchemaOption {
+	return func(s *Schema) {
+		if metadata.Description == "" {
+			metadata.Description = s.Properties["metadata"].Description
+		}
+		s.Properties["metadata"] = metadata
+		s.schemaIssues = append(s.schemaIssues, compilePatterns(metadata, "metadata")...)
+	}
+}
+
+// compilePatterns compiles the patterns of s and its subschemas and
+// reports the invalid ones
+func compilePatterns(s *Schema, path string) []ConfigIssue {
+	if s == nil {
+		return nil
+	}
+
+	var issues []ConfigIssue
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			issues = append(issues, ConfigIssue{Path: path, Message: fmt.Sprintf("schema pattern %q is invalid: %v", s.Pattern, err)})
+		}
+		s.pattern = re
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		issues = append(issues, compilePatterns(s.Properties[name], joinPath(path, name))...)
+	}
+	if elem, ok := s.AdditionalProperties.(*Schema); ok {
+		issues = append(issues, compilePatterns(elem, path+"[]")...)
+	}
+	issues = append(issues, compilePatterns(s.Items, path+"[]")...)
+	issues = append(issues, compilePatterns(s.If, path)...)
+	issues = append(issues, compilePatterns(s.Then, path)...)
+	return issues
+}
+
+// schemaAnnotation adds what cannot be derived from Go types
+type schemaAnnotation struct {
+	description       string
+	required          []string
+	requiredIfEnabled []string
+	enum              []string
+	format            string
+	pattern           string
+	minimum, maximum  *float64
+	def               interface{}
+}
+
+func schemaNumber(f float64) *float64 { return &f }
+
+// merchantSchemaAnnotations are keyed by dotted YAML path
+var merchantSchemaAnnotations = map[string]schemaAnnotation{
+	"":             {description: "Конфигурация мерчанта (config.yaml плагина)", required: []string{"id", "name", "yandex"}},
+	"id":           {description: "Уникальный идентификатор клиента"},
+	"name":         {description: "Название мерчанта"},
+	"description":  {description: "Описание мерчанта"},
+	"domain":       {description: "Домен сайта мерчанта без схемы, например example.com", format: "hostname"},
+	"enabled":      {description: "Включен ли мерчант"},
+	"sandbox_mode": {description: "Тестовый режим; наследуется yandex.sandbox_mode, если тот не задан"},
+	"metadata":     {description: "Произвольные данные плагина"},
+	"field_labels": {description: "Подписи полей метаданных заказа в уведомлениях"},
+
+	"security":                     {description: "Настройки безопасности"},
+	"security.request_enforcement": {description: "Политика валидации запросов", enum: []string{EnforcementStrict, EnforcementOrigin, EnforcementMonitor}, def: DefaultRequestEnforcement},
+	"security.rate_limit":          {description: "Лимит запросов в минуту", minimum: schemaNumber(1), def: DefaultRateLimit},
+	"security.cors":                {description: "Настройки CORS"},
+	"security.cors.origins":        {description: "Разрешенные origins: \"*\" или схема://хост[:порт] без пути"},
+	"security.cors.origins[]":      {pattern: `^(\*|https?://[^/?#\s]+)$`},
+
+	"yandex":                  {description: "Настройки Яндекс Пэй", required: []string{"merchant_id"}},
+	"yandex.merchant_id":      {description: "ID мерчанта в Яндекс Пэй"},
+	"yandex.secret_key":       {description: "API-ключ мерчанта; обязателен вне тестового режима"},
+	"yandex.sandbox_mode":     {description: "Использовать песочницу Яндекс Пэй"},
+	"yandex.currency":         {description: "Валюта по ISO 4217", pattern: "^[A-Za-z]{3}$", def: DefaultCurrency},
+	"yandex.api_base_url":     {description: "Базовый URL Merchant API", format: "uri"},
+	"yandex.orders_endpoint":  {description: "Путь (от api_base_url) или полный URL эндпоинта заказов"},
+	"yandex.jwks_endpoint":    {description: "URL JWKS для проверки подписи webhook'ов", format: "uri"},
+	"yandex.private_key_path": {description: "Путь к закрытому ключу мерчанта"},
+
+	"notifications":                              {description: "Настройки уведомлений"},
+	"notifications.telegram":                     {description: "Уведомления в Telegram", requiredIfEnabled: []string{"chat_id", "bot_token"}},
+	"notifications.telegram.enabled":             {description: "Включить уведомления в Telegram"},
+	"notifications.telegram.chat_id":             {description: "ID чата"},
+	"notifications.telegram.bot_token":           {description: "Токен бота"},
+	"notifications.telegram.api_base_url":        {description: "URL Bot API, если используется свой сервер", format: "uri"},
+	"notifications.email":                        {description: "Уведомления по email", requiredIfEnabled: []string{"smtp_host", "from"}},
+	"notifications.email.enabled":                {description: "Включить уведомления по email"},
+	"notifications.email.smtp_host":              {description: "SMTP-сервер"},
+	"notifications.email.smtp_port":              {description: "Порт SMTP-сервера", minimum: schemaNumber(1), maximum: schemaNumber(65535), def: DefaultSMTPPort},
+	"notifications.email.username":               {description: "Имя пользователя SMTP"},
+	"notifications.email.password":               {description: "Пароль SMTP"},
+	"notifications.email.from":                   {description: "Адрес отправителя", format: "email"},
+	"notifications.email.to":                     {description: "Адреса получателей"},
+	"notifications.email.to[]":                   {format: "email"},
+	"notifications.email.tls":                    {description: "Защита соединения: starttls, tls (SMTPS) или none", enum: []string{SMTPTLSStartTLS, SMTPTLSImplicit, SMTPTLSNone}, def: DefaultSMTPTLS},
+	"notifications.templates":                    {description: "Шаблоны сообщений по типам уведомлений: payment_created, payment_success, payment_failed, system_error, webhook, digest"},
+	"notifications.templates[]":                  {description: "Шаблоны одного типа уведомлений; пустые поля оставляют встроенный шаблон"},
+	"notifications.templates[].subject":          {description: "Тема письма (text/template)"},
+	"notifications.templates[].text":             {description: "Текст письма (text/template)"},
+	"notifications.templates[].html":             {description: "HTML письма (html/template)"},
+	"notifications.templates[].telegram":         {description: "Сообщение в Telegram; html/template в режиме HTML"},
+	"notifications.templates[].parse_mode":       {description: "Режим разметки Telegram", enum: []string{ParseModeHTML, ParseModeMarkdownV2, ParseModeText}, def: ParseModeHTML},
+	"notifications.email.insecure_skip_verify":   {description: "Не проверять сертификат SMTP-сервера (только для тестов)"},
+	"notifications.rules":                        {description: "Правила маршрутизации уведомлений; без правил уведомления уходят во все включенные каналы"},
+	"notifications.rules[]":                      {description: "Правило: уведомление отправляется по каждому подходящему правилу"},
+	"notifications.rules[].name":                 {description: "Название правила для логов"},
+	"notifications.rules[].types":                {description: "Типы уведомлений; пусто - все типы"},
+	"notifications.rules[].types[]":              {enum: notificationTypeNames()},
+	"notifications.rules[].channels":             {description: "Каналы; пусто - все включенные"},
+	"notifications.rules[].channels[]":           {enum: []string{NotificationChannelTelegram, NotificationChannelEmail}},
+	"notifications.rules[].recipients":           {description: "Получатели вместо настроенных в каналах"},
+	"notifications.rules[].recipients.telegram":  {description: "ID чатов Telegram"},
+	"notifications.rules[].recipients.email":     {description: "Адреса email"},
+	"notifications.rules[].recipients.email[]":   {format: "email"},
+	"notifications.rules[].min_amount":           {description: "Минимальная сумма платежа в валюте платежа, например 1000.00", pattern: `^\d+(\.\d+)?$`},
+	"notifications.rules[].max_amount":           {description: "Максимальная сумма платежа в валюте платежа", pattern: `^\d+(\.\d+)?$`},
+	"notifications.rules[].quiet_hours":          {description: "Тихие часы: доставка откладывается до их окончания", required: []string{"start", "end"}},
+	"notifications.rules[].quiet_hours.start":    {description: "Начало, ЧЧ:ММ", pattern: `^([01]\d|2[0-3]):[0-5]\d$`},
+	"notifications.rules[].quiet_hours.end":      {description: "Окончание, ЧЧ:ММ; может быть после полуночи", pattern: `^([01]\d|2[0-3]):[0-5]\d$`},
+	"notifications.rules[].quiet_hours.timezone": {description: "Часовой пояс IANA, например Europe/Moscow", def: "UTC"},
+	"notifications.rules[].rate_limit":           {description: "Не больше max уведомлений за period; лишние отбрасываются", required: []string{"max"}},
+	"notifications.rules[].rate_limit.max":       {description: "Число уведомлений", minimum: schemaNumber(1)},
+	"notifications.rules[].rate_limit.period":    {description: "Период, например 10m или 1h", def: DefaultNotificationRatePeriod},
+	"notifications.digest":                       {description: "Настройки сводок, в которые правила с digest собирают уведомления"},
+	"notifications.digest.timezone":              {description: "Часовой пояс окон сводок, IANA", def: "UTC"},
+	"notifications.digest.daily_at":              {description: "Время отправки ежедневной сводки, ЧЧ:ММ", pattern: `^([01]\d|2[0-3]):[0-5]\d$`, def: "00:00"},
+	"notifications.digest.max_payments":          {description: "Сколько платежей перечислять в сводке; итоги учитывают все", minimum: schemaNumber(0), def: DefaultDigestMaxPayments},
+	"notifications.rules[].digest":               {description: "Собирать уведомления в сводку вместо отправки по одному", enum: []string{DigestHourly, DigestDaily}},
+}
+
+// notificationTypeNames lists the known notification types for enums
+func notificationTypeNames() []string {
+	names := make([]string, len(NotificationTypes))
+	for i, t := range NotificationTypes {
+		names[i] = string(t)
+	}
+	return names
+}
+
+// MerchantConfigSchema generates the JSON Schema of config.yaml from the
+// Merchant struct and its yaml tags
+func MerchantConfigSchema(opts ...SchemaOption) *Schema {
+	s := schemaForType(reflect.TypeOf(Merchant{}), "")
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = MerchantConfigSchemaID
+	s.Title = "Yapay merchant config"
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// schemaForType builds the schema of t at the given YAML path
+func schemaForType(t reflect.Type, path string) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	s := &Schema{}
+	switch t.Kind() {
+	case reflect.Struct:
+		s.Type = "object"
+		s.Properties = make(map[string]*Schema)
+		s.AdditionalProperties = false
+		for name, field := range yamlFields(t) {
+			s.Properties[name] = schemaForType(field.Type, joinPath(path, name))
+		}
+	case reflect.Map:
+		s.Type = "object"
+		if elem := schemaForType(t.Elem(), path+"[]"); elem.Type != "" {
+			s.AdditionalProperties = elem
+		}
+	case reflect.Slice, reflect.Array:
+		s.Type = "array"
+		s.Items = schemaForType(t.Elem(), path+"[]")
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		s.Type = "number"
+	}
+
+	a := merchantSchemaAnnotations[path]
+	s.Description = a.description
+	s.Required = a.required
+	s.Enum = a.enum
+	s.Format = a.format
+	s.Pattern = a.pattern
+	if a.pattern != "" {
+		s.pattern = regexp.MustCompile(a.pattern)
+	}
+	s.Minimum = a.minimum
+	s.Maximum = a.maximum
+	s.Default = a.def
+	if len(a.requiredIfEnabled) > 0 {
+		s.If = &Schema{
+			Properties: map[string]*Schema{"enabled": {Const: true}},
+			Required:   []string{"enabled"},
+		}
+		s.Then = &Schema{Required: a.requiredIfEnabled}
+	}
+	return s
+}
+
+// Validate checks a YAML or JSON document against the schema and reports
+// every problem with its line number. Scalars containing ${VAR} references
+// are accepted for any scalar type, since they are expanded at load time.
+func (s *Schema) Validate(data []byte) error {
+	if len(s.schemaIssues) > 0 {
+		return &ConfigError{Issues: append([]ConfigIssue(nil), s.schemaIssues...)}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return &ConfigError{Issues: []ConfigIssue{yamlIssue(err.Error())}}
+	}
+	if len(doc.Content) == 0 {
+		return &ConfigError{Issues: []ConfigIssue{{Message: "config is empty"}}}
+	}
+
+	v := &configValidator{}
+	v.checkSchema(s, doc.Content[0], "")
+	return v.err()
+}
+
+// addAt records an issue at the position of node
+func (v *configValidator) addAt(node *yaml.Node, path, format string, args ...interface{}) {
+	v.issues = append(v.issues, ConfigIssue{
+		Path:    path,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkSchema validates node against s
+func (v *configValidator) checkSchema(s *Schema, node *yaml.Node, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		return
+	}
+	if !v.checkType(s, node, path) {
+		return
+	}
+
+	if s.Const != nil && node.Value != fmt.Sprint(s.Const) {
+		v.addAt(node, path, "must be %v", s.Const)
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() != "!!null" {
+		v.checkScalar(s, node, path)
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.checkMapping(s, node, path)
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range node.Content {
+				v.checkSchema(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+
+	if s.If != nil && s.Then != nil {
+		probe := &configValidator{}
+		probe.checkSchema(s.If, node, path)
+		if len(probe.issues) == 0 {
+			v.checkSchema(s.Then, node, path)
+		}
+	}
+}
+
+// checkType reports whether node has the schema type; null matches any scalar type
+func (v *configValidator) checkType(s *Schema, node *yaml.Node, path string) bool {
+	tag := node.ShortTag()
+	var ok bool
+	switch s.Type {
+	case "":
+		return true
+	case "object":
+		ok = node.Kind == yaml.MappingNode
+	case "array":
+		ok = node.Kind == yaml.SequenceNode
+	case "string":
+		ok = node.Kind == yaml.ScalarNode
+	case "integer":
+		ok = node.Kind == yaml.ScalarNode && (tag == "!!int" || tag == "!!null")
+	case "number":
+		ok = node.Kind == yaml.ScalarNode && (tag == "!!int" || tag == "!!float" || tag == "!!null")
+	case "boolean":
+		ok = node.Kind == yaml.ScalarNode && (tag == "!!bool" || tag == "!!null")
+	}
+	if !ok {
+		v.addAt(node, path, "must be of type %s", s.Type)
+	}
+	return ok
+}
+
+// checkScalar applies the value constraints of s; empty values count as unset
+func (v *configValidator) checkScalar(s *Schema, node *yaml.Node, path string) {
+	value := node.Value
+	if value == "" {
+		return
+	}
+	if len(s.Enum) > 0 && !containsString(s.Enum, value) {
+		v.addAt(node, path, "must be one of %s, got %q", strings.Join(s.Enum, ", "), value)
+	}
+	if s.Pattern != "" {
+		re := s.pattern
+		if re == nil {
+			// Schemas built by hand are compiled on use
+			var err error
+			if re, err = regexp.Compile(s.Pattern); err != nil {
+				v.addAt(node, path, "schema pattern %q is invalid: %v", s.Pattern, err)
+			}
+		}
+		if re != nil && !re.MatchString(value) {
+			v.addAt(node, path, "must match %s, got %q", s.Pattern, value)
+		}
+	}
+	if s.MinLength != nil && len([]rune(value)) < *s.MinLength {
+		v.addAt(node, path, "must be at least %d characters", *s.MinLength)
+	}
+
+	switch s.Format {
+	case "uri":
+		if !validHTTPURL(value) {
+			v.addAt(node, path, "must be an absolute http(s) URL, got %q", value)
+		}
+	case "email":
+		if _, err := mail.ParseAddress(value); err != nil {
+			v.addAt(node, path, "must be an email address, got %q", value)
+		}
+	case "hostname":
+		if !validHostname(value) {
+			v.addAt(node, path, "must be a host name, got %q", value)
+		}
+	}
+
+	if s.Minimum != nil || s.Maximum != nil {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			v.addAt(node, path, "must be at least %v, got %s", *s.Minimum, value)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			v.addAt(node, path, "must be at most %v, got %s", *s.Maximum, value)
+		}
+	}
+}
+
+// checkMapping checks required, known and additional properties
+func (v *configValidator) checkMapping(s *Schema, node *yaml.Node, path string) {
+	present := make(map[string]bool, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		child := joinPath(path, key.Value)
+		present[key.Value] = true
+
+		if prop, ok := s.Properties[key.Value]; ok {
+			v.checkSchema(prop, value, child)
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case bool:
+			if !extra {
+				v.addAt(key, child, "unknown key %q", key.Value)
+			}
+		case *Schema:
+			v.checkSchema(extra, value, child)
+		}
+	}
+
+	for _, name := range s.Required {
+		if !present[name] {
+			v.addAt(node, joinPath(path, name), "is required")
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
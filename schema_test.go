@@ -0,0 +1,134 @@
+package yapay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerchantConfigSchema_UpToDate(t *testing.T) {
+	shipped, err := os.ReadFile(filepath.Join("docs", "api-reference", "merchant-config.schema.json"))
+	require.NoError(t, err)
+
+	generated, err := json.MarshalIndent(MerchantConfigSchema(), "", "  ")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(generated), string(shipped), "run go generate to refresh the shipped schema")
+}
+
+func TestMerchantConfigSchema(t *testing.T) {
+	s := MerchantConfigSchema()
+	assert.Equal(t, []string{"id", "name", "yandex"}, s.Required)
+	assert.Equal(t, false, s.AdditionalProperties)
+
+	enforcement := s.Properties["security"].Properties["request_enforcement"]
+	assert.Equal(t, []string{"strict", "origin", "monitor"}, enforcement.Enum)
+	assert.Equal(t, EnforcementStrict, enforcement.Default)
+	assert.NotEmpty(t, enforcement.Description)
+
+	telegram := s.Properties["notifications"].Properties["telegram"]
+	require.NotNil(t, telegram.Then)
+	assert.Equal(t, []string{"chat_id", "bot_token"}, telegram.Then.Required)
+}
+
+func TestSchema_Validate(t *testing.T) {
+	s := MerchantConfigSchema()
+
+	// The example plugin config must match the schema
+	data, err := os.ReadFile(filepath.Join("examples", "simple-plugin", "config.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, s.Validate(data))
+
+	data = []byte(`id: shop
+name: Shop
+domain: "https://shop.example.com"
+cors_origins: []
+security:
+  request_enforcement: stirct
+  rate_limit: 0
+  cors:
+    origins: ["https://shop.example.com/"]
+yandex:
+  secret_key: ${YANDEX_SECRET_KEY}
+  sandbox_mode: "yes"
+notifications:
+  telegram:
+    enabled: true
+    chat_id: "123"
+  email:
+    smtp_port: 70000
+    from: not-an-email
+`)
+	err = s.Validate(data)
+	require.Error(t, err)
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"domain", 3},
+		{"cors_origins", 4},
+		{"security.request_enforcement", 6},
+		{"security.rate_limit", 7},
+		{"security.cors.origins[0]", 9},
+		{"yandex.merchant_id", 11},
+		{"yandex.sandbox_mode", 12},
+		{"notifications.telegram.bot_token", 15},
+		{"notifications.email.smtp_port", 18},
+		{"notifications.email.from", 19},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.line, issueAt(t, err, tt.path).Line, tt.path)
+	}
+
+	// Environment references are not type checked
+	for _, issue := range err.(*ConfigError).Issues {
+		assert.NotEqual(t, "yandex.secret_key", issue.Path)
+	}
+}
+
+func TestWithMetadataSchema(t *testing.T) {
+	s := MerchantConfigSchema(WithMetadataSchema(&Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"tariff": {Type: "string", Enum: []string{"basic", "pro"}},
+		},
+		Required:             []string{"tariff"},
+		AdditionalProperties: false,
+	}))
+	assert.NotEmpty(t, s.Properties["metadata"].Description)
+
+	base := "id: shop\nname: Shop\nyandex:\n  merchant_id: m-1\n"
+	require.NoError(t, s.Validate([]byte(base+"metadata:\n  tariff: pro\n")))
+
+	err := s.Validate([]byte(base + "metadata:\n  tariff: gold\n"))
+	assert.Equal(t, 6, issueAt(t, err, "metadata.tariff").Line)
+	err = s.Validate([]byte(base + "metadata: {}\n"))
+	issueAt(t, err, "metadata.tariff")
+}
+
+func TestWithMetadataSchema_InvalidPattern(t *testing.T) {
+	s := MerchantConfigSchema(WithMetadataSchema(&Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"code": {Type: "string", Pattern: "^[a-z+$"},
+		},
+	}))
+
+	base := "id: shop\nname: Shop\nyandex:\n  merchant_id: m-1\n"
+	var err error
+	assert.NotPanics(t, func() { err = s.Validate([]byte(base + "metadata:\n  code: abc\n")) })
+	assert.Contains(t, issueAt(t, err, "metadata.code").Message, "invalid")
+
+	// The schema is rejected even when the field is absent
+	err = s.Validate([]byte(base))
+	issueAt(t, err, "metadata.code")
+
+	// Hand-built schemas report the bad pattern instead of panicking
+	hand := &Schema{Type: "object", Properties: map[string]*Schema{"code": {Type: "string", Pattern: "("}}}
+	assert.NotPanics(t, func() { err = hand.Validate([]byte("code: abc\n")) })
+	issueAt(t, err, "code")
+}
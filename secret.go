@@ -0,0 +1,169 @@
+package yapay
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secret values in output
+const Redacted = "[REDACTED]"
+
+// Secret is a string that never prints its value. fmt, JSON, YAML and slog
+// output show Redacted instead; use Reveal to get the actual value.
+type Secret string
+
+// Reveal returns the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer. Empty secrets print as empty strings so
+// missing values stay visible.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer for %#v
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// LogValue implements slog.LogValuer
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// DefaultSecretFields are log field names masked by RedactHook
+var DefaultSecretFields = []string{
+	"secret_key", "api_key", "bot_token", "token", "password", "authorization", "private_key",
+}
+
+// DefaultSecretPatterns match secrets in log messages and string fields
+var DefaultSecretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b\d{6,12}:[A-Za-z0-9_-]{30,}\b`),              // Telegram bot token
+	regexp.MustCompile(`(?i)\b(Bearer|Api-Key)\s+[A-Za-z0-9._~+/=-]+`), // Authorization header values
+}
+
+// RedactHook is a logrus hook that masks secrets in entries before they are
+// formatted: fields with secret names, values matching secret patterns and
+// registered secret values.
+type RedactHook struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+
+	mu     sync.RWMutex
+	values []string
+}
+
+// RedactOption configures a RedactHook
+type RedactOption func(*RedactHook)
+
+// WithSecretFields masks additional field names (case-insensitive)
+func WithSecretFields(names ...string) RedactOption {
+	return func(h *RedactHook) {
+		for _, name := range names {
+			h.fields[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// WithSecretPatterns masks additional value patterns
+func WithSecretPatterns(patterns ...*regexp.Regexp) RedactOption {
+	return func(h *RedactHook) {
+		h.patterns = append(h.patterns, patterns...)
+	}
+}
+
+// NewRedactHook creates a hook masking DefaultSecretFields and DefaultSecretPatterns
+func NewRedactHook(opts ...RedactOption) *RedactHook {
+	h := &RedactHook{
+		fields:   make(map[string]bool, len(DefaultSecretFields)),
+		patterns: append([]*regexp.Regexp(nil), DefaultSecretPatterns...),
+	}
+	for _, name := range DefaultSecretFields {
+		h.fields[name] = true
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// AddSecrets registers literal values to mask wherever they appear
+func (h *RedactHook) AddSecrets(values ...Secret) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, value := range values {
+		if value != "" {
+			h.values = append(h.values, value.Reveal())
+		}
+	}
+}
+
+// AddMerchantSecrets registers every secret of the merchant config
+func (h *RedactHook) AddMerchantSecrets(m *Merchant) {
+	h.AddSecrets(m.Yandex.SecretKey, m.Yandex.PrivateKey, m.Notifications.Telegram.BotToken, m.Notifications.Email.Password)
+}
+
+// Levels implements logrus.Hook
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redact(entry.Message)
+
+	// Entries share Data with the logger's WithFields result, so replace it
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case Secret:
+			data[key] = v.String()
+		case string:
+			if h.fields[strings.ToLower(key)] && v != "" {
+				data[key] = Redacted
+			} else {
+				data[key] = h.redact(v)
+			}
+		case error:
+			data[key] = h.redact(v.Error())
+		default:
+			data[key] = value
+		}
+	}
+	entry.Data = data
+	return nil
+}
+
+// redact masks registered values and patterns in s
+func (h *RedactHook) redact(s string) string {
+	h.mu.RLock()
+	for _, value := range h.values {
+		s = strings.ReplaceAll(s, value, Redacted)
+	}
+	h.mu.RUnlock()
+
+	for _, pattern := range h.patterns {
+		s = pattern.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
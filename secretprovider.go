@@ -0,0 +1,237 @@
+package yapay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrSecretNotFound is returned by secret providers for missing secrets
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Secret reference schemes resolved by LoadMerchantConfig
+const (
+	SecretSchemeFile  = "file"
+	SecretSchemeEnv   = "env"
+	SecretSchemeVault = "vault"
+)
+
+// DefaultSecretTimeout bounds the resolution of a single secret reference
+const DefaultSecretTimeout = 10 * time.Second
+
+// SecretProvider resolves the part of a secret reference after "scheme:"
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref string) (Secret, error)
+}
+
+// SecretProviderFunc adapts a function to SecretProvider
+type SecretProviderFunc func(ctx context.Context, ref string) (Secret, error)
+
+// Resolve calls f
+func (f SecretProviderFunc) Resolve(ctx context.Context, ref string) (Secret, error) {
+	return f(ctx, ref)
+}
+
+// FileSecretProvider reads secrets from files such as Docker or Kubernetes
+// secret mounts. Trailing newlines are trimmed.
+type FileSecretProvider struct{}
+
+// Resolve reads the file at ref
+func (FileSecretProvider) Resolve(_ context.Context, ref string) (Secret, error) {
+	data, err := os.ReadFile(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: file %s does not exist", ErrSecretNotFound, ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	return Secret(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// EnvSecretProvider reads secrets from environment variables
+type EnvSecretProvider struct {
+	// Lookup defaults to os.LookupEnv
+	Lookup func(string) (string, bool)
+}
+
+// Resolve returns the variable named ref
+func (p EnvSecretProvider) Resolve(_ context.Context, ref string) (Secret, error) {
+	lookup := p.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	value, ok := lookup(ref)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrSecretNotFound, ref)
+	}
+	return Secret(value), nil
+}
+
+// HTTPSecretProvider reads "path#key" references from a Vault-compatible
+// HTTP API: GET {baseURL}/v1/{path} with the token in X-Vault-Token. Both
+// KV v1 ({"data": {...}}) and KV v2 ({"data": {"data": {...}}}) responses
+// are supported.
+type HTTPSecretProvider struct {
+	baseURL string
+	token   Secret
+	client  *http.Client
+}
+
+// HTTPSecretOption configures an HTTPSecretProvider
+type HTTPSecretOption func(*HTTPSecretProvider)
+
+// WithSecretToken sets the token sent in X-Vault-Token
+func WithSecretToken(token Secret) HTTPSecretOption {
+	return func(p *HTTPSecretProvider) {
+		p.token = token
+	}
+}
+
+// WithSecretHTTPClient replaces the default HTTP client
+func WithSecretHTTPClient(client *http.Client) HTTPSecretOption {
+	return func(p *HTTPSecretProvider) {
+		p.client = client
+	}
+}
+
+// NewHTTPSecretProvider creates a provider for the API at baseURL
+func NewHTTPSecretProvider(baseURL string, opts ...HTTPSecretOption) *HTTPSecretProvider {
+	p := &HTTPSecretProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: DefaultSecretTimeout},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Resolve fetches the secret at "path#key"
+func (p *HTTPSecretProvider) Resolve(ctx context.Context, ref string) (Secret, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("secret reference %q must have the form path#key", ref)
+	}
+
+	endpoint := p.baseURL + "/v1/" + strings.TrimLeft(path, "/")
+	if _, err := url.Parse(endpoint); err != nil {
+		return "", fmt.Errorf("invalid secret path %q: %w", path, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Vault-Token", p.token.Reveal())
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("failed to fetch secret %s: HTTP %d", path, resp.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode secret %s: %w", path, err)
+	}
+
+	data := body.Data
+	if nested, ok := data["data"]; ok {
+		var kv2 map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &kv2); err == nil {
+			data = kv2
+		}
+	}
+	var value string
+	if raw, ok := data[key]; !ok || json.Unmarshal(raw, &value) != nil {
+		return "", fmt.Errorf("%w: %s has no string key %s", ErrSecretNotFound, path, key)
+	}
+	return Secret(value), nil
+}
+
+// WithSecretProvider resolves "scheme:ref" values of secret fields and
+// yandex.private_key_path with p. The file and env schemes are registered
+// by default; vault needs an HTTPSecretProvider.
+func WithSecretProvider(scheme string, p SecretProvider) LoadOption {
+	return func(o *loadOptions) {
+		o.providers[scheme] = p
+	}
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// resolveSecrets replaces secret references in every Secret field of m and
+// loads the private key referenced by yandex.private_key_path
+func (v *configValidator) resolveSecrets(m *Merchant, providers map[string]SecretProvider) {
+	v.resolveSecretFields(reflect.ValueOf(m).Elem(), "", providers)
+
+	if value, ok := v.resolveSecret(m.Yandex.PrivateKeyPath, "yandex.private_key_path", providers); ok {
+		m.Yandex.PrivateKey = value
+	}
+}
+
+func (v *configValidator) resolveSecretFields(rv reflect.Value, path string, providers map[string]SecretProvider) {
+	for name, field := range yamlFields(rv.Type()) {
+		fv := rv.FieldByIndex(field.Index)
+		switch {
+		case field.Type == secretType:
+			if value, ok := v.resolveSecret(fv.String(), joinPath(path, name), providers); ok {
+				fv.Set(reflect.ValueOf(value))
+			}
+		case field.Type.Kind() == reflect.Struct:
+			v.resolveSecretFields(fv, joinPath(path, name), providers)
+		}
+	}
+}
+
+// resolveSecret resolves value if it is a reference with a registered scheme
+func (v *configValidator) resolveSecret(value, path string, providers map[string]SecretProvider) (Secret, bool) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	provider := providers[scheme]
+	if ok && provider == nil && scheme == SecretSchemeVault {
+		v.add(path, "no secret provider is configured for vault references")
+		return "", false
+	}
+	if !ok || provider == nil {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSecretTimeout)
+	defer cancel()
+	secret, err := provider.Resolve(ctx, ref)
+	if err != nil {
+		v.add(path, "cannot resolve %s secret: %v", scheme, err)
+		return "", false
+	}
+	return secret, true
+}
+
+// LoadPrivateKey returns the merchant private key: the value resolved from a
+// private_key_path reference, or the contents of the file at PrivateKeyPath
+func (y YandexConfig) LoadPrivateKey() (Secret, error) {
+	if y.PrivateKey != "" {
+		return y.PrivateKey, nil
+	}
+	if y.PrivateKeyPath == "" {
+		return "", fmt.Errorf("%w: private_key_path is not set", ErrSecretNotFound)
+	}
+	return FileSecretProvider{}.Resolve(context.Background(), y.PrivateKeyPath)
+}
@@ -0,0 +1,146 @@
+package yapay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PaymentStatus represents the lifecycle status of a payment
+type PaymentStatus string
+
+// Canonical payment statuses
+const (
+	PaymentStatusCreated           PaymentStatus = "created"
+	PaymentStatusPending           PaymentStatus = "pending"
+	PaymentStatusSuccess           PaymentStatus = "success"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusCanceled          PaymentStatus = "canceled"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+)
+
+// paymentTransitions lists the statuses reachable from each status.
+// Statuses without outgoing transitions are terminal. A partially refunded
+// payment may receive further partial refunds until it is fully refunded.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusCreated: {
+		PaymentStatusPending,
+		PaymentStatusSuccess,
+		PaymentStatusFailed,
+		PaymentStatusCanceled,
+	},
+	PaymentStatusPending: {
+		PaymentStatusSuccess,
+		PaymentStatusFailed,
+		PaymentStatusCanceled,
+	},
+	PaymentStatusSuccess: {
+		PaymentStatusPartiallyRefunded,
+		PaymentStatusRefunded,
+	},
+	PaymentStatusPartiallyRefunded: {
+		PaymentStatusPartiallyRefunded,
+		PaymentStatusRefunded,
+	},
+	PaymentStatusFailed:   {},
+	PaymentStatusCanceled: {},
+	PaymentStatusRefunded: {},
+}
+
+// paymentStatusAliases maps spellings used by Yandex Pay, the OpenAPI spec
+// and older plugins to canonical statuses
+var paymentStatusAliases = map[string]PaymentStatus{
+	"new":        PaymentStatusCreated,
+	"authorized": PaymentStatusPending,
+	"succeeded":  PaymentStatusSuccess,
+	"paid":       PaymentStatusSuccess,
+	"captured":   PaymentStatusSuccess,
+	"confirmed":  PaymentStatusSuccess,
+	"fail":       PaymentStatusFailed,
+	"cancelled":  PaymentStatusCanceled,
+	"voided":     PaymentStatusCanceled,
+}
+
+// ErrUnknownPaymentStatus is returned when a status string cannot be recognized
+var ErrUnknownPaymentStatus = errors.New("unknown payment status")
+
+// ErrInvalidTransition is matched by errors.Is for every *TransitionError
+var ErrInvalidTransition = errors.New("invalid payment status transition")
+
+// TransitionError reports an illegal payment status transition
+type TransitionError struct {
+	From PaymentStatus
+	To   PaymentStatus
+}
+
+// Error implements the error interface
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid payment status transition: %s -> %s", e.From, e.To)
+}
+
+// Is reports whether target is ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// ParsePaymentStatus converts a status string into a canonical PaymentStatus.
+// Matching is case-insensitive and accepts known aliases such as
+// "succeeded", "CAPTURED" or "cancelled".
+func ParsePaymentStatus(s string) (PaymentStatus, error) {
+	normalized := strings.ToLower(strings.TrimSpace(s))
+	if status := PaymentStatus(normalized); status.IsValid() {
+		return status, nil
+	}
+	if status, ok := paymentStatusAliases[normalized]; ok {
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownPaymentStatus, s)
+}
+
+// IsValid reports whether s is one of the canonical statuses
+func (s PaymentStatus) IsValid() bool {
+	_, ok := paymentTransitions[s]
+	return ok
+}
+
+// IsTerminal reports whether no further transitions are allowed from s
+func (s PaymentStatus) IsTerminal() bool {
+	next, ok := paymentTransitions[s]
+	return ok && len(next) == 0
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition returns a *TransitionError if moving from s to next is not allowed
+func (s PaymentStatus) ValidateTransition(next PaymentStatus) error {
+	if !s.CanTransitionTo(next) {
+		return &TransitionError{From: s, To: next}
+	}
+	return nil
+}
+
+// String returns the status as a string
+func (s PaymentStatus) String() string {
+	return string(s)
+}
+
+// TransitionTo moves the payment to the next status and updates UpdatedAt.
+// The payment is left unchanged if the transition is not allowed.
+func (p *Payment) TransitionTo(next PaymentStatus) error {
+	if err := p.Status.ValidateTransition(next); err != nil {
+		return err
+	}
+	p.Status = next
+	p.UpdatedAt = time.Now().Format(time.RFC3339)
+	return nil
+}
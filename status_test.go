@@ -0,0 +1,76 @@
+package yapay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePaymentStatus(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected PaymentStatus
+	}{
+		{"created", PaymentStatusCreated},
+		{"pending", PaymentStatusPending},
+		{"success", PaymentStatusSuccess},
+		{"succeeded", PaymentStatusSuccess},
+		{"CAPTURED", PaymentStatusSuccess},
+		{"AUTHORIZED", PaymentStatusPending},
+		{"cancelled", PaymentStatusCanceled},
+		{"VOIDED", PaymentStatusCanceled},
+		{" Failed ", PaymentStatusFailed},
+		{"refunded", PaymentStatusRefunded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			status, err := ParsePaymentStatus(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, status)
+		})
+	}
+
+	_, err := ParsePaymentStatus("teleported")
+	assert.True(t, errors.Is(err, ErrUnknownPaymentStatus))
+}
+
+func TestPaymentStatus_Transitions(t *testing.T) {
+	assert.True(t, PaymentStatusCreated.CanTransitionTo(PaymentStatusPending))
+	assert.True(t, PaymentStatusPending.CanTransitionTo(PaymentStatusSuccess))
+	assert.True(t, PaymentStatusSuccess.CanTransitionTo(PaymentStatusRefunded))
+
+	assert.False(t, PaymentStatusCanceled.CanTransitionTo(PaymentStatusSuccess))
+	assert.False(t, PaymentStatusPending.CanTransitionTo(PaymentStatusCreated))
+	assert.False(t, PaymentStatusFailed.CanTransitionTo(PaymentStatusRefunded))
+	assert.False(t, PaymentStatusSuccess.CanTransitionTo(PaymentStatusSuccess))
+
+	assert.True(t, PaymentStatusCanceled.IsTerminal())
+	assert.True(t, PaymentStatusRefunded.IsTerminal())
+	assert.False(t, PaymentStatusPending.IsTerminal())
+	assert.False(t, PaymentStatus("unknown").IsTerminal())
+}
+
+func TestPayment_TransitionTo(t *testing.T) {
+	payment := &Payment{ID: "p1", Status: PaymentStatusCreated}
+
+	require.NoError(t, payment.TransitionTo(PaymentStatusPending))
+	assert.Equal(t, PaymentStatusPending, payment.Status)
+	assert.NotEmpty(t, payment.UpdatedAt)
+
+	require.NoError(t, payment.TransitionTo(PaymentStatusCanceled))
+
+	err := payment.TransitionTo(PaymentStatusSuccess)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+
+	var transitionErr *TransitionError
+	require.True(t, errors.As(err, &transitionErr))
+	assert.Equal(t, PaymentStatusCanceled, transitionErr.From)
+	assert.Equal(t, PaymentStatusSuccess, transitionErr.To)
+
+	// Rejected transitions leave the payment untouched
+	assert.Equal(t, PaymentStatusCanceled, payment.Status)
+}
@@ -0,0 +1,194 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SubscriptionStatus represents the status of a customer subscription
+type SubscriptionStatus string
+
+// Canonical subscription statuses
+const (
+	SubscriptionStatusNew            SubscriptionStatus = "new"
+	SubscriptionStatusActive         SubscriptionStatus = "active"
+	SubscriptionStatusWriteOffFailed SubscriptionStatus = "write_off_failed"
+	SubscriptionStatusPaused         SubscriptionStatus = "paused"
+	SubscriptionStatusCanceled       SubscriptionStatus = "canceled"
+	SubscriptionStatusExpired        SubscriptionStatus = "expired"
+)
+
+// subscriptionStatusAliases maps Yandex Pay spellings to canonical statuses
+var subscriptionStatusAliases = map[string]SubscriptionStatus{
+	"new":              SubscriptionStatusNew,
+	"created":          SubscriptionStatusNew,
+	"active":           SubscriptionStatusActive,
+	"renewed":          SubscriptionStatusActive,
+	"write_off_failed": SubscriptionStatusWriteOffFailed,
+	"past_due":         SubscriptionStatusWriteOffFailed,
+	"failed":           SubscriptionStatusWriteOffFailed,
+	"paused":           SubscriptionStatusPaused,
+	"suspended":        SubscriptionStatusPaused,
+	"canceled":         SubscriptionStatusCanceled,
+	"cancelled":        SubscriptionStatusCanceled,
+	"expired":          SubscriptionStatusExpired,
+}
+
+// ErrUnknownSubscriptionStatus is returned when a subscription status cannot be recognized
+var ErrUnknownSubscriptionStatus = errors.New("unknown subscription status")
+
+// ParseSubscriptionStatus converts a webhook status such as "ACTIVE" into a SubscriptionStatus
+func ParseSubscriptionStatus(s string) (SubscriptionStatus, error) {
+	if status, ok := subscriptionStatusAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownSubscriptionStatus, s)
+}
+
+// BillingPeriodUnit is the unit of a subscription billing period
+type BillingPeriodUnit string
+
+// Billing period units
+const (
+	BillingPeriodDay   BillingPeriodUnit = "DAY"
+	BillingPeriodWeek  BillingPeriodUnit = "WEEK"
+	BillingPeriodMonth BillingPeriodUnit = "MONTH"
+	BillingPeriodYear  BillingPeriodUnit = "YEAR"
+)
+
+// BillingPeriod is a billing interval such as 1 MONTH or 2 WEEK
+type BillingPeriod struct {
+	Unit  BillingPeriodUnit `json:"unit" yaml:"unit"`
+	Count int               `json:"count" yaml:"count"`
+}
+
+// AddTo returns t advanced by the billing period
+func (p BillingPeriod) AddTo(t time.Time) time.Time {
+	switch p.Unit {
+	case BillingPeriodDay:
+		return t.AddDate(0, 0, p.Count)
+	case BillingPeriodWeek:
+		return t.AddDate(0, 0, 7*p.Count)
+	case BillingPeriodMonth:
+		return t.AddDate(0, p.Count, 0)
+	case BillingPeriodYear:
+		return t.AddDate(p.Count, 0, 0)
+	default:
+		return t
+	}
+}
+
+// SubscriptionPlan represents a subscription plan offered by the merchant
+type SubscriptionPlan struct {
+	ID          string         `json:"id" yaml:"id"`
+	Title       string         `json:"title" yaml:"title"`
+	Price       Money          `json:"price" yaml:"price"`
+	Period      BillingPeriod  `json:"period" yaml:"period"`
+	TrialPeriod *BillingPeriod `json:"trial_period,omitempty" yaml:"trial_period,omitempty"`
+}
+
+// Subscription represents a customer subscription
+type Subscription struct {
+	ID         string             `json:"id"`
+	PlanID     string             `json:"plan_id"`
+	MerchantID string             `json:"merchant_id"`
+	Status     SubscriptionStatus `json:"status"`
+	// PreviousStatus is the status the host stored before this event; empty
+	// for the first event the host sees. It tells activation from renewal.
+	PreviousStatus SubscriptionStatus `json:"previous_status,omitempty"`
+	NextWriteOff   time.Time          `json:"next_write_off"` // Zero when no write-off is scheduled
+	EventTime      time.Time          `json:"event_time"`
+	Plan           *SubscriptionPlan  `json:"plan,omitempty"` // Filled by the host when the plan is known
+}
+
+// SubscriptionFromWebhook builds a Subscription from a webhook's subscription payload
+func SubscriptionFromWebhook(webhook *PaymentWebhook) (*Subscription, error) {
+	if webhook == nil || webhook.Subscription == nil {
+		return nil, NewError(ErrorCodeValidation, "webhook has no subscription data").
+			WithDetail("subscription", "Subscription is required")
+	}
+	data := webhook.Subscription
+
+	status, err := ParseSubscriptionStatus(data.Status)
+	if err != nil {
+		return nil, WrapError(ErrorCodeValidation, err, "invalid subscription status").
+			WithDetail("subscription.status", "Unknown status")
+	}
+
+	sub := &Subscription{
+		ID:         data.CustomerSubscriptionID,
+		PlanID:     data.SubscriptionPlanID,
+		MerchantID: webhook.MerchantID,
+		Status:     status,
+	}
+	if data.NextWriteOff != "" {
+		if sub.NextWriteOff, err = time.Parse(time.RFC3339, data.NextWriteOff); err != nil {
+			return nil, WrapError(ErrorCodeValidation, err, "invalid nextWriteOff").
+				WithDetail("subscription.nextWriteOff", "Must be an RFC 3339 timestamp")
+		}
+	}
+	if webhook.EventTime != "" {
+		if sub.EventTime, err = time.Parse(time.RFC3339, webhook.EventTime); err != nil {
+			return nil, WrapError(ErrorCodeValidation, err, "invalid eventTime").
+				WithDetail("eventTime", "Must be an RFC 3339 timestamp")
+		}
+	}
+	return sub, nil
+}
+
+// SubscriptionHandler is an optional interface for plugins that manage
+// subscriptions. The host discovers it with AsSubscriptionHandler.
+type SubscriptionHandler interface {
+	// HandleSubscriptionCreated is called for a new subscription
+	HandleSubscriptionCreated(ctx context.Context, sub *Subscription) error
+	// HandleSubscriptionActivated is called when the subscription becomes
+	// active from any other status, including the first activation
+	HandleSubscriptionActivated(ctx context.Context, sub *Subscription) error
+	// HandleSubscriptionRenewed is called when a write-off succeeded and the
+	// subscription stays active
+	HandleSubscriptionRenewed(ctx context.Context, sub *Subscription) error
+	// HandleSubscriptionWriteOffFailed is called when a scheduled write-off failed
+	HandleSubscriptionWriteOffFailed(ctx context.Context, sub *Subscription) error
+	// HandleSubscriptionPaused is called when the subscription is paused
+	HandleSubscriptionPaused(ctx context.Context, sub *Subscription) error
+	// HandleSubscriptionCanceled is called when the subscription is canceled or expired
+	HandleSubscriptionCanceled(ctx context.Context, sub *Subscription) error
+}
+
+// AsSubscriptionHandler returns the plugin's SubscriptionHandler, looking through SDK adapters
+func AsSubscriptionHandler(handler interface{}) (SubscriptionHandler, bool) {
+	h, ok := UnwrapHandler(handler).(SubscriptionHandler)
+	return h, ok
+}
+
+// DispatchSubscription calls the SubscriptionHandler method matching the
+// subscription status. An active subscription is renewed if its
+// PreviousStatus is active and activated otherwise. It reports false if the
+// handler has no subscription support.
+func DispatchSubscription(ctx context.Context, handler interface{}, sub *Subscription) (bool, error) {
+	h, ok := AsSubscriptionHandler(handler)
+	if !ok {
+		return false, nil
+	}
+
+	switch sub.Status {
+	case SubscriptionStatusNew:
+		return true, h.HandleSubscriptionCreated(ctx, sub)
+	case SubscriptionStatusActive:
+		if sub.PreviousStatus != SubscriptionStatusActive {
+			return true, h.HandleSubscriptionActivated(ctx, sub)
+		}
+		return true, h.HandleSubscriptionRenewed(ctx, sub)
+	case SubscriptionStatusWriteOffFailed:
+		return true, h.HandleSubscriptionWriteOffFailed(ctx, sub)
+	case SubscriptionStatusPaused:
+		return true, h.HandleSubscriptionPaused(ctx, sub)
+	case SubscriptionStatusCanceled, SubscriptionStatusExpired:
+		return true, h.HandleSubscriptionCanceled(ctx, sub)
+	default:
+		return true, Errorf(ErrorCodeValidation, "unsupported subscription status %q", sub.Status)
+	}
+}
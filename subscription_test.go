@@ -0,0 +1,123 @@
+package yapay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscriptionRecorder records which SubscriptionHandler callback was called
+type subscriptionRecorder struct {
+	calls []string
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionCreated(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "created")
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionActivated(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "activated")
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionRenewed(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "renewed")
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionWriteOffFailed(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "write_off_failed")
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionPaused(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "paused")
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleSubscriptionCanceled(_ context.Context, _ *Subscription) error {
+	r.calls = append(r.calls, "canceled")
+	return nil
+}
+
+func TestSubscriptionFromWebhook(t *testing.T) {
+	webhook := &PaymentWebhook{
+		Event:      "SUBSCRIPTION_STATUS_UPDATED",
+		EventTime:  "2025-09-15T10:00:00Z",
+		MerchantID: "merchant-1",
+		Subscription: &SubscriptionWebhookData{
+			CustomerSubscriptionID: "sub-1",
+			NextWriteOff:           "2025-10-15T10:00:00+03:00",
+			Status:                 "ACTIVE",
+			SubscriptionPlanID:     "plan-1",
+		},
+	}
+
+	sub, err := SubscriptionFromWebhook(webhook)
+	require.NoError(t, err)
+	assert.Equal(t, "sub-1", sub.ID)
+	assert.Equal(t, "plan-1", sub.PlanID)
+	assert.Equal(t, "merchant-1", sub.MerchantID)
+	assert.Equal(t, SubscriptionStatusActive, sub.Status)
+	assert.True(t, sub.NextWriteOff.Equal(time.Date(2025, 10, 15, 7, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2025, sub.EventTime.Year())
+
+	webhook.Subscription.NextWriteOff = "tomorrow"
+	_, err = SubscriptionFromWebhook(webhook)
+	assert.True(t, errors.Is(err, ErrValidation))
+
+	webhook.Subscription.NextWriteOff = ""
+	webhook.Subscription.Status = "SLEEPING"
+	_, err = SubscriptionFromWebhook(webhook)
+	assert.True(t, errors.Is(err, ErrUnknownSubscriptionStatus))
+
+	_, err = SubscriptionFromWebhook(&PaymentWebhook{})
+	assert.True(t, errors.Is(err, ErrValidation))
+}
+
+func TestDispatchSubscription(t *testing.T) {
+	tests := []struct {
+		status   string
+		previous SubscriptionStatus
+		expected string
+	}{
+		{"NEW", "", "created"},
+		{"ACTIVE", "", "activated"},
+		{"ACTIVE", SubscriptionStatusNew, "activated"},
+		{"ACTIVE", SubscriptionStatusWriteOffFailed, "activated"},
+		{"ACTIVE", SubscriptionStatusActive, "renewed"},
+		{"PAST_DUE", SubscriptionStatusActive, "write_off_failed"},
+		{"PAUSED", SubscriptionStatusActive, "paused"},
+		{"CANCELLED", SubscriptionStatusActive, "canceled"},
+		{"EXPIRED", SubscriptionStatusActive, "canceled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status+" after "+string(tt.previous), func(t *testing.T) {
+			status, err := ParseSubscriptionStatus(tt.status)
+			require.NoError(t, err)
+
+			recorder := &subscriptionRecorder{}
+			sub := &Subscription{Status: status, PreviousStatus: tt.previous}
+			handled, err := DispatchSubscription(context.Background(), recorder, sub)
+			require.NoError(t, err)
+			assert.True(t, handled)
+			assert.Equal(t, []string{tt.expected}, recorder.calls)
+		})
+	}
+
+	handled, err := DispatchSubscription(context.Background(), struct{}{}, &Subscription{Status: SubscriptionStatusNew})
+	assert.NoError(t, err)
+	assert.False(t, handled)
+}
+
+func TestBillingPeriod_AddTo(t *testing.T) {
+	start := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 2, 14, 0, 0, 0, 0, time.UTC), BillingPeriod{Unit: BillingPeriodWeek, Count: 2}.AddTo(start))
+	assert.Equal(t, time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), BillingPeriod{Unit: BillingPeriodYear, Count: 1}.AddTo(start))
+}
@@ -1,6 +1,7 @@
 package testing
 
 import (
+	"context"
 	"testing"
 
 	"github.com/metalmon/yapay-sdk"
@@ -14,6 +15,12 @@ func TestMockClientHandlerImplementsInterface(t *testing.T) {
 	assert.NotNil(t, handler)
 }
 
+// TestMockClientHandlerImplementsRefundHandler tests that MockClientHandler implements RefundHandler interface
+func TestMockClientHandlerImplementsRefundHandler(t *testing.T) {
+	var handler yapay.RefundHandler = NewMockClientHandler()
+	assert.NotNil(t, handler)
+}
+
 // TestMockPaymentGeneratorImplementsInterface tests that MockPaymentGenerator implements PaymentLinkGenerator interface
 func TestMockPaymentGeneratorImplementsInterface(t *testing.T) {
 	// This test will fail at compile time if MockPaymentGenerator doesn't implement PaymentLinkGenerator
@@ -58,6 +65,29 @@ func TestMockClientHandlerMethods(t *testing.T) {
 	assert.Equal(t, 1, counts["ValidateRequest"])
 }
 
+// TestMockClientHandlerRefunds tests that refunds are dispatched to the mock
+func TestMockClientHandlerRefunds(t *testing.T) {
+	mock := NewMockClientHandler()
+	testData := NewTestData()
+	payment := testData.CreateTestPayment()
+	payment.Status = yapay.PaymentStatusSuccess
+	ctx := context.Background()
+
+	// Refund a part of the payment through the v2 adapter, then the rest
+	handler := yapay.AdaptHandler(mock)
+	assert.NoError(t, yapay.DispatchRefund(ctx, handler, payment, testData.CreateTestRefund(payment, 400)))
+	assert.NoError(t, yapay.DispatchRefund(ctx, handler, payment, testData.CreateTestRefund(payment, 600)))
+
+	counts := mock.GetCallCounts()
+	assert.Equal(t, 1, counts["HandlePartialRefund"])
+	assert.Equal(t, 1, counts["HandlePaymentRefunded"])
+	assert.Equal(t, yapay.PaymentStatusRefunded, payment.Status)
+	assert.Equal(t, payment.Amount, payment.RefundedAmount)
+
+	mock.Reset()
+	assert.Equal(t, 0, mock.GetCallCounts()["HandlePaymentRefunded"])
+}
+
 // TestMockPaymentGeneratorMethods tests that all required methods work correctly
 func TestMockPaymentGeneratorMethods(t *testing.T) {
 	mock := NewMockPaymentGenerator()
@@ -1,6 +1,8 @@
 package testing
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/metalmon/yapay-sdk"
@@ -16,6 +18,8 @@ type MockClientHandler struct {
 	ValidateRequestCalls []*yapay.PaymentRequest
 	ValidateRequestError error
 	PaymentGenerator     yapay.PaymentLinkGenerator
+	RefundedCalls        []*yapay.Refund
+	PartialRefundCalls   []*yapay.Refund
 }
 
 // NewMockClientHandler creates a new mock client handler
@@ -26,6 +30,8 @@ func NewMockClientHandler() *MockClientHandler {
 		PaymentFailedCalls:   make([]*yapay.Payment, 0),
 		PaymentCanceledCalls: make([]*yapay.Payment, 0),
 		ValidateRequestCalls: make([]*yapay.PaymentRequest, 0),
+		RefundedCalls:        make([]*yapay.Refund, 0),
+		PartialRefundCalls:   make([]*yapay.Refund, 0),
 	}
 }
 
@@ -63,6 +69,18 @@ func (m *MockClientHandler) HandlePaymentCanceled(payment *yapay.Payment) error
 	return nil
 }
 
+// HandlePaymentRefunded records the call and returns nil
+func (m *MockClientHandler) HandlePaymentRefunded(_ context.Context, _ *yapay.Payment, refund *yapay.Refund) error {
+	m.RefundedCalls = append(m.RefundedCalls, refund)
+	return nil
+}
+
+// HandlePartialRefund records the call and returns nil
+func (m *MockClientHandler) HandlePartialRefund(_ context.Context, _ *yapay.Payment, refund *yapay.Refund) error {
+	m.PartialRefundCalls = append(m.PartialRefundCalls, refund)
+	return nil
+}
+
 // ValidateRequest records the call and returns the configured error
 func (m *MockClientHandler) ValidateRequest(req *yapay.PaymentRequest) error {
 	m.ValidateRequestCalls = append(m.ValidateRequestCalls, req)
@@ -110,6 +128,8 @@ func (m *MockClientHandler) Reset() {
 	m.PaymentCanceledCalls = make([]*yapay.Payment, 0)
 	m.ValidateRequestCalls = make([]*yapay.PaymentRequest, 0)
 	m.ValidateRequestError = nil
+	m.RefundedCalls = make([]*yapay.Refund, 0)
+	m.PartialRefundCalls = make([]*yapay.Refund, 0)
 }
 
 // GetCallCounts returns the number of calls for each method
@@ -120,6 +140,8 @@ func (m *MockClientHandler) GetCallCounts() map[string]int {
 		"HandlePaymentFailed":   len(m.PaymentFailedCalls),
 		"HandlePaymentCanceled": len(m.PaymentCanceledCalls),
 		"ValidateRequest":       len(m.ValidateRequestCalls),
+		"HandlePaymentRefunded": len(m.RefundedCalls),
+		"HandlePartialRefund":   len(m.PartialRefundCalls),
 	}
 }
 
@@ -278,7 +300,7 @@ func (t *TestData) CreateTestPayment() *yapay.Payment {
 		Amount:      1000,
 		Currency:    "RUB",
 		Description: "Test payment",
-		Status:      "created",
+		Status:      yapay.PaymentStatusCreated,
 		ReturnURL:   "https://test.example.com/return",
 		Metadata: map[string]interface{}{
 			"test": true,
@@ -288,6 +310,21 @@ func (t *TestData) CreateTestPayment() *yapay.Payment {
 	}
 }
 
+// CreateTestRefund creates a completed refund of the given amount for the
+// payment. Its ID is unique among the refunds applied to the payment.
+func (t *TestData) CreateTestRefund(payment *yapay.Payment, amount int) *yapay.Refund {
+	return &yapay.Refund{
+		ID:        fmt.Sprintf("test-refund-%d", len(payment.RefundIDs)+1),
+		PaymentID: payment.ID,
+		OrderID:   payment.OrderID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		Reason:    "Test refund",
+		Status:    yapay.RefundStatusSuccess,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+}
+
 // CreateTestPaymentRequest creates a test payment request
 func (t *TestData) CreateTestPaymentRequest() *yapay.PaymentRequest {
 	return &yapay.PaymentRequest{
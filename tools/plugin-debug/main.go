@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -12,7 +13,7 @@ import (
 
 	"github.com/metalmon/yapay-sdk"
 	"github.com/metalmon/yapay-sdk/testing"
-	"gopkg.in/yaml.v3"
+	"github.com/sirupsen/logrus"
 )
 
 // loadPlugin loads a plugin using the same logic as the main application
@@ -26,6 +27,18 @@ func loadPlugin(name, pluginsDir string) (*plugin.Plugin, error) {
 		}
 	}
 
+	// A JSON manifest explains version mismatches before plugin.Open fails opaquely
+	manifestPath := yapay.ManifestPath(pluginPath)
+	if _, err := os.Stat(manifestPath); err == nil {
+		manifest, err := yapay.ReadPluginManifest(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := printCompatibility(manifest); err != nil {
+			return nil, err
+		}
+	}
+
 	fmt.Printf("Loading plugin from: %s\n", pluginPath)
 	p, err := plugin.Open(pluginPath)
 	if err != nil {
@@ -35,7 +48,38 @@ func loadPlugin(name, pluginsDir string) (*plugin.Plugin, error) {
 	return p, nil
 }
 
+// lookupManifest returns the exported PluginManifest, or nil for plugins without one
+func lookupManifest(p *plugin.Plugin) (*yapay.PluginManifest, error) {
+	sym, err := p.Lookup(yapay.PluginManifestSymbol)
+	if err != nil {
+		fmt.Println("⚠️  Plugin exports no PluginManifest")
+		return nil, nil
+	}
+	manifest, err := yapay.ManifestFromSymbol(sym)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, printCompatibility(manifest)
+}
+
+// printCompatibility prints the compatibility verdict and fails for incompatible plugins
+func printCompatibility(manifest *yapay.PluginManifest) error {
+	report := yapay.CheckCompatibility(manifest)
+	if !report.Compatible {
+		return report.Err()
+	}
+	fmt.Printf("✅ %s\n", report)
+	return nil
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run executes the debugger and returns the process exit code. Failures
+// return instead of exiting, so deferred calls such as the handler shutdown
+// still run.
+func run() int {
 	var (
 		pluginName = flag.String("plugin", "", "Plugin name (e.g., swschool)")
 		configPath = flag.String("config", "", "Path to plugin config.yaml")
@@ -49,25 +93,28 @@ func main() {
 		fmt.Println("Usage: plugin-debug -plugin <plugin-name> [-config <path/to/config.yaml>] [-test <mode>] [-plugins-dir <dir>]")
 		fmt.Println("Test modes: validate, simulate, benchmark")
 		fmt.Println("Example: plugin-debug -plugin swschool -test validate")
-		os.Exit(1)
+		return 1
 	}
 
 	// Load plugin using the same logic as main application
 	fmt.Printf("Loading plugin: %s\n", *pluginName)
 	p, err := loadPlugin(*pluginName, *pluginsDir)
 	if err != nil {
-		log.Fatalf("Failed to load plugin: %v", err)
+		log.Printf("Failed to load plugin: %v", err)
+		return 1
 	}
 
-	// Look for NewHandler function
-	newHandlerSym, err := p.Lookup("NewHandler")
+	manifest, err := lookupManifest(p)
 	if err != nil {
-		log.Fatalf("Plugin does not export NewHandler function: %v", err)
+		log.Printf("%v", err)
+		return 1
 	}
 
-	newHandler, ok := newHandlerSym.(func(*yapay.Merchant) yapay.ClientHandler)
-	if !ok {
-		log.Fatalf("NewHandler has wrong signature: expected func(*yapay.Merchant) yapay.ClientHandler")
+	// Look for NewHandlerV3 and NewHandlerV2 first, then fall back to the legacy NewHandler
+	newHandler, err := lookupHandlerFactory(p)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
 	}
 
 	// Load config if provided
@@ -76,7 +123,8 @@ func main() {
 		fmt.Printf("Loading config: %s\n", *configPath)
 		merchant, err = loadConfig(*configPath)
 		if err != nil {
-			log.Fatalf("Failed to load config: %v", err)
+			log.Printf("Failed to load config: %v", err)
+			return 1
 		}
 	} else {
 		// Use test data
@@ -88,25 +136,133 @@ func main() {
 	// Create handler
 	fmt.Println("Creating handler...")
 	handler := newHandler(merchant)
+	ctx := context.Background()
+	installGenerator(p, merchant, handler)
+
+	// Run the optional Init hook and report health like the host does
+	lifecycle := yapay.NewLifecycle()
+	if err := lifecycle.Start(ctx, merchant, handler); err != nil {
+		log.Printf("Handler initialization failed: %v", err)
+		return 1
+	}
+	printHealthReport("Readiness", lifecycle.Readiness(ctx))
+	defer func() {
+		if err := lifecycle.Shutdown(ctx); err != nil {
+			fmt.Printf("❌ Handler shutdown failed: %v\n", err)
+		} else {
+			fmt.Println("✅ Handler shut down")
+		}
+	}()
 
 	// Validate handler
 	fmt.Println("Validating handler...")
-	if err := validateHandler(handler); err != nil {
-		log.Fatalf("Handler validation failed: %v", err)
+	if err := validateHandler(ctx, handler); err != nil {
+		log.Printf("Handler validation failed: %v", err)
+		return 1
 	}
 	fmt.Println("✅ Handler validation passed")
 
+	if manifest != nil {
+		if err := manifest.VerifyCapabilities(handler); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		} else {
+			fmt.Printf("✅ Declared capabilities: %v\n", manifest.Capabilities)
+		}
+	}
+
 	// Run tests based on mode
 	switch *testMode {
 	case "validate":
-		runValidationTests(handler, *verbose)
+		runValidationTests(ctx, handler, *verbose)
 	case "simulate":
-		runSimulationTests(handler, *verbose)
+		runSimulationTests(ctx, handler, *verbose)
 	case "benchmark":
-		runBenchmarkTests(handler, *verbose)
+		runBenchmarkTests(ctx, handler, *verbose)
 	default:
 		fmt.Println("No test mode specified. Use -test validate|simulate|benchmark")
 	}
+	return 0
+}
+
+// lookupHandlerFactory resolves the plugin constructor, preferring
+// NewHandlerV3, then the context-aware NewHandlerV2, and adapting a legacy
+// NewHandler otherwise
+func lookupHandlerFactory(p *plugin.Plugin) (yapay.NewHandlerV2Func, error) {
+	if sym, err := p.Lookup("NewHandlerV3"); err == nil {
+		newHandlerV3, ok := sym.(func(*yapay.Merchant) yapay.ClientHandlerV3)
+		if !ok {
+			return nil, fmt.Errorf("NewHandlerV3 has wrong signature: expected func(*yapay.Merchant) yapay.ClientHandlerV3")
+		}
+		fmt.Println("Using typed-generator handler (NewHandlerV3)")
+		return func(merchant *yapay.Merchant) yapay.ClientHandlerV2 {
+			return yapay.DowngradeHandler(newHandlerV3(merchant))
+		}, nil
+	}
+
+	if sym, err := p.Lookup("NewHandlerV2"); err == nil {
+		newHandlerV2, ok := sym.(func(*yapay.Merchant) yapay.ClientHandlerV2)
+		if !ok {
+			return nil, fmt.Errorf("NewHandlerV2 has wrong signature: expected func(*yapay.Merchant) yapay.ClientHandlerV2")
+		}
+		fmt.Println("Using context-aware handler (NewHandlerV2)")
+		return newHandlerV2, nil
+	}
+
+	sym, err := p.Lookup("NewHandler")
+	if err != nil {
+		return nil, fmt.Errorf("plugin exports none of NewHandlerV3, NewHandlerV2 or NewHandler: %w", err)
+	}
+
+	newHandler, ok := sym.(func(*yapay.Merchant) yapay.ClientHandler)
+	if !ok {
+		return nil, fmt.Errorf("NewHandler has wrong signature: expected func(*yapay.Merchant) yapay.ClientHandler")
+	}
+	fmt.Println("Using legacy handler (NewHandler) through the v2 adapter")
+	return func(merchant *yapay.Merchant) yapay.ClientHandlerV2 {
+		return yapay.AdaptHandler(newHandler(merchant))
+	}, nil
+}
+
+// installGenerator passes the plugin's NewPaymentGenerator result to the
+// handler like the host does, warning if the handler drops it
+func installGenerator(p *plugin.Plugin, merchant *yapay.Merchant, handler yapay.ClientHandlerV2) {
+	sym, err := p.Lookup("NewPaymentGenerator")
+	if err != nil {
+		return
+	}
+	newGenerator, ok := sym.(func(*yapay.Merchant, *logrus.Logger) yapay.PaymentLinkGenerator)
+	if !ok {
+		fmt.Println("⚠️  NewPaymentGenerator has wrong signature: expected func(*yapay.Merchant, *logrus.Logger) yapay.PaymentLinkGenerator")
+		return
+	}
+	logger := logrus.New()
+	redact := yapay.NewRedactHook()
+	redact.AddMerchantSecrets(merchant)
+	logger.AddHook(redact)
+	if err := yapay.InstallPaymentGenerator(handler, newGenerator(merchant, logger), logger); err != nil {
+		fmt.Printf("⚠️  Payment generator not installed: %v\n", err)
+	}
+}
+
+// printHealthReport prints the lifecycle report of every merchant
+func printHealthReport(name string, report *yapay.HealthReport) {
+	for _, m := range report.Merchants {
+		mark := "✅"
+		if !m.Ready {
+			mark = "❌"
+		}
+		fmt.Printf("%s %s of %s: ready=%v live=%v\n", mark, name, m.MerchantID, m.Ready, m.Live)
+		if m.Init == nil {
+			fmt.Println("   Init: not implemented")
+		} else {
+			fmt.Printf("   Init: %s in %v %s\n", m.Init.Status, m.Init.Duration, m.Init.Error)
+		}
+		if m.Health == nil {
+			fmt.Println("   HealthCheck: not implemented")
+		} else {
+			fmt.Printf("   HealthCheck: %s in %v %s\n", m.Health.Status, m.Health.Duration, m.Health.Error)
+		}
+	}
 }
 
 func loadConfig(configPath string) (*yapay.Merchant, error) {
@@ -119,20 +275,17 @@ func loadConfig(configPath string) (*yapay.Merchant, error) {
 		}
 	}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	var merchant yapay.Merchant
-	if err := yaml.Unmarshal(data, &merchant); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	// Expands ${ENV}, resolves secret references, applies defaults and
+	// reports every problem with its line
+	var opts []yapay.LoadOption
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		vault := yapay.NewHTTPSecretProvider(addr, yapay.WithSecretToken(yapay.Secret(os.Getenv("VAULT_TOKEN"))))
+		opts = append(opts, yapay.WithSecretProvider(yapay.SecretSchemeVault, vault))
 	}
-
-	return &merchant, nil
+	return yapay.LoadMerchantConfig(configPath, opts...)
 }
 
-func validateHandler(handler yapay.ClientHandler) error {
+func validateHandler(ctx context.Context, handler yapay.ClientHandlerV2) error {
 	// Check required methods
 	if handler.GetMerchantConfig() == nil {
 		return fmt.Errorf("GetMerchantConfig() returned nil")
@@ -149,21 +302,21 @@ func validateHandler(handler yapay.ClientHandler) error {
 	// Test ValidateRequest with valid data
 	testData := testing.NewTestData()
 	validRequest := testData.CreateTestPaymentRequest()
-	if err := handler.ValidateRequest(validRequest); err != nil {
+	if err := handler.ValidateRequest(ctx, validRequest); err != nil {
 		return fmt.Errorf("ValidateRequest failed with valid data: %v", err)
 	}
 
 	return nil
 }
 
-func runValidationTests(handler yapay.ClientHandler, verbose bool) {
+func runValidationTests(ctx context.Context, handler yapay.ClientHandlerV2, verbose bool) {
 	fmt.Println("\n🧪 Running validation tests...")
 
 	testData := testing.NewTestData()
 
 	// Test valid payment request
 	validRequest := testData.CreateTestPaymentRequest()
-	if err := handler.ValidateRequest(validRequest); err != nil {
+	if err := handler.ValidateRequest(ctx, validRequest); err != nil {
 		fmt.Printf("❌ Valid request failed: %v\n", err)
 	} else {
 		fmt.Println("✅ Valid request passed")
@@ -201,7 +354,7 @@ func runValidationTests(handler yapay.ClientHandler, verbose bool) {
 	}
 
 	for _, test := range invalidRequests {
-		if err := handler.ValidateRequest(test.request); err != nil {
+		if err := handler.ValidateRequest(ctx, test.request); err != nil {
 			if verbose {
 				fmt.Printf("✅ %s correctly rejected: %v\n", test.name, err)
 			}
@@ -214,7 +367,7 @@ func runValidationTests(handler yapay.ClientHandler, verbose bool) {
 	payment := testData.CreateTestPayment()
 	lifecycleTests := []struct {
 		name string
-		fn   func(*yapay.Payment) error
+		fn   func(context.Context, *yapay.Payment) error
 	}{
 		{"HandlePaymentCreated", handler.HandlePaymentCreated},
 		{"HandlePaymentSuccess", handler.HandlePaymentSuccess},
@@ -223,7 +376,7 @@ func runValidationTests(handler yapay.ClientHandler, verbose bool) {
 	}
 
 	for _, test := range lifecycleTests {
-		if err := test.fn(payment); err != nil {
+		if err := test.fn(ctx, payment); err != nil {
 			fmt.Printf("❌ %s failed: %v\n", test.name, err)
 		} else {
 			if verbose {
@@ -233,7 +386,7 @@ func runValidationTests(handler yapay.ClientHandler, verbose bool) {
 	}
 }
 
-func runSimulationTests(handler yapay.ClientHandler, verbose bool) {
+func runSimulationTests(ctx context.Context, handler yapay.ClientHandlerV2, verbose bool) {
 	fmt.Println("\n🎭 Running simulation tests...")
 
 	testData := testing.NewTestData()
@@ -241,7 +394,7 @@ func runSimulationTests(handler yapay.ClientHandler, verbose bool) {
 
 	// Simulate payment flow
 	fmt.Println("1. Payment created...")
-	if err := handler.HandlePaymentCreated(payment); err != nil {
+	if err := handler.HandlePaymentCreated(ctx, payment); err != nil {
 		fmt.Printf("❌ Payment creation failed: %v\n", err)
 		return
 	}
@@ -253,40 +406,74 @@ func runSimulationTests(handler yapay.ClientHandler, verbose bool) {
 	}
 
 	// Simulate successful payment
-	payment.Status = "success"
+	if err := payment.TransitionTo(yapay.PaymentStatusSuccess); err != nil {
+		fmt.Printf("❌ Payment status transition failed: %v\n", err)
+		return
+	}
 	fmt.Println("2. Payment successful...")
-	if err := handler.HandlePaymentSuccess(payment); err != nil {
+	if err := handler.HandlePaymentSuccess(ctx, payment); err != nil {
 		fmt.Printf("❌ Payment success handling failed: %v\n", err)
 		return
 	}
 
+	// Simulate refunds if the plugin supports them
+	if _, ok := yapay.AsRefundHandler(handler); ok {
+		if !simulateRefunds(ctx, handler, payment, verbose) {
+			return
+		}
+	} else if verbose {
+		fmt.Println("   Plugin does not implement RefundHandler, skipping refunds")
+	}
+
 	fmt.Println("✅ Payment simulation completed successfully")
 
 	// Test payment generator if available
-	generator := handler.GetPaymentLinkGenerator()
-	if generator != nil {
+	if paymentGen, err := yapay.UpgradeHandler(handler).PaymentGenerator(); err == nil {
 		fmt.Println("\n🔗 Testing payment generator...")
-		if paymentGen, ok := generator.(yapay.PaymentLinkGenerator); ok {
-			request := testData.CreateTestPaymentRequest()
-
-			fmt.Println("   Generating payment data...")
-			result, err := paymentGen.GeneratePaymentData(request)
-			if err != nil {
-				fmt.Printf("❌ Payment data generation failed: %v\n", err)
-			} else {
-				fmt.Printf("✅ Payment data generated: OrderID=%s, Amount=%d\n", result.OrderID, result.Amount)
-			}
+		request := testData.CreateTestPaymentRequest()
 
-			fmt.Println("   Getting payment settings...")
-			settings := paymentGen.GetPaymentSettings()
-			if settings != nil {
-				fmt.Printf("✅ Payment settings: Currency=%s, Sandbox=%v\n", settings.Currency, settings.SandboxMode)
-			}
+		fmt.Println("   Generating payment data...")
+		result, err := paymentGen.GeneratePaymentData(request)
+		if err != nil {
+			fmt.Printf("❌ Payment data generation failed: %v\n", err)
+		} else {
+			fmt.Printf("✅ Payment data generated: OrderID=%s, Amount=%d\n", result.OrderID, result.Amount)
+		}
+
+		fmt.Println("   Getting payment settings...")
+		settings := paymentGen.GetPaymentSettings()
+		if settings != nil {
+			fmt.Printf("✅ Payment settings: Currency=%s, Sandbox=%v\n", settings.Currency, settings.SandboxMode)
 		}
 	}
 }
 
-func runBenchmarkTests(handler yapay.ClientHandler, _ bool) {
+// simulateRefunds refunds half of the payment and then the remainder
+func simulateRefunds(ctx context.Context, handler yapay.ClientHandlerV2, payment *yapay.Payment, verbose bool) bool {
+	testData := testing.NewTestData()
+	partialAmount := payment.Amount / 2
+
+	fmt.Println("3. Partial refund...")
+	if err := yapay.DispatchRefund(ctx, handler, payment, testData.CreateTestRefund(payment, partialAmount)); err != nil {
+		fmt.Printf("❌ Partial refund handling failed: %v\n", err)
+		return false
+	}
+	if verbose {
+		fmt.Printf("   Refunded %d of %d, status=%s\n", payment.RefundedAmount, payment.Amount, payment.Status)
+	}
+
+	fmt.Println("4. Full refund...")
+	if err := yapay.DispatchRefund(ctx, handler, payment, testData.CreateTestRefund(payment, payment.RefundableAmount())); err != nil {
+		fmt.Printf("❌ Full refund handling failed: %v\n", err)
+		return false
+	}
+	if verbose {
+		fmt.Printf("   Refunded %d of %d, status=%s\n", payment.RefundedAmount, payment.Amount, payment.Status)
+	}
+	return true
+}
+
+func runBenchmarkTests(ctx context.Context, handler yapay.ClientHandlerV2, _ bool) {
 	fmt.Println("\n⚡ Running benchmark tests...")
 
 	testData := testing.NewTestData()
@@ -298,7 +485,7 @@ func runBenchmarkTests(handler yapay.ClientHandler, _ bool) {
 	start := time.Now()
 	iterations := 1000
 	for i := 0; i < iterations; i++ {
-		_ = handler.HandlePaymentCreated(payment)
+		_ = handler.HandlePaymentCreated(ctx, payment)
 	}
 	duration := time.Since(start)
 	opsPerSec := float64(iterations) / duration.Seconds()
@@ -308,24 +495,21 @@ func runBenchmarkTests(handler yapay.ClientHandler, _ bool) {
 	fmt.Println("Benchmarking ValidateRequest...")
 	start = time.Now()
 	for i := 0; i < iterations; i++ {
-		_ = handler.ValidateRequest(request)
+		_ = handler.ValidateRequest(ctx, request)
 	}
 	duration = time.Since(start)
 	opsPerSec = float64(iterations) / duration.Seconds()
 	fmt.Printf("✅ %d operations in %v (%.0f ops/sec)\n", iterations, duration, opsPerSec)
 
 	// Benchmark payment generator if available
-	generator := handler.GetPaymentLinkGenerator()
-	if generator != nil {
-		if paymentGen, ok := generator.(yapay.PaymentLinkGenerator); ok {
-			fmt.Println("Benchmarking GeneratePaymentData...")
-			start = time.Now()
-			for i := 0; i < iterations; i++ {
-				_, _ = paymentGen.GeneratePaymentData(request)
-			}
-			duration = time.Since(start)
-			opsPerSec = float64(iterations) / duration.Seconds()
-			fmt.Printf("✅ %d operations in %v (%.0f ops/sec)\n", iterations, duration, opsPerSec)
+	if paymentGen, err := yapay.UpgradeHandler(handler).PaymentGenerator(); err == nil {
+		fmt.Println("Benchmarking GeneratePaymentData...")
+		start = time.Now()
+		for i := 0; i < iterations; i++ {
+			_, _ = paymentGen.GeneratePaymentData(request)
 		}
+		duration = time.Since(start)
+		opsPerSec = float64(iterations) / duration.Seconds()
+		fmt.Printf("✅ %d operations in %v (%.0f ops/sec)\n", iterations, duration, opsPerSec)
 	}
 }
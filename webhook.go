@@ -0,0 +1,387 @@
+package yapay
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Yandex Pay JWKS endpoints used when YandexConfig.JWKSEndpoint is empty
+const (
+	YandexJWKSURL        = "https://pay.yandex.ru/api/jwks"
+	YandexSandboxJWKSURL = "https://sandbox.pay.yandex.ru/api/jwks"
+)
+
+const (
+	defaultJWKSCacheTTL           = time.Hour
+	defaultJWKSMinRefreshInterval = 30 * time.Second
+	defaultWebhookClockSkew       = time.Minute
+	defaultWebhookMaxAge          = 15 * time.Minute
+	maxJWKSResponseSize           = 1 << 20
+	es256CoordinateSize           = 32
+)
+
+// Webhook verification failures. They are wrapped in *Error, so both
+// errors.Is(err, ErrWebhookSignature) and errors.Is(err, ErrUnauthorized) hold.
+var (
+	ErrWebhookMalformed = errors.New("malformed webhook token")
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookClaims    = errors.New("invalid webhook claims")
+)
+
+// WebhookVerifier verifies Yandex Pay webhook JWTs (ES256) against the
+// merchant JWKS endpoint. Keys are cached and refreshed when the TTL expires
+// or when a token references an unknown kid, which handles key rotation.
+// Concurrent verifications share a single JWKS refresh and never wait for it
+// when their key is cached. It is safe for concurrent use.
+type WebhookVerifier struct {
+	merchantID         string
+	jwksURL            string
+	client             *http.Client
+	cacheTTL           time.Duration
+	minRefreshInterval time.Duration
+	clockSkew          time.Duration
+	maxAge             time.Duration
+	now                func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]*ecdsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time    // start of the last fetch, successful or not
+	refreshErr  error        // result of the last fetch
+	refresh     *jwksRefresh // in-flight refresh, nil when idle
+}
+
+// jwksRefresh is a JWKS fetch shared by the verifications waiting for it
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// WebhookVerifierOption configures a WebhookVerifier
+type WebhookVerifierOption func(*WebhookVerifier)
+
+// WithHTTPClient sets the HTTP client used to fetch the JWKS
+func WithHTTPClient(client *http.Client) WebhookVerifierOption {
+	return func(v *WebhookVerifier) {
+		v.client = client
+	}
+}
+
+// WithJWKSCacheTTL sets how long fetched keys are trusted before a refresh
+func WithJWKSCacheTTL(ttl time.Duration) WebhookVerifierOption {
+	return func(v *WebhookVerifier) {
+		v.cacheTTL = ttl
+	}
+}
+
+// WithJWKSMinRefreshInterval limits how often an unknown kid may trigger a refresh
+func WithJWKSMinRefreshInterval(interval time.Duration) WebhookVerifierOption {
+	return func(v *WebhookVerifier) {
+		v.minRefreshInterval = interval
+	}
+}
+
+// WithClockSkew sets the tolerance applied to exp, nbf and iat claims
+func WithClockSkew(skew time.Duration) WebhookVerifierOption {
+	return func(v *WebhookVerifier) {
+		v.clockSkew = skew
+	}
+}
+
+// WithMaxTokenAge sets how old a token may be, judged by its iat claim.
+// Tokens without exp must carry iat, so a captured webhook cannot be
+// replayed indefinitely.
+func WithMaxTokenAge(age time.Duration) WebhookVerifierOption {
+	return func(v *WebhookVerifier) {
+		v.maxAge = age
+	}
+}
+
+// NewWebhookVerifier creates a verifier for the merchant described by cfg.
+// The JWKS endpoint defaults to the sandbox or production URL depending on
+// cfg.SandboxMode. cfg.MerchantID is required: tokens of other merchants are
+// rejected.
+func NewWebhookVerifier(cfg YandexConfig, opts ...WebhookVerifierOption) (*WebhookVerifier, error) {
+	if cfg.MerchantID == "" {
+		return nil, NewError(ErrorCodeValidation, "merchant ID is required to verify webhooks").
+			WithDetail("yandex.merchant_id", "must not be empty")
+	}
+
+	jwksURL := cfg.JWKSEndpoint
+	if jwksURL == "" {
+		jwksURL = YandexJWKSURL
+		if cfg.SandboxMode {
+			jwksURL = YandexSandboxJWKSURL
+		}
+	}
+
+	v := &WebhookVerifier{
+		merchantID:         cfg.MerchantID,
+		jwksURL:            jwksURL,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		cacheTTL:           defaultJWKSCacheTTL,
+		minRefreshInterval: defaultJWKSMinRefreshInterval,
+		clockSkew:          defaultWebhookClockSkew,
+		maxAge:             defaultWebhookMaxAge,
+		now:                time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// jwtHeader is the JOSE header of a webhook token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// webhookClaims is the JWT payload: the webhook body plus registered time claims
+type webhookClaims struct {
+	PaymentWebhook
+	IssuedAt  *int64 `json:"iat,omitempty"`
+	ExpiresAt *int64 `json:"exp,omitempty"`
+	NotBefore *int64 `json:"nbf,omitempty"`
+}
+
+// Verify checks the token signature, merchant ID and time claims and returns the decoded webhook
+func (v *WebhookVerifier) Verify(ctx context.Context, token []byte) (*PaymentWebhook, error) {
+	token = bytes.TrimSpace(token)
+	parts := bytes.Split(token, []byte("."))
+	if len(parts) != 3 {
+		return nil, webhookError(ErrorCodeValidation, ErrWebhookMalformed, "token must have three segments")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, webhookError(ErrorCodeValidation, ErrWebhookMalformed, "invalid header: %v", err)
+	}
+	if header.Alg != "ES256" {
+		return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "unsupported algorithm %q", header.Alg)
+	}
+	if header.Kid == "" {
+		return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "missing kid")
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(string(parts[2]))
+	if err != nil || len(signature) != 2*es256CoordinateSize {
+		return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "invalid signature encoding")
+	}
+	digest := sha256.Sum256(token[:len(parts[0])+1+len(parts[1])])
+	r := new(big.Int).SetBytes(signature[:es256CoordinateSize])
+	s := new(big.Int).SetBytes(signature[es256CoordinateSize:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "signature mismatch")
+	}
+
+	var claims webhookClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, webhookError(ErrorCodeValidation, ErrWebhookMalformed, "invalid payload: %v", err)
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims.PaymentWebhook, nil
+}
+
+func (v *WebhookVerifier) validateClaims(claims *webhookClaims) error {
+	if claims.MerchantID != v.merchantID {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "merchantId %q does not match configured merchant", claims.MerchantID)
+	}
+	if claims.ExpiresAt == nil && claims.IssuedAt == nil {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "token has neither exp nor iat")
+	}
+
+	now := v.now()
+	if claims.ExpiresAt != nil && now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.clockSkew)) {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "token expired")
+	}
+	if claims.NotBefore != nil && now.Add(v.clockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "token not valid yet")
+	}
+	if claims.IssuedAt != nil && now.Add(v.clockSkew).Before(time.Unix(*claims.IssuedAt, 0)) {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "token issued in the future")
+	}
+	if claims.IssuedAt != nil && now.After(time.Unix(*claims.IssuedAt, 0).Add(v.maxAge+v.clockSkew)) {
+		return webhookError(ErrorCodeUnauthorized, ErrWebhookClaims, "token is older than %s", v.maxAge)
+	}
+	return nil
+}
+
+// key returns the public key for kid, refreshing the JWKS when the cache is
+// stale or the kid is unknown. A cached key is served at once while its
+// refresh runs, and still if the refresh fails; only an unknown kid waits for
+// the fetch. Fetches, failed ones included, are at least minRefreshInterval
+// apart, so an outage of the JWKS endpoint does not cause a fetch per webhook.
+func (v *WebhookVerifier) key(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	v.mu.Lock()
+	now := v.now()
+	key, known := v.keys[kid]
+	fresh := !v.fetchedAt.IsZero() && now.Sub(v.fetchedAt) < v.cacheTTL
+	if known && fresh {
+		v.mu.Unlock()
+		return key, nil
+	}
+
+	call := v.refresh
+	recent := !v.attemptedAt.IsZero() && now.Sub(v.attemptedAt) < v.minRefreshInterval
+	if call == nil && !recent {
+		call = &jwksRefresh{done: make(chan struct{})}
+		v.refresh = call
+		v.attemptedAt = now
+		// The fetch is shared, so it must not be canceled with this caller;
+		// the HTTP client timeout bounds it
+		go v.refreshKeys(context.WithoutCancel(ctx), call)
+	}
+	if known {
+		v.mu.Unlock()
+		return key, nil
+	}
+	if call == nil {
+		err := v.refreshErr
+		v.mu.Unlock()
+		if err != nil {
+			return nil, WrapError(ErrorCodeBackendUnavailable, err, "failed to fetch JWKS")
+		}
+		return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "unknown kid %q", kid)
+	}
+	v.mu.Unlock()
+
+	var err error
+	select {
+	case <-call.done:
+		err = call.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, WrapError(ErrorCodeBackendUnavailable, err, "failed to fetch JWKS")
+	}
+
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, webhookError(ErrorCodeUnauthorized, ErrWebhookSignature, "unknown kid %q", kid)
+}
+
+// refreshKeys fetches the JWKS for call and publishes the result
+func (v *WebhookVerifier) refreshKeys(ctx context.Context, call *jwksRefresh) {
+	keys, err := v.fetchJWKS(ctx)
+
+	v.mu.Lock()
+	if err == nil {
+		v.keys = keys
+		v.fetchedAt = v.now()
+	}
+	v.refreshErr = err
+	call.err = err
+	v.refresh = nil
+	v.mu.Unlock()
+	close(call.done)
+}
+
+// jwk is a JSON Web Key as served by the JWKS endpoint
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Kid string `json:"kid"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *WebhookVerifier) fetchJWKS(ctx context.Context) (map[string]*ecdsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected JWKS response status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSResponseSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*ecdsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "EC" || k.Crv != "P-256" || k.Kid == "" {
+			continue
+		}
+		pub, err := parseP256Key(k.X, k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func parseP256Key(x, y string) (*ecdsa.PublicKey, error) {
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	if len(xb) != es256CoordinateSize || len(yb) != es256CoordinateSize {
+		return nil, errors.New("invalid coordinate length")
+	}
+
+	// Reject points that are not on the curve
+	point := append(append([]byte{4}, xb...), yb...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xb),
+		Y:     new(big.Int).SetBytes(yb),
+	}, nil
+}
+
+func decodeSegment(segment []byte, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(string(segment))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func webhookError(code ErrorCode, sentinel error, format string, args ...interface{}) *Error {
+	return WrapError(code, sentinel, fmt.Sprintf(format, args...))
+}
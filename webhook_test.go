@@ -0,0 +1,321 @@
+package yapay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jwksServer serves a mutable set of ES256 keys
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     map[string]*ecdsa.PrivateKey
+	requests int32
+	down     bool
+	hold     chan struct{} // blocks responses until closed
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: make(map[string]*ecdsa.PrivateKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) serve(w http.ResponseWriter, _ *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	s.mu.Lock()
+	hold := s.hold
+	s.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	keys := make([]map[string]string, 0, len(s.keys))
+	for kid, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": kid,
+			"alg": "ES256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func (s *jwksServer) removeKey(kid string) {
+	s.mu.Lock()
+	delete(s.keys, kid)
+	s.mu.Unlock()
+}
+
+func (s *jwksServer) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func signWebhook(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) []byte {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return []byte(signingInput + "." + base64.RawURLEncoding.EncodeToString(signature))
+}
+
+func webhookClaimsFor(merchantID string, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"event":      "ORDER_STATUS_UPDATED",
+		"eventTime":  now.Format(time.RFC3339),
+		"merchantId": merchantID,
+		"order": map[string]interface{}{
+			"orderId":       "order-1",
+			"paymentStatus": "CAPTURED",
+		},
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func newTestVerifier(t *testing.T, server *jwksServer, opts ...WebhookVerifierOption) *WebhookVerifier {
+	cfg := YandexConfig{MerchantID: "merchant-1", JWKSEndpoint: server.URL}
+	v, err := NewWebhookVerifier(cfg, append([]WebhookVerifierOption{WithHTTPClient(server.Client())}, opts...)...)
+	require.NoError(t, err)
+	return v
+}
+
+func TestWebhookVerifier_ValidToken(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server)
+
+	token := signWebhook(t, key, "key-1", webhookClaimsFor("merchant-1", time.Now()))
+	webhook, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ORDER_STATUS_UPDATED", webhook.Event)
+	assert.Equal(t, "merchant-1", webhook.MerchantID)
+	require.NotNil(t, webhook.Order)
+	assert.Equal(t, "order-1", webhook.Order.OrderID)
+	assert.Equal(t, "CAPTURED", webhook.Order.PaymentStatus)
+
+	// Keys are cached between verifications
+	_, err = verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+}
+
+func TestWebhookVerifier_Rejections(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	foreignKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	verifier := newTestVerifier(t, server, WithJWKSMinRefreshInterval(time.Hour))
+
+	now := time.Now()
+	expired := webhookClaimsFor("merchant-1", now.Add(-time.Hour))
+	future := webhookClaimsFor("merchant-1", now.Add(time.Hour))
+	untimed := webhookClaimsFor("merchant-1", now)
+	delete(untimed, "iat")
+	delete(untimed, "exp")
+	// Without exp the token age is limited by iat
+	old := webhookClaimsFor("merchant-1", now.Add(-time.Hour))
+	delete(old, "exp")
+
+	tests := []struct {
+		name     string
+		token    []byte
+		sentinel error
+		code     ErrorCode
+	}{
+		{"malformed", []byte("not-a-jwt"), ErrWebhookMalformed, ErrorCodeValidation},
+		{"wrong key", signWebhook(t, foreignKey, "key-1", webhookClaimsFor("merchant-1", now)), ErrWebhookSignature, ErrorCodeUnauthorized},
+		{"unknown kid", signWebhook(t, key, "key-2", webhookClaimsFor("merchant-1", now)), ErrWebhookSignature, ErrorCodeUnauthorized},
+		{"wrong merchant", signWebhook(t, key, "key-1", webhookClaimsFor("merchant-2", now)), ErrWebhookClaims, ErrorCodeUnauthorized},
+		{"expired", signWebhook(t, key, "key-1", expired), ErrWebhookClaims, ErrorCodeUnauthorized},
+		{"issued in future", signWebhook(t, key, "key-1", future), ErrWebhookClaims, ErrorCodeUnauthorized},
+		{"no time claims", signWebhook(t, key, "key-1", untimed), ErrWebhookClaims, ErrorCodeUnauthorized},
+		{"too old", signWebhook(t, key, "key-1", old), ErrWebhookClaims, ErrorCodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.sentinel), err.Error())
+			assert.Equal(t, tt.code, ErrorCodeOf(err))
+		})
+	}
+}
+
+func TestWebhookVerifier_RejectsAlgNone(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"merchantId":"merchant-1"}`))
+	_, err := verifier.Verify(context.Background(), []byte(header+"."+payload+"."))
+	assert.True(t, errors.Is(err, ErrWebhookSignature))
+}
+
+func TestWebhookVerifier_KeyRotation(t *testing.T) {
+	server := newJWKSServer(t)
+	oldKey := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server, WithJWKSMinRefreshInterval(0))
+
+	_, err := verifier.Verify(context.Background(), signWebhook(t, oldKey, "key-1", webhookClaimsFor("merchant-1", time.Now())))
+	require.NoError(t, err)
+
+	// Yandex rotates keys: the new kid triggers a refresh even though the cache is fresh
+	server.removeKey("key-1")
+	newKey := server.addKey(t, "key-2")
+
+	_, err = verifier.Verify(context.Background(), signWebhook(t, newKey, "key-2", webhookClaimsFor("merchant-1", time.Now())))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+}
+
+func TestWebhookVerifier_StaleKeysWhenJWKSUnavailable(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+
+	current := time.Now()
+	verifier := newTestVerifier(t, server, WithJWKSCacheTTL(time.Minute))
+	verifier.now = func() time.Time { return current }
+
+	token := signWebhook(t, key, "key-1", webhookClaimsFor("merchant-1", current))
+	_, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// After the TTL expires the cached key is served without waiting for the
+	// refresh, which hangs and then fails
+	hold := make(chan struct{})
+	server.mu.Lock()
+	server.hold = hold
+	server.mu.Unlock()
+	server.setDown(true)
+	current = current.Add(2 * time.Minute)
+	_, err = verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	close(hold)
+
+	// Unknown keys cannot be resolved while the endpoint is down
+	other := server.addKey(t, "key-2")
+	otherToken := signWebhook(t, other, "key-2", webhookClaimsFor("merchant-1", current))
+	_, err = verifier.Verify(context.Background(), otherToken)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrBackendUnavailable))
+	assert.True(t, IsRetryable(err))
+
+	// The failed fetch counts against the refresh interval: more webhooks do
+	// not hit the endpoint again
+	for i := 0; i < 3; i++ {
+		_, err = verifier.Verify(context.Background(), otherToken)
+		assert.True(t, errors.Is(err, ErrBackendUnavailable))
+		_, err = verifier.Verify(context.Background(), token)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+
+	// Once the interval passes the endpoint is tried again
+	server.setDown(false)
+	current = current.Add(defaultJWKSMinRefreshInterval)
+	_, err = verifier.Verify(context.Background(), otherToken)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&server.requests))
+}
+
+func TestWebhookVerifier_CachedKeysDuringRefresh(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server, WithJWKSMinRefreshInterval(0))
+	token := signWebhook(t, key, "key-1", webhookClaimsFor("merchant-1", time.Now()))
+	_, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// An unknown kid starts a refresh that hangs on a slow endpoint
+	hold := make(chan struct{})
+	server.mu.Lock()
+	server.hold = hold
+	server.mu.Unlock()
+	rotated := server.addKey(t, "key-2")
+	rotatedToken := signWebhook(t, rotated, "key-2", webhookClaimsFor("merchant-1", time.Now()))
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := verifier.Verify(context.Background(), rotatedToken)
+			results <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&server.requests) == 2 }, time.Second, time.Millisecond)
+
+	// The cached key is still served while the refresh is in flight
+	_, err = verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// A waiter gives up with its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = verifier.Verify(ctx, rotatedToken)
+	assert.True(t, errors.Is(err, ErrBackendUnavailable))
+
+	close(hold)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-results)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests), "waiters share one refresh")
+}
+
+func TestNewWebhookVerifier(t *testing.T) {
+	v, err := NewWebhookVerifier(YandexConfig{MerchantID: "m", SandboxMode: true})
+	require.NoError(t, err)
+	assert.Equal(t, YandexSandboxJWKSURL, v.jwksURL)
+	v, err = NewWebhookVerifier(YandexConfig{MerchantID: "m"})
+	require.NoError(t, err)
+	assert.Equal(t, YandexJWKSURL, v.jwksURL)
+
+	_, err = NewWebhookVerifier(YandexConfig{})
+	assert.True(t, errors.Is(err, ErrValidation))
+}